@@ -11,14 +11,16 @@ import (
 	"github.com/in4it/ecs-deploy/service"
 	"github.com/in4it/ecs-deploy/session"
 	"github.com/in4it/ecs-deploy/util"
+	"github.com/in4it/ecs-deploy/validation"
 	"github.com/juju/loggo"
 	"github.com/robbiet480/go.sns"
 	"github.com/swaggo/gin-swagger"              // gin-swagger middleware
 	"github.com/swaggo/gin-swagger/swaggerFiles" // swagger embed files
 
 	"encoding/json"
-	"errors"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,6 +37,7 @@ type API struct {
 	authMiddleware *jwt.GinJWTMiddleware
 	//sp             saml.ServiceProviderSettings
 	samlHelper *SAML
+	oidcHelper *OIDC
 }
 
 func (a *API) Launch() error {
@@ -45,6 +48,13 @@ func (a *API) Launch() error {
 		}
 	}
 
+	if util.GetEnv("OIDC_ENABLED", "") == "yes" {
+		err := a.initOIDC()
+		if err != nil {
+			return err
+		}
+	}
+
 	a.createAuthMiddleware()
 	a.createRoutes()
 
@@ -62,10 +72,30 @@ func (a *API) initSAML() error {
 	return nil
 }
 
+func (a *API) initOIDC() error {
+	var err error
+	scopes := strings.Fields(util.GetEnv("OIDC_SCOPES", "openid email profile"))
+	a.oidcHelper, err = newOIDC(util.GetEnv("OIDC_ISSUER", ""), util.GetEnv("OIDC_CLIENT_ID", ""), util.GetEnv("OIDC_CLIENT_SECRET", ""), util.GetEnv("OIDC_REDIRECT_URL", ""), scopes)
+	if err != nil {
+		return err
+	}
+	a.oidcHelper.userClaim = util.GetEnv("OIDC_USER_CLAIM", "email")
+	a.oidcHelper.groupsClaim = util.GetEnv("OIDC_GROUPS_CLAIM", "groups")
+	a.oidcHelper.roleMapping, err = parseOIDCRoleMapping(util.GetEnv("OIDC_ROLE_MAPPING", ""))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (a *API) createRoutes() {
 	// create
 	r := gin.Default()
 
+	// request id and error responses
+	r.Use(requestID(), handleErrors())
+
 	// location
 	r.Use(location.Default())
 
@@ -80,7 +110,8 @@ func (a *API) createRoutes() {
 	r.Use(ngserve.ServeWithDefault(prefix+"/webapp", ngserve.LocalFile("./webapp/dist", false), "./webapp/dist/index.html"))
 
 	auth := r.Group(apiPrefix)
-	auth.Use(a.authMiddleware.MiddlewareFunc())
+	// the audit is first, to record the calls that fail authentication
+	auth.Use(a.audit(), a.authenticate())
 	{
 		// frontend redirect
 		r.GET(prefix, a.redirectFrontendHandler)
@@ -91,19 +122,26 @@ func (a *API) createRoutes() {
 
 		// saml init
 		if util.GetEnv("SAML_ENABLED", "") == "yes" {
-			r.POST(prefix+"/saml/acs", a.samlHelper.samlInitHandler)
+			r.POST(prefix+"/saml/acs", a.audit(), a.samlHelper.samlInitHandler)
 			r.GET(prefix+"/saml/acs", a.samlHelper.samlInitHandler)
 		}
 		r.GET(prefix+"/saml/enabled", a.samlHelper.samlEnabledHandler)
 
+		// oidc
+		if util.GetEnv("OIDC_ENABLED", "") == "yes" {
+			r.GET(prefix+"/oidc/login", a.auditAll(), a.oidcHelper.oidcLoginHandler)
+			r.GET(prefix+"/oidc/callback", a.auditAll(), a.oidcHelper.oidcCallbackHandler)
+		}
+		r.GET(prefix+"/oidc/enabled", a.oidcHelper.oidcEnabledHandler)
+
 		// swagger
 		r.GET(prefix+"/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 		// webhook
-		r.POST(prefix+"/webhook", a.webhookHandler)
+		r.POST(prefix+"/webhook", a.audit(), a.webhookHandler)
 
 		// login handlers
-		r.POST(prefix+"/login", a.authMiddleware.LoginHandler)
+		r.POST(prefix+"/login", a.audit(), a.authMiddleware.LoginHandler)
 
 		// health with auth
 		auth.GET("/health", a.healthHandler)
@@ -112,56 +150,74 @@ func (a *API) createRoutes() {
 		auth.GET("/refresh_token", a.authMiddleware.RefreshHandler)
 
 		// ECR
-		auth.POST("/ecr/create/:repository", a.ecrCreateHandler)
+		auth.POST("/ecr/create/:repository", a.authorize(service.PermissionEcrWrite), a.ecrCreateHandler)
 
 		// Deploy
-		auth.POST("/deploy/:service", a.deployServiceHandler)
+		auth.POST("/deploy/:service", a.authorize(service.PermissionDeploy), a.deployServiceHandler)
+		// the permissions are checked per service in the handler
 		auth.POST("/deploy", a.deployServicesHandler)
 
 		// Redeploy existing version
-		auth.POST("/deploy/:service/:time", a.redeployServiceHandler)
+		auth.POST("/deploy/:service/:time", a.authorize(service.PermissionDeploy), a.redeployServiceHandler)
 
 		// Export
-		auth.GET("/export/terraform", a.exportTerraformHandler)
-		auth.GET("/export/terraform/:service/targetgrouparn", a.exportTerraformTargetGroupArnHandler)
-		auth.GET("/export/terraform/:service/listenerrulearn", a.exportTerraformListenerRuleArnsHandler)
-		auth.GET("/export/terraform/:service/listenerrulearn/:rule", a.exportTerraformListenerRuleArnHandler)
+		auth.GET("/export/terraform", a.authorize(service.PermissionRead), a.exportTerraformHandler)
+		auth.GET("/export/terraform/:service/targetgrouparn", a.authorize(service.PermissionRead), a.exportTerraformTargetGroupArnHandler)
+		auth.GET("/export/terraform/:service/listenerrulearn", a.authorize(service.PermissionRead), a.exportTerraformListenerRuleArnsHandler)
+		auth.GET("/export/terraform/:service/listenerrulearn/:rule", a.authorize(service.PermissionRead), a.exportTerraformListenerRuleArnHandler)
 
 		// deploy list
-		auth.GET("/deploy/list", a.listDeploysHandler)
-		auth.GET("/deploy/list/:service", a.listDeploysForServiceHandler)
-		auth.GET("/deploy/status/:service/:time", a.getDeploymentStatusHandler)
-		auth.GET("/deploy/get/:service/:time", a.getDeploymentHandler)
+		auth.GET("/deploy/list", a.authorize(service.PermissionRead), a.listDeploysHandler)
+		auth.GET("/deploy/list/:service", a.authorize(service.PermissionRead), a.listDeploysForServiceHandler)
+		auth.GET("/deploy/status/:service/:time", a.authorize(service.PermissionRead), a.getDeploymentStatusHandler)
+		auth.GET("/deploy/stream/:service/:time", a.authorize(service.PermissionRead), a.streamDeploymentHandler)
+		auth.GET("/deploy/get/:service/:time", a.authorize(service.PermissionRead), a.getDeploymentHandler)
+		// the permissions are checked per service in the handler
+		auth.GET("/deploy/waves/:id", a.getWaveDeployHandler)
 		// service list
-		auth.GET("/service/list", a.listServicesHandler)
+		auth.GET("/service/list", a.authorize(service.PermissionRead), a.listServicesHandler)
 		// service list
-		auth.GET("/service/describe", a.describeServicesHandler)
+		auth.GET("/service/describe", a.authorize(service.PermissionRead), a.describeServicesHandler)
 		// get service information
-		auth.GET("/service/describe/:service", a.describeServiceHandler)
+		auth.GET("/service/describe/:service", a.authorize(service.PermissionRead), a.describeServiceHandler)
 		// get version information
-		auth.GET("/service/describe/:service/versions", a.describeServiceVersionsHandler)
+		auth.GET("/service/describe/:service/versions", a.authorize(service.PermissionRead), a.describeServiceVersionsHandler)
 		// scale service
-		auth.POST("/service/scale/:service/:count", a.scaleServiceHandler)
+		auth.POST("/service/scale/:service/:count", a.authorize(service.PermissionScale), a.scaleServiceHandler)
+		// roll back blueGreen deployment
+		auth.POST("/service/rollback/:service", a.authorize(service.PermissionDeploy), a.rollbackServiceHandler)
 		// run task
-		auth.POST("/service/runtask/:service", a.runTaskHandler)
+		auth.POST("/service/runtask/:service", a.authorize(service.PermissionRunTask), a.runTaskHandler)
 		// get taskdefinition
-		auth.GET("/service/describe/:service/taskdefinition", a.describeServiceTaskdefinitionHandler)
+		auth.GET("/service/describe/:service/taskdefinition", a.authorize(service.PermissionRead), a.describeServiceTaskdefinitionHandler)
 		// get all tasks
-		auth.GET("/service/describe/:service/tasks", a.describeTasksHandler)
+		auth.GET("/service/describe/:service/tasks", a.authorize(service.PermissionRead), a.describeTasksHandler)
 
 		// parameter store
-		auth.GET("/service/parameter/:service/list", a.listServiceParametersHandler)
-		auth.POST("/service/parameter/:service/put", a.putServiceParameterHandler)
-		auth.POST("/service/parameter/:service/delete/:parameter", a.deleteServiceParameterHandler)
+		auth.GET("/service/parameter/:service/list", a.authorize(service.PermissionParameterRead), a.listServiceParametersHandler)
+		auth.POST("/service/parameter/:service/put", a.authorize(service.PermissionParameterWrite), a.putServiceParameterHandler)
+		auth.POST("/service/parameter/:service/delete/:parameter", a.authorize(service.PermissionParameterWrite), a.deleteServiceParameterHandler)
 
 		// cloudwatch logs
-		auth.GET("/service/log/:service/get/:taskarn/:container/:start/:end", a.getServiceLogsHandler)
+		auth.GET("/service/log/:service/get/:taskarn/:container/:start/:end", a.authorize(service.PermissionRead), a.getServiceLogsHandler)
 
 		// service autoscaling
-		auth.POST("/service/autoscaling/:service/put", a.putServiceAutoscalingHandler)
-		auth.GET("/service/autoscaling/:service/get", a.getServiceAutoscalingHandler)
-		auth.POST("/service/autoscaling/:service/delete/:policyname", a.deleteServiceAutoscalingPolicyHandler)
-		auth.POST("/service/autoscaling/:service/delete", a.deleteServiceAutoscalingHandler)
+		auth.POST("/service/autoscaling/:service/put", a.authorize(service.PermissionAutoscalingWrite), a.putServiceAutoscalingHandler)
+		auth.GET("/service/autoscaling/:service/get", a.authorize(service.PermissionRead), a.getServiceAutoscalingHandler)
+		auth.POST("/service/autoscaling/:service/delete/:policyname", a.authorize(service.PermissionAutoscalingWrite), a.deleteServiceAutoscalingPolicyHandler)
+		auth.POST("/service/autoscaling/:service/delete", a.authorize(service.PermissionAutoscalingWrite), a.deleteServiceAutoscalingHandler)
+
+		// rbac
+		auth.GET("/rbac", a.authorize(service.PermissionRBAC), a.getRBACHandler)
+		auth.POST("/rbac", a.authorize(service.PermissionRBAC), a.putRBACHandler)
+
+		// api tokens
+		auth.GET("/token/list", a.authorize(service.PermissionToken), a.listAPITokensHandler)
+		auth.POST("/token/create", a.authorize(service.PermissionToken), a.createAPITokenHandler)
+		auth.POST("/token/revoke/:name", a.authorize(service.PermissionToken), a.revokeAPITokenHandler)
+
+		// audit log
+		auth.GET("/audit", a.authorize(service.PermissionAudit), a.getAuditHandler)
 	}
 
 	// run API
@@ -182,24 +238,32 @@ func (a *API) createAuthMiddleware() {
 		Timeout:          time.Hour,
 		MaxRefresh:       time.Hour,
 		Authenticator: func(userId string, password string, c *gin.Context) (string, bool) {
+			c.Set("userID", userId)
 			if (userId == "deploy" && password == util.GetEnv("DEPLOY_PASSWORD", "deploy")) || (userId == "developer" && password == util.GetEnv("DEVELOPER_PASSWORD", "developer")) {
 				return userId, true
 			}
 
 			return userId, false
 		},
+		// the permissions are checked per route (see authorize), users need at least one role
 		Authorizator: func(userId string, c *gin.Context) bool {
-			if userId != "" {
-				return true
+			if userId == "" {
+				return false
 			}
-
-			return false
+			r, err := service.NewService().GetRBAC()
+			if err != nil {
+				apiLogger.Errorf("Could not get rbac: %v", err)
+				return false
+			}
+			claims := jwt.ExtractClaims(c)
+			return len(r.GetRoles(userId, getClaimsStrings(claims, "groups"), getClaimsStrings(claims, "roles"))) > 0
 		},
 		Unauthorized: func(c *gin.Context, code int, message string) {
-			c.JSON(code, gin.H{
-				"code":    code,
-				"message": message,
-			})
+			if code == http.StatusForbidden {
+				writeError(c, newForbiddenError(message))
+				return
+			}
+			writeError(c, &APIError{Status: code, Code: ErrorCodeUnauthorized, Message: message})
 		},
 		// TokenLookup is a string in the form of "<source>:<name>" that is used
 		// to extract token from the request.
@@ -228,16 +292,14 @@ func (a *API) createAuthMiddleware() {
 // @param   repository     path    string     true        "repository"
 // @router /api/v1/ecr/create/{repository} [post]
 func (a *API) ecrCreateHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	res, err := controller.createRepository(c.Param("repository"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"message": res,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
@@ -259,65 +321,170 @@ func (a *API) healthHandler(c *gin.Context) {
 // @accept  json
 // @produce  json
 // @param   service         path    string     true        "service name"
+// @param   dryRun          query   bool       false       "return the changes of the deploy without deploying"
 // @router /api/v1/deploy/{service} [post]
 func (a *API) deployServiceHandler(c *gin.Context) {
 	var json service.Deploy
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	service.SetDeployDefaults(&json)
 	if err := c.ShouldBindJSON(&json); err == nil {
-		if err = a.deployServiceValidator(c.Param("service"), json); err == nil {
-			res, err := controller.Deploy(c.Param("service"), json)
+		// the service can move to another cluster
+		if !a.isAuthorized(c, service.PermissionDeploy, json.Cluster, c.Param("service")) {
+			c.Error(newForbiddenError("You don't have the deploy permission on " + c.Param("service") + " in cluster " + json.Cluster))
+			return
+		}
+		if err = a.deployServiceValidator(c.Param("service"), json); err == nil && c.Query("dryRun") == "true" {
+			plan, err := controller.planDeploy(c.Param("service"), json)
+			if err == nil {
+				c.JSON(200, gin.H{
+					"plan": plan,
+				})
+			} else {
+				c.Error(err)
+			}
+		} else if err == nil {
+			res, err := controller.DeployWithContext(c.Request.Context(), c.Param("service"), json)
 			if err == nil {
 				c.JSON(200, gin.H{
 					"message": res,
 				})
 			} else {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.Error(err)
 			}
 		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(err)
 		}
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(newBadRequestError(err.Error()))
 	}
 }
 
 // @summary Deploy services to ECS
-// @description Deploy services to ECS
+// @description Deploy services to ECS. Services are deployed in waves, a service with dependsOn is deployed once the services it depends on are deployed successfully
 // @id ecs-deploy-service
 // @accept  json
 // @produce  json
+// @param   dryRun          query   bool       false       "return the changes of the deploy without deploying"
 // @router /api/v1/deploy [post]
 func (a *API) deployServicesHandler(c *gin.Context) {
 	var json service.DeployServices
 	var errors map[string]string
-	var results []*service.DeployResult
+	var waveNames [][]string
 	var err error
-	var res *service.DeployResult
-	var failures int
 	errors = make(map[string]string)
-	controller := Controller{}
+	forbidden := make(map[string]bool)
+	validationErrors := make(map[string]validation.Errors)
+	controller := newController(c.Request.Context())
 	if err = c.ShouldBindJSON(&json); err == nil {
+		if len(json.Services) == 0 {
+			c.Error(newBadRequestError("services can't be empty"))
+			return
+		}
 		for i, v := range json.Services {
-			if err = a.deployServiceValidator(v.ServiceName, json.Services[i]); err == nil {
-				res, err = controller.Deploy(v.ServiceName, json.Services[i])
-				if err == nil {
-					results = append(results, res)
-				}
+			if !a.isAuthorizedToDeploy(c, v.Cluster, v.ServiceName) {
+				errors[v.ServiceName] = "You don't have the deploy permission on " + v.ServiceName
+				forbidden[v.ServiceName] = true
+				continue
 			}
-			if err != nil {
-				failures += 1
+			if err = a.deployServiceValidator(v.ServiceName, json.Services[i]); err != nil {
 				errors[v.ServiceName] = err.Error()
+				validationErrors[v.ServiceName] = err.(validation.Errors)
 			}
 		}
-		c.JSON(200, gin.H{
-			"messages": results,
-			"failures": failures,
-			"errors":   errors,
+		waves, err := service.GetDeployWaves(json.Services)
+		if err != nil {
+			c.Error(newBadRequestError(err.Error()))
+			return
+		}
+		for _, wave := range waves {
+			var serviceNames []string
+			for _, d := range wave {
+				serviceNames = append(serviceNames, d.ServiceName)
+			}
+			waveNames = append(waveNames, serviceNames)
+		}
+		if c.Query("dryRun") == "true" {
+			var plans []*service.DeployPlan
+			for _, v := range json.Services {
+				if _, ok := errors[v.ServiceName]; ok {
+					continue
+				}
+				plan, err := controller.planDeploy(v.ServiceName, v)
+				if err != nil {
+					errors[v.ServiceName] = err.Error()
+				} else {
+					plans = append(plans, plan)
+				}
+			}
+			if apiErr := getDeployServicesError(len(json.Services), errors, forbidden, validationErrors); apiErr != nil {
+				c.Error(apiErr)
+				return
+			}
+			c.JSON(getDeployServicesStatus(errors), gin.H{
+				"plans":            plans,
+				"failures":         len(errors),
+				"errors":           errors,
+				"validationErrors": validationErrors,
+				"waves":            waveNames,
+			})
+			return
+		}
+		results, queued, waveDeployId := controller.DeployServices(c.Request.Context(), waves, errors)
+		if apiErr := getDeployServicesError(len(json.Services), errors, forbidden, validationErrors); apiErr != nil {
+			c.Error(apiErr)
+			return
+		}
+		c.JSON(getDeployServicesStatus(errors), gin.H{
+			"id":               waveDeployId,
+			"messages":         results,
+			"failures":         len(errors),
+			"errors":           errors,
+			"validationErrors": validationErrors,
+			"waves":            waveNames,
+			"queued":           queued,
 		})
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(newBadRequestError(err.Error()))
+	}
+}
+
+// getDeployServicesStatus returns the status of a deploy of multiple services: 200 when all services are deployed, 207
+// (multi-status) when some services failed
+func getDeployServicesStatus(errors map[string]string) int {
+	if len(errors) > 0 {
+		return http.StatusMultiStatus
 	}
+	return http.StatusOK
+}
+
+// getDeployServicesError returns the error when all services of a deploy failed: forbidden when the user can't deploy
+// any of the services, a validation error when the services are forbidden or invalid, a failed deploy otherwise
+func getDeployServicesError(services int, errors map[string]string, forbidden map[string]bool, validationErrors map[string]validation.Errors) *APIError {
+	if services == 0 || len(errors) < services {
+		return nil
+	}
+	var serviceNames []string
+	for serviceName := range errors {
+		serviceNames = append(serviceNames, serviceName)
+	}
+	sort.Strings(serviceNames)
+	var messages []string
+	for _, serviceName := range serviceNames {
+		messages = append(messages, serviceName+": "+errors[serviceName])
+	}
+	e := &APIError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    ErrorCodeDeployFailed,
+		Message: "All services failed: " + strings.Join(messages, "; "),
+		Details: gin.H{"errors": errors, "validationErrors": validationErrors},
+	}
+	switch {
+	case len(forbidden) == len(errors):
+		e.Status, e.Code = http.StatusForbidden, ErrorCodeForbidden
+	case len(forbidden)+len(validationErrors) == len(errors):
+		e.Status, e.Code = http.StatusBadRequest, ErrorCodeValidation
+	}
+	return e
 }
 
 // @summary Redeploy existing service to ECS
@@ -329,36 +496,44 @@ func (a *API) deployServicesHandler(c *gin.Context) {
 // @param   time            path    time       true        "timestamp"
 // @router /api/v1/deploy/{service} [post]
 func (a *API) redeployServiceHandler(c *gin.Context) {
-	controller := Controller{}
-	res, err := controller.redeploy(c.Param("service"), c.Param("time"))
+	controller := newController(c.Request.Context())
+	res, err := controller.redeploy(c.Request.Context(), c.Param("service"), c.Param("time"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"message": res,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
+// deployServiceValidator returns the problems of the deploy as validation.Errors
 func (a *API) deployServiceValidator(serviceName string, d service.Deploy) error {
-	if len(serviceName) < 3 {
-		return errors.New("service name needs to be at least 3 characters")
+	controller := Controller{}
+	listeners := make(map[string][]string)
+	var deploys []service.Deploy
+	if len(d.RuleConditions) > 0 && strings.ToLower(d.ServiceProtocol) != "none" {
+		deploys = append(deploys, d)
 	}
-	if strings.ToLower(d.ServiceProtocol) != "none" && d.ServicePort == 0 {
-		return errors.New("ServicePort needs to be set if ServiceProtocol is not set to none.")
+	for _, lb := range d.LoadBalancers {
+		deploys = append(deploys, service.GetLoadBalancerDeploy(d, lb))
 	}
-	t := false
-	for _, container := range d.Containers {
-		if container.ContainerName == serviceName {
-			t = true
+	for _, deploy := range deploys {
+		loadBalancer := service.GetLoadBalancerName(deploy)
+		if _, ok := listeners[loadBalancer]; ok {
+			continue
 		}
+		protocols, err := controller.getListenerProtocols(deploy)
+		if err != nil {
+			// the deploy returns the loadbalancer error
+			apiLogger.Debugf("Could not get listeners of loadbalancer %v, skipping listener validation: %v", loadBalancer, err)
+			continue
+		}
+		listeners[loadBalancer] = protocols
 	}
-	if !t {
-		return errors.New("At least one container needs to have the same name as the service (" + serviceName + ")")
+	if errs := validation.ValidateDeploy(serviceName, d, listeners); len(errs) > 0 {
+		return errs
 	}
-
 	return nil
 }
 
@@ -381,9 +556,7 @@ func (a *API) exportTerraformHandler(c *gin.Context) {
 			})
 		}
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
@@ -395,14 +568,12 @@ func (a *API) exportTerraformHandler(c *gin.Context) {
 func (a *API) exportTerraformTargetGroupArnHandler(c *gin.Context) {
 	e := Export{}
 	targetGroupArn, err := e.getTargetGroupArn(c.Param("service"))
-	if err == nil && targetGroupArn != nil {
+	if err == nil {
 		c.JSON(200, gin.H{
 			"targetGroupArn": targetGroupArn,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
@@ -414,14 +585,12 @@ func (a *API) exportTerraformTargetGroupArnHandler(c *gin.Context) {
 func (a *API) exportTerraformListenerRuleArnHandler(c *gin.Context) {
 	e := Export{}
 	listenerRuleArn, err := e.getListenerRuleArn(c.Param("service"), c.Param("rule"))
-	if err == nil && listenerRuleArn != nil {
+	if err == nil {
 		c.JSON(200, gin.H{
 			"listenerRuleArn": listenerRuleArn,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
@@ -433,120 +602,174 @@ func (a *API) exportTerraformListenerRuleArnHandler(c *gin.Context) {
 func (a *API) exportTerraformListenerRuleArnsHandler(c *gin.Context) {
 	e := Export{}
 	listenerRuleArns, err := e.getListenerRuleArns(c.Param("service"))
-	if err == nil && listenerRuleArns != nil {
+	if err == nil {
 		c.JSON(200, gin.H{
 			"listenerRuleKeys": listenerRuleArns.RuleKeys,
 			"listenerRules":    listenerRuleArns.Rules,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) listDeploysHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	deploys, err := controller.getDeploys()
 	if err == nil {
 		c.JSON(200, gin.H{
 			"deployments": deploys,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) listDeploysForServiceHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	deploys, err := controller.getDeploysForService(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"deployments": deploys,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) listServicesHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	services, err := controller.getServices()
 	if err == nil {
 		c.JSON(200, gin.H{
 			"services": services,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
 func (a *API) describeServicesHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	services, err := controller.describeServices()
 	if err == nil {
 		c.JSON(200, gin.H{
 			"services": services,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) describeServiceHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	service, err := controller.describeService(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"service": service,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) describeServiceVersionsHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	versions, err := controller.describeServiceVersions(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"versions": versions,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) getDeploymentStatusHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	service, err := controller.getDeploymentStatus(c.Param("service"), c.Param("time"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"service": service,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
+
+// @summary Stream the progress of a deployment
+// @description Server-Sent Events stream with the ecs service events, task state changes, target health changes and status of a deployment. The stream ends when the deployment is finished, or with an error event when the deployment can't be polled
+// @id ecs-deploy-stream
+// @produce  text/event-stream
+// @param   service         path    string     true        "service name"
+// @param   time            path    string     true        "deployment time"
+// @router /api/v1/deploy/stream/{service}/{time} [get]
+func (a *API) streamDeploymentHandler(c *gin.Context) {
+	w := newDeploymentWatcher(c.Param("service"), c.Param("time"))
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	first := true
+	failures := 0
+	c.Stream(func(_ io.Writer) bool {
+		if !first {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-time.After(deployStreamInterval):
+			}
+		}
+		first = false
+		events, finished, err := w.poll()
+		for _, event := range events {
+			c.SSEvent(event.Type, event)
+		}
+		if err != nil {
+			failures++
+			if !isPollRetryable(err) || failures > deployStreamRetries {
+				c.SSEvent("error", gin.H{"error": err.Error()})
+				return false
+			}
+			apiLogger.Infof("[%v] Could not poll deployment %v/%v, retrying: %v", getRequestID(c), c.Param("service"), c.Param("time"), err)
+			return true
+		}
+		failures = 0
+		return !finished
+	})
+}
+
+// @summary Get the progress of a deploy in waves
+// @description Get the status of every service of a deploy in waves, the id is returned by the deploy of the services
+// @id ecs-deploy-waves
+// @produce  json
+// @param   id              path    string     true        "wave deploy id"
+// @router /api/v1/deploy/waves/{id} [get]
+func (a *API) getWaveDeployHandler(c *gin.Context) {
+	controller := newController(c.Request.Context())
+	w, err := controller.getWaveDeploy(c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	for i, ws := range w.Services {
+		s := newService(c)
+		s.ServiceName = ws.ServiceName
+		clusterName, err := s.GetClusterName()
+		if err != nil {
+			clusterName = ws.Deploy.Cluster
+		}
+		if !a.isAuthorized(c, service.PermissionRead, clusterName, ws.ServiceName) {
+			c.Error(newForbiddenError("You don't have the " + service.PermissionRead + " permission on " + ws.ServiceName))
+			return
+		}
+		// the deploys can contain environment variables
+		w.Services[i].Deploy = nil
+	}
+	c.JSON(200, gin.H{
+		"waveDeploy": w,
+	})
+}
 func (a *API) getDeploymentHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	deployment, err := controller.getDeployment(c.Param("service"), c.Param("time"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"deployment": deployment,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 
@@ -557,7 +780,7 @@ func (a *API) redirectFrontendHandler(c *gin.Context) {
 func (a *API) listServiceParametersHandler(c *gin.Context) {
 	var creds string
 	claims := jwt.ExtractClaims(c)
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	session, sessionExists := session.RetrieveSession(c)
 	if sessionExists {
 		if c, ok := session.Get("paramstore_creds").(string); ok {
@@ -572,16 +795,14 @@ func (a *API) listServiceParametersHandler(c *gin.Context) {
 			"parameters": parameters,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) putServiceParameterHandler(c *gin.Context) {
 	var json service.DeployServiceParameter
 	var creds string
 	claims := jwt.ExtractClaims(c)
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	session, sessionExists := session.RetrieveSession(c)
 	if sessionExists {
 		if c, ok := session.Get("paramstore_creds").(string); ok {
@@ -597,20 +818,16 @@ func (a *API) putServiceParameterHandler(c *gin.Context) {
 				"parameters": res,
 			})
 		} else {
-			c.JSON(200, gin.H{
-				"error": err.Error(),
-			})
+			c.Error(err)
 		}
 	} else {
-		c.JSON(200, gin.H{
-			"error": "Invalid input",
-		})
+		c.Error(newBadRequestError("Invalid input: " + err.Error()))
 	}
 }
 func (a *API) deleteServiceParameterHandler(c *gin.Context) {
 	var creds string
 	claims := jwt.ExtractClaims(c)
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	session, sessionExists := session.RetrieveSession(c)
 	if sessionExists {
 		if c, ok := session.Get("paramstore_creds").(string); ok {
@@ -625,18 +842,14 @@ func (a *API) deleteServiceParameterHandler(c *gin.Context) {
 			"message": "OK",
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) scaleServiceHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	desiredCount, err := strconv.ParseInt(c.Param("count"), 10, 64)
 	if err != nil {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(newBadRequestError("count needs to be a number"))
 		return
 	}
 	err = controller.scaleService(c.Param("service"), desiredCount)
@@ -645,9 +858,26 @@ func (a *API) scaleServiceHandler(c *gin.Context) {
 			"message": "OK",
 		})
 	} else {
+		c.Error(err)
+	}
+}
+
+// @summary Roll back a blueGreen deployment
+// @description Moves the traffic back to the previous color, as long as its tasks are still running (within the bake time)
+// @id ecs-rollback-service
+// @produce  json
+// @param   service         path    string     true        "service name"
+// @router /api/v1/service/rollback/{service} [post]
+func (a *API) rollbackServiceHandler(c *gin.Context) {
+	controller := newController(c.Request.Context())
+	color, err := controller.rollbackService(c.Param("service"))
+	if err == nil {
 		c.JSON(200, gin.H{
-			"error": err.Error(),
+			"message": "OK",
+			"color":   color,
 		})
+	} else {
+		c.Error(err)
 	}
 }
 
@@ -658,35 +888,36 @@ func (a *API) webhookHandler(c *gin.Context) {
 	snsMessageType := c.GetHeader("x-amz-sns-message-type")
 	apiLogger.Tracef("Checking message type: %v", snsMessageType)
 	var snsPayload sns.Payload
-	if err = c.ShouldBindJSON(&snsPayload); err == nil {
-		err = snsPayload.VerifyPayload()
-		if err == nil {
-			apiLogger.Tracef("Verified Payload.")
-			if snsMessageType == "SubscriptionConfirmation" {
-				apiLogger.Debugf("Subscribing...")
-				_, err = snsPayload.Subscribe()
-			} else if snsMessageType == "Notification" {
-				apiLogger.Debugf("Incoming Notification")
-				var genericMessage ecs.SNSPayloadGeneric
-				if err = json.Unmarshal([]byte(snsPayload.Message), &genericMessage); err == nil {
-					apiLogger.Tracef("Message detail type: %v", genericMessage.DetailType)
-					if genericMessage.DetailType == "ECS Container Instance State Change" {
-						var ecsMessage ecs.SNSPayloadEcs
-						if err = json.Unmarshal([]byte(snsPayload.Message), &ecsMessage); err == nil {
-							apiLogger.Tracef("ECS Message: %v", snsPayload.Message)
-							err = asController.processEcsMessage(ecsMessage)
-						}
-					} else if genericMessage.DetailType == "EC2 Instance-terminate Lifecycle Action" {
-						var lifecycleMessage ecs.SNSPayloadLifecycle
-						if err = json.Unmarshal([]byte(snsPayload.Message), &lifecycleMessage); err == nil {
-							apiLogger.Debugf("Lifecycle Message: %v", snsPayload.Message)
-							err = asController.processLifecycleMessage(lifecycleMessage)
-						}
+	if err = c.ShouldBindJSON(&snsPayload); err != nil {
+		err = newBadRequestError("Invalid sns message: " + err.Error())
+	} else if err = snsPayload.VerifyPayload(); err != nil {
+		err = &APIError{Status: http.StatusForbidden, Code: ErrorCodeForbidden, Message: "Could not verify the sns message", Err: err}
+	} else {
+		apiLogger.Tracef("Verified Payload.")
+		if snsMessageType == "SubscriptionConfirmation" {
+			apiLogger.Debugf("Subscribing...")
+			_, err = snsPayload.Subscribe()
+		} else if snsMessageType == "Notification" {
+			apiLogger.Debugf("Incoming Notification")
+			var genericMessage ecs.SNSPayloadGeneric
+			if err = json.Unmarshal([]byte(snsPayload.Message), &genericMessage); err == nil {
+				apiLogger.Tracef("Message detail type: %v", genericMessage.DetailType)
+				if genericMessage.DetailType == "ECS Container Instance State Change" {
+					var ecsMessage ecs.SNSPayloadEcs
+					if err = json.Unmarshal([]byte(snsPayload.Message), &ecsMessage); err == nil {
+						apiLogger.Tracef("ECS Message: %v", snsPayload.Message)
+						err = asController.processEcsMessage(ecsMessage)
+					}
+				} else if genericMessage.DetailType == "EC2 Instance-terminate Lifecycle Action" {
+					var lifecycleMessage ecs.SNSPayloadLifecycle
+					if err = json.Unmarshal([]byte(snsPayload.Message), &lifecycleMessage); err == nil {
+						apiLogger.Debugf("Lifecycle Message: %v", snsPayload.Message)
+						err = asController.processLifecycleMessage(lifecycleMessage)
 					}
 				}
-			} else {
-				err = errors.New("MessageType not recognized")
 			}
+		} else {
+			err = newBadRequestError("MessageType not recognized")
 		}
 	}
 	if err == nil {
@@ -694,15 +925,12 @@ func (a *API) webhookHandler(c *gin.Context) {
 			"message": "OK",
 		})
 	} else {
-		apiLogger.Errorf("Error: %v", err.Error())
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) runTaskHandler(c *gin.Context) {
 	var json service.RunTask
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	if err := c.ShouldBindJSON(&json); err == nil {
 		claims := jwt.ExtractClaims(c)
 		json.StartedBy = strings.Replace(claims["id"].(string), "@", "-", -1)
@@ -712,57 +940,45 @@ func (a *API) runTaskHandler(c *gin.Context) {
 				"taskArn": taskArn,
 			})
 		} else {
-			c.JSON(200, gin.H{
-				"error": err.Error(),
-			})
+			c.Error(err)
 		}
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(newBadRequestError(err.Error()))
 	}
 }
 func (a *API) describeServiceTaskdefinitionHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	taskDefinition, err := controller.describeTaskDefinition(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"taskDefinition": taskDefinition,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) describeTasksHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	taskArns, err := controller.listTasks(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"tasks": taskArns,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) getServiceLogsHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	layout := "2006-01-02T15:04:05.9Z"
 	start, err := time.Parse(layout, c.Param("start"))
 	if err != nil {
-		c.JSON(200, gin.H{
-			"error": "Can't parse start date: " + err.Error(),
-		})
+		c.Error(newBadRequestError("Can't parse start date: " + err.Error()))
 		return
 	}
 	end, err := time.Parse(layout, c.Param("end"))
 	if err != nil {
-		c.JSON(200, gin.H{
-			"error": "Can't parse end date",
-		})
+		c.Error(newBadRequestError("Can't parse end date: " + err.Error()))
 		return
 	}
 	logs, err := controller.getServiceLogs(c.Param("service"), c.Param("taskarn"), c.Param("container"), start, end)
@@ -771,14 +987,12 @@ func (a *API) getServiceLogsHandler(c *gin.Context) {
 			"logs": logs,
 		})
 	} else {
-		c.JSON(200, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 	}
 }
 func (a *API) putServiceAutoscalingHandler(c *gin.Context) {
 	var json service.Autoscaling
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	if err := c.ShouldBindJSON(&json); err == nil {
 		result, err := controller.putServiceAutoscaling(c.Param("service"), json)
 		if err == nil {
@@ -786,43 +1000,43 @@ func (a *API) putServiceAutoscalingHandler(c *gin.Context) {
 				"autoscaling": result,
 			})
 		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(err)
 		}
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(newBadRequestError(err.Error()))
 	}
 }
 func (a *API) getServiceAutoscalingHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	result, err := controller.getServiceAutoscaling(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"autoscaling": result,
 		})
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 	}
 }
 func (a *API) deleteServiceAutoscalingPolicyHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	err := controller.deleteServiceAutoscalingPolicy(c.Param("service"), c.Param("policyname"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"autoscaling": "deleted",
 		})
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 	}
 }
 
 func (a *API) deleteServiceAutoscalingHandler(c *gin.Context) {
-	controller := Controller{}
+	controller := newController(c.Request.Context())
 	err := controller.deleteServiceAutoscaling(c.Param("service"))
 	if err == nil {
 		c.JSON(200, gin.H{
 			"autoscaling": "deleted",
 		})
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 	}
 }
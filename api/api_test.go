@@ -1,6 +1,17 @@
 package api
 
 import (
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/validation"
+	jwtgo "gopkg.in/dgrijalva/jwt-go.v3"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -9,24 +20,30 @@ func TestDeployServiceValidator(t *testing.T) {
 	a := API{}
 
 	// test with 2 characters
-	d := Deploy{
-		Containers: []*DeployContainer{
+	d := service.Deploy{
+		Cluster:     "mycluster",
+		ServicePort: 80,
+		Containers: []*service.DeployContainer{
 			{
-				ContainerName: "abc",
+				ContainerName:     "abc",
+				MemoryReservation: 128,
 			},
 		},
 	}
 	serviceName := "ab"
 	err := a.deployServiceValidator(serviceName, d)
-	if err == nil || err.Error() != "service name needs to be at least 3 characters" {
+	if errs, ok := err.(validation.Errors); !ok || errs[0].Field != "serviceName" {
 		t.Errorf("Servicename with 2 characters didn't get error message")
 	}
 
 	// test with 3 characters
-	d = Deploy{
-		Containers: []*DeployContainer{
+	d = service.Deploy{
+		Cluster:     "mycluster",
+		ServicePort: 80,
+		Containers: []*service.DeployContainer{
 			{
-				ContainerName: "abc",
+				ContainerName:     "abc",
+				MemoryReservation: 128,
 			},
 		},
 	}
@@ -38,13 +55,17 @@ func TestDeployServiceValidator(t *testing.T) {
 
 	// test with wrong container name
 	serviceName = "myservice"
-	d = Deploy{
-		Containers: []*DeployContainer{
+	d = service.Deploy{
+		Cluster:     "mycluster",
+		ServicePort: 80,
+		Containers: []*service.DeployContainer{
 			{
-				ContainerName: "ab",
+				ContainerName:     "ab",
+				MemoryReservation: 128,
 			},
 			{
-				ContainerName: "abd",
+				ContainerName:     "abd",
+				MemoryReservation: 128,
 			},
 		},
 	}
@@ -54,3 +75,92 @@ func TestDeployServiceValidator(t *testing.T) {
 		t.Errorf("No containerName is equal to serviceName, but no error raised")
 	}
 }
+
+func TestDeployServicesHandlerWithoutServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := API{}
+	r := gin.New()
+	r.Use(handleErrors())
+	r.POST("/deploy", a.deployServicesHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/deploy", strings.NewReader(`{"services":[]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad request without services, got %v: %v", w.Code, w.Body.String())
+	}
+
+	c := Controller{}
+	if results, queued, id := c.DeployServices(context.Background(), nil, make(map[string]string)); len(results) != 0 || len(queued) != 0 || id != "" {
+		t.Errorf("Expected nothing to be deployed without waves, got %v %v", results, queued)
+	}
+}
+
+func TestDeployServicesHandlerAuthorization(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	gin.SetMode(gin.TestMode)
+
+	s := service.NewService()
+	s.ServiceName = "myservice"
+	s.ClusterName = "production"
+	if err := s.CreateService(&service.DynamoServicesElement{S: "myservice", C: "production"}); err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	err := s.PutRBAC(&service.DynamoRBAC{
+		Roles:    []service.Role{{Name: "staging", Permissions: []service.Permission{{Action: service.PermissionDeploy, Cluster: "mycluster"}}}},
+		Bindings: []service.RoleBinding{{User: "alice", Roles: []string{"staging"}}},
+	})
+	if err != nil {
+		t.Fatalf("PutRBAC: %v", err)
+	}
+	a := API{}
+	r := gin.New()
+	r.Use(handleErrors(), func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", jwtgo.MapClaims{"id": "alice"})
+	})
+	r.POST("/deploy", a.deployServicesHandler)
+
+	// the cluster of the deploy doesn't give access to a service in another cluster, all services failed
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/deploy?dryRun=true", strings.NewReader(`{"services":[{"serviceName":"myservice","cluster":"mycluster"},{"serviceName":"newservice","cluster":"mycluster"}]}`)))
+	var response struct {
+		ErrorResponse
+		Details struct {
+			Errors map[string]string `json:"errors"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Could not unmarshal the response: %v", err)
+	}
+	if w.Code != http.StatusBadRequest || response.Code != ErrorCodeValidation {
+		t.Errorf("Expected a validation error when all services are forbidden or invalid, got %v %v", w.Code, response.Code)
+	}
+	if !strings.Contains(response.Details.Errors["myservice"], "deploy permission") {
+		t.Errorf("Expected myservice in production to be forbidden, got %v", response.Details.Errors)
+	}
+	if strings.Contains(response.Details.Errors["newservice"], "deploy permission") {
+		t.Errorf("Expected newservice to be authorized with the cluster of the deploy, got %v", response.Details.Errors)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/deploy?dryRun=true", strings.NewReader(`{"services":[{"serviceName":"myservice","cluster":"mycluster"}]}`)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden when all services are forbidden, got %v: %v", w.Code, w.Body.String())
+	}
+
+	// some services failed
+	d := newTestDeploy()
+	d.ServiceName = "newservice"
+	d.Containers[0].ContainerName = "newservice"
+	b, _ := json.Marshal(service.DeployServices{Services: []service.Deploy{d, {ServiceName: "myservice", Cluster: "mycluster"}}})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/deploy?dryRun=true", bytes.NewReader(b)))
+	var partialResponse struct {
+		Errors map[string]string `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &partialResponse); err != nil {
+		t.Fatalf("Could not unmarshal the response: %v", err)
+	}
+	if w.Code != http.StatusMultiStatus || len(partialResponse.Errors) != 1 || partialResponse.Errors["myservice"] == "" {
+		t.Errorf("Expected a multi-status with the error of myservice, got %v: %v", w.Code, w.Body.String())
+	}
+}
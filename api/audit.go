@@ -0,0 +1,205 @@
+package api
+
+import (
+	"github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/provider/ecs"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/util"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// the response of an audited call is kept up to this length to find the error
+const auditResponseMaxLength = 64 * 1024
+
+// auditResponseWriter keeps the start of the response, to find the errors of a deploy of multiple services
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.body.Len() < auditResponseMaxLength {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// audit returns a middleware that records the user, the source ip, the route, the services, a redacted summary of the
+// request and the outcome of every POST
+func (a *API) audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != "POST" {
+			c.Next()
+			return
+		}
+		a.auditRequest(c)
+	}
+}
+
+// auditAll returns a middleware that records every call, for the single sign-on logins that are a GET
+func (a *API) auditAll() gin.HandlerFunc {
+	return a.auditRequest
+}
+
+// auditRequest records the call, once it is handled
+func (a *API) auditRequest(c *gin.Context) {
+	var body []byte
+	if c.Request.Body != nil {
+		body, _ = ioutil.ReadAll(c.Request.Body)
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	writer := &auditResponseWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	c.Next()
+
+	e := &service.DynamoAuditEntry{
+		Time:       time.Now().UTC(),
+		User:       getAuditUser(c),
+		SourceIP:   c.ClientIP(),
+		Method:     c.Request.Method,
+		Route:      c.Request.URL.Path,
+		Services:   getAuditServices(c, body),
+		Summary:    service.GetAuditSummary(body),
+		StatusCode: writer.Status(),
+		Outcome:    service.AuditOutcomeSuccess,
+		RequestID:  getRequestID(c),
+	}
+	errorMessage := getResponseError(writer.body.Bytes())
+	// the error response of c.Error is written by handleErrors, after the audit
+	if len(c.Errors) > 0 && !writer.Written() {
+		apiErr := toAPIError(c.Errors.Last().Err)
+		e.StatusCode = apiErr.Status
+		errorMessage = apiErr.Message
+	}
+	if e.StatusCode >= 400 || errorMessage != "" {
+		e.Outcome = service.AuditOutcomeFailure
+		e.Error = errorMessage
+	}
+	err := newService(c).PutAuditEntry(e)
+	if err != nil {
+		apiLogger.Errorf("[%v] Could not write audit entry of %v %v by %v: %v", e.RequestID, e.Method, e.Route, e.User, err)
+	}
+	if logGroup := util.GetEnv("AUDIT_CLOUDWATCH_LOGS_GROUP", ""); logGroup != "" {
+		go shipAuditEntry(logGroup, e)
+	}
+}
+
+// getAuditUser returns the user of the jwt, or the user that tried to log in (set as userID by the login handlers)
+func getAuditUser(c *gin.Context) string {
+	if userId, _ := jwt.ExtractClaims(c)["id"].(string); userId != "" {
+		return userId
+	}
+	return c.GetString("userID")
+}
+
+// getAuditServices returns the service of the route, or the services of a deploy of multiple services
+func getAuditServices(c *gin.Context, body []byte) []string {
+	if c.Param("service") != "" {
+		return []string{c.Param("service")}
+	}
+	if c.Param("repository") != "" {
+		return []string{c.Param("repository")}
+	}
+	var deployServices service.DeployServices
+	if json.Unmarshal(body, &deployServices) != nil {
+		return nil
+	}
+	var services []string
+	for _, d := range deployServices.Services {
+		services = append(services, d.ServiceName)
+	}
+	return services
+}
+
+// getResponseError returns the error of a json response, the errors of a deploy of multiple services are combined
+func getResponseError(body []byte) string {
+	var response struct {
+		Error  interface{}       `json:"error"`
+		Errors map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		return ""
+	}
+	if response.Error != nil && response.Error != "" {
+		return fmt.Sprint(response.Error)
+	}
+	if len(response.Errors) > 0 {
+		b, _ := json.Marshal(response.Errors)
+		return string(b)
+	}
+	return ""
+}
+
+// shipAuditEntry writes the entry as json line to cloudwatch logs, in a log stream per ecs-deploy instance
+func shipAuditEntry(logGroup string, e *service.DynamoAuditEntry) {
+	message, err := json.Marshal(e)
+	if err != nil {
+		apiLogger.Errorf("Could not marshal audit entry: %v", err)
+		return
+	}
+	logStream, _ := os.Hostname()
+	if logStream == "" {
+		logStream = "ecs-deploy"
+	}
+	cloudwatch := ecs.CloudWatch{}
+	if err = cloudwatch.PutLogEvent(logGroup, logStream, string(message), e.Time); err != nil {
+		apiLogger.Errorf("Could not ship audit entry to cloudwatch logs: %v", err)
+	}
+}
+
+// @summary Get audit log
+// @description Get the audit log of the mutating api calls, newest first
+// @id audit-get
+// @produce  json
+// @param service query string false "only the calls of this service"
+// @param user query string false "only the calls of this user"
+// @param from query string false "start time (RFC3339), defaults to 24 hours ago"
+// @param to query string false "end time (RFC3339), defaults to now"
+// @param limit query int false "maximum number of entries, defaults to 100"
+// @router /api/v1/audit [get]
+func (a *API) getAuditHandler(c *gin.Context) {
+	to := time.Now().UTC()
+	if c.Query("to") != "" {
+		t, err := time.Parse(time.RFC3339, c.Query("to"))
+		if err != nil {
+			c.Error(newBadRequestError("to needs to be a RFC3339 time"))
+			return
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if c.Query("from") != "" {
+		t, err := time.Parse(time.RFC3339, c.Query("from"))
+		if err != nil {
+			c.Error(newBadRequestError("from needs to be a RFC3339 time"))
+			return
+		}
+		from = t
+	}
+	limit := int64(100)
+	if c.Query("limit") != "" {
+		l, err := strconv.ParseInt(c.Query("limit"), 10, 64)
+		if err != nil || l <= 0 || l > 1000 {
+			c.Error(newBadRequestError("limit needs to be between 1 and 1000"))
+			return
+		}
+		limit = l
+	}
+	entries, err := newService(c).GetAuditEntries(from, to, c.Query("service"), c.Query("user"), limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"entries": entries,
+	})
+}
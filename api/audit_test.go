@@ -0,0 +1,148 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/provider/ecs"
+	"github.com/in4it/ecs-deploy/service"
+	jwtgo "gopkg.in/dgrijalva/jwt-go.v3"
+
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	gin.SetMode(gin.TestMode)
+	cloudwatch := ecs.CloudWatch{}
+	if err := cloudwatch.CreateLogGroup("mycluster", "ecs-deploy-audit"); err != nil {
+		t.Fatalf("CreateLogGroup: %v", err)
+	}
+	os.Setenv("AUDIT_CLOUDWATCH_LOGS_GROUP", "ecs-deploy-audit")
+	defer os.Unsetenv("AUDIT_CLOUDWATCH_LOGS_GROUP")
+
+	a := &API{}
+	r := gin.New()
+	r.Use(requestID(), handleErrors(), func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", jwtgo.MapClaims{"id": "alice"})
+	}, a.audit())
+	r.GET("/service/describe/:service", func(c *gin.Context) {
+		c.JSON(200, gin.H{"service": c.Param("service")})
+	})
+	r.POST("/service/parameter/:service/put", func(c *gin.Context) {
+		c.JSON(200, gin.H{"error": "Could not put parameter"})
+	})
+	r.POST("/deploy", func(c *gin.Context) {
+		c.JSON(200, gin.H{"errors": map[string]string{}, "failures": 0})
+	})
+	r.POST("/service/scale/:service/:count", func(c *gin.Context) {
+		c.Error(service.ErrServiceNotFound)
+	})
+	requests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/service/describe/myservice", "", 200},
+		{"POST", "/service/parameter/myservice/put", `{"name":"db-password","value":"hunter2"}`, 200},
+		{"POST", "/deploy", `{"services":[{"serviceName":"a"},{"serviceName":"b"}]}`, 200},
+		{"POST", "/service/scale/myservice/2", "", 404},
+	}
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(request.method, request.path, strings.NewReader(request.body)))
+		if w.Code != request.status {
+			t.Fatalf("%v %v: unexpected status %v", request.method, request.path, w.Code)
+		}
+	}
+
+	entries, err := service.NewService().GetAuditEntries(time.Now().Add(-time.Minute), time.Now(), "", "", 100)
+	if err != nil {
+		t.Fatalf("GetAuditEntries: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected only the POST calls to be audited, got: %+v", entries)
+	}
+	scale, deploy, parameter := entries[0], entries[1], entries[2]
+	if scale.StatusCode != 404 || scale.Outcome != service.AuditOutcomeFailure || scale.Error != "Service not found" || scale.RequestID == "" {
+		t.Errorf("Unexpected audit entry of scale: %+v", scale)
+	}
+	if deploy.Outcome != service.AuditOutcomeSuccess || strings.Join(deploy.Services, ",") != "a,b" {
+		t.Errorf("Unexpected audit entry of deploy: %+v", deploy)
+	}
+	if parameter.User != "alice" || parameter.Outcome != service.AuditOutcomeFailure || parameter.Error != "Could not put parameter" {
+		t.Errorf("Unexpected audit entry of parameter put: %+v", parameter)
+	}
+	if strings.Contains(parameter.Summary, "hunter2") || !strings.Contains(parameter.Summary, "db-password") {
+		t.Errorf("Expected the parameter value to be redacted: %v", parameter.Summary)
+	}
+
+	// the entries are shipped in the background
+	logStream, _ := os.Hostname()
+	for i := 0; i < 50; i++ {
+		logs, err := cloudwatch.GetLogEventsByTime("ecs-deploy-audit", logStream, time.Now().Add(-time.Minute), time.Now().Add(time.Minute), "")
+		if err == nil && len(logs.LogEvents) == 3 {
+			if strings.Contains(logs.LogEvents[0].Message+logs.LogEvents[1].Message+logs.LogEvents[2].Message, "hunter2") {
+				t.Errorf("Expected the shipped entries to be redacted: %+v", logs.LogEvents)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected 3 audit entries in cloudwatch logs")
+}
+
+func TestAuditAuthentication(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	gin.SetMode(gin.TestMode)
+
+	a := &API{}
+	a.createAuthMiddleware()
+	r := gin.New()
+	r.Use(requestID(), handleErrors())
+	r.POST("/login", a.audit(), a.authMiddleware.LoginHandler)
+	auth := r.Group("/api/v1")
+	auth.Use(a.audit(), a.authenticate())
+	auth.POST("/service/scale/:service/:count", a.authorize(service.PermissionScale), func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "Service updated"})
+	})
+	requests := []struct {
+		path, body string
+		status     int
+	}{
+		{"/api/v1/service/scale/myservice/2", "", 401},
+		{"/login", `{"username":"deploy","password":"wrong"}`, 401},
+		{"/login", `{"username":"deploy","password":"deploy"}`, 200},
+	}
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", request.path, strings.NewReader(request.body)))
+		if w.Code != request.status {
+			t.Fatalf("POST %v: unexpected status %v", request.path, w.Code)
+		}
+	}
+
+	entries, err := service.NewService().GetAuditEntries(time.Now().Add(-time.Minute), time.Now(), "", "", 100)
+	if err != nil {
+		t.Fatalf("GetAuditEntries: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 audit entries, got: %+v", entries)
+	}
+	login, failedLogin, scale := entries[0], entries[1], entries[2]
+	if scale.StatusCode != 401 || scale.Outcome != service.AuditOutcomeFailure || scale.User != "" || strings.Join(scale.Services, ",") != "myservice" {
+		t.Errorf("Unexpected audit entry of the unauthenticated scale: %+v", scale)
+	}
+	if failedLogin.StatusCode != 401 || failedLogin.Outcome != service.AuditOutcomeFailure || failedLogin.User != "deploy" {
+		t.Errorf("Unexpected audit entry of the failed login: %+v", failedLogin)
+	}
+	if strings.Contains(failedLogin.Summary, "wrong") {
+		t.Errorf("Expected the password to be redacted: %v", failedLogin.Summary)
+	}
+	if login.StatusCode != 200 || login.Outcome != service.AuditOutcomeSuccess || login.User != "deploy" {
+		t.Errorf("Unexpected audit entry of the login: %+v", login)
+	}
+}
@@ -1,15 +1,18 @@
 package api
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/google/go-cmp/cmp"
 	"github.com/in4it/ecs-deploy/provider/ecs"
 	"github.com/in4it/ecs-deploy/service"
 	"github.com/in4it/ecs-deploy/util"
 	"github.com/juju/loggo"
 
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"sort"
 	"strconv"
@@ -19,41 +22,300 @@ import (
 
 // Controller struct
 type Controller struct {
+	// requestID is the id of the api request, added to the logs
+	requestID string
 }
 
 // logging
 var controllerLogger = loggo.GetLogger("controller")
 
+// newController returns a controller for the api request of the context
+func newController(ctx context.Context) Controller {
+	return Controller{requestID: util.GetRequestID(ctx)}
+}
+
+// logger returns the logger of the controller, with the request id in the messages
+func (c *Controller) logger() util.RequestLogger {
+	return util.RequestLogger{Logger: controllerLogger, RequestID: c.requestID}
+}
+
+// newService returns a service that logs the request id of the controller
+func (c *Controller) newService() *service.Service {
+	s := service.NewService()
+	s.RequestID = c.requestID
+	return s
+}
+
 func (c *Controller) createRepository(repository string) (*string, error) {
 	// create service in ECR if not exists
 	ecr := ecs.ECR{RepositoryName: repository}
 	err := ecr.CreateRepository()
 	if err != nil {
-		controllerLogger.Errorf("Could not create repository %v: %v", repository, err)
-		return nil, errors.New("CouldNotCreateRepository")
+		c.logger().Errorf("Could not create repository %v: %v", repository, err)
+		return nil, err
 	}
 	msg := fmt.Sprintf("Service: %v - ECR: %v", repository, ecr.RepositoryURI)
 	return &msg, nil
 }
 
+// interval between the attempts to acquire a deploy lock that is held by another deployment
+var deployLockRetryInterval = 5 * time.Second
+
+// Deploy deploys the service while holding the deploy lock of the service. The lock is held until the deployment is finished
 func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.DeployResult, error) {
+	return c.DeployWithContext(context.Background(), serviceName, d)
+}
+
+// DeployWithContext deploys the service like Deploy, a queued deploy stops waiting for the deploy lock when the
+// context is done (e.g. the client of the api call went away)
+func (c *Controller) DeployWithContext(ctx context.Context, serviceName string, d service.Deploy) (*service.DeployResult, error) {
+	s := c.newService()
+	s.ServiceName = serviceName
+	lockId, err := c.acquireDeployLock(ctx, s)
+	if err != nil {
+		c.logger().Errorf("Could not deploy %v: %v", serviceName, err)
+		return nil, err
+	}
+	res, err := c.deploy(serviceName, d)
+	if err != nil {
+		if err := s.ReleaseDeployLock(lockId); err != nil {
+			c.logger().Errorf("Could not release deploy lock of %v: %v", serviceName, err)
+		}
+		return nil, err
+	}
+	err = s.SetDeployLockDeployment(lockId, res.DeploymentTime)
+	if err != nil {
+		c.logger().Errorf("Could not set deployment of deploy lock of %v: %v", serviceName, err)
+	}
+	return res, nil
+}
+
+// acquireDeployLock acquires the deploy lock of the service, depending on DEPLOY_LOCK_BEHAVIOR:
+// reject returns an error when the lock is held, queue waits until the lock is free and supersede takes over
+// the lock of a running deployment. Returns the lock id
+func (c *Controller) acquireDeployLock(ctx context.Context, s *service.Service) (string, error) {
+	behavior := strings.ToLower(util.GetEnv("DEPLOY_LOCK_BEHAVIOR", "supersede"))
+	queueTimeout, err := strconv.Atoi(util.GetEnv("DEPLOY_LOCK_QUEUE_TIMEOUT", "600"))
+	if err != nil {
+		return "", err
+	}
+	lockId := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
+	deadline := time.Now().Add(time.Duration(queueTimeout) * time.Second)
+	for {
+		held, err := s.AcquireDeployLock(lockId, behavior == "supersede")
+		if err != nil {
+			return "", err
+		}
+		if held == nil {
+			return lockId, nil
+		}
+		// a deploy that is still starting can't be superseded, supersede waits for it like queue
+		if behavior == "reject" || !time.Now().Before(deadline) {
+			return "", &service.DeployLockedError{ServiceName: s.ServiceName, DeploymentTime: held.DeploymentTime}
+		}
+		c.logger().Debugf("Deploy lock of %v is held, waiting", s.ServiceName)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("Stopped waiting for the deploy lock of %v: %v", s.ServiceName, ctx.Err())
+		case <-time.After(deployLockRetryInterval):
+		}
+	}
+}
+
+// interval between the checks whether the deployments of a wave are finished
+var deployWaveInterval = 15 * time.Second
+
+// DeployServices deploys the waves of services (see service.GetDeployWaves). The first wave is deployed right away,
+// the next wave is deployed in the background once all deployments of the previous wave succeeded. Services that
+// are already in errors (e.g. failed validation) are not deployed and halt the waves after them. The progress of the
+// waves is stored in a wave deploy, so the next waves are resumed after a restart.
+// Returns the results of the first wave, the services that are queued in the next waves and the id of the wave deploy
+func (c *Controller) DeployServices(ctx context.Context, waves [][]service.Deploy, errors map[string]string) ([]*service.DeployResult, []string, string) {
+	var queued []string
+	if len(waves) == 0 {
+		return nil, queued, ""
+	}
+	if len(waves) == 1 {
+		return c.deployWave(ctx, waves[0], errors), queued, ""
+	}
+	s := c.newService()
+	w := service.NewWaveDeploy(waves, errors)
+	if err := s.CreateWaveDeploy(w); err != nil {
+		c.logger().Errorf("Could not store wave deploy: %v", err)
+		for _, wave := range waves {
+			for _, d := range wave {
+				if _, ok := errors[d.ServiceName]; !ok {
+					errors[d.ServiceName] = "Not deployed: could not store the wave deploy: " + err.Error()
+				}
+			}
+		}
+		return nil, queued, ""
+	}
+	results := c.deployQueued(ctx, w, 0, errors)
+	if w.IsWaveFailed(0) {
+		w.Halt("Not deployed: a service of a previous wave failed")
+		for _, wave := range waves[1:] {
+			for _, d := range wave {
+				if _, ok := errors[d.ServiceName]; !ok {
+					errors[d.ServiceName] = "Not deployed: a service of a previous wave failed"
+				}
+			}
+		}
+		c.updateWaveDeploy(s, w)
+		return results, queued, w.Id
+	}
+	if !c.updateWaveDeploy(s, w) {
+		return results, queued, w.Id
+	}
+	for _, wave := range waves[1:] {
+		for _, d := range wave {
+			queued = append(queued, d.ServiceName)
+		}
+	}
+	// the wave deploy is changed in the background, it can't be returned
+	go c.runWaveDeploy(w)
+	return results, queued, w.Id
+}
+
+func (c *Controller) deployWave(ctx context.Context, wave []service.Deploy, errors map[string]string) []*service.DeployResult {
+	var results []*service.DeployResult
+	for _, d := range wave {
+		if _, ok := errors[d.ServiceName]; ok {
+			continue
+		}
+		res, err := c.DeployWithContext(ctx, d.ServiceName, d)
+		if err != nil {
+			errors[d.ServiceName] = err.Error()
+		} else {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// deployQueued deploys the queued services of the wave and sets their status in the wave deploy. The services that
+// were already in errors are failed
+func (c *Controller) deployQueued(ctx context.Context, w *service.DynamoWaveDeploy, wave int64, errors map[string]string) []*service.DeployResult {
+	deploys, waveErrors := w.GetQueued(wave)
+	if len(deploys) == 0 {
+		return nil
+	}
+	for serviceName, err := range waveErrors {
+		errors[serviceName] = err
+	}
+	results := c.deployWave(ctx, deploys, errors)
+	for _, res := range results {
+		w.SetServiceRunning(res.ServiceName, res.DeploymentTime)
+	}
+	for _, d := range deploys {
+		if err, ok := errors[d.ServiceName]; ok {
+			w.SetServiceStatus(d.ServiceName, service.WaveDeployStatusFailed, err)
+		}
+	}
+	return results
+}
+
+// updateWaveDeploy stores the progress of the wave deploy, returns false when another ecs-deploy took over the
+// wave deploy (e.g. after a restart)
+func (c *Controller) updateWaveDeploy(s *service.Service, w *service.DynamoWaveDeploy) bool {
+	err := s.UpdateWaveDeploy(w)
+	if err == service.ErrConditionalCheckFailed {
+		c.logger().Infof("Wave deploy %v was updated by another ecs-deploy, stopping", w.Id)
+		return false
+	}
+	if err != nil {
+		// the progress is stored with the next update
+		c.logger().Errorf("Could not store progress of wave deploy %v: %v", w.Id, err)
+	}
+	return true
+}
+
+// runWaveDeploy waits for the running deployments and deploys the queued services, one wave at a time. A failed
+// service halts the waves after it
+func (c *Controller) runWaveDeploy(w *service.DynamoWaveDeploy) {
+	s := c.newService()
+	for wave := int64(0); wave < w.GetWaves(); wave++ {
+		deploys, _ := w.GetQueued(wave)
+		if len(deploys) > 0 {
+			var serviceNames []string
+			for _, d := range deploys {
+				serviceNames = append(serviceNames, d.ServiceName)
+			}
+			c.logger().Infof("Deploying wave: %v", strings.Join(serviceNames, ", "))
+			errors := make(map[string]string)
+			c.deployQueued(context.Background(), w, wave, errors)
+			for serviceName, err := range errors {
+				c.logger().Errorf("Could not deploy %v: %v", serviceName, err)
+			}
+			if !c.updateWaveDeploy(s, w) {
+				return
+			}
+		}
+		c.waitForWave(w, wave)
+		if w.IsWaveFailed(wave) {
+			c.logger().Errorf("Deployment of wave failed, halting the next waves of wave deploy %v", w.Id)
+			w.Halt("Not deployed: a service of a previous wave failed")
+			c.updateWaveDeploy(s, w)
+			return
+		}
+		if !c.updateWaveDeploy(s, w) {
+			return
+		}
+	}
+	w.Status = service.WaveDeployStatusSuccess
+	c.updateWaveDeploy(s, w)
+}
+
+// resumeWaveDeploy takes over the wave deploy after a restart, when another ecs-deploy took it over already it's not
+// resumed
+func (c *Controller) resumeWaveDeploy(w *service.DynamoWaveDeploy) {
+	if err := c.newService().UpdateWaveDeploy(w); err != nil {
+		c.logger().Infof("Not resuming wave deploy %v: %v", w.Id, err)
+		return
+	}
+	c.runWaveDeploy(w)
+}
+
+// waitForWave waits until the running deployments of the wave are finished and sets their status in the wave deploy
+func (c *Controller) waitForWave(w *service.DynamoWaveDeploy, wave int64) {
+	s := c.newService()
+	for _, ws := range w.GetRunning(wave) {
+		for {
+			dd, err := s.GetDeployment(ws.ServiceName, ws.DeploymentTime.UTC().Format(time.RFC3339Nano))
+			if err != nil {
+				c.logger().Errorf("Could not get deployment of %v: %v", ws.ServiceName, err)
+				w.SetServiceStatus(ws.ServiceName, service.WaveDeployStatusFailed, "Could not get deployment: "+err.Error())
+				break
+			}
+			if dd.Status == "success" {
+				w.SetServiceStatus(ws.ServiceName, service.WaveDeployStatusSuccess, "")
+				break
+			}
+			if dd.Status != "running" {
+				c.logger().Infof("Deployment of %v has status %v", ws.ServiceName, dd.Status)
+				w.SetServiceStatus(ws.ServiceName, service.WaveDeployStatusFailed, "Deployment has status "+dd.Status)
+				break
+			}
+			time.Sleep(deployWaveInterval)
+		}
+	}
+}
+
+func (c *Controller) deploy(serviceName string, d service.Deploy) (*service.DeployResult, error) {
 	// get last deployment
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	s.ClusterName = d.Cluster
 	ddLast, err := s.GetLastDeploy()
 	if err != nil {
-		if !strings.HasPrefix(err.Error(), "NoItemsFound") {
-			controllerLogger.Errorf("Error while getting last deployment for %v: %v", serviceName, err)
+		if err != service.ErrNoDeploymentFound {
+			c.logger().Errorf("Error while getting last deployment for %v: %v", serviceName, err)
 			return nil, err
 		}
 	}
-	// validate
-	for _, container := range d.Containers {
-		if container.Memory == 0 && container.MemoryReservation == 0 {
-			controllerLogger.Errorf("Could not deploy %v: Memory / MemoryReservation not set", serviceName)
-			return nil, errors.New("At least one of 'memory' or 'memoryReservation' must be specified within the container specification.")
-		}
+	err = checkDeploymentStrategyChange(d, ddLast)
+	if err != nil {
+		return nil, err
 	}
 
 	// create role if role doesn't exists
@@ -62,7 +324,7 @@ func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.Depl
 	if err == nil && iamRoleArn == nil {
 		if util.GetEnv("AWS_RESOURCE_CREATION_ENABLED", "yes") == "yes" {
 			// role does not exist, create it
-			controllerLogger.Debugf("Role does not exist, creating: ecs-%v", serviceName)
+			c.logger().Debugf("Role does not exist, creating: ecs-%v", serviceName)
 			iamRoleArn, err = iam.CreateRole("ecs-"+serviceName, iam.GetEcsTaskIAMTrust())
 			if err != nil {
 				return nil, err
@@ -74,7 +336,7 @@ func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.Depl
 				if namespace == "" {
 					namespace = serviceName
 				}
-				controllerLogger.Debugf("Paramstore enabled, putting role: paramstore-%v", namespace)
+				c.logger().Debugf("Paramstore enabled, putting role: paramstore-%v", namespace)
 				err = iam.PutRolePolicy("ecs-"+serviceName, "paramstore-"+namespace, ps.GetParamstoreIAMPolicy(namespace))
 				if err != nil {
 					return nil, err
@@ -89,24 +351,32 @@ func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.Depl
 
 	// create task definition
 	e := ecs.ECS{ServiceName: serviceName, IamRoleArn: *iamRoleArn, ClusterName: d.Cluster}
+	e.ExecutionRoleArn, err = c.putExecutionRole(serviceName, d, ddLast)
+	if err != nil {
+		return nil, err
+	}
 	taskDefArn, err := e.CreateTaskDefinition(d)
 	if err != nil {
-		controllerLogger.Errorf("Could not create task def %v", serviceName)
+		c.logger().Errorf("Could not create task def %v", serviceName)
 		return nil, err
 	}
-	controllerLogger.Debugf("Created task definition: %v", *taskDefArn)
+	c.logger().Debugf("Created task definition: %v", *taskDefArn)
 
 	// update service with new task (update desired instance in case of difference)
-	controllerLogger.Debugf("Updating service: %v with taskdefarn: %v", serviceName, *taskDefArn)
+	c.logger().Debugf("Updating service: %v with taskdefarn: %v", serviceName, *taskDefArn)
+	var canary bool
 	serviceExists, err := e.ServiceExists(serviceName)
 	if err == nil && !serviceExists {
-		controllerLogger.Debugf("service (%v) not found, creating...", serviceName)
+		c.logger().Debugf("service (%v) not found, creating...", serviceName)
 		if util.GetEnv("AWS_RESOURCE_CREATION_ENABLED", "yes") == "yes" {
 			s.Listeners, err = c.createService(serviceName, d, taskDefArn)
 			if err != nil {
-				controllerLogger.Errorf("Could not create service %v", serviceName)
+				c.logger().Errorf("Could not create service %v", serviceName)
 				return nil, err
 			}
+			if strings.ToLower(d.DeploymentStrategy) == "bluegreen" {
+				s.Color = ecs.ColorBlue
+			}
 		} else {
 			return nil, errors.New("ECS Service not found and resource creation is disabled")
 		}
@@ -117,18 +387,37 @@ func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.Depl
 		if err == nil && !serviceExistsInDynamo {
 			err = c.createServiceInDynamo(s, d)
 			if err != nil {
-				controllerLogger.Errorf("Could not create service %v in dynamodb", serviceName)
+				c.logger().Errorf("Could not create service %v in dynamodb", serviceName)
+				return nil, err
+			}
+		}
+		if strings.ToLower(d.DeploymentStrategy) == "bluegreen" {
+			s.Color, err = c.updateBlueGreenDeployment(d, ddLast, serviceName, taskDefArn)
+			if err != nil {
+				c.logger().Errorf("Could not deploy %v: %v", serviceName, err)
+				return nil, err
+			}
+		} else if strings.ToLower(d.DeploymentStrategy) == "canary" {
+			err = c.updateCanaryDeployment(d, ddLast, serviceName, taskDefArn)
+			if err != nil {
+				c.logger().Errorf("Could not deploy %v: %v", serviceName, err)
+				return nil, err
+			}
+			canary = true
+		} else {
+			err = c.updateDeployment(d, ddLast, serviceName, taskDefArn, iamRoleArn)
+			if err != nil {
+				c.logger().Errorf("Could not deploy %v: %v", serviceName, err)
 				return nil, err
 			}
 		}
-		c.updateDeployment(d, ddLast, serviceName, taskDefArn, iamRoleArn)
 	}
 
 	// Mark previous deployment as aborted if still running
 	if ddLast != nil && ddLast.Status == "running" {
 		err = s.SetDeploymentStatus(ddLast, "aborted")
 		if err != nil {
-			controllerLogger.Errorf("Could not set status of %v to aborted: %v", serviceName, err)
+			c.logger().Errorf("Could not set status of %v to aborted: %v", serviceName, err)
 			return nil, err
 		}
 	}
@@ -136,12 +425,27 @@ func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.Depl
 	// write changes in db
 	dd, err := s.NewDeployment(taskDefArn, &d)
 	if err != nil {
-		controllerLogger.Errorf("Could not create/update service (%v) in db: %v", serviceName, err)
+		c.logger().Errorf("Could not create/update service (%v) in db: %v", serviceName, err)
 		return nil, err
 	}
 
+	// record the canary steps
+	if canary {
+		err = s.SetCanaryProgress(dd, service.DynamoDeploymentCanary{Steps: int64(len(ecs.GetCanarySteps(d.Canary)))})
+		if err != nil {
+			c.logger().Errorf("Could not create/update service (%v) in db: %v", serviceName, err)
+			return nil, err
+		}
+	}
+
 	// run goroutine to update status of service
-	go e.LaunchWaitUntilServicesStable(dd)
+	if dd.Color != "" {
+		go e.LaunchWaitUntilBlueGreenStable(dd)
+	} else if dd.Canary != nil {
+		go e.LaunchCanaryDeployment(dd)
+	} else {
+		go e.LaunchWaitUntilServicesStable(dd)
+	}
 
 	ret := &service.DeployResult{
 		ServiceName:       serviceName,
@@ -152,15 +456,203 @@ func (c *Controller) Deploy(serviceName string, d service.Deploy) (*service.Depl
 	return ret, nil
 }
 
+// the rolling update would deploy to the blue color, which doesn't necessarily receive the traffic
+func checkDeploymentStrategyChange(d service.Deploy, ddLast *service.DynamoDeployment) error {
+	if ddLast != nil && strings.ToLower(ddLast.DeployData.DeploymentStrategy) == "bluegreen" && strings.ToLower(d.DeploymentStrategy) != "bluegreen" {
+		return newBadRequestError("Changing the deploymentStrategy of a blueGreen service is not supported")
+	}
+	return nil
+}
+
+// isLoadBalancerChange returns true if the service moves to another loadbalancer, which recreates the service
+func isLoadBalancerChange(d service.Deploy, ddLast *service.DynamoDeployment) bool {
+	if strings.ToLower(d.ServiceProtocol) == "none" {
+		return false
+	}
+	if ddLast.DeployData.LoadBalancer == "" && strings.ToLower(d.LoadBalancer) == strings.ToLower(d.Cluster) {
+		return false
+	}
+	return strings.ToLower(d.LoadBalancer) != strings.ToLower(ddLast.DeployData.LoadBalancer)
+}
+
+// getListenerProtocols returns the protocols of the listeners of the loadbalancer of the service
+func (c *Controller) getListenerProtocols(d service.Deploy) ([]string, error) {
+	var alb *ecs.ALB
+	var err error
+	protocols := []string{}
+	if d.LoadBalancer == "" {
+		alb, err = ecs.NewALB(d.Cluster)
+	} else {
+		alb, err = ecs.NewALB(d.LoadBalancer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, listener := range alb.Listeners {
+		protocols = append(protocols, strings.ToLower(aws.StringValue(listener.Protocol)))
+	}
+	return protocols, nil
+}
+
+// putExecutionRole creates the execution role of the service if it doesn't exist. The execution role is used by ecs
+// to pull the images, write the logs and retrieve the secrets and repository credentials of the containers
+func (c *Controller) putExecutionRole(serviceName string, d service.Deploy, ddLast *service.DynamoDeployment) (string, error) {
+	iam := ecs.IAM{}
+	roleName := "ecs-exec-" + serviceName
+	executionRoleArn, err := iam.RoleExists(roleName)
+	if err != nil {
+		return "", err
+	}
+	if executionRoleArn == nil {
+		if util.GetEnv("AWS_RESOURCE_CREATION_ENABLED", "yes") != "yes" {
+			return "", errors.New("IAM Task Execution Role not found and resource creation is disabled")
+		}
+		c.logger().Debugf("Execution role does not exist, creating: %v", roleName)
+		executionRoleArn, err = iam.CreateRole(roleName, iam.GetEcsTaskIAMTrust())
+		if err != nil {
+			return "", err
+		}
+	}
+	err = iam.AttachRolePolicy(roleName, iam.GetEcsTaskExecutionPolicy())
+	if err != nil {
+		return "", err
+	}
+	// the secrets policy only allows reading the secrets of this deploy
+	err = iam.GetAccountId()
+	if err != nil {
+		return "", err
+	}
+	ps := ecs.Paramstore{}
+	secretArns := ps.GetSecretArns(d, serviceName, iam.AccountId)
+	if len(secretArns) > 0 {
+		err = iam.PutRolePolicy(roleName, "secrets", iam.GetSecretsIAMPolicy(secretArns))
+		if err != nil {
+			return "", err
+		}
+	} else if ddLast != nil && len(ps.GetSecretArns(*ddLast.DeployData, serviceName, iam.AccountId)) > 0 {
+		c.logger().Debugf("No secrets anymore, deleting secrets policy of %v", roleName)
+		err = iam.DeleteRolePolicy(roleName, "secrets")
+		if err != nil {
+			return "", err
+		}
+	}
+	return aws.StringValue(executionRoleArn), nil
+}
+
+// planDeploy returns the changes a deploy would make to the service, compared with the last deployment and the
+// live ecs service, without making them
+func (c *Controller) planDeploy(serviceName string, d service.Deploy) (*service.DeployPlan, error) {
+	s := c.newService()
+	s.ServiceName = serviceName
+	s.ClusterName = d.Cluster
+	plan := &service.DeployPlan{ServiceName: serviceName}
+	ddLast, err := s.GetLastDeploy()
+	if err != nil {
+		if err != service.ErrNoDeploymentFound {
+			return nil, err
+		}
+	}
+	err = checkDeploymentStrategyChange(d, ddLast)
+	if err != nil {
+		return nil, err
+	}
+	iam := ecs.IAM{}
+	iamRoleArn, err := iam.RoleExists("ecs-" + serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if iamRoleArn == nil {
+		plan.Changes = append(plan.Changes, service.DeployPlanChange{Field: "iamRole", After: "ecs-" + serviceName, Effect: "role created"})
+	}
+	executionRoleArn, err := iam.RoleExists("ecs-exec-" + serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if executionRoleArn == nil {
+		plan.Changes = append(plan.Changes, service.DeployPlanChange{Field: "executionRole", After: "ecs-exec-" + serviceName, Effect: "role created"})
+	}
+	e := ecs.ECS{ServiceName: serviceName, ClusterName: d.Cluster}
+	serviceExists, err := e.ServiceExists(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if !serviceExists {
+		plan.Action = "create"
+		return plan, nil
+	}
+	plan.Action = "update"
+	if ddLast == nil {
+		plan.Warnings = append(plan.Warnings, "Service was not deployed with ecs-deploy before, changes can't be determined")
+		return plan, nil
+	}
+	changes, err := service.DiffDeploy(*ddLast.DeployData, d)
+	if err != nil {
+		return nil, err
+	}
+	rolling := strings.ToLower(d.DeploymentStrategy) != "bluegreen" && strings.ToLower(d.DeploymentStrategy) != "canary"
+	for _, change := range changes {
+		if change.Field == "loadBalancer" {
+			if !isLoadBalancerChange(d, ddLast) {
+				continue
+			}
+			if rolling {
+				plan.Action = "recreate"
+			} else {
+				change.Effect = "not applied to existing service"
+			}
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	// compare with the live resources, they can be changed outside of ecs-deploy
+	if ddLast.Color == "" {
+		runningService, err := e.DescribeService(d.Cluster, serviceName, false, false, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, deployment := range runningService.Deployments {
+			if deployment.Status == "PRIMARY" && deployment.TaskDefinition != aws.StringValue(ddLast.TaskDefinitionArn) {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("Service runs task definition %v instead of %v of the last deployment", deployment.TaskDefinition, aws.StringValue(ddLast.TaskDefinitionArn)))
+			}
+		}
+	}
+	if strings.ToLower(d.ServiceProtocol) != "none" && !isLoadBalancerChange(d, ddLast) {
+		var alb *ecs.ALB
+		if ddLast.DeployData.LoadBalancer == "" {
+			alb, err = ecs.NewALB(ddLast.DeployData.Cluster)
+		} else {
+			alb, err = ecs.NewALB(ddLast.DeployData.LoadBalancer)
+		}
+		if err != nil {
+			return nil, err
+		}
+		targetGroupArn, err := alb.FindTargetGroupArn(serviceName)
+		if err != nil {
+			return nil, err
+		}
+		if targetGroupArn == nil {
+			plan.Warnings = append(plan.Warnings, "Target group "+serviceName+" not found")
+		}
+	}
+	return plan, nil
+}
+
 func (c *Controller) updateDeployment(d service.Deploy, ddLast *service.DynamoDeployment, serviceName string, taskDefArn *string, iamRoleArn *string) error {
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	s.ClusterName = d.Cluster
 	e := ecs.ECS{ServiceName: serviceName, IamRoleArn: *iamRoleArn, ClusterName: d.Cluster, TaskDefArn: taskDefArn}
 	updateECSService := true
+	var removedLoadBalancers []service.DeployLoadBalancer
 	// compare with previous deployment if there is one
 	if ddLast != nil {
 		var err error
+		removedLoadBalancers, err = c.updateLoadBalancers(serviceName, d, ddLast, &e)
+		if err != nil {
+			return err
+		}
+		var listeners []string
+		var rulesChanged bool
 		if strings.ToLower(d.ServiceProtocol) != "none" {
 			var alb *ecs.ALB
 			if d.LoadBalancer == "" {
@@ -174,7 +666,7 @@ func (c *Controller) updateDeployment(d service.Deploy, ddLast *service.DynamoDe
 			}
 			// update healthchecks if changed
 			if !cmp.Equal(ddLast.DeployData.HealthCheck, d.HealthCheck) {
-				controllerLogger.Debugf("Updating ecs healthcheck: %v", serviceName)
+				c.logger().Debugf("Updating ecs healthcheck: %v", serviceName)
 				alb.UpdateHealthCheck(*targetGroupArn, d.HealthCheck)
 			}
 			// update target group attributes if changed
@@ -185,12 +677,8 @@ func (c *Controller) updateDeployment(d service.Deploy, ddLast *service.DynamoDe
 				}
 			}
 			// update loadbalancer if changed
-			var noLBChange bool
-			if ddLast.DeployData.LoadBalancer == "" && strings.ToLower(d.LoadBalancer) == strings.ToLower(d.Cluster) {
-				noLBChange = true
-			}
-			if strings.ToLower(d.LoadBalancer) != strings.ToLower(ddLast.DeployData.LoadBalancer) && !noLBChange && strings.ToLower(d.ServiceProtocol) != "none" {
-				controllerLogger.Infof("LoadBalancer change detected for service %s", serviceName)
+			if isLoadBalancerChange(d, ddLast) {
+				c.logger().Infof("LoadBalancer change detected for service %s", serviceName)
 				// delete old loadbalancer rules
 				var oldAlb *ecs.ALB
 				if ddLast.DeployData.LoadBalancer == "" {
@@ -198,107 +686,365 @@ func (c *Controller) updateDeployment(d service.Deploy, ddLast *service.DynamoDe
 				} else {
 					oldAlb, err = ecs.NewALB(ddLast.DeployData.LoadBalancer)
 				}
-				err = c.deleteRulesForTarget(serviceName, d, targetGroupArn, oldAlb)
+				err = checkLoadBalancer(d, alb)
+				if err != nil {
+					return err
+				}
+				err = c.deleteRulesForTarget(serviceName, *ddLast.DeployData, targetGroupArn, oldAlb)
+				if err != nil {
+
+				}
+				// delete target group
+				c.logger().Debugf("Deleting target group for service: %v", serviceName)
+				err = oldAlb.DeleteTargetGroup(*targetGroupArn)
+				if err != nil {
+					return err
+				}
+				// create new target group
+				c.logger().Debugf("Creating target group for service: %v", serviceName)
+				newTargetGroupArn, err := alb.CreateTargetGroup(serviceName, d)
+				if err != nil {
+					return err
+				}
+				// modify target group attributes
+				if d.DeregistrationDelay != -1 || d.Stickiness.Enabled {
+					err = alb.ModifyTargetGroupAttributes(*newTargetGroupArn, d)
+					if err != nil {
+						return err
+					}
+				}
+				// create new rules
+				listeners, err = c.createRulesForTarget(serviceName, d, newTargetGroupArn, alb)
+				s.Listeners = listeners
+				if err != nil {
+					return err
+				}
+				// recreating ecs service
+				c.logger().Infof("Recreating ecs service: %v", serviceName)
+				err = e.DeleteService(d.Cluster, serviceName)
+				if err != nil {
+					return err
+				}
+				err = e.WaitUntilServicesInactive(d.Cluster, serviceName)
+				if err != nil {
+					return err
+				}
+				// create ecs service
+				e.TargetGroupArn = newTargetGroupArn
+				err = e.CreateService(d)
+				if err != nil {
+					return err
+				}
+				// don't update ecs service later
+				updateECSService = false
+				rulesChanged = true
+			} else {
+				// apply the changes of the rule conditions
+				listeners, rulesChanged, err = c.reconcileRulesForTarget(serviceName, d, targetGroupArn, alb)
 				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, lb := range d.LoadBalancers {
+			lbListeners, changed, err := c.reconcileLoadBalancerRules(serviceName, d, lb)
+			if err != nil {
+				return err
+			}
+			listeners = append(listeners, lbListeners...)
+			rulesChanged = rulesChanged || changed
+		}
+		// update listeners
+		if rulesChanged {
+			err = s.UpdateServiceListeners(s.ClusterName, s.ServiceName, listeners)
+			if err != nil {
+				c.logger().Errorf("Could not update the listeners of service %v: %v", serviceName, err)
+			}
+		}
+		err = c.updateServiceProperties(d, ddLast, serviceName)
+		if err != nil {
+			return err
+		}
+	}
+	// update service
+	if updateECSService {
+		var err error
+		_, err = e.UpdateService(serviceName, taskDefArn, d)
+		c.logger().Debugf("Updating ecs service: %v", serviceName)
+		if err != nil {
+			c.logger().Errorf("Could not update service %v: %v", serviceName, err)
+			return err
+		}
+	}
+	// the ecs service doesn't use the target groups of the removed loadBalancers anymore
+	for _, lb := range removedLoadBalancers {
+		err := c.deleteLoadBalancerTargetGroup(serviceName, *ddLast.DeployData, lb)
+		if err != nil {
+			c.logger().Errorf("Could not delete target group of port %d of service %v: %v", lb.ContainerPort, serviceName, err)
+		}
+	}
+	return nil
+}
+
+// update the paramstore policy and the container limits if they changed since the last deployment
+func (c *Controller) updateServiceProperties(d service.Deploy, ddLast *service.DynamoDeployment, serviceName string) error {
+	var err error
+	s := c.newService()
+	s.ServiceName = serviceName
+	s.ClusterName = d.Cluster
+	e := ecs.ECS{}
+	ps := ecs.Paramstore{}
+	if ps.IsEnabled() {
+		iam := ecs.IAM{}
+		thisNamespace, lastNamespace := d.EnvNamespace, ddLast.DeployData.EnvNamespace
+		if thisNamespace == "" {
+			thisNamespace = serviceName
+		}
+		if lastNamespace == "" {
+			lastNamespace = serviceName
+		}
+		if thisNamespace != lastNamespace {
+			c.logger().Debugf("Paramstore enabled, putting role: paramstore-%v", serviceName)
+			err = iam.DeleteRolePolicy("ecs-"+serviceName, "paramstore-"+lastNamespace)
+			if err != nil {
+				return err
+			}
+			err = iam.PutRolePolicy("ecs-"+serviceName, "paramstore-"+thisNamespace, ps.GetParamstoreIAMPolicy(thisNamespace))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// update memory limits if changed
+	if !e.IsEqualContainerLimits(d, *ddLast.DeployData) {
+		cpuReservation, cpuLimit, memoryReservation, memoryLimit := e.GetContainerLimits(d)
+		s.UpdateServiceLimits(s.ClusterName, s.ServiceName, cpuReservation, cpuLimit, memoryReservation, memoryLimit)
+	}
+	return nil
+}
+
+// deploy the new task definition to the color that doesn't receive traffic, returns the color that is deployed to
+func (c *Controller) updateBlueGreenDeployment(d service.Deploy, ddLast *service.DynamoDeployment, serviceName string, taskDefArn *string) (string, error) {
+	var alb *ecs.ALB
+	var err error
+	if d.LoadBalancer == "" {
+		alb, err = ecs.NewALB(d.Cluster)
+	} else {
+		alb, err = ecs.NewALB(d.LoadBalancer)
+	}
+	if err != nil {
+		return "", err
+	}
+	if ddLast != nil && strings.ToLower(d.LoadBalancer) != strings.ToLower(ddLast.DeployData.LoadBalancer) {
+		return "", newBadRequestError("LoadBalancer changes are not supported with blueGreen deployments")
+	}
+	activeColor, err := alb.GetActiveColor(serviceName)
+	if err != nil {
+		return "", err
+	}
+	color := ecs.GetOtherColor(activeColor)
+	newServiceName := ecs.GetBlueGreenServiceName(serviceName, color)
+	e := ecs.ECS{ServiceName: newServiceName, ContainerName: serviceName, ClusterName: d.Cluster, TaskDefArn: taskDefArn}
+
+	// start with the same amount of tasks as the active color, autoscaling might have scaled it up
+	activeService, err := e.DescribeService(d.Cluster, ecs.GetBlueGreenServiceName(serviceName, activeColor), false, false, false)
+	if err != nil {
+		return "", err
+	}
+	if activeService.DesiredCount > d.DesiredCount {
+		d.DesiredCount = activeService.DesiredCount
+	}
+
+	// create or update the target group of the new color
+	targetGroupArn, err := alb.FindTargetGroupArn(newServiceName)
+	if err != nil {
+		return "", err
+	}
+	if targetGroupArn == nil {
+		c.logger().Debugf("Creating target group for service: %v", newServiceName)
+		targetGroupArn, err = alb.CreateTargetGroup(newServiceName, d)
+	} else {
+		err = alb.UpdateHealthCheck(*targetGroupArn, d.HealthCheck)
+	}
+	if err != nil {
+		return "", err
+	}
+	if d.DeregistrationDelay != -1 || d.Stickiness.Enabled {
+		err = alb.ModifyTargetGroupAttributes(*targetGroupArn, d)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// create or update the ecs service of the new color
+	serviceExists, err := e.ServiceExists(newServiceName)
+	if err != nil {
+		return "", err
+	}
+	if !serviceExists {
+		c.logger().Debugf("Creating ecs service: %v", newServiceName)
+		e.TargetGroupArn = targetGroupArn
+		err = e.CreateService(d)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		c.logger().Debugf("Updating ecs service: %v", newServiceName)
+		_, err = e.UpdateService(newServiceName, taskDefArn, d)
+		if err != nil {
+			return "", err
+		}
+		err = e.ManualScaleService(d.Cluster, newServiceName, d.DesiredCount)
+		if err != nil {
+			return "", err
+		}
+	}
+	if ddLast != nil {
+		err = c.updateServiceProperties(d, ddLast, serviceName)
+		if err != nil {
+			return "", err
+		}
+	}
+	return color, nil
+}
+
+// deploy the new task definition to the canary service, the traffic is shifted to the canary by LaunchCanaryDeployment
+func (c *Controller) updateCanaryDeployment(d service.Deploy, ddLast *service.DynamoDeployment, serviceName string, taskDefArn *string) error {
+	var alb *ecs.ALB
+	var err error
+	if d.LoadBalancer == "" {
+		alb, err = ecs.NewALB(d.Cluster)
+	} else {
+		alb, err = ecs.NewALB(d.LoadBalancer)
+	}
+	if err != nil {
+		return err
+	}
+	if ddLast != nil && strings.ToLower(d.LoadBalancer) != strings.ToLower(ddLast.DeployData.LoadBalancer) {
+		return newBadRequestError("LoadBalancer changes are not supported with canary deployments")
+	}
+	primaryTargetGroupArn, err := alb.GetTargetGroupArn(serviceName)
+	if err != nil {
+		return err
+	}
+	if ddLast != nil && !cmp.Equal(ddLast.DeployData.HealthCheck, d.HealthCheck) {
+		c.logger().Debugf("Updating ecs healthcheck: %v", serviceName)
+		err = alb.UpdateHealthCheck(*primaryTargetGroupArn, d.HealthCheck)
+		if err != nil {
+			return err
+		}
+	}
+	if ddLast != nil && (!cmp.Equal(ddLast.DeployData.Stickiness, d.Stickiness) || ddLast.DeployData.DeregistrationDelay != d.DeregistrationDelay) {
+		err = alb.ModifyTargetGroupAttributes(*primaryTargetGroupArn, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	canaryServiceName := ecs.GetCanaryServiceName(serviceName)
+	e := ecs.ECS{ServiceName: canaryServiceName, ContainerName: serviceName, ClusterName: d.Cluster, TaskDefArn: taskDefArn}
+
+	// start with the same amount of tasks as the primary service, autoscaling might have scaled it up
+	primaryService, err := e.DescribeService(d.Cluster, serviceName, false, false, false)
+	if err != nil {
+		return err
+	}
+	if primaryService.DesiredCount > d.DesiredCount {
+		d.DesiredCount = primaryService.DesiredCount
+	}
+
+	// create or update the canary target group, a previous canary that is still running doesn't receive traffic anymore
+	canaryTargetGroupArn, err := alb.FindTargetGroupArn(canaryServiceName)
+	if err != nil {
+		return err
+	}
+	if canaryTargetGroupArn == nil {
+		c.logger().Debugf("Creating target group for service: %v", canaryServiceName)
+		canaryTargetGroupArn, err = alb.CreateTargetGroup(canaryServiceName, d)
+	} else {
+		err = alb.SetCanaryWeight(*primaryTargetGroupArn, *canaryTargetGroupArn, 0)
+		if err != nil {
+			return err
+		}
+		err = alb.UpdateHealthCheck(*canaryTargetGroupArn, d.HealthCheck)
+	}
+	if err != nil {
+		return err
+	}
+	if d.DeregistrationDelay != -1 || d.Stickiness.Enabled {
+		err = alb.ModifyTargetGroupAttributes(*canaryTargetGroupArn, d)
+		if err != nil {
+			return err
+		}
+	}
 
-				}
-				// delete target group
-				controllerLogger.Debugf("Deleting target group for service: %v", serviceName)
-				err = oldAlb.DeleteTargetGroup(*targetGroupArn)
-				if err != nil {
-					return err
-				}
-				// create new target group
-				controllerLogger.Debugf("Creating target group for service: %v", serviceName)
-				newTargetGroupArn, err := alb.CreateTargetGroup(serviceName, d)
-				if err != nil {
-					return err
-				}
-				// modify target group attributes
-				if d.DeregistrationDelay != -1 || d.Stickiness.Enabled {
-					err = alb.ModifyTargetGroupAttributes(*newTargetGroupArn, d)
-					if err != nil {
-						return err
-					}
-				}
-				// create new rules
-				listeners, err := c.createRulesForTarget(serviceName, d, newTargetGroupArn, alb)
-				s.Listeners = listeners
-				if err != nil {
-					return err
-				}
-				// recreating ecs service
-				controllerLogger.Infof("Recreating ecs service: %v", serviceName)
-				err = e.DeleteService(d.Cluster, serviceName)
-				if err != nil {
-					return err
-				}
-				err = e.WaitUntilServicesInactive(d.Cluster, serviceName)
-				if err != nil {
-					return err
-				}
-				// create ecs service
-				e.TargetGroupArn = newTargetGroupArn
-				err = e.CreateService(d)
-				if err != nil {
-					return err
-				}
-				// update listeners
-				s.UpdateServiceListeners(s.ClusterName, s.ServiceName, listeners)
-				// don't update ecs service later
-				updateECSService = false
-			}
+	// create or update the canary ecs service
+	serviceExists, err := e.ServiceExists(canaryServiceName)
+	if err != nil {
+		return err
+	}
+	if !serviceExists {
+		c.logger().Debugf("Creating ecs service: %v", canaryServiceName)
+		e.TargetGroupArn = canaryTargetGroupArn
+		err = e.CreateService(d)
+		if err != nil {
+			return err
 		}
-		ps := ecs.Paramstore{}
-		if ps.IsEnabled() {
-			iam := ecs.IAM{}
-			thisNamespace, lastNamespace := d.EnvNamespace, ddLast.DeployData.EnvNamespace
-			if thisNamespace == "" {
-				thisNamespace = serviceName
-			}
-			if lastNamespace == "" {
-				lastNamespace = serviceName
-			}
-			if thisNamespace != lastNamespace {
-				controllerLogger.Debugf("Paramstore enabled, putting role: paramstore-%v", serviceName)
-				err = iam.DeleteRolePolicy("ecs-"+serviceName, "paramstore-"+lastNamespace)
-				if err != nil {
-					return err
-				}
-				err = iam.PutRolePolicy("ecs-"+serviceName, "paramstore-"+thisNamespace, ps.GetParamstoreIAMPolicy(thisNamespace))
-				if err != nil {
-					return err
-				}
-			}
+	} else {
+		c.logger().Debugf("Updating ecs service: %v", canaryServiceName)
+		_, err = e.UpdateService(canaryServiceName, taskDefArn, d)
+		if err != nil {
+			return err
 		}
-		// update memory limits if changed
-		if !e.IsEqualContainerLimits(d, *ddLast.DeployData) {
-			cpuReservation, cpuLimit, memoryReservation, memoryLimit := e.GetContainerLimits(d)
-			s.UpdateServiceLimits(s.ClusterName, s.ServiceName, cpuReservation, cpuLimit, memoryReservation, memoryLimit)
+		err = e.ManualScaleService(d.Cluster, canaryServiceName, d.DesiredCount)
+		if err != nil {
+			return err
 		}
 	}
-	// update service
-	if updateECSService {
-		var err error
-		_, err = e.UpdateService(serviceName, taskDefArn, d)
-		controllerLogger.Debugf("Updating ecs service: %v", serviceName)
+	if ddLast != nil {
+		err = c.updateServiceProperties(d, ddLast, serviceName)
 		if err != nil {
-			controllerLogger.Errorf("Could not update service %v: %v", serviceName, err)
 			return err
 		}
 	}
 	return nil
 }
-func (c *Controller) redeploy(serviceName, time string) (*service.DeployResult, error) {
-	s := service.NewService()
+
+// move the traffic back to the previous color of a blueGreen deployment
+func (c *Controller) rollbackService(serviceName string) (string, error) {
+	s := c.newService()
+	s.ServiceName = serviceName
+	dd, err := s.GetLastDeploy()
+	if err != nil {
+		return "", err
+	}
+	if strings.ToLower(dd.DeployData.DeploymentStrategy) != "bluegreen" {
+		return "", newBadRequestError("Rollback is only supported for services using blueGreen deployments")
+	}
+	if dd.Status != "success" {
+		return "", newConflictError("Could not rollback, last deployment has status "+dd.Status, nil)
+	}
+	e := ecs.ECS{}
+	color, err := e.RollbackBlueGreen(dd)
+	if err != nil {
+		return "", err
+	}
+	err = s.SetDeploymentStatusWithReason(dd, "failed", "Rolled back to "+color)
+	if err != nil {
+		return "", err
+	}
+	return color, nil
+}
+func (c *Controller) redeploy(ctx context.Context, serviceName, time string) (*service.DeployResult, error) {
+	s := c.newService()
 	dd, err := s.GetDeployment(serviceName, time)
 	if err != nil {
-		return nil, err
+		return nil, getDeploymentError(serviceName, time, err)
 	}
 
-	controllerLogger.Debugf("Redeploying %v_%v", serviceName, time)
+	c.logger().Debugf("Redeploying %v_%v", serviceName, time)
 
-	ret, err := c.Deploy(serviceName, *dd.DeployData)
+	ret, err := c.DeployWithContext(ctx, serviceName, *dd.DeployData)
 
 	if err != nil {
 		return nil, err
@@ -326,7 +1072,11 @@ func (c *Controller) createService(serviceName string, d service.Deploy, taskDef
 	// create target group
 	if strings.ToLower(d.ServiceProtocol) != "none" {
 		var err error
-		controllerLogger.Debugf("Creating target group for service: %v", serviceName)
+		err = checkLoadBalancer(d, alb)
+		if err != nil {
+			return nil, err
+		}
+		c.logger().Debugf("Creating target group for service: %v", serviceName)
 		targetGroupArn, err = alb.CreateTargetGroup(serviceName, d)
 		if err != nil {
 			return nil, err
@@ -346,16 +1096,27 @@ func (c *Controller) createService(serviceName string, d service.Deploy, taskDef
 		}
 	}
 
+	// create target groups of the other ports
+	var targetGroupArns []*string
+	for _, lb := range d.LoadBalancers {
+		lbTargetGroupArn, lbListeners, err := c.createLoadBalancerTargetGroup(serviceName, d, lb)
+		if err != nil {
+			return nil, err
+		}
+		targetGroupArns = append(targetGroupArns, lbTargetGroupArn)
+		listeners = append(listeners, lbListeners...)
+	}
+
 	// check whether ecs-service-role exists
-	controllerLogger.Debugf("Checking whether role exists: %v", util.GetEnv("AWS_ECS_SERVICE_ROLE", "ecs-service-role"))
+	c.logger().Debugf("Checking whether role exists: %v", util.GetEnv("AWS_ECS_SERVICE_ROLE", "ecs-service-role"))
 	iamServiceRoleArn, err := iam.RoleExists(util.GetEnv("AWS_ECS_SERVICE_ROLE", "ecs-service-role"))
 	if err == nil && iamServiceRoleArn == nil {
-		controllerLogger.Debugf("Creating ecs service role")
+		c.logger().Debugf("Creating ecs service role")
 		_, err = iam.CreateRole(util.GetEnv("AWS_ECS_SERVICE_ROLE", "ecs-service-role"), iam.GetEcsServiceIAMTrust())
 		if err != nil {
 			return nil, err
 		}
-		controllerLogger.Debugf("Attaching ecs service role")
+		c.logger().Debugf("Attaching ecs service role")
 		err = iam.AttachRolePolicy(util.GetEnv("AWS_ECS_SERVICE_ROLE", "ecs-service-role"), iam.GetEcsServicePolicy())
 		if err != nil {
 			return nil, err
@@ -365,8 +1126,8 @@ func (c *Controller) createService(serviceName string, d service.Deploy, taskDef
 	}
 
 	// create ecs service
-	controllerLogger.Debugf("Creating ecs service: %v", serviceName)
-	e := ecs.ECS{ServiceName: serviceName, TaskDefArn: taskDefArn, TargetGroupArn: targetGroupArn}
+	c.logger().Debugf("Creating ecs service: %v", serviceName)
+	e := ecs.ECS{ServiceName: serviceName, TaskDefArn: taskDefArn, TargetGroupArn: targetGroupArn, TargetGroupArns: targetGroupArns}
 	err = e.CreateService(d)
 	if err != nil {
 		return nil, err
@@ -374,6 +1135,139 @@ func (c *Controller) createService(serviceName string, d service.Deploy, taskDef
 	return listeners, nil
 }
 
+// createLoadBalancerTargetGroup creates the target group and the rules of one of the loadBalancers of the service
+func (c *Controller) createLoadBalancerTargetGroup(serviceName string, d service.Deploy, lb service.DeployLoadBalancer) (*string, []string, error) {
+	lbDeploy := service.GetLoadBalancerDeploy(d, lb)
+	alb, err := ecs.NewALB(service.GetLoadBalancerName(lbDeploy))
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkLoadBalancer(lbDeploy, alb)
+	if err != nil {
+		return nil, nil, err
+	}
+	targetGroupName := service.GetLoadBalancerTargetGroupName(serviceName, lb)
+	c.logger().Debugf("Creating target group %v for service: %v", targetGroupName, serviceName)
+	targetGroupArn, err := alb.CreateTargetGroup(targetGroupName, lbDeploy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lbDeploy.DeregistrationDelay != -1 || lbDeploy.Stickiness.Enabled {
+		err = alb.ModifyTargetGroupAttributes(*targetGroupArn, lbDeploy)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	listeners, err := c.createRulesForTarget(targetGroupName, lbDeploy, targetGroupArn, alb)
+	if err != nil {
+		return nil, nil, err
+	}
+	return targetGroupArn, listeners, nil
+}
+
+// updateLoadBalancers creates the target groups of the new loadBalancers of the deploy and updates the existing ones.
+// The ecs service is updated when the loadBalancers changed. Returns the loadBalancers that were removed, their target
+// groups can be deleted once the ecs service is updated
+func (c *Controller) updateLoadBalancers(serviceName string, d service.Deploy, ddLast *service.DynamoDeployment, e *ecs.ECS) ([]service.DeployLoadBalancer, error) {
+	var removed []service.DeployLoadBalancer
+	previous := make(map[string]service.DeployLoadBalancer)
+	for _, lb := range ddLast.DeployData.LoadBalancers {
+		previous[service.GetLoadBalancerTargetGroupName(serviceName, lb)] = lb
+	}
+	current := make(map[string]bool)
+	var changed bool
+	e.TargetGroupArns = []*string{}
+	for _, lb := range d.LoadBalancers {
+		targetGroupName := service.GetLoadBalancerTargetGroupName(serviceName, lb)
+		current[targetGroupName] = true
+		lbDeploy := service.GetLoadBalancerDeploy(d, lb)
+		alb, err := ecs.NewALB(service.GetLoadBalancerName(lbDeploy))
+		if err != nil {
+			return nil, err
+		}
+		targetGroupArn, err := alb.FindTargetGroupArn(targetGroupName)
+		if err != nil {
+			return nil, err
+		}
+		lastLb, ok := previous[targetGroupName]
+		if targetGroupArn == nil {
+			targetGroupArn, _, err = c.createLoadBalancerTargetGroup(serviceName, d, lb)
+			if err != nil {
+				return nil, err
+			}
+			changed = true
+		} else if ok {
+			if !cmp.Equal(lastLb.HealthCheck, lb.HealthCheck) {
+				c.logger().Debugf("Updating healthcheck of target group: %v", targetGroupName)
+				alb.UpdateHealthCheck(*targetGroupArn, lb.HealthCheck)
+			}
+			if !cmp.Equal(ddLast.DeployData.Stickiness, d.Stickiness) || ddLast.DeployData.DeregistrationDelay != d.DeregistrationDelay {
+				err = alb.ModifyTargetGroupAttributes(*targetGroupArn, lbDeploy)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !ok || lastLb.ContainerName != lb.ContainerName {
+			changed = true
+		}
+		e.TargetGroupArns = append(e.TargetGroupArns, targetGroupArn)
+	}
+	for _, lb := range ddLast.DeployData.LoadBalancers {
+		if !current[service.GetLoadBalancerTargetGroupName(serviceName, lb)] {
+			removed = append(removed, lb)
+			changed = true
+		}
+	}
+	if changed && strings.ToLower(d.ServiceProtocol) != "none" {
+		alb, err := ecs.NewALB(service.GetLoadBalancerName(d))
+		if err != nil {
+			return nil, err
+		}
+		e.TargetGroupArn, err = alb.GetTargetGroupArn(serviceName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.UpdateLoadBalancers = changed
+	return removed, nil
+}
+
+// reconcileLoadBalancerRules changes the rules of the target group of a loadBalancer to match its rule conditions
+func (c *Controller) reconcileLoadBalancerRules(serviceName string, d service.Deploy, lb service.DeployLoadBalancer) ([]string, bool, error) {
+	lbDeploy := service.GetLoadBalancerDeploy(d, lb)
+	alb, err := ecs.NewALB(service.GetLoadBalancerName(lbDeploy))
+	if err != nil {
+		return nil, false, err
+	}
+	targetGroupName := service.GetLoadBalancerTargetGroupName(serviceName, lb)
+	targetGroupArn, err := alb.FindTargetGroupArn(targetGroupName)
+	if err != nil || targetGroupArn == nil {
+		return nil, false, err
+	}
+	return c.reconcileRulesForTarget(targetGroupName, lbDeploy, targetGroupArn, alb)
+}
+
+// deleteLoadBalancerTargetGroup deletes the rules and the target group of a loadBalancer that was removed from the deploy
+func (c *Controller) deleteLoadBalancerTargetGroup(serviceName string, d service.Deploy, lb service.DeployLoadBalancer) error {
+	lbDeploy := service.GetLoadBalancerDeploy(d, lb)
+	alb, err := ecs.NewALB(service.GetLoadBalancerName(lbDeploy))
+	if err != nil {
+		return err
+	}
+	targetGroupName := service.GetLoadBalancerTargetGroupName(serviceName, lb)
+	targetGroupArn, err := alb.FindTargetGroupArn(targetGroupName)
+	if err != nil || targetGroupArn == nil {
+		return err
+	}
+	err = c.deleteRulesForTarget(targetGroupName, lbDeploy, targetGroupArn, alb)
+	if err != nil {
+		return err
+	}
+	c.logger().Debugf("Deleting target group %v of service: %v", targetGroupName, serviceName)
+	return alb.DeleteTargetGroup(*targetGroupArn)
+}
+
 func (c *Controller) createServiceInDynamo(s *service.Service, d service.Deploy) error {
 	var err error
 	e := ecs.ECS{ServiceName: s.ServiceName}
@@ -383,92 +1277,133 @@ func (c *Controller) createServiceInDynamo(s *service.Service, d service.Deploy)
 
 	err = s.CreateService(dsEl)
 	if err != nil {
-		controllerLogger.Errorf("Could not create/update service (%v) in db: %v", s.ServiceName, err)
+		c.logger().Errorf("Could not create/update service (%v) in db: %v", s.ServiceName, err)
 		return err
 	}
 	return nil
 }
 
-// Deploy rules for a specific targetGroup
+// checkLoadBalancer returns an error when the loadbalancer can't serve the protocol of the deploy. Network protocols
+// need a network loadbalancer with the service port still available, as the service gets its own listener
+func checkLoadBalancer(d service.Deploy, alb *ecs.ALB) error {
+	loadBalancerName := service.GetLoadBalancerName(d)
+	if !service.IsNetworkProtocol(d.ServiceProtocol) {
+		if alb.IsNetwork() {
+			return newBadRequestError(fmt.Sprintf("Protocol %v can't be used with network loadbalancer %v", d.ServiceProtocol, loadBalancerName))
+		}
+		return nil
+	}
+	if !alb.IsNetwork() {
+		return newBadRequestError(fmt.Sprintf("Protocol %v needs a network loadbalancer, %v is not a network loadbalancer", d.ServiceProtocol, loadBalancerName))
+	}
+	if alb.GetListenerByPort(d.ServicePort) != nil {
+		return newConflictError(fmt.Sprintf("Port %d of loadbalancer %v already has a listener", d.ServicePort, loadBalancerName), nil)
+	}
+	return nil
+}
+
+// Delete the rules for a specific targetGroup, or the listeners forwarding to it on a network loadbalancer
 func (c *Controller) deleteRulesForTarget(serviceName string, d service.Deploy, targetGroupArn *string, alb *ecs.ALB) error {
+	if alb.IsNetwork() {
+		for _, listenerArn := range alb.GetListenersByTargetGroupArn(*targetGroupArn) {
+			c.logger().Debugf("Deleting listener %v of service: %v", listenerArn, serviceName)
+			err := alb.DeleteListener(listenerArn)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	err := alb.GetRulesForAllListeners()
 	if err != nil {
 		return err
 	}
 	ruleArns := alb.GetRulesByTargetGroupArn(*targetGroupArn)
+	// fixed responses and redirects don't forward to the target group
+	if hasRuleActions(d) {
+		taggedRuleArns, err := alb.GetRulesByServiceTag(serviceName)
+		if err != nil {
+			return err
+		}
+		forwards := make(map[string]bool)
+		for _, ruleArn := range ruleArns {
+			forwards[ruleArn] = true
+		}
+		for _, ruleArn := range taggedRuleArns {
+			if !forwards[ruleArn] {
+				ruleArns = append(ruleArns, ruleArn)
+			}
+		}
+	}
 	for _, ruleArn := range ruleArns {
 		alb.DeleteRule(ruleArn)
 	}
-	return nil
+	return alb.ReleaseRulePriorities(serviceName)
 }
 
-// Deploy rules for a specific targetGroup
-func (c *Controller) createRulesForTarget(serviceName string, d service.Deploy, targetGroupArn *string, alb *ecs.ALB) ([]string, error) {
-	var listeners []string
-	// get last priority number
-	priority, err := alb.GetHighestRule()
-	if err != nil {
-		return nil, err
+// hasRuleActions returns true if one of the rule conditions doesn't forward to the target group
+func hasRuleActions(d service.Deploy) bool {
+	for _, r := range d.RuleConditions {
+		switch strings.ToLower(r.Action.Type) {
+		case "", "forward":
+		default:
+			return true
+		}
 	}
+	return false
+}
 
-	if len(d.RuleConditions) > 0 {
-		// create rules based on conditions
-		var newRules int
-		for _, r := range d.RuleConditions {
-			if r.PathPattern != "" && r.Hostname != "" {
-				rules := []string{r.PathPattern, r.Hostname}
-				l, err := alb.CreateRuleForListeners("combined", r.Listeners, *targetGroupArn, rules, (priority + 10 + int64(newRules)))
-				if err != nil {
-					return nil, err
-				}
-				newRules += len(r.Listeners)
-				listeners = append(listeners, l...)
-			} else if r.PathPattern != "" {
-				rules := []string{r.PathPattern}
-				l, err := alb.CreateRuleForListeners("pathPattern", r.Listeners, *targetGroupArn, rules, (priority + 10 + int64(newRules)))
-				if err != nil {
-					return nil, err
-				}
-				newRules += len(r.Listeners)
-				listeners = append(listeners, l...)
-			} else if r.Hostname != "" {
-				rules := []string{r.Hostname}
-				l, err := alb.CreateRuleForListeners("hostname", r.Listeners, *targetGroupArn, rules, (priority + 10 + int64(newRules)))
-				if err != nil {
-					return nil, err
-				}
-				newRules += len(r.Listeners)
-				listeners = append(listeners, l...)
-			}
-		}
-	} else {
-		// create default rules ( /servicename path on all listeners )
-		controllerLogger.Debugf("Creating alb rule(s) service: %v", serviceName)
-		rules := []string{"/" + serviceName}
-		l, err := alb.CreateRuleForAllListeners("pathPattern", *targetGroupArn, rules, (priority + 10))
-		if err != nil {
-			return nil, err
-		}
-		rules = []string{"/" + serviceName + "/*"}
-		_, err = alb.CreateRuleForAllListeners("pathPattern", *targetGroupArn, rules, (priority + 11))
+// Deploy rules for a specific targetGroup
+func (c *Controller) createRulesForTarget(serviceName string, d service.Deploy, targetGroupArn *string, alb *ecs.ALB) ([]string, error) {
+	// network loadbalancers don't have rules, the service gets a listener on its port
+	if alb.IsNetwork() {
+		c.logger().Debugf("Creating %v listener on port %d for service: %v", d.ServiceProtocol, d.ServicePort, serviceName)
+		err := alb.CreateListenerWithCertificate(strings.ToUpper(d.ServiceProtocol), d.ServicePort, *targetGroupArn, d.CertificateArn)
 		if err != nil {
 			return nil, err
 		}
-		listeners = append(listeners, l...)
+		listener := alb.Listeners[len(alb.Listeners)-1]
+		return []string{aws.StringValue(listener.ListenerArn)}, nil
+	}
+	c.logger().Debugf("Creating alb rule(s) service: %v", serviceName)
+	return alb.CreateRulesWithPriorities(serviceName, *targetGroupArn, getRuleConditions(serviceName, d, alb))
+}
+
+// reconcileRulesForTarget changes the rules of a target group to match the rule conditions of the deploy. Returns the
+// listeners with rules of the service and whether the rules were changed
+func (c *Controller) reconcileRulesForTarget(serviceName string, d service.Deploy, targetGroupArn *string, alb *ecs.ALB) ([]string, bool, error) {
+	if alb.IsNetwork() {
+		return alb.GetListenersByTargetGroupArn(*targetGroupArn), false, nil
+	}
+	return alb.ReconcileRules(serviceName, *targetGroupArn, getRuleConditions(serviceName, d, alb))
+}
+
+// getRuleConditions returns the rule conditions of the deploy, or the default rules ( /servicename path on all
+// listeners ) when there are none
+func getRuleConditions(serviceName string, d service.Deploy, alb *ecs.ALB) []*service.DeployRuleConditions {
+	if len(d.RuleConditions) > 0 {
+		return d.RuleConditions
+	}
+	var protocols []string
+	for _, l := range alb.Listeners {
+		protocols = append(protocols, aws.StringValue(l.Protocol))
+	}
+	return []*service.DeployRuleConditions{
+		{Listeners: protocols, PathPattern: "/" + serviceName},
+		{Listeners: protocols, PathPattern: "/" + serviceName + "/*"},
 	}
-	return listeners, nil
 }
 
 func (c *Controller) getDeploys() ([]service.DynamoDeployment, error) {
-	s := service.NewService()
+	s := c.newService()
 	return s.GetDeploys("byMonth", 20)
 }
 func (c *Controller) getDeploysForService(serviceName string) ([]service.DynamoDeployment, error) {
-	s := service.NewService()
+	s := c.newService()
 	return s.GetDeploysForService(serviceName)
 }
 func (c *Controller) getServices() ([]*service.DynamoServicesElement, error) {
-	s := service.NewService()
+	s := c.newService()
 	var ds service.DynamoServices
 	err := s.GetServices(&ds)
 	return ds.Services, err
@@ -522,12 +1457,12 @@ func (c *Controller) describeService(serviceName string) (service.RunningService
 			return rs, nil
 		}
 	}
-	return rs, errors.New("Service " + serviceName + " not found")
+	return rs, newNotFoundError("Service " + serviceName + " not found")
 }
 func (c *Controller) describeServiceVersions(serviceName string) ([]service.ServiceVersion, error) {
 	var imageName string
 	var sv []service.ServiceVersion
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	ecr := ecs.ECR{}
 	// get last service to know container name
@@ -561,10 +1496,10 @@ func (c *Controller) describeServiceVersions(serviceName string) ([]service.Serv
 	return sv, nil
 }
 func (c *Controller) getDeploymentStatus(serviceName, time string) (*service.DeployResult, error) {
-	s := service.NewService()
+	s := c.newService()
 	dd, err := s.GetDeployment(serviceName, time)
 	if err != nil {
-		return nil, err
+		return nil, getDeploymentError(serviceName, time, err)
 	}
 	ret := &service.DeployResult{
 		ClusterName:       dd.DeployData.Cluster,
@@ -574,16 +1509,40 @@ func (c *Controller) getDeploymentStatus(serviceName, time string) (*service.Dep
 		DeployError:       dd.DeployError,
 		TaskDefinitionArn: *dd.TaskDefinitionArn,
 	}
+	if dd.Canary != nil {
+		ret.CanaryWeight = dd.Canary.Weight
+	}
 	return ret, nil
 }
+
+// getDeploymentError returns the api error of a deployment that couldn't be retrieved
+func getDeploymentError(serviceName, time string, err error) error {
+	if err == service.ErrNoItemFound {
+		return newNotFoundError("Deployment of " + serviceName + " at " + time + " not found")
+	}
+	return err
+}
 func (c *Controller) getDeployment(serviceName, time string) (*service.Deploy, error) {
-	s := service.NewService()
+	s := c.newService()
 	dd, err := s.GetDeployment(serviceName, time)
 	if err != nil {
-		return nil, err
+		return nil, getDeploymentError(serviceName, time, err)
 	}
 	return dd.DeployData, nil
 }
+
+// getWaveDeploy returns the wave deploy with the progress of every service
+func (c *Controller) getWaveDeploy(id string) (*service.DynamoWaveDeploy, error) {
+	s := c.newService()
+	w, err := s.GetWaveDeploy(id)
+	if err != nil {
+		if err == service.ErrNoItemFound {
+			return nil, newNotFoundError("Wave deploy " + id + " not found")
+		}
+		return nil, err
+	}
+	return w, nil
+}
 func (c *Controller) getServiceParameters(serviceName, userId, creds string) (map[string]ecs.Parameter, string, error) {
 	var err error
 	p := ecs.Paramstore{}
@@ -635,7 +1594,7 @@ func (c *Controller) deleteServiceParameter(serviceName, userId, creds, paramete
 func (c *Controller) deleteService(serviceName string) error {
 	var ds *service.DynamoServices
 	var clusterName string
-	s := service.NewService()
+	s := c.newService()
 	err := s.GetServices(ds)
 	if err != nil {
 		return err
@@ -660,20 +1619,38 @@ func (c *Controller) deleteService(serviceName string) error {
 	return nil
 }
 func (c *Controller) scaleService(serviceName string, desiredCount int64) error {
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	clusterName, err := s.GetClusterName()
 	if err != nil {
 		return err
 	}
 	s.SetScalingProperty(desiredCount)
+	// scale the color that receives the traffic
+	dd, err := s.GetLastDeploy()
+	if err == nil && dd.Color != "" {
+		var alb *ecs.ALB
+		if dd.DeployData.LoadBalancer == "" {
+			alb, err = ecs.NewALB(dd.DeployData.Cluster)
+		} else {
+			alb, err = ecs.NewALB(dd.DeployData.LoadBalancer)
+		}
+		if err != nil {
+			return err
+		}
+		activeColor, err := alb.GetActiveColor(serviceName)
+		if err != nil {
+			return err
+		}
+		serviceName = ecs.GetBlueGreenServiceName(serviceName, activeColor)
+	}
 	e := ecs.ECS{}
 	e.ManualScaleService(clusterName, serviceName, desiredCount)
 	return nil
 }
 
 func (c *Controller) runTask(serviceName string, runTask service.RunTask) (string, error) {
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	var taskArn string
 	clusterName, err := s.GetClusterName()
@@ -701,7 +1678,7 @@ func (c *Controller) runTask(serviceName string, runTask service.RunTask) (strin
 }
 func (c *Controller) describeTaskDefinition(serviceName string) (ecs.TaskDefinition, error) {
 	var taskDefinition ecs.TaskDefinition
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	clusterName, err := s.GetClusterName()
 	if err != nil {
@@ -721,7 +1698,7 @@ func (c *Controller) describeTaskDefinition(serviceName string) (ecs.TaskDefinit
 func (c *Controller) listTasks(serviceName string) ([]service.RunningTask, error) {
 	var tasks []service.RunningTask
 	var taskArns []*string
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	clusterName, err := s.GetClusterName()
 	if err != nil {
@@ -751,12 +1728,12 @@ func (c *Controller) getServiceLogs(serviceName, taskArn, containerName string,
 
 func (c *Controller) Resume() error {
 	migration := Migration{}
-	s := service.NewService()
+	s := c.newService()
 	// check api version of database
 	dbApiVersion, err := s.GetApiVersion()
 	if err != nil {
-		if err.Error() == "dynamo: no item found" {
-			controllerLogger.Infof("Database is empty - starting app for the first time")
+		if err == service.ErrNoItemFound {
+			c.logger().Infof("Database is empty - starting app for the first time")
 			err = s.InitDB(apiVersion)
 			if err != nil {
 				return err
@@ -781,10 +1758,29 @@ func (c *Controller) Resume() error {
 	for i, dd := range dds {
 		if dd.Status == "running" {
 			// run goroutine to update status of service
-			controllerLogger.Infof("Starting waitUntilServiceStable for %v", dd.ServiceName)
-			go e.LaunchWaitUntilServicesStable(&dds[i])
+			c.logger().Infof("Starting waitUntilServiceStable for %v", dd.ServiceName)
+			if dd.Color != "" {
+				go e.LaunchWaitUntilBlueGreenStable(&dds[i])
+			} else if dd.Canary != nil {
+				go e.LaunchCanaryDeployment(&dds[i])
+			} else {
+				go e.LaunchWaitUntilServicesStable(&dds[i])
+			}
+		} else if dd.Status == "success" && dd.BakeDeadline != nil {
+			// the previous color of a blueGreen deployment still needs to be scaled down
+			c.logger().Infof("Resuming bake time of %v", dd.ServiceName)
+			go e.LaunchBlueGreenBake(&dds[i])
 		}
 	}
+	// resume the next waves of the wave deploys of the last day
+	waveDeploys, err := s.GetRunningWaveDeploys(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		return err
+	}
+	for i := range waveDeploys {
+		c.logger().Infof("Resuming wave deploy %v", waveDeploys[i].Id)
+		go c.resumeWaveDeploy(&waveDeploys[i])
+	}
 	// check for nodes draining
 	autoscaling := ecs.AutoScaling{}
 	services := make(map[string][]string)
@@ -797,7 +1793,7 @@ func (c *Controller) Resume() error {
 		autoScalingGroupName, err := autoscaling.GetAutoScalingGroupByTag(clusterName)
 		if err != nil {
 			if strings.HasPrefix(err.Error(), "ClusterNotFound:") {
-				controllerLogger.Infof("Cluster %v not running - skipping resume for this cluster", clusterName)
+				c.logger().Infof("Cluster %v not running - skipping resume for this cluster", clusterName)
 				clusterNotFound = true
 			} else {
 				return err
@@ -817,7 +1813,7 @@ func (c *Controller) Resume() error {
 			var lifecycleHookNotFound bool
 			hn, err := autoscaling.GetLifecycleHookNames(autoScalingGroupName, "autoscaling:EC2_INSTANCE_TERMINATING")
 			if err != nil || len(hn) == 0 {
-				controllerLogger.Errorf("Cluster %v doesn't have a lifecycle hook", clusterName)
+				c.logger().Errorf("Cluster %v doesn't have a lifecycle hook", clusterName)
 				lifecycleHookNotFound = true
 			}
 			if !lifecycleHookNotFound {
@@ -841,7 +1837,7 @@ func (c *Controller) Resume() error {
 							s.PutClusterInfo(*dc, clusterName, "no", "")
 						}
 						// launch wait for drained
-						controllerLogger.Infof("Launching waitForDrainedNode for cluster=%v, instance=%v, autoscalingGroupName=%v", clusterName, ci.Ec2InstanceId, autoScalingGroupName)
+						c.logger().Infof("Launching waitForDrainedNode for cluster=%v, instance=%v, autoscalingGroupName=%v", clusterName, ci.Ec2InstanceId, autoScalingGroupName)
 						go e.LaunchWaitForDrainedNode(clusterName, ci.ContainerInstanceArn, ci.Ec2InstanceId, autoScalingGroupName, hn[0], "")
 					}
 				}
@@ -865,7 +1861,7 @@ func (c *Controller) Resume() error {
 					return err
 				}
 				if pendingAction == scalingOp {
-					controllerLogger.Infof("Launching process for pending scaling operation: %s ", pendingAction)
+					c.logger().Infof("Launching process for pending scaling operation: %s ", pendingAction)
 					go asc.launchProcessPendingScalingOp(clusterName, pendingAction, registeredInstanceCpu, registeredInstanceMemory)
 				}
 			}
@@ -876,11 +1872,11 @@ func (c *Controller) Resume() error {
 	for _, v := range autoscalingStrategies {
 		if strings.ToLower(v) == "polling" {
 			asc := AutoscalingController{}
-			controllerLogger.Debugf("Starting AutoscalingPollingStrategy in goroutine")
+			c.logger().Debugf("Starting AutoscalingPollingStrategy in goroutine")
 			go asc.startAutoscalingPollingStrategy()
 		}
 	}
-	controllerLogger.Debugf("Finished controller resume. Checked %d services", len(dds))
+	c.logger().Debugf("Finished controller resume. Checked %d services", len(dds))
 	return err
 }
 
@@ -919,7 +1915,7 @@ func (c *Controller) Bootstrap(b *Flags) error {
 	e := ecs.ECS{}
 	iam := ecs.IAM{}
 	paramstore := ecs.Paramstore{}
-	s := service.NewService()
+	s := c.newService()
 	cloudwatch := ecs.CloudWatch{}
 	autoscaling := ecs.AutoScaling{}
 	roleName := "ecs-" + b.ClusterName
@@ -1244,13 +2240,13 @@ func (c *Controller) putServiceAutoscaling(serviceName string, autoscaling servi
 	var writeChanges bool
 	// validation
 	if autoscaling.MinimumCount == 0 && autoscaling.MaximumCount == 0 {
-		return result, errors.New("minimumCount / maximumCount missing")
+		return result, newBadRequestError("minimumCount / maximumCount missing")
 	}
 	// autoscaling
 	as := ecs.AutoScaling{}
 	cloudwatch := ecs.CloudWatch{}
 	iam := ecs.IAM{}
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	clusterName, err := s.GetClusterName()
 	if err != nil {
@@ -1362,7 +2358,7 @@ func (c *Controller) putServiceAutoscaling(serviceName string, autoscaling servi
 func (c *Controller) getServiceAutoscaling(serviceName string) (service.Autoscaling, error) {
 	var a service.Autoscaling
 	e := ecs.ECS{}
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	clusterName, err := s.GetClusterName()
 	autoscaling := ecs.AutoScaling{}
@@ -1419,7 +2415,7 @@ func (c *Controller) getServiceAutoscaling(serviceName string) (service.Autoscal
 	return a, nil
 }
 func (c *Controller) deleteServiceAutoscalingPolicy(serviceName, policyName string) error {
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	autoscaling := ecs.AutoScaling{}
 	cloudwatch := ecs.CloudWatch{}
@@ -1431,7 +2427,7 @@ func (c *Controller) deleteServiceAutoscalingPolicy(serviceName, policyName stri
 	}
 
 	if dd.Scaling.Autoscaling.ResourceId == "" {
-		return errors.New("Autoscaling not active for service")
+		return newNotFoundError("Autoscaling not active for service")
 	}
 
 	var newPolicyNames []string
@@ -1444,7 +2440,7 @@ func (c *Controller) deleteServiceAutoscalingPolicy(serviceName, policyName stri
 		}
 	}
 	if !found {
-		return fmt.Errorf("Autoscaling policy %v not found", policyName)
+		return newNotFoundError("Autoscaling policy " + policyName + " not found")
 	}
 
 	err = autoscaling.DeleteScalingPolicy(policyName, dd.Scaling.Autoscaling.ResourceId)
@@ -1467,7 +2463,7 @@ func (c *Controller) deleteServiceAutoscalingPolicy(serviceName, policyName stri
 }
 
 func (c *Controller) deleteServiceAutoscaling(serviceName string) error {
-	s := service.NewService()
+	s := c.newService()
 	s.ServiceName = serviceName
 	autoscaling := ecs.AutoScaling{}
 	cloudwatch := ecs.CloudWatch{}
@@ -1479,7 +2475,7 @@ func (c *Controller) deleteServiceAutoscaling(serviceName string) error {
 	}
 
 	if dd.Scaling.Autoscaling.ResourceId == "" {
-		return errors.New("Autoscaling not active for service")
+		return newNotFoundError("Autoscaling not active for service")
 	}
 
 	for _, policyName := range dd.Scaling.Autoscaling.PolicyNames {
@@ -0,0 +1,1415 @@
+package api
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/in4it/ecs-deploy/provider/ecs"
+	"github.com/in4it/ecs-deploy/provider/ecs/fake"
+	"github.com/in4it/ecs-deploy/service"
+
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeEnvironment sets up a local store and a fake AWS account with a load balancer for mycluster
+func newFakeEnvironment(t *testing.T) (*fake.AWS, func()) {
+	dir, err := ioutil.TempDir("", "ecs-deploy-test")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %v", err)
+	}
+	os.Setenv("STORAGE_BACKEND", "local")
+	os.Setenv("STORAGE_LOCAL_PATH", filepath.Join(dir, "ecs-deploy.db"))
+	os.Setenv("PARAMSTORE_ENABLED", "no")
+
+	f := fake.New()
+	lbArn := f.AddLoadBalancer("mycluster", "vpc-123")
+	f.AddListener(lbArn, "HTTP", 80)
+	ecs.SetDefaultClients(f)
+
+	return f, func() {
+		ecs.SetDefaultClients(ecs.AWSClients{})
+		os.Unsetenv("STORAGE_BACKEND")
+		os.Unsetenv("STORAGE_LOCAL_PATH")
+		os.Unsetenv("PARAMSTORE_ENABLED")
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestDeploy() service.Deploy {
+	return service.Deploy{
+		Cluster:             "mycluster",
+		ServicePort:         80,
+		ServiceProtocol:     "HTTP",
+		DesiredCount:        2,
+		DeregistrationDelay: -1,
+		HealthCheck: service.DeployHealthCheck{
+			HealthyThreshold:   3,
+			UnhealthyThreshold: 3,
+			Path:               "/myservice",
+			Interval:           60,
+			Matcher:            "200",
+			Timeout:            30,
+		},
+		Containers: []*service.DeployContainer{
+			{
+				ContainerName:     "myservice",
+				ContainerTag:      "latest",
+				ContainerPort:     80,
+				ContainerURI:      "nginx:latest",
+				Essential:         true,
+				MemoryReservation: 128,
+				CPUReservation:    64,
+			},
+		},
+	}
+}
+
+// waitForDeployment waits until the deployment is not running anymore and returns the final status
+func waitForDeployment(t *testing.T, serviceName string, deployTime time.Time) string {
+	c := Controller{}
+	for i := 0; i < 100; i++ {
+		dd, err := c.getDeploymentStatus(serviceName, deployTime.UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			t.Fatalf("getDeploymentStatus: %v", err)
+		}
+		if dd.Status != "running" {
+			return dd.Status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Deployment of %v didn't finish", serviceName)
+	return ""
+}
+
+func TestDeployNewService(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	res, err := c.Deploy("myservice", newTestDeploy())
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+
+	svc := f.GetService("mycluster", "myservice")
+	if svc == nil {
+		t.Fatalf("Service myservice not created")
+	}
+	if aws.StringValue(svc.TaskDefinition) != res.TaskDefinitionArn {
+		t.Errorf("Expected task definition %v, got %v", res.TaskDefinitionArn, aws.StringValue(svc.TaskDefinition))
+	}
+	if aws.Int64Value(svc.RunningCount) != 2 {
+		t.Errorf("Expected 2 running tasks, got %d", aws.Int64Value(svc.RunningCount))
+	}
+	if taskDefinition := f.GetTaskDefinition(res.TaskDefinitionArn); !strings.HasSuffix(aws.StringValue(taskDefinition.ExecutionRoleArn), ":role/ecs-exec-myservice") {
+		t.Errorf("Expected execution role ecs-exec-myservice, got %v", aws.StringValue(taskDefinition.ExecutionRoleArn))
+	}
+	tg := f.GetTargetGroup("myservice")
+	if tg == nil {
+		t.Fatalf("Target group myservice not created")
+	}
+	if aws.StringValue(tg.HealthCheckPath) != "/myservice" {
+		t.Errorf("Expected health check path /myservice, got %v", aws.StringValue(tg.HealthCheckPath))
+	}
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	var paths []string
+	for _, rule := range f.GetRules(aws.StringValue(alb.Listeners[0].ListenerArn)) {
+		for _, condition := range rule.Conditions {
+			if aws.StringValue(rule.Actions[0].TargetGroupArn) == aws.StringValue(tg.TargetGroupArn) {
+				paths = append(paths, aws.StringValue(condition.Values[0]))
+			}
+		}
+	}
+	if len(paths) != 2 || paths[0] != "/myservice" || paths[1] != "/myservice/*" {
+		t.Errorf("Expected rules for /myservice and /myservice/*, got %v", paths)
+	}
+}
+
+func TestDeployUpdateService(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	waitForDeployment(t, "myservice", res.DeploymentTime)
+
+	d.HealthCheck.Path = "/myservice/health"
+	d.DeregistrationDelay = 10
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+
+	svc := f.GetService("mycluster", "myservice")
+	if aws.StringValue(svc.TaskDefinition) != res.TaskDefinitionArn {
+		t.Errorf("Expected task definition %v, got %v", res.TaskDefinitionArn, aws.StringValue(svc.TaskDefinition))
+	}
+	tg := f.GetTargetGroup("myservice")
+	if aws.StringValue(tg.HealthCheckPath) != "/myservice/health" {
+		t.Errorf("Expected health check path /myservice/health, got %v", aws.StringValue(tg.HealthCheckPath))
+	}
+	if v := f.GetTargetGroupAttributes(aws.StringValue(tg.TargetGroupArn))["deregistration_delay.timeout_seconds"]; v != "10" {
+		t.Errorf("Expected deregistration delay 10, got %v", v)
+	}
+}
+
+func TestDeploySecrets(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	os.Setenv("AWS_REGION", "us-east-1")
+	defer os.Unsetenv("AWS_REGION")
+
+	secretArn := "arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf:password::"
+	d := newTestDeploy()
+	d.Containers[0].Secrets = []*service.DeployContainerSecret{
+		{Name: "DB_USER", ValueFrom: "db/user"},
+		{Name: "DB_PASSWORD", ValueFrom: secretArn},
+	}
+	d.Containers[0].RepositoryCredentials.CredentialsParameter = "arn:aws:secretsmanager:us-east-1:123456789012:secret:dockerhub-AbCdEf"
+	c := Controller{}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+
+	taskDefinition := f.GetTaskDefinition(res.TaskDefinitionArn)
+	if aws.StringValue(taskDefinition.ExecutionRoleArn) != "arn:aws:iam::123456789012:role/ecs-exec-myservice" {
+		t.Errorf("Expected execution role ecs-exec-myservice, got %v", aws.StringValue(taskDefinition.ExecutionRoleArn))
+	}
+	repositoryCredentials := taskDefinition.ContainerDefinitions[0].RepositoryCredentials
+	if repositoryCredentials == nil || !strings.HasSuffix(aws.StringValue(repositoryCredentials.CredentialsParameter), ":secret:dockerhub-AbCdEf") {
+		t.Errorf("Expected repository credentials, got %v", repositoryCredentials)
+	}
+	secrets := taskDefinition.ContainerDefinitions[0].Secrets
+	if len(secrets) != 2 {
+		t.Fatalf("Expected 2 secrets, got %v", secrets)
+	}
+	if aws.StringValue(secrets[0].ValueFrom) != "arn:aws:ssm:us-east-1:123456789012:parameter/myservice/db/user" {
+		t.Errorf("Expected parameter relative to the service namespace, got %v", aws.StringValue(secrets[0].ValueFrom))
+	}
+	if aws.StringValue(secrets[1].ValueFrom) != secretArn {
+		t.Errorf("Expected secret %v, got %v", secretArn, aws.StringValue(secrets[1].ValueFrom))
+	}
+
+	policy, err := f.IAM().GetRolePolicy(&iam.GetRolePolicyInput{RoleName: aws.String("ecs-exec-myservice"), PolicyName: aws.String("secrets")})
+	if err != nil {
+		t.Fatalf("GetRolePolicy: %v", err)
+	}
+	document := aws.StringValue(policy.PolicyDocument)
+	if !strings.Contains(document, "parameter/myservice/db/user") || !strings.Contains(document, "secret:db-AbCdEf*") || !strings.Contains(document, "secret:dockerhub-AbCdEf*") {
+		t.Errorf("Expected policy to be limited to the secrets, got %v", document)
+	}
+}
+
+func TestDeployFargate(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	d := newTestDeploy()
+	d.LaunchType = "FARGATE"
+	d.PlatformVersion = "1.4.0"
+	d.NetworkMode = "awsvpc"
+	d.NetworkConfiguration.Subnets = []string{"subnet-123"}
+	d.CPU = 256
+	d.Memory = 512
+	c := Controller{}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	taskDefinition := f.GetTaskDefinition(res.TaskDefinitionArn)
+	if aws.StringValue(taskDefinition.Cpu) != "256" || aws.StringValue(taskDefinition.Memory) != "512" {
+		t.Errorf("Expected task size 256/512, got %v/%v", aws.StringValue(taskDefinition.Cpu), aws.StringValue(taskDefinition.Memory))
+	}
+	if len(taskDefinition.RequiresCompatibilities) != 1 || aws.StringValue(taskDefinition.RequiresCompatibilities[0]) != "FARGATE" {
+		t.Errorf("Expected FARGATE compatibility, got %v", aws.StringValueSlice(taskDefinition.RequiresCompatibilities))
+	}
+	svc := f.GetService("mycluster", "myservice")
+	if aws.StringValue(svc.LaunchType) != "FARGATE" || aws.StringValue(svc.PlatformVersion) != "1.4.0" {
+		t.Errorf("Expected FARGATE service with platform version 1.4.0, got %v %v", aws.StringValue(svc.LaunchType), aws.StringValue(svc.PlatformVersion))
+	}
+	if len(svc.PlacementStrategy) != 0 {
+		t.Errorf("Expected no placement strategy for FARGATE, got %v", svc.PlacementStrategy)
+	}
+}
+
+func TestDeployCapacityProviders(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	d := newTestDeploy()
+	d.CapacityProviderStrategy = []service.DeployCapacityProviderStrategyItem{
+		{CapacityProvider: "FARGATE_SPOT", Weight: 3},
+		{CapacityProvider: "FARGATE", Weight: 1, Base: 1},
+	}
+	d.NetworkMode = "awsvpc"
+	d.NetworkConfiguration.Subnets = []string{"subnet-123"}
+	d.CPU = 256
+	d.Memory = 512
+	c := Controller{}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	svc := f.GetService("mycluster", "myservice")
+	if svc.LaunchType != nil || len(svc.CapacityProviderStrategy) != 2 || aws.StringValue(svc.CapacityProviderStrategy[0].CapacityProvider) != "FARGATE_SPOT" {
+		t.Fatalf("Expected capacity provider strategy without launch type, got %v %v", aws.StringValue(svc.LaunchType), svc.CapacityProviderStrategy)
+	}
+	if len(svc.PlacementStrategy) != 0 {
+		t.Errorf("Expected no placement strategy for FARGATE capacity providers, got %v", svc.PlacementStrategy)
+	}
+	taskDefinition := f.GetTaskDefinition(res.TaskDefinitionArn)
+	if len(taskDefinition.RequiresCompatibilities) != 1 || aws.StringValue(taskDefinition.RequiresCompatibilities[0]) != "FARGATE" {
+		t.Errorf("Expected FARGATE compatibility, got %v", aws.StringValueSlice(taskDefinition.RequiresCompatibilities))
+	}
+
+	// change the weights
+	d.CapacityProviderStrategy[0].Weight = 1
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	svc = f.GetService("mycluster", "myservice")
+	if aws.Int64Value(svc.CapacityProviderStrategy[0].Weight) != 1 {
+		t.Errorf("Expected weight of FARGATE_SPOT to be updated to 1, got %d", aws.Int64Value(svc.CapacityProviderStrategy[0].Weight))
+	}
+}
+
+func TestDeploySidecars(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	d := newTestDeploy()
+	d.Containers[0].PortMappings = []*service.DeployContainerPortMapping{{ContainerPort: 9090, Protocol: "UDP"}}
+	d.Containers[0].DependsOn = []*service.DeployContainerDependency{{ContainerName: "log-router"}}
+	d.Containers = append(d.Containers, &service.DeployContainer{
+		ContainerName:     "log-router",
+		ContainerURI:      "amazon/aws-for-fluent-bit:latest",
+		MemoryReservation: 64,
+		StartTimeout:      30,
+		FirelensConfiguration: service.DeployContainerFirelensConfiguration{
+			Type:    "fluentbit",
+			Options: map[string]string{"enable-ecs-log-metadata": "true"},
+		},
+	})
+	c := Controller{}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	taskDefinition := f.GetTaskDefinition(res.TaskDefinitionArn)
+	portMappings := taskDefinition.ContainerDefinitions[0].PortMappings
+	if len(portMappings) != 2 || aws.Int64Value(portMappings[0].ContainerPort) != 80 || aws.StringValue(portMappings[1].Protocol) != "udp" {
+		t.Errorf("Expected port mappings for 80 and 9090/udp, got %v", portMappings)
+	}
+	dependsOn := taskDefinition.ContainerDefinitions[0].DependsOn
+	if len(dependsOn) != 1 || aws.StringValue(dependsOn[0].ContainerName) != "log-router" || aws.StringValue(dependsOn[0].Condition) != "START" {
+		t.Errorf("Expected dependency on log-router, got %v", dependsOn)
+	}
+	logRouter := taskDefinition.ContainerDefinitions[1]
+	if aws.Int64Value(logRouter.StartTimeout) != 30 || logRouter.FirelensConfiguration == nil || aws.StringValue(logRouter.FirelensConfiguration.Type) != "fluentbit" {
+		t.Errorf("Expected firelens configuration and start timeout on log-router, got %v", logRouter)
+	}
+}
+
+func TestDeployLoadBalancers(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	d := newTestDeploy()
+	d.Containers[0].PortMappings = []*service.DeployContainerPortMapping{{ContainerPort: 9090}}
+	d.LoadBalancers = []service.DeployLoadBalancer{
+		{
+			ContainerName:  "myservice",
+			ContainerPort:  9090,
+			Protocol:       "HTTP",
+			HealthCheck:    service.DeployHealthCheck{Path: "/health"},
+			RuleConditions: []*service.DeployRuleConditions{{Listeners: []string{"http"}, PathPattern: "/grpc/*"}},
+		},
+	}
+	c := Controller{}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	tg := f.GetTargetGroup("myservice-9090")
+	if tg == nil {
+		t.Fatalf("Target group myservice-9090 not created")
+	}
+	if aws.Int64Value(tg.Port) != 9090 || aws.StringValue(tg.HealthCheckPath) != "/health" {
+		t.Errorf("Expected target group on port 9090 with health check /health, got %v", tg)
+	}
+	svc := f.GetService("mycluster", "myservice")
+	if len(svc.LoadBalancers) != 2 || aws.StringValue(svc.LoadBalancers[1].TargetGroupArn) != aws.StringValue(tg.TargetGroupArn) {
+		t.Fatalf("Expected 2 load balancers on the service, got %v", svc.LoadBalancers)
+	}
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	var found bool
+	for _, rule := range f.GetRules(aws.StringValue(alb.Listeners[0].ListenerArn)) {
+		if len(rule.Actions) > 0 && aws.StringValue(rule.Actions[0].TargetGroupArn) == aws.StringValue(tg.TargetGroupArn) && aws.StringValue(rule.Conditions[0].Values[0]) == "/grpc/*" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected rule /grpc/* for target group myservice-9090")
+	}
+
+	// remove the load balancer
+	d.LoadBalancers = nil
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	svc = f.GetService("mycluster", "myservice")
+	if len(svc.LoadBalancers) != 1 {
+		t.Errorf("Expected 1 load balancer on the service, got %v", svc.LoadBalancers)
+	}
+	if f.GetTargetGroup("myservice-9090") != nil {
+		t.Errorf("Expected target group myservice-9090 to be deleted")
+	}
+}
+
+func TestDeployNetworkLoadBalancer(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	nlbArn := f.AddNetworkLoadBalancer("mynlb", "vpc-123")
+
+	d := newTestDeploy()
+	d.LoadBalancer = "mynlb"
+	d.ServicePort = 1883
+	d.ServiceProtocol = "TCP"
+	d.HealthCheck = service.DeployHealthCheck{HealthyThreshold: 3, UnhealthyThreshold: 3, Interval: 30}
+	d.Containers[0].ContainerPort = 1883
+	c := Controller{}
+	res, err := c.Deploy("mqtt", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "mqtt", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	tg := f.GetTargetGroup("mqtt")
+	if tg == nil {
+		t.Fatalf("Target group mqtt not created")
+	}
+	if aws.StringValue(tg.Protocol) != "TCP" || aws.StringValue(tg.HealthCheckProtocol) != "TCP" || tg.HealthCheckPath != nil {
+		t.Errorf("Expected TCP target group with TCP health check, got %v", tg)
+	}
+	listeners := f.GetListeners(nlbArn)
+	if len(listeners) != 1 {
+		t.Fatalf("Expected 1 listener on the network loadbalancer, got %v", listeners)
+	}
+	if aws.Int64Value(listeners[0].Port) != 1883 || aws.StringValue(listeners[0].Protocol) != "TCP" || aws.StringValue(listeners[0].DefaultActions[0].TargetGroupArn) != aws.StringValue(tg.TargetGroupArn) {
+		t.Errorf("Expected TCP listener on port 1883 forwarding to target group mqtt, got %v", listeners[0])
+	}
+
+	// the port of the listener is taken
+	_, err = c.Deploy("mqtt2", d)
+	if err == nil || !strings.Contains(err.Error(), "already has a listener") {
+		t.Errorf("Expected listener error, got %v", err)
+	}
+	// http services need an application loadbalancer
+	d = newTestDeploy()
+	d.LoadBalancer = "mynlb"
+	_, err = c.Deploy("myservice", d)
+	if err == nil || !strings.Contains(err.Error(), "network loadbalancer") {
+		t.Errorf("Expected loadbalancer type error, got %v", err)
+	}
+}
+
+func TestDeployRuleActions(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	httpsListenerArn := f.AddListener(aws.StringValue(alb.Listeners[0].LoadBalancerArn), "HTTPS", 443)
+	_, err = f.SSM().PutParameter(&ssm.PutParameterInput{Name: aws.String("/oidc/admin"), Type: aws.String("SecureString"), Value: aws.String("secret")})
+	if err != nil {
+		t.Fatalf("PutParameter: %v", err)
+	}
+
+	d := newTestDeploy()
+	d.RuleConditions = []*service.DeployRuleConditions{
+		{
+			Listeners:   []string{"http"},
+			PathPattern: "/beta/*",
+			HttpHeaders: []service.DeployRuleHttpHeader{{Name: "X-Beta", Values: []string{"true"}}},
+		},
+		{
+			Listeners:    []string{"http"},
+			PathPatterns: []string{"/old", "/old/*"},
+			Action: service.DeployRuleAction{
+				Type:          "fixed-response",
+				FixedResponse: service.DeployRuleFixedResponse{StatusCode: "410", ContentType: "text/plain", MessageBody: "gone"},
+			},
+		},
+		{
+			Listeners:   []string{"https"},
+			PathPattern: "/admin/*",
+			Action: service.DeployRuleAction{
+				AuthenticateOidc: &service.DeployRuleAuthenticateOidc{
+					Issuer:                "https://idp.example.com",
+					AuthorizationEndpoint: "https://idp.example.com/authorize",
+					TokenEndpoint:         "https://idp.example.com/token",
+					UserInfoEndpoint:      "https://idp.example.com/userinfo",
+					ClientId:              "ecs-deploy",
+					ClientSecretParameter: "/oidc/admin",
+				},
+			},
+		},
+	}
+	c := Controller{}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+	tg := f.GetTargetGroup("myservice")
+	rules := f.GetRules(aws.StringValue(alb.Listeners[0].ListenerArn))
+	if len(rules) != 3 {
+		t.Fatalf("Expected 2 rules next to the default rule on the http listener, got %v", rules)
+	}
+	if len(rules[1].Conditions) != 2 || aws.StringValue(rules[1].Conditions[1].HttpHeaderConfig.HttpHeaderName) != "X-Beta" || aws.StringValue(rules[1].Actions[0].TargetGroupArn) != aws.StringValue(tg.TargetGroupArn) {
+		t.Errorf("Expected path and header condition forwarding to the target group, got %v", rules[1])
+	}
+	if len(rules[2].Conditions[0].PathPatternConfig.Values) != 2 || aws.StringValue(rules[2].Actions[0].FixedResponseConfig.StatusCode) != "410" {
+		t.Errorf("Expected fixed response for 2 paths, got %v", rules[2])
+	}
+	httpsRules := f.GetRules(httpsListenerArn)
+	if len(httpsRules) != 2 || len(httpsRules[1].Actions) != 2 {
+		t.Fatalf("Expected rule with authentication on the https listener, got %v", httpsRules)
+	}
+	if aws.StringValue(httpsRules[1].Actions[0].Type) != elbv2.ActionTypeEnumAuthenticateOidc || aws.StringValue(httpsRules[1].Actions[0].AuthenticateOidcConfig.ClientSecret) != "secret" || aws.Int64Value(httpsRules[1].Actions[1].Order) != 2 {
+		t.Errorf("Expected oidc authentication before the forward, got %v", httpsRules[1].Actions)
+	}
+
+	// the authentication is kept when the forward is modified
+	alb, err = ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	err = alb.SetCanaryWeight(aws.StringValue(tg.TargetGroupArn), aws.StringValue(tg.TargetGroupArn), 0)
+	if err != nil {
+		t.Fatalf("SetCanaryWeight: %v", err)
+	}
+	httpsRules = f.GetRules(httpsListenerArn)
+	if len(httpsRules[1].Actions) != 2 || !aws.BoolValue(httpsRules[1].Actions[0].AuthenticateOidcConfig.UseExistingClientSecret) {
+		t.Errorf("Expected oidc authentication to be kept, got %v", httpsRules[1].Actions)
+	}
+
+	// the fixed response is deleted with the rules of the target group
+	err = c.deleteRulesForTarget("myservice", d, tg.TargetGroupArn, alb)
+	if err != nil {
+		t.Fatalf("deleteRulesForTarget: %v", err)
+	}
+	if rules = f.GetRules(aws.StringValue(alb.Listeners[0].ListenerArn)); len(rules) != 1 {
+		t.Errorf("Expected only the default rule, got %v", rules)
+	}
+}
+
+func TestDeployRulePriorities(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	listenerArn := aws.StringValue(alb.Listeners[0].ListenerArn)
+	newDeploy := func(serviceName string) service.Deploy {
+		d := newTestDeploy()
+		d.HealthCheck.Path = "/" + serviceName
+		d.Containers[0].ContainerName = serviceName
+		return d
+	}
+	deploy := func(serviceName string, d service.Deploy) {
+		c := Controller{}
+		res, err := c.Deploy(serviceName, d)
+		if err != nil {
+			t.Fatalf("Deploy %v: %v", serviceName, err)
+		}
+		if status := waitForDeployment(t, serviceName, res.DeploymentTime); status != "success" {
+			t.Fatalf("Expected deployment status success for %v, got %v", serviceName, status)
+		}
+	}
+	priorities := func() map[string]string {
+		result := make(map[string]string)
+		for _, rule := range f.GetRules(listenerArn) {
+			for _, condition := range rule.Conditions {
+				// the loadbalancer domain is appended to the hostnames
+				value := strings.Split(aws.StringValue(condition.Values[0]), ".")[0]
+				result[value] = aws.StringValue(rule.Priority)
+			}
+		}
+		return result
+	}
+
+	// a catch-all host rule deployed first
+	d := newDeploy("catchall")
+	d.RuleConditions = []*service.DeployRuleConditions{{Listeners: []string{"http"}, Hostname: "www"}}
+	deploy("catchall", d)
+	// the path rules of the next service need to go before the host rule
+	deploy("myservice", newDeploy("myservice"))
+	expected := map[string]string{"/myservice": "1", "/myservice/*": "2", "www": "3"}
+	if p := priorities(); !reflect.DeepEqual(p, expected) {
+		t.Errorf("Expected priorities %v, got %v", expected, p)
+	}
+
+	// an explicit priority is kept, the other rules move around it
+	d = newDeploy("explicit")
+	d.RuleConditions = []*service.DeployRuleConditions{{Listeners: []string{"http"}, Hostname: "admin", Priority: 2}}
+	deploy("explicit", d)
+	expected = map[string]string{"/myservice": "1", "admin": "2", "/myservice/*": "3", "www": "4"}
+	if p := priorities(); !reflect.DeepEqual(p, expected) {
+		t.Errorf("Expected priorities %v, got %v", expected, p)
+	}
+
+	// the rules of the other services stay where they are when the rules of a service can't be created
+	f.SetError("CreateRule", awserr.New(elbv2.ErrCodeTooManyRulesException, "Too many rules", nil))
+	c := Controller{}
+	if res, err := c.Deploy("failing", newDeploy("failing")); err == nil {
+		if status := waitForDeployment(t, "failing", res.DeploymentTime); status == "success" {
+			t.Fatalf("Expected the deploy of failing to fail when the rules can't be created")
+		}
+	}
+	if p := priorities(); !reflect.DeepEqual(p, expected) {
+		t.Errorf("Expected priorities %v, got %v", expected, p)
+	}
+	// the reservations of the failed deploy were reverted, they don't leave gaps
+	f.SetError("CreateRule", nil)
+	deploy("retry", newDeploy("retry"))
+	expected = map[string]string{"/myservice": "1", "admin": "2", "/retry": "3", "/myservice/*": "4", "/retry/*": "5", "www": "6"}
+	if p := priorities(); !reflect.DeepEqual(p, expected) {
+		t.Errorf("Expected priorities %v, got %v", expected, p)
+	}
+}
+
+func TestDeployRuleReconciliation(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	if err := service.NewService().InitDB(apiVersion); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	listenerArn := aws.StringValue(alb.Listeners[0].ListenerArn)
+	httpsListenerArn := f.AddListener(aws.StringValue(alb.Listeners[0].LoadBalancerArn), "HTTPS", 443)
+	deploy := func(d service.Deploy) {
+		c := Controller{}
+		res, err := c.Deploy("myservice", d)
+		if err != nil {
+			t.Fatalf("Deploy: %v", err)
+		}
+		if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+			t.Fatalf("Expected deployment status success, got %v", status)
+		}
+	}
+	findRule := func(listenerArn, value string) *elbv2.Rule {
+		for _, rule := range f.GetRules(listenerArn) {
+			for _, condition := range rule.Conditions {
+				for _, v := range condition.Values {
+					if strings.Split(aws.StringValue(v), ".")[0] == value {
+						return rule
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	d := newTestDeploy()
+	d.RuleConditions = []*service.DeployRuleConditions{
+		{Listeners: []string{"http"}, Hostname: "www"},
+		{
+			Listeners:   []string{"http"},
+			PathPattern: "/old",
+			Action:      service.DeployRuleAction{Type: "fixed-response", FixedResponse: service.DeployRuleFixedResponse{StatusCode: "404"}},
+		},
+	}
+	deploy(d)
+	oldRule := findRule(listenerArn, "/old")
+	wwwRule := findRule(listenerArn, "www")
+	if oldRule == nil || wwwRule == nil {
+		t.Fatalf("Expected rules for www and /old, got %v", f.GetRules(listenerArn))
+	}
+
+	// the hostname changes, the fixed response gets another status code and a rule is added on the https listener
+	d.RuleConditions = []*service.DeployRuleConditions{
+		{Listeners: []string{"http"}, Hostname: "www2"},
+		{
+			Listeners:   []string{"http"},
+			PathPattern: "/old",
+			Action:      service.DeployRuleAction{Type: "fixed-response", FixedResponse: service.DeployRuleFixedResponse{StatusCode: "410"}},
+		},
+		{Listeners: []string{"https"}, PathPattern: "/api/*"},
+	}
+	deploy(d)
+	if rules := f.GetRules(listenerArn); len(rules) != 3 || findRule(listenerArn, "www") != nil || findRule(listenerArn, "www2") == nil {
+		t.Errorf("Expected the www rule to be replaced by www2, got %v", rules)
+	}
+	// the conditions are changed in place, the traffic doesn't fall through to the default action in between
+	if rule := findRule(listenerArn, "www2"); rule == nil || aws.StringValue(rule.RuleArn) != aws.StringValue(wwwRule.RuleArn) {
+		t.Errorf("Expected the www rule to be modified to www2, got %v", rule)
+	}
+	rule := findRule(listenerArn, "/old")
+	if rule == nil || aws.StringValue(rule.RuleArn) != aws.StringValue(oldRule.RuleArn) || aws.StringValue(rule.Actions[0].FixedResponseConfig.StatusCode) != "410" {
+		t.Errorf("Expected the /old rule to be modified, got %v", rule)
+	}
+	rule = findRule(httpsListenerArn, "/api/*")
+	if rule == nil || aws.StringValue(rule.Actions[0].TargetGroupArn) != aws.StringValue(f.GetTargetGroup("myservice").TargetGroupArn) {
+		t.Errorf("Expected a /api/* rule forwarding to the target group on the https listener, got %v", f.GetRules(httpsListenerArn))
+	}
+	var ds service.DynamoServices
+	if err := service.NewService().GetServices(&ds); err != nil {
+		t.Fatalf("GetServices: %v", err)
+	}
+	for _, el := range ds.Services {
+		if el.S == "myservice" && !reflect.DeepEqual(el.Listeners, []string{listenerArn, httpsListenerArn}) {
+			t.Errorf("Expected the http and https listeners in the services record, got %v", el.Listeners)
+		}
+	}
+
+	// nothing changes when the rule conditions stay the same
+	deploy(d)
+	if rule = findRule(listenerArn, "www2"); rule == nil || len(f.GetRules(listenerArn)) != 3 {
+		t.Errorf("Expected the rules to stay the same, got %v", f.GetRules(listenerArn))
+	}
+}
+
+func TestDeployRuleReconciliationError(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	if err := service.NewService().InitDB(apiVersion); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	c := Controller{}
+	d := newTestDeploy()
+	d.RuleConditions = []*service.DeployRuleConditions{{Listeners: []string{"http"}, Hostname: "www"}}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+	taskDefinition := aws.StringValue(f.GetService("mycluster", "myservice").TaskDefinition)
+
+	// the rule can't be modified, the service is not updated and the old rule stays
+	f.SetError("ModifyRule", awserr.New(elbv2.ErrCodeTooManyRulesException, "Too many rules", nil))
+	d.RuleConditions = []*service.DeployRuleConditions{{Listeners: []string{"http"}, Hostname: "www2"}}
+	d.Containers[0].ContainerTag = "v2"
+	if _, err = c.Deploy("myservice", d); err == nil {
+		t.Fatalf("Expected the deploy to fail when the rules can't be reconciled")
+	}
+	if td := aws.StringValue(f.GetService("mycluster", "myservice").TaskDefinition); td != taskDefinition {
+		t.Errorf("Expected the service not to be updated, got task definition %v", td)
+	}
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	var hostnames []string
+	for _, rule := range f.GetRules(aws.StringValue(alb.Listeners[0].ListenerArn)) {
+		for _, condition := range rule.Conditions {
+			hostnames = append(hostnames, strings.Split(aws.StringValue(condition.Values[0]), ".")[0])
+		}
+	}
+	if !reflect.DeepEqual(hostnames, []string{"www"}) {
+		t.Errorf("Expected the www rule to stay, got %v", hostnames)
+	}
+	s := service.NewService()
+	s.ServiceName = "myservice"
+	dd, err := s.GetLastDeploy()
+	if err != nil {
+		t.Fatalf("GetLastDeploy: %v", err)
+	}
+	if !dd.Time.Equal(res.DeploymentTime) {
+		t.Errorf("Expected no deployment to be recorded, got deployment of %v with status %v", dd.Time, dd.Status)
+	}
+}
+
+func TestDeployRollback(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+	stableTaskDefinition := res.TaskDefinitionArn
+
+	// tasks of the next deployment don't start
+	f.SetStartTasks(false)
+	d.Containers[0].ContainerTag = "broken"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "failed" {
+		t.Errorf("Expected deployment status failed, got %v", status)
+	}
+	svc := f.GetService("mycluster", "myservice")
+	if aws.StringValue(svc.TaskDefinition) != stableTaskDefinition {
+		t.Errorf("Expected rollback to %v, got %v", stableTaskDefinition, aws.StringValue(svc.TaskDefinition))
+	}
+}
+
+func TestScaleUpDecision(t *testing.T) {
+	c := AutoscalingController{}
+	instances := []service.DynamoClusterContainerInstance{
+		{ClusterName: "mycluster", ContainerInstanceId: "i-1", AvailabilityZone: "us-east-1a", FreeCpu: 512, FreeMemory: 1024},
+		{ClusterName: "mycluster", ContainerInstanceId: "i-2", AvailabilityZone: "us-east-1b", FreeCpu: 128, FreeMemory: 256},
+		{ClusterName: "mycluster", ContainerInstanceId: "i-3", AvailabilityZone: "us-east-1b", FreeCpu: 1024, FreeMemory: 2048, Status: "DRAINING"},
+	}
+	if !c.scaleUpDecision("mycluster", instances, 64, 128) {
+		t.Errorf("Expected resources to fit in every availability zone")
+	}
+	if c.scaleUpDecision("mycluster", instances, 256, 512) {
+		t.Errorf("Expected resources not to fit in us-east-1b")
+	}
+	if !c.scaleUpDecision("othercluster", instances, 256, 512) {
+		t.Errorf("Expected instances of other clusters to be ignored")
+	}
+}
+
+func TestScaleDownDecision(t *testing.T) {
+	c := AutoscalingController{}
+	instances := []service.DynamoClusterContainerInstance{
+		{ClusterName: "mycluster", ContainerInstanceId: "i-1", AvailabilityZone: "us-east-1a", FreeCpu: 1024, FreeMemory: 2048},
+		{ClusterName: "mycluster", ContainerInstanceId: "i-2", AvailabilityZone: "us-east-1a", FreeCpu: 512, FreeMemory: 1024},
+		{ClusterName: "mycluster", ContainerInstanceId: "i-3", AvailabilityZone: "us-east-1b", FreeCpu: 1024, FreeMemory: 2048},
+		{ClusterName: "mycluster", ContainerInstanceId: "i-4", AvailabilityZone: "us-east-1b", FreeCpu: 512, FreeMemory: 1024},
+	}
+	// an instance of 1024 cpu / 2048 memory + 256 cpu / 512 memory + 50% buffer fits in both zones
+	if !c.scaleDownDecision("mycluster", instances, 1024, 2048, 256, 512) {
+		t.Errorf("Expected free resources in every availability zone")
+	}
+	instances[3].Status = "DRAINING"
+	if c.scaleDownDecision("mycluster", instances, 1024, 2048, 256, 512) {
+		t.Errorf("Expected no free resources in us-east-1b")
+	}
+}
+
+func TestProcessEcsMessageScaleUp(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	s := service.NewService()
+	s.ServiceName = "myservice"
+	s.ClusterName = "mycluster"
+	err := s.CreateService(&service.DynamoServicesElement{S: "myservice", C: "mycluster", CpuReservation: 256, MemoryReservation: 512})
+	if err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	f.AddAutoScalingGroup("mycluster-asg", "mycluster", 1, 1, 3)
+	f.AddContainerInstance("mycluster", "i-1", "us-east-1a", 1024, 2048, 128, 256)
+
+	c := AutoscalingController{}
+	err = c.processEcsMessage(ecs.SNSPayloadEcs{
+		DetailType: "ECS Container Instance State Change",
+		Detail: ecs.SNSPayloadEcsDetail{
+			ClusterArn:    "arn:aws:ecs:us-east-1:123456789012:cluster/mycluster",
+			Ec2InstanceId: "i-1",
+			RegisteredResources: []ecs.ContainerInstanceResource{
+				{Name: "CPU", Type: "INTEGER", IntegerValue: 1024},
+				{Name: "MEMORY", Type: "INTEGER", IntegerValue: 2048},
+			},
+			RemainingResources: []ecs.ContainerInstanceResource{
+				{Name: "CPU", Type: "INTEGER", IntegerValue: 128},
+				{Name: "MEMORY", Type: "INTEGER", IntegerValue: 256},
+			},
+			Attributes: []ecs.SNSPayloadEcsDetailAttributes{
+				{Name: "ecs.availability-zone", Value: "us-east-1a"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("processEcsMessage: %v", err)
+	}
+	asg := f.GetAutoScalingGroup("mycluster-asg")
+	if aws.Int64Value(asg.DesiredCapacity) != 2 {
+		t.Errorf("Expected desired capacity 2, got %d", aws.Int64Value(asg.DesiredCapacity))
+	}
+}
+
+func getActiveColor(t *testing.T, serviceName string) string {
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	color, err := alb.GetActiveColor(serviceName)
+	if err != nil {
+		t.Fatalf("GetActiveColor: %v", err)
+	}
+	return color
+}
+
+// waitForDesiredCount waits until the bake time is over and the service is scaled down
+func waitForDesiredCount(t *testing.T, f *fake.AWS, serviceName string, desiredCount int64) {
+	for i := 0; i < 100; i++ {
+		if svc := f.GetService("mycluster", serviceName); svc != nil && aws.Int64Value(svc.DesiredCount) == desiredCount {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Expected %v to have a desired count of %d", serviceName, desiredCount)
+}
+
+func TestDeployBlueGreen(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	d.DeploymentStrategy = "blueGreen"
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+	if color := getActiveColor(t, "myservice"); color != ecs.ColorBlue {
+		t.Errorf("Expected traffic to go to blue, got %v", color)
+	}
+
+	// second deployment goes to green
+	d.Containers[0].ContainerTag = "v2"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+	if color := getActiveColor(t, "myservice"); color != ecs.ColorGreen {
+		t.Errorf("Expected traffic to go to green, got %v", color)
+	}
+	green := f.GetService("mycluster", "myservice-green")
+	if green == nil || aws.StringValue(green.TaskDefinition) != res.TaskDefinitionArn {
+		t.Fatalf("Expected myservice-green to run %v", res.TaskDefinitionArn)
+	}
+	if containerName := aws.StringValue(green.LoadBalancers[0].ContainerName); containerName != "myservice" {
+		t.Errorf("Expected container myservice to be attached to the target group, got %v", containerName)
+	}
+	waitForDesiredCount(t, f, "myservice", 0)
+
+	// failed deployment to blue doesn't move the traffic
+	f.SetStartTasks(false)
+	d.Containers[0].ContainerTag = "broken"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "failed" {
+		t.Errorf("Expected deployment status failed, got %v", status)
+	}
+	if color := getActiveColor(t, "myservice"); color != ecs.ColorGreen {
+		t.Errorf("Expected traffic to stay on green, got %v", color)
+	}
+	waitForDesiredCount(t, f, "myservice", 0)
+}
+
+func TestRollbackBlueGreen(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	d.DeploymentStrategy = "blueGreen"
+	d.BakeTime = 3600
+	for _, tag := range []string{"v1", "v2"} {
+		d.Containers[0].ContainerTag = tag
+		res, err := c.Deploy("myservice", d)
+		if err != nil {
+			t.Fatalf("Deploy: %v", err)
+		}
+		if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+			t.Fatalf("Expected deployment status success, got %v", status)
+		}
+	}
+	if color := getActiveColor(t, "myservice"); color != ecs.ColorGreen {
+		t.Fatalf("Expected traffic to go to green, got %v", color)
+	}
+
+	// blue is still running during the bake time
+	color, err := c.rollbackService("myservice")
+	if err != nil {
+		t.Fatalf("rollbackService: %v", err)
+	}
+	if color != ecs.ColorBlue || getActiveColor(t, "myservice") != ecs.ColorBlue {
+		t.Errorf("Expected traffic to go back to blue")
+	}
+	if _, err = c.rollbackService("myservice"); err == nil {
+		t.Errorf("Expected error when rolling back a deployment that is already rolled back")
+	}
+}
+
+func TestResumeBlueGreenBake(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	d.DeploymentStrategy = "blueGreen"
+	d.BakeTime = 3600
+	var res *service.DeployResult
+	for _, tag := range []string{"v1", "v2"} {
+		var err error
+		d.Containers[0].ContainerTag = tag
+		res, err = c.Deploy("myservice", d)
+		if err != nil {
+			t.Fatalf("Deploy: %v", err)
+		}
+		if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+			t.Fatalf("Expected deployment status success, got %v", status)
+		}
+	}
+	s := service.NewService()
+	dd, err := s.GetDeployment("myservice", res.DeploymentTime.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("GetDeployment: %v", err)
+	}
+	if dd.BakeDeadline == nil || dd.BakeDeadline.Before(time.Now().Add(time.Hour-time.Minute)) {
+		t.Fatalf("Expected the bake deadline to be stored, got %v", dd.BakeDeadline)
+	}
+
+	// the bake time is over while ecs-deploy was restarting
+	past := time.Now().Add(-time.Minute)
+	if err := s.SetBakeDeadline(dd, &past); err != nil {
+		t.Fatalf("SetBakeDeadline: %v", err)
+	}
+	if err := c.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	waitForDesiredCount(t, f, "myservice", 0)
+	for i := 0; i < 100; i++ {
+		if dd, err = s.GetDeployment("myservice", res.DeploymentTime.UTC().Format(time.RFC3339Nano)); err == nil && dd.BakeDeadline == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Expected the bake deadline to be cleared, got %v", dd.BakeDeadline)
+}
+
+// getCanaryRules returns the rules that forward to the primary and to the canary target group
+func getCanaryRules(t *testing.T, f *fake.AWS, serviceName string) ([]string, []string) {
+	alb, err := ecs.NewALB("mycluster")
+	if err != nil {
+		t.Fatalf("NewALB: %v", err)
+	}
+	if err = alb.GetRulesForAllListeners(); err != nil {
+		t.Fatalf("GetRulesForAllListeners: %v", err)
+	}
+	primary := f.GetTargetGroup(serviceName)
+	canary := f.GetTargetGroup(ecs.GetCanaryServiceName(serviceName))
+	if primary == nil || canary == nil {
+		t.Fatalf("Expected primary and canary target group to exist")
+	}
+	return alb.GetRulesByTargetGroupArn(aws.StringValue(primary.TargetGroupArn)), alb.GetRulesByTargetGroupArn(aws.StringValue(canary.TargetGroupArn))
+}
+
+func TestDeployCanary(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	d.DeploymentStrategy = "canary"
+	d.Canary = service.DeployCanary{Steps: []int64{50, 100}, Interval: 1, MaxErrorRate: 10}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+
+	// second deployment goes through the canary
+	d.Containers[0].ContainerTag = "v2"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+	dr, err := c.getDeploymentStatus("myservice", res.DeploymentTime.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("getDeploymentStatus: %v", err)
+	}
+	if dr.CanaryWeight != 100 {
+		t.Errorf("Expected canary weight 100, got %d", dr.CanaryWeight)
+	}
+	if svc := f.GetService("mycluster", "myservice"); aws.StringValue(svc.TaskDefinition) != res.TaskDefinitionArn {
+		t.Errorf("Expected myservice to be promoted to %v, got %v", res.TaskDefinitionArn, aws.StringValue(svc.TaskDefinition))
+	}
+	primaryRules, canaryRules := getCanaryRules(t, f, "myservice")
+	if len(primaryRules) == 0 || len(canaryRules) != 0 {
+		t.Errorf("Expected all traffic to go to the primary target group")
+	}
+	waitForDesiredCount(t, f, "myservice-canary", 0)
+
+	// error rate above maxErrorRate reverts the traffic
+	previousTaskDefinition := res.TaskDefinitionArn
+	d.Containers[0].ContainerTag = "v3"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	dimensions := map[string]string{
+		"LoadBalancer": "app/mycluster/1",
+		"TargetGroup":  "targetgroup/myservice-canary/" + strings.Split(aws.StringValue(f.GetTargetGroup("myservice-canary").TargetGroupArn), "/")[2],
+	}
+	f.AddMetricDatapoint("AWS/ApplicationELB", "RequestCount", dimensions, 100, time.Now())
+	f.AddMetricDatapoint("AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", dimensions, 20, time.Now())
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "failed" {
+		t.Fatalf("Expected deployment status failed, got %v", status)
+	}
+	dr, err = c.getDeploymentStatus("myservice", res.DeploymentTime.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("getDeploymentStatus: %v", err)
+	}
+	if !strings.Contains(dr.DeployError, "error rate") {
+		t.Errorf("Expected deployment to fail on the error rate, got %v", dr.DeployError)
+	}
+	if svc := f.GetService("mycluster", "myservice"); aws.StringValue(svc.TaskDefinition) != previousTaskDefinition {
+		t.Errorf("Expected myservice to keep running %v", previousTaskDefinition)
+	}
+	primaryRules, canaryRules = getCanaryRules(t, f, "myservice")
+	if len(primaryRules) == 0 || len(canaryRules) != 0 {
+		t.Errorf("Expected all traffic to go back to the primary target group")
+	}
+	waitForDesiredCount(t, f, "myservice-canary", 0)
+}
+
+func TestDeployHealthGates(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	d.HealthGates = service.DeployHealthGates{
+		Window:   1,
+		Interval: 1,
+		Alarms:   []string{"myservice-5xx"},
+		Metrics: []service.DeployHealthGateMetric{
+			{Namespace: "AWS/ECS", MetricName: "CPUUtilization", Threshold: 80},
+		},
+	}
+	f.SetAlarmState("myservice-5xx", "OK")
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Fatalf("Expected deployment status success, got %v", status)
+	}
+	stableTaskDefinition := res.TaskDefinitionArn
+
+	// alarm goes off during the window
+	f.SetAlarmState("myservice-5xx", "ALARM")
+	d.Containers[0].ContainerTag = "v2"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "failed" {
+		t.Errorf("Expected deployment status failed, got %v", status)
+	}
+	if svc := f.GetService("mycluster", "myservice"); aws.StringValue(svc.TaskDefinition) != stableTaskDefinition {
+		t.Errorf("Expected myservice to be rolled back to %v, got %v", stableTaskDefinition, aws.StringValue(svc.TaskDefinition))
+	}
+
+	// metric above the threshold
+	f.SetAlarmState("myservice-5xx", "OK")
+	f.AddMetricDatapoint("AWS/ECS", "CPUUtilization", map[string]string{"ClusterName": "mycluster", "ServiceName": "myservice"}, 95, time.Now())
+	d.Containers[0].ContainerTag = "v3"
+	res, err = c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "failed" {
+		t.Errorf("Expected deployment status failed, got %v", status)
+	}
+	dr, err := c.getDeploymentStatus("myservice", res.DeploymentTime.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("getDeploymentStatus: %v", err)
+	}
+	if !strings.Contains(dr.DeployError, "CPUUtilization") {
+		t.Errorf("Expected deployment to fail on CPUUtilization, got %v", dr.DeployError)
+	}
+}
+
+func TestDeployLock(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	defer os.Unsetenv("DEPLOY_LOCK_BEHAVIOR")
+	defer os.Unsetenv("DEPLOY_LOCK_QUEUE_TIMEOUT")
+	deployLockRetryInterval = 10 * time.Millisecond
+	defer func() { deployLockRetryInterval = 5 * time.Second }()
+
+	c := Controller{}
+	res, err := c.Deploy("myservice", newTestDeploy())
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	waitForDeployment(t, "myservice", res.DeploymentTime)
+
+	// the health gates keep the deployment running
+	d := newTestDeploy()
+	d.HealthGates = service.DeployHealthGates{Window: 3600, Interval: 3600, Alarms: []string{"myservice-5xx"}}
+	d.Containers[0].ContainerTag = "v2"
+	running, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	d.Containers[0].ContainerTag = "v3"
+	os.Setenv("DEPLOY_LOCK_BEHAVIOR", "reject")
+	_, err = c.Deploy("myservice", d)
+	lockErr, ok := err.(*service.DeployLockedError)
+	if !ok {
+		t.Fatalf("Expected DeployLockedError, got: %v", err)
+	}
+	if !lockErr.DeploymentTime.Equal(running.DeploymentTime) {
+		t.Errorf("Expected lock to be held by %v, got %v", running.DeploymentTime, lockErr.DeploymentTime)
+	}
+
+	os.Setenv("DEPLOY_LOCK_BEHAVIOR", "queue")
+	os.Setenv("DEPLOY_LOCK_QUEUE_TIMEOUT", "1")
+	if _, err = c.Deploy("myservice", d); err == nil {
+		t.Errorf("Expected queued deploy to time out")
+	}
+	// a queued deploy stops waiting when the client of the api call went away
+	os.Setenv("DEPLOY_LOCK_QUEUE_TIMEOUT", "600")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = c.DeployWithContext(ctx, "myservice", d); err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected queued deploy to stop waiting when the context is done, got: %v", err)
+	}
+
+	os.Setenv("DEPLOY_LOCK_BEHAVIOR", "supersede")
+	res, err = c.Deploy("myservice", newTestDeploy())
+	if err != nil {
+		t.Fatalf("Expected deploy to supersede the running deployment, got: %v", err)
+	}
+	if status := waitForDeployment(t, "myservice", res.DeploymentTime); status != "success" {
+		t.Errorf("Expected deployment status success, got %v", status)
+	}
+}
+
+// waitForWaveDeploy waits until the wave deploy is finished
+func waitForWaveDeploy(id string) *service.DynamoWaveDeploy {
+	s := service.NewService()
+	var w *service.DynamoWaveDeploy
+	for i := 0; i < 100; i++ {
+		var err error
+		w, err = s.GetWaveDeploy(id)
+		if err == nil && w.Status != service.WaveDeployStatusRunning {
+			return w
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return w
+}
+
+func TestDeployServicesWaves(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	deployWaveInterval = 10 * time.Millisecond
+	defer func() { deployWaveInterval = 15 * time.Second }()
+
+	newDeploy := func(serviceName, tag string, dependsOn ...string) service.Deploy {
+		d := newTestDeploy()
+		d.ServiceName = serviceName
+		d.HealthCheck.Path = "/" + serviceName
+		d.Containers[0].ContainerName = serviceName
+		d.Containers[0].ContainerTag = tag
+		d.Containers[0].ContainerURI = "nginx:" + tag
+		d.DependsOn = dependsOn
+		return d
+	}
+	waitForService := func(serviceName, tag string) {
+		for i := 0; i < 100; i++ {
+			svc := f.GetService("mycluster", serviceName)
+			if svc != nil && strings.HasSuffix(aws.StringValue(f.GetTaskDefinition(aws.StringValue(svc.TaskDefinition)).ContainerDefinitions[0].Image), ":"+tag) {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("%v wasn't deployed with tag %v", serviceName, tag)
+	}
+
+	c := Controller{}
+	waves, err := service.GetDeployWaves([]service.Deploy{newDeploy("worker", "v1", "api"), newDeploy("api", "v1")})
+	if err != nil {
+		t.Fatalf("GetDeployWaves: %v", err)
+	}
+	errors := make(map[string]string)
+	results, queued, id := c.DeployServices(context.Background(), waves, errors)
+	if len(errors) > 0 || len(results) != 1 || results[0].ServiceName != "api" {
+		t.Fatalf("Expected api to be deployed in the first wave, got %v (errors: %v)", results, errors)
+	}
+	if len(queued) != 1 || queued[0] != "worker" {
+		t.Fatalf("Expected worker to be queued, got %v", queued)
+	}
+	waitForService("worker", "v1")
+	w := waitForWaveDeploy(id)
+	if w.Status != service.WaveDeployStatusSuccess {
+		t.Fatalf("Expected wave deploy status success, got %+v", w)
+	}
+	for _, ws := range w.Services {
+		if ws.Status != service.WaveDeployStatusSuccess || ws.DeploymentTime == nil {
+			t.Errorf("Expected %v to be deployed, got %+v", ws.ServiceName, ws)
+		}
+	}
+
+	// the worker is not deployed when the api fails
+	f.SetStartTasks(false)
+	waves, err = service.GetDeployWaves([]service.Deploy{newDeploy("worker", "v2", "api"), newDeploy("api", "v2")})
+	if err != nil {
+		t.Fatalf("GetDeployWaves: %v", err)
+	}
+	results, _, id = c.DeployServices(context.Background(), waves, errors)
+	if status := waitForDeployment(t, "api", results[0].DeploymentTime); status != "failed" {
+		t.Fatalf("Expected deployment status failed, got %v", status)
+	}
+	w = waitForWaveDeploy(id)
+	if w.Status != service.WaveDeployStatusFailed || w.Services[1].ServiceName != "worker" || w.Services[1].Status != service.WaveDeployStatusSkipped {
+		t.Fatalf("Expected the worker to be skipped, got %+v", w)
+	}
+	dds, err := c.getDeploysForService("worker")
+	if err != nil {
+		t.Fatalf("getDeploysForService: %v", err)
+	}
+	if len(dds) != 1 {
+		t.Errorf("Expected worker not to be deployed again, got %d deployments", len(dds))
+	}
+
+	// the next waves of a stored wave deploy are resumed after a restart
+	f.SetStartTasks(true)
+	waves, err = service.GetDeployWaves([]service.Deploy{newDeploy("worker", "v3", "api"), newDeploy("api", "v1")})
+	if err != nil {
+		t.Fatalf("GetDeployWaves: %v", err)
+	}
+	w = service.NewWaveDeploy(waves, map[string]string{})
+	w.Services[0].Status = service.WaveDeployStatusSuccess
+	s := service.NewService()
+	if err := s.CreateWaveDeploy(w); err != nil {
+		t.Fatalf("CreateWaveDeploy: %v", err)
+	}
+	if err := c.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	waitForService("worker", "v3")
+	if w = waitForWaveDeploy(w.Id); w.Status != service.WaveDeployStatusSuccess {
+		t.Errorf("Expected the resumed wave deploy to succeed, got %+v", w)
+	}
+}
+
+func TestPlanDeploy(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+
+	c := Controller{}
+	d := newTestDeploy()
+	plan, err := c.planDeploy("myservice", d)
+	if err != nil {
+		t.Fatalf("planDeploy: %v", err)
+	}
+	if plan.Action != "create" {
+		t.Errorf("Expected action create, got %v", plan.Action)
+	}
+	res, err := c.Deploy("myservice", d)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	waitForDeployment(t, "myservice", res.DeploymentTime)
+
+	d.Containers[0].ContainerTag = "v2"
+	d.LoadBalancer = "mycluster"
+	plan, err = c.planDeploy("myservice", d)
+	if err != nil {
+		t.Fatalf("planDeploy: %v", err)
+	}
+	if plan.Action != "update" || len(plan.Changes) != 1 || plan.Changes[0].Field != "containers[0].containerTag" {
+		t.Errorf("Expected update of the container tag, got %v: %+v", plan.Action, plan.Changes)
+	}
+	if len(plan.Warnings) > 0 {
+		t.Errorf("Expected no warnings, got %v", plan.Warnings)
+	}
+
+	d.LoadBalancer = "otherlb"
+	plan, err = c.planDeploy("myservice", d)
+	if err != nil {
+		t.Fatalf("planDeploy: %v", err)
+	}
+	if plan.Action != "recreate" {
+		t.Errorf("Expected action recreate, got %v", plan.Action)
+	}
+	dds, err := c.getDeploysForService("myservice")
+	if err != nil {
+		t.Fatalf("getDeploysForService: %v", err)
+	}
+	if len(dds) != 1 {
+		t.Errorf("Expected plan not to deploy, got %d deployments", len(dds))
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/util"
+	"github.com/in4it/ecs-deploy/validation"
+
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// error codes of the error response
+const (
+	ErrorCodeBadRequest   = "bad_request"
+	ErrorCodeValidation   = "validation_failed"
+	ErrorCodeUnauthorized = "unauthorized"
+	ErrorCodeForbidden    = "forbidden"
+	ErrorCodeNotFound     = "not_found"
+	ErrorCodeConflict     = "conflict"
+	ErrorCodeRateLimited  = "rate_limited"
+	ErrorCodeDeployFailed = "deploy_failed"
+	ErrorCodeUpstream     = "upstream_error"
+	ErrorCodeInternal     = "internal_error"
+)
+
+// the header with the id of a request, a valid id of the client is kept
+const requestIDHeader = "X-Request-ID"
+
+var requestIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// APIError is an error with the http status and the error code of the response
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details interface{}
+	// Err is the underlying error, it is logged but not returned to the client
+	Err error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId"`
+	// Error is the message, for the clients that only read the error
+	Error string `json:"error"`
+}
+
+func newBadRequestError(message string) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: ErrorCodeBadRequest, Message: message}
+}
+func newValidationError(errs validation.Errors) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: ErrorCodeValidation, Message: errs.Error(), Details: errs}
+}
+func newForbiddenError(message string) *APIError {
+	return &APIError{Status: http.StatusForbidden, Code: ErrorCodeForbidden, Message: message}
+}
+func newNotFoundError(message string) *APIError {
+	return &APIError{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: message}
+}
+func newConflictError(message string, details interface{}) *APIError {
+	return &APIError{Status: http.StatusConflict, Code: ErrorCodeConflict, Message: message, Details: details}
+}
+
+// toAPIError maps the errors of the controller, the services and aws to an api error
+func toAPIError(err error) *APIError {
+	switch e := err.(type) {
+	case *APIError:
+		return e
+	case validation.Errors:
+		return newValidationError(e)
+	case *service.DeployLockedError:
+		return newConflictError(e.Error(), gin.H{"deploymentTime": e.DeploymentTime})
+	case *time.ParseError:
+		return &APIError{Status: http.StatusBadRequest, Code: ErrorCodeBadRequest, Message: "Invalid time: " + e.Value, Err: e}
+	case awserr.Error:
+		switch code := e.Code(); {
+		case strings.Contains(code, "NotFound"):
+			return &APIError{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: e.Error(), Err: e}
+		case strings.Contains(code, "AlreadyExists"):
+			return &APIError{Status: http.StatusConflict, Code: ErrorCodeConflict, Message: e.Error(), Err: e}
+		case strings.Contains(code, "AccessDenied"), strings.Contains(code, "UnauthorizedOperation"):
+			return &APIError{Status: http.StatusForbidden, Code: ErrorCodeForbidden, Message: e.Error(), Err: e}
+		case strings.Contains(code, "Throttling"), strings.Contains(code, "TooManyRequests"):
+			return &APIError{Status: http.StatusTooManyRequests, Code: ErrorCodeRateLimited, Message: e.Error(), Err: e}
+		case strings.HasPrefix(code, "Validation"), strings.HasPrefix(code, "InvalidParameter"):
+			return &APIError{Status: http.StatusBadRequest, Code: ErrorCodeBadRequest, Message: e.Error(), Err: e}
+		}
+		return &APIError{Status: http.StatusBadGateway, Code: ErrorCodeUpstream, Message: e.Error(), Err: e}
+	}
+	switch err {
+	case service.ErrServiceNotFound:
+		return &APIError{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: "Service not found", Err: err}
+	case service.ErrNoDeploymentFound:
+		return &APIError{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: "No deployment found for service", Err: err}
+	case service.ErrNoItemFound:
+		return &APIError{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: "Not found", Err: err}
+	case service.ErrConditionalCheckFailed:
+		return &APIError{Status: http.StatusConflict, Code: ErrorCodeConflict, Message: "The resource was changed in the meantime, try again", Err: err}
+	}
+	return &APIError{Status: http.StatusInternalServerError, Code: ErrorCodeInternal, Message: err.Error(), Err: err}
+}
+
+// requestID returns a middleware that sets the X-Request-ID of the client, or a new one, on the request and the response
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set("requestId", id)
+		c.Header(requestIDHeader, id)
+		// the controller and the services log the id of the request context
+		c.Request = c.Request.WithContext(util.WithRequestID(c.Request.Context(), id))
+		start := time.Now()
+
+		c.Next()
+
+		apiLogger.Debugf("[%v] %v %v: %d (%v)", id, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), time.Since(start))
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// getRequestID returns the id of the request, to add to the logs
+func getRequestID(c *gin.Context) string {
+	return c.GetString("requestId")
+}
+
+// newService returns a service that logs the id of the request
+func newService(c *gin.Context) *service.Service {
+	s := service.NewService()
+	s.RequestID = getRequestID(c)
+	return s
+}
+
+// handleErrors returns a middleware that writes the error response of the last error of the handlers (see c.Error)
+func handleErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		writeError(c, toAPIError(c.Errors.Last().Err))
+	}
+}
+
+// writeError logs the error with the request id and writes the error response
+func writeError(c *gin.Context, e *APIError) {
+	logError := e.Message
+	if e.Err != nil && e.Err.Error() != e.Message {
+		logError += ": " + e.Err.Error()
+	}
+	if e.Status >= 500 {
+		apiLogger.Errorf("[%v] %v %v: %d %v", getRequestID(c), c.Request.Method, c.Request.URL.Path, e.Status, logError)
+	} else {
+		apiLogger.Infof("[%v] %v %v: %d %v", getRequestID(c), c.Request.Method, c.Request.URL.Path, e.Status, logError)
+	}
+	c.AbortWithStatusJSON(e.Status, ErrorResponse{
+		Code:      e.Code,
+		Message:   e.Message,
+		Details:   e.Details,
+		RequestID: getRequestID(c),
+		Error:     e.Message,
+	})
+}
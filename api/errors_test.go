@@ -0,0 +1,108 @@
+package api
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/validation"
+
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestToAPIError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{newNotFoundError("Service myservice not found"), http.StatusNotFound, ErrorCodeNotFound},
+		{service.ErrServiceNotFound, http.StatusNotFound, ErrorCodeNotFound},
+		{service.ErrNoDeploymentFound, http.StatusNotFound, ErrorCodeNotFound},
+		{service.ErrConditionalCheckFailed, http.StatusConflict, ErrorCodeConflict},
+		{&service.DeployLockedError{ServiceName: "myservice", DeploymentTime: time.Now()}, http.StatusConflict, ErrorCodeConflict},
+		{validation.Errors{{Field: "serviceName", Message: "too short"}}, http.StatusBadRequest, ErrorCodeValidation},
+		{awserr.New("ServiceNotFoundException", "Service not found.", nil), http.StatusNotFound, ErrorCodeNotFound},
+		{awserr.New("RepositoryAlreadyExistsException", "The repository already exists", nil), http.StatusConflict, ErrorCodeConflict},
+		{awserr.New("AccessDeniedException", "User is not authorized to perform ecs:UpdateService", nil), http.StatusForbidden, ErrorCodeForbidden},
+		{awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil), http.StatusForbidden, ErrorCodeForbidden},
+		{awserr.New("ThrottlingException", "Rate exceeded", nil), http.StatusTooManyRequests, ErrorCodeRateLimited},
+		{awserr.New("TooManyRequestsException", "Too many requests", nil), http.StatusTooManyRequests, ErrorCodeRateLimited},
+		{awserr.New("ValidationError", "1 validation error detected", nil), http.StatusBadRequest, ErrorCodeBadRequest},
+		{awserr.New("InvalidParameterException", "Invalid revision number", nil), http.StatusBadRequest, ErrorCodeBadRequest},
+		{awserr.New("ServerException", "Server error", nil), http.StatusBadGateway, ErrorCodeUpstream},
+		{errors.New("something went wrong"), http.StatusInternalServerError, ErrorCodeInternal},
+	}
+	for _, test := range tests {
+		e := toAPIError(test.err)
+		if e.Status != test.status || e.Code != test.code {
+			t.Errorf("%v: expected %v %v, got %v %v", test.err, test.status, test.code, e.Status, e.Code)
+		}
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestID(), handleErrors())
+	r.POST("/service/scale/:service/:count", func(c *gin.Context) {
+		c.Error(service.ErrServiceNotFound)
+	})
+	r.POST("/deploy/:service", func(c *gin.Context) {
+		c.Error(validation.Errors{{Field: "serviceName", Message: "too short"}})
+	})
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "OK", "requestId": newController(c.Request.Context()).requestID})
+	})
+
+	// the request id of the client is kept
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/service/scale/myservice/2", nil)
+	req.Header.Set(requestIDHeader, "ci-build-42")
+	r.ServeHTTP(w, req)
+	var response ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Could not unmarshal the error response: %v", err)
+	}
+	if w.Code != http.StatusNotFound || response.Code != ErrorCodeNotFound || response.Message != "Service not found" || response.Error != response.Message {
+		t.Errorf("Unexpected error response: %v %+v", w.Code, response)
+	}
+	if response.RequestID != "ci-build-42" || w.Header().Get(requestIDHeader) != "ci-build-42" {
+		t.Errorf("Expected the request id of the client, got %v (header: %v)", response.RequestID, w.Header().Get(requestIDHeader))
+	}
+
+	// an invalid request id is replaced
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/deploy/ab", nil)
+	req.Header.Set(requestIDHeader, "id with spaces")
+	r.ServeHTTP(w, req)
+	var validationResponse struct {
+		ErrorResponse
+		Details validation.Errors `json:"details"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &validationResponse); err != nil {
+		t.Fatalf("Could not unmarshal the error response: %v", err)
+	}
+	if w.Code != http.StatusBadRequest || validationResponse.Code != ErrorCodeValidation || len(validationResponse.Details) != 1 {
+		t.Errorf("Unexpected error response: %v %+v", w.Code, validationResponse)
+	}
+	if id := w.Header().Get(requestIDHeader); id == "" || id == "id with spaces" || validationResponse.RequestID != id {
+		t.Errorf("Expected a new request id, got %v (response: %v)", id, validationResponse.RequestID)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != 200 || w.Header().Get(requestIDHeader) == "" {
+		t.Errorf("Expected a request id on a successful response, got %v", w.Header())
+	}
+	var health struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil || health.RequestID != w.Header().Get(requestIDHeader) {
+		t.Errorf("Expected the request id in the context of the request, got %v (header: %v)", health.RequestID, w.Header().Get(requestIDHeader))
+	}
+}
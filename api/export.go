@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/in4it/ecs-deploy/provider/ecs"
 	"github.com/in4it/ecs-deploy/service"
 	"github.com/in4it/ecs-deploy/util"
@@ -106,6 +107,7 @@ func (e *Export) getTemplateMap(serviceName, clusterName string) error {
 	e.templateMap["${CLUSTERNAME}"] = clusterName
 	e.templateMap["${LOADBALANCER}"] = loadBalancer
 	if targetGroup != nil {
+		e.templateMap["${TARGET_GROUP_NAME}"] = serviceName
 		e.templateMap["${TARGET_GROUP_ARN}"] = *targetGroup
 	}
 	e.templateMap["${SERVICE_DESIREDCOUNT}"] = strconv.FormatInt(e.deployData.DesiredCount, 10)
@@ -133,50 +135,110 @@ func (e *Export) getTemplateMap(serviceName, clusterName string) error {
 	e.templateMap["${PARAMSTORE_KMS_ARN}"] = util.GetEnv("PARAMSTORE_KMS_ARN", "")
 	e.templateMap["${VPC_ID}"] = e.alb[loadBalancer].VpcId
 	if e.deployData.HealthCheck.HealthyThreshold != 0 {
-		b, err := ioutil.ReadFile("templates/export/alb_targetgroup_healthcheck.tf")
+		e.templateMap["${HEALTHCHECK}"], err = e.getHealthCheckTemplate(e.deployData.HealthCheck, e.deployData.ServiceProtocol)
 		if err != nil {
-			exportLogger.Errorf("Can't read template templates/export/alb_targetgroup_healthcheck.tf")
 			return err
 		}
-		str := string(b)
-		if e.deployData.HealthCheck.HealthyThreshold != 0 {
-			str = strings.Replace(str, "${HEALTHCHECK_HEALTHYTHRESHOLD}", strconv.FormatInt(e.deployData.HealthCheck.HealthyThreshold, 10), -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_HEALTHYTHRESHOLD}", "3", -1)
-		}
-		if e.deployData.HealthCheck.UnhealthyThreshold != 0 {
-			str = strings.Replace(str, "${HEALTHCHECK_UNHEALTHYTHRESHOLD}", strconv.FormatInt(e.deployData.HealthCheck.UnhealthyThreshold, 10), -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_UNHEALTHYTHRESHOLD}", "2", -1)
+	}
+	return nil
+}
+
+func (e *Export) getHealthCheckTemplate(healthCheck service.DeployHealthCheck, serviceProtocol string) (string, error) {
+	template := "alb_targetgroup_healthcheck.tf"
+	// target groups of network loadbalancers use tcp health checks, without path and matcher
+	if service.IsNetworkProtocol(serviceProtocol) && (healthCheck.Protocol == "" || strings.ToUpper(healthCheck.Protocol) == "TCP") {
+		template = "nlb_targetgroup_healthcheck.tf"
+	}
+	b, err := ioutil.ReadFile("templates/export/" + template)
+	if err != nil {
+		exportLogger.Errorf("Can't read template templates/export/" + template)
+		return "", err
+	}
+	str := string(b)
+	if healthCheck.HealthyThreshold != 0 {
+		str = strings.Replace(str, "${HEALTHCHECK_HEALTHYTHRESHOLD}", strconv.FormatInt(healthCheck.HealthyThreshold, 10), -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_HEALTHYTHRESHOLD}", "3", -1)
+	}
+	if healthCheck.UnhealthyThreshold != 0 {
+		str = strings.Replace(str, "${HEALTHCHECK_UNHEALTHYTHRESHOLD}", strconv.FormatInt(healthCheck.UnhealthyThreshold, 10), -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_UNHEALTHYTHRESHOLD}", "2", -1)
+	}
+	if healthCheck.Protocol != "" {
+		str = strings.Replace(str, "${HEALTHCHECK_PROTOCOL}", healthCheck.Protocol, -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_PROTOCOL}", "HTTP", -1)
+	}
+	if healthCheck.Path != "" {
+		str = strings.Replace(str, "${HEALTHCHECK_PATH}", healthCheck.Path, -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_PATH}", "/", -1)
+	}
+	if healthCheck.Interval != 0 {
+		str = strings.Replace(str, "${HEALTHCHECK_INTERVAL}", strconv.FormatInt(healthCheck.Interval, 10), -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_INTERVAL}", "30", -1)
+	}
+	if healthCheck.Matcher != "" {
+		str = strings.Replace(str, "${HEALTHCHECK_MATCHER}", healthCheck.Matcher, -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_MATCHER}", "200", -1)
+	}
+	if healthCheck.Timeout > 0 {
+		str = strings.Replace(str, "${HEALTHCHECK_TIMEOUT}", strconv.FormatInt(healthCheck.Timeout, 10), -1)
+	} else {
+		str = strings.Replace(str, "${HEALTHCHECK_TIMEOUT}", "5", -1)
+	}
+	return str, nil
+}
+
+// getLoadBalancerTemplates returns the target groups and listener rules of the loadBalancers of the service
+func (e *Export) getLoadBalancerTemplates(serviceName string) (string, error) {
+	var ret string
+	for _, lb := range e.deployData.LoadBalancers {
+		lbDeploy := service.GetLoadBalancerDeploy(*e.deployData, lb)
+		loadBalancer := service.GetLoadBalancerName(lbDeploy)
+		if _, ok := e.alb[loadBalancer]; !ok {
+			var err error
+			e.alb[loadBalancer], err = ecs.NewALB(loadBalancer)
+			if err != nil {
+				return "", err
+			}
+			err = e.alb[loadBalancer].GetRulesForAllListeners()
+			if err != nil {
+				return "", err
+			}
 		}
-		if e.deployData.HealthCheck.Protocol != "" {
-			str = strings.Replace(str, "${HEALTHCHECK_PROTOCOL}", e.deployData.HealthCheck.Protocol, -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_PROTOCOL}", "HTTP", -1)
+		targetGroupName := service.GetLoadBalancerTargetGroupName(serviceName, lb)
+		targetGroup, err := e.alb[loadBalancer].FindTargetGroupArn(targetGroupName)
+		if err != nil {
+			return "", err
 		}
-		if e.deployData.HealthCheck.Path != "" {
-			str = strings.Replace(str, "${HEALTHCHECK_PATH}", e.deployData.HealthCheck.Path, -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_PATH}", "/", -1)
+		if targetGroup == nil {
+			return "", errors.New("No target group found for " + targetGroupName)
 		}
-		if e.deployData.HealthCheck.Interval != 0 {
-			str = strings.Replace(str, "${HEALTHCHECK_INTERVAL}", strconv.FormatInt(e.deployData.HealthCheck.Interval, 10), -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_INTERVAL}", "30", -1)
+		e.templateMap["${TARGET_GROUP_NAME}"] = targetGroupName
+		e.templateMap["${TARGET_GROUP_ARN}"] = *targetGroup
+		e.templateMap["${SERVICE_PORT}"] = strconv.FormatInt(lb.ContainerPort, 10)
+		e.templateMap["${SERVICE_PROTOCOL}"] = lb.Protocol
+		e.templateMap["${VPC_ID}"] = e.alb[loadBalancer].VpcId
+		e.templateMap["${HEALTHCHECK}"], err = e.getHealthCheckTemplate(lb.HealthCheck, lb.Protocol)
+		if err != nil {
+			return "", err
 		}
-		if e.deployData.HealthCheck.Matcher != "" {
-			str = strings.Replace(str, "${HEALTHCHECK_MATCHER}", e.deployData.HealthCheck.Matcher, -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_MATCHER}", "200", -1)
+		t, err := e.getTemplate("alb_targetgroup.tf")
+		if err != nil {
+			return "", err
 		}
-		if e.deployData.HealthCheck.Timeout > 0 {
-			str = strings.Replace(str, "${HEALTHCHECK_TIMEOUT}", strconv.FormatInt(e.deployData.HealthCheck.Timeout, 10), -1)
-		} else {
-			str = strings.Replace(str, "${HEALTHCHECK_TIMEOUT}", "5", -1)
+		ret += *t
+		t, err = e.getListenerRules(serviceName, nil, loadBalancer, lb.RuleConditions)
+		if err != nil {
+			return "", err
 		}
-		e.templateMap["${HEALTHCHECK}"] = str
+		ret += *t
 	}
-	return nil
+	return ret, nil
 }
 
 // check first whether the template is in the parameter store
@@ -259,19 +321,31 @@ func (e *Export) terraform() (*map[string]ExportedApps, error) {
 
 		// get listener rules
 		if processTargetGroup {
-			t, err := e.getListenerRules(service.S, service.C, service.Listeners, e.templateMap["${LOADBALANCER}"])
+			t, err := e.getListenerRules(service.S, service.Listeners, e.templateMap["${LOADBALANCER}"], e.deployData.RuleConditions)
 			if err != nil {
 				return nil, err
 			}
 			ret += *t
 		}
+		// target groups of the other ports
+		t, err := e.getLoadBalancerTemplates(service.S)
+		if err != nil {
+			return nil, err
+		}
+		ret += t
 		export["apps"][service.S] = base64.StdEncoding.EncodeToString([]byte(ret))
 	}
 	return &export, nil
 }
 
-func (e *Export) getListenerRules(serviceName string, clusterName string, listeners []string, loadBalancer string) (*string, error) {
+// getListenerRules returns the listener rules of the current target group (TARGET_GROUP_ARN). Without rule conditions,
+// the default rules of the service are used
+func (e *Export) getListenerRules(serviceName string, listeners []string, loadBalancer string, ruleConditions []*service.DeployRuleConditions) (*string, error) {
 	var ret string
+	// network loadbalancers have a listener per service instead of rules
+	if e.alb[loadBalancer].IsNetwork() {
+		return e.getNetworkListeners(loadBalancer)
+	}
 	// listeners
 	albListenerRule, err := e.getTemplate("alb_listenerrule.tf")
 	if err != nil {
@@ -281,7 +355,7 @@ func (e *Export) getListenerRules(serviceName string, clusterName string, listen
 	if err != nil {
 		return nil, err
 	}
-	if len(e.deployData.RuleConditions) == 0 {
+	if len(ruleConditions) == 0 {
 		exportLogger.Debugf("No rule conditions, going with default rules")
 		for _, l := range listeners {
 			a := strings.Replace(*albListenerRule, "${LISTENER_ARN}", l, -1)
@@ -301,7 +375,12 @@ func (e *Export) getListenerRules(serviceName string, clusterName string, listen
 		}
 	} else {
 		exportLogger.Debugf("Found rule conditions in deploy, examining conditions")
-		for _, y := range e.deployData.RuleConditions {
+		for _, y := range ruleConditions {
+			// the listener rule template only has path-pattern and host-header conditions
+			if !isExportableRuleCondition(y) {
+				exportLogger.Infof("Skipping export of rule condition of %v: only pathPattern and hostname can be exported", serviceName)
+				continue
+			}
 			for _, l := range e.alb[loadBalancer].Listeners {
 				for _, l2 := range y.Listeners {
 					if l.Protocol != nil && strings.ToLower(*l.Protocol) == strings.ToLower(l2) {
@@ -338,6 +417,45 @@ func (e *Export) getListenerRules(serviceName string, clusterName string, listen
 	return &ret, nil
 }
 
+func isExportableRuleCondition(r *service.DeployRuleConditions) bool {
+	if len(r.PathPatterns) > 0 || len(r.Hostnames) > 0 || len(r.HttpHeaders) > 0 || len(r.QueryStrings) > 0 || len(r.HttpRequestMethods) > 0 || len(r.SourceIps) > 0 {
+		return false
+	}
+	action := r.Action
+	return (action.Type == "" || strings.ToLower(action.Type) == "forward") && action.AuthenticateOidc == nil && action.AuthenticateCognito == nil
+}
+
+// getNetworkListeners returns the listeners of a network loadbalancer that forward to the current target group
+func (e *Export) getNetworkListeners(loadBalancer string) (*string, error) {
+	var ret string
+	for _, l := range e.alb[loadBalancer].Listeners {
+		var forward bool
+		for _, action := range l.DefaultActions {
+			if aws.StringValue(action.TargetGroupArn) == e.templateMap["${TARGET_GROUP_ARN}"] {
+				forward = true
+			}
+		}
+		if !forward {
+			continue
+		}
+		t, err := e.getTemplate("nlb_listener.tf")
+		if err != nil {
+			return nil, err
+		}
+		a := strings.Replace(*t, "${LISTENER_ARN}", aws.StringValue(l.ListenerArn), -1)
+		a = strings.Replace(a, "${LOADBALANCER_ARN}", aws.StringValue(l.LoadBalancerArn), -1)
+		a = strings.Replace(a, "${LISTENER_PORT}", strconv.FormatInt(aws.Int64Value(l.Port), 10), -1)
+		a = strings.Replace(a, "${LISTENER_PROTOCOL}", aws.StringValue(l.Protocol), -1)
+		if len(l.Certificates) > 0 {
+			a = strings.Replace(a, "${LISTENER_CERTIFICATE}", `certificate_arn = "`+aws.StringValue(l.Certificates[0].CertificateArn)+`"`, -1)
+		} else {
+			a = strings.Replace(a, "${LISTENER_CERTIFICATE}", "// no certificate set", -1)
+		}
+		ret += a
+	}
+	return &ret, nil
+}
+
 func (e *Export) getTargetGroupArn(serviceName string) (*string, error) {
 	a := ecs.ALB{}
 	return a.GetTargetGroupArn(serviceName)
@@ -354,7 +472,7 @@ func (e *Export) getListenerRuleArn(serviceName string, rulePriority string) (*s
 		}
 	}
 	if clusterName == "" {
-		return nil, errors.New("Service not found: " + serviceName)
+		return nil, newNotFoundError("Service not found: " + serviceName)
 	}
 	a, err := ecs.NewALB(clusterName)
 	if err != nil {
@@ -369,7 +487,7 @@ func (e *Export) getListenerRuleArn(serviceName string, rulePriority string) (*s
 		for _, rule := range rules {
 			if *rule.Priority == rulePriority {
 				if listenerRuleArn != "" {
-					return nil, errors.New("Duplicate listener rule found, can't determine listener (rule = " + rulePriority + ", Conflict between " + listenerRuleArn + " and " + *rule.RuleArn + ")")
+					return nil, newConflictError("Duplicate listener rule found, can't determine listener (rule = "+rulePriority+", Conflict between "+listenerRuleArn+" and "+*rule.RuleArn+")", nil)
 				} else {
 					if len(rule.Actions) > 0 && *rule.Actions[0].TargetGroupArn == *targetGroupArn {
 						listenerRuleArn = *rule.RuleArn
@@ -379,7 +497,7 @@ func (e *Export) getListenerRuleArn(serviceName string, rulePriority string) (*s
 		}
 	}
 	if listenerRuleArn == "" {
-		return nil, errors.New("No rule with priority " + rulePriority + " found")
+		return nil, newNotFoundError("No rule with priority " + rulePriority + " found")
 	}
 	return &listenerRuleArn, nil
 }
@@ -397,7 +515,7 @@ func (e *Export) getListenerRuleArns(serviceName string) (*ListenerRuleExport, e
 		}
 	}
 	if clusterName == "" {
-		return nil, errors.New("Service not found: " + serviceName)
+		return nil, newNotFoundError("Service not found: " + serviceName)
 	}
 	a, err := ecs.NewALB(clusterName)
 	if err != nil {
@@ -431,7 +549,7 @@ func (e *Export) getListenerRuleArns(serviceName string) (*ListenerRuleExport, e
 		}
 	}
 	if len(exportRuleKeys) == 0 {
-		return nil, errors.New("No rules found for service: " + serviceName)
+		return nil, newNotFoundError("No rules found for service: " + serviceName)
 	}
 	sort.Sort(exportRuleKeys)
 	result = &ListenerRuleExport{RuleKeys: exportRuleKeys, Rules: exportRules}
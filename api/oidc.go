@@ -0,0 +1,415 @@
+package api
+
+// openid connect authorization code flow, issues the same jwt as the saml login
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/util"
+	"github.com/juju/loggo"
+
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logging
+var oidcLogger = loggo.GetLogger("oidc")
+
+// the state of a login is kept in a cookie for this long
+const oidcStateTimeout = 10 * time.Minute
+
+type OIDC struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	userClaim    string
+	groupsClaim  string
+	roleMapping  []OIDCRoleMapping
+	discovery    oidcDiscovery
+	keys         map[string]*rsa.PublicKey
+	keysMu       sync.Mutex
+	httpClient   *http.Client
+	TimeFunc     func() time.Time
+}
+
+// OIDCRoleMapping grants a role when the claim of the id token has the value (or contains it, for lists)
+type OIDCRoleMapping struct {
+	Claim string
+	Value string
+	Role  string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+type oidcJwks struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// newOIDC retrieves the openid configuration of the issuer
+func newOIDC(issuer, clientID, clientSecret, redirectURL string, scopes []string) (*OIDC, error) {
+	if issuer == "" || clientID == "" || redirectURL == "" {
+		return nil, errors.New("OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required to enable oidc")
+	}
+	o := &OIDC{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		userClaim:    "email",
+		groupsClaim:  "groups",
+		keys:         make(map[string]*rsa.PublicKey),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		TimeFunc:     time.Now,
+	}
+	err := o.getJSON(o.issuer+"/.well-known/openid-configuration", &o.discovery)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the openid configuration of %v: %v", o.issuer, err)
+	}
+	if o.discovery.Issuer != o.issuer {
+		return nil, fmt.Errorf("Issuer of the openid configuration (%v) doesn't match %v", o.discovery.Issuer, o.issuer)
+	}
+	return o, nil
+}
+
+// parseOIDCRoleMapping parses a comma separated list of claim:value=role
+func parseOIDCRoleMapping(mapping string) ([]OIDCRoleMapping, error) {
+	var result []OIDCRoleMapping
+	for _, m := range strings.Split(mapping, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		colon := strings.Index(m, ":")
+		equals := strings.LastIndex(m, "=")
+		if colon <= 0 || equals <= colon || equals == len(m)-1 {
+			return nil, fmt.Errorf("Invalid oidc role mapping %v, expected claim:value=role", m)
+		}
+		result = append(result, OIDCRoleMapping{Claim: m[:colon], Value: m[colon+1 : equals], Role: m[equals+1:]})
+	}
+	return result, nil
+}
+
+func (o *OIDC) getJSON(url string, v interface{}) error {
+	resp, err := o.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%v returned %v", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (o *OIDC) oidcEnabledHandler(c *gin.Context) {
+	if util.GetEnv("OIDC_ENABLED", "") == "yes" {
+		c.JSON(200, gin.H{
+			"oidc": "enabled",
+		})
+	} else {
+		c.JSON(200, gin.H{
+			"oidc": "disabled",
+		})
+	}
+}
+
+// oidcLoginHandler redirects to the identity provider. The state, the nonce and the redirect (a localhost url of the
+// client, or the web UI) are kept in a signed cookie
+func (o *OIDC) oidcLoginHandler(c *gin.Context) {
+	redirect := c.Query("redirect")
+	if redirect != "" && !isLoopbackURL(redirect) {
+		c.Error(newBadRequestError("redirect needs to be a localhost url"))
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(randomBytes(32))
+	nonce := base64.RawURLEncoding.EncodeToString(randomBytes(32))
+
+	token := jwt.New(jwtSigningMethod)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["state"] = state
+	claims["nonce"] = nonce
+	claims["redirect"] = redirect
+	claims["exp"] = o.TimeFunc().Add(oidcStateTimeout).Unix()
+	signedState, err := token.SignedString([]byte(util.GetEnv("JWT_SECRET", "unsecure secret key 8a045eb")))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	redirectURL, _ := url.Parse(o.redirectURL)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    signedState,
+		MaxAge:   int(oidcStateTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   redirectURL != nil && redirectURL.Scheme == "https",
+		Path:     "/",
+	})
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", o.clientID)
+	params.Set("redirect_uri", o.redirectURL)
+	params.Set("scope", strings.Join(o.scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	separator := "?"
+	if strings.Contains(o.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, o.discovery.AuthorizationEndpoint+separator+params.Encode())
+}
+
+// oidcCallbackHandler exchanges the code for the id token, and redirects to the client or the web UI with a jwt
+func (o *OIDC) oidcCallbackHandler(c *gin.Context) {
+	if c.Query("error") != "" {
+		oidcLogger.Errorf("Login failed: %v: %v", c.Query("error"), c.Query("error_description"))
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	stateClaims, err := o.getStateClaims(c)
+	if err != nil || stateClaims["state"] != c.Query("state") {
+		oidcLogger.Errorf("Invalid state: %v", err)
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{Name: "oidc_state", Value: "", MaxAge: -1, Path: "/"})
+
+	idToken, err := o.exchangeCode(c.Query("code"))
+	if err != nil {
+		oidcLogger.Errorf("Could not exchange the code: %v", err)
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	nonce, _ := stateClaims["nonce"].(string)
+	claims, err := o.verifyIDToken(idToken, nonce)
+	if err != nil {
+		oidcLogger.Errorf("Invalid id token: %v", err)
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	id, _ := claims[o.userClaim].(string)
+	c.Set("userID", id)
+	if id == "" {
+		oidcLogger.Errorf("Claim %v is missing in the id token", o.userClaim)
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	// anyone can sign up at some identity providers with an email address they don't own
+	if o.userClaim == "email" && !isEmailVerified(claims) {
+		oidcLogger.Errorf("Email %v is not verified", id)
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
+		return
+	}
+	tokenString, err := createToken(id, getClaimValues(claims, o.groupsClaim), o.getRoles(claims), o.TimeFunc())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	// redirect to the client or the UI with jwt token
+	if redirect, _ := stateClaims["redirect"].(string); redirect != "" {
+		c.Redirect(http.StatusFound, redirect+"?token="+url.QueryEscape(tokenString))
+		return
+	}
+	c.Redirect(http.StatusFound, util.GetEnv("URL_PREFIX", "")+"/webapp/saml?token="+tokenString)
+}
+
+func (o *OIDC) getStateClaims(c *gin.Context) (jwt.MapClaims, error) {
+	cookie, err := c.Request.Cookie("oidc_state")
+	if err != nil {
+		return nil, err
+	}
+	jwtParser := jwt.Parser{
+		ValidMethods: []string{jwtSigningMethod.Name},
+	}
+	token, err := jwtParser.Parse(cookie.Value, func(t *jwt.Token) (interface{}, error) {
+		return []byte(util.GetEnv("JWT_SECRET", "unsecure secret key 8a045eb")), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims.(jwt.MapClaims), nil
+}
+
+func (o *OIDC) exchangeCode(code string) (string, error) {
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", code)
+	params.Set("redirect_uri", o.redirectURL)
+	params.Set("client_id", o.clientID)
+	params.Set("client_secret", o.clientSecret)
+	req, err := http.NewRequest("POST", o.discovery.TokenEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var tokenResponse oidcTokenResponse
+	if err = json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 || tokenResponse.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %v: %v", resp.Status, tokenResponse.Error)
+	}
+	if tokenResponse.IDToken == "" {
+		return "", errors.New("no id token in the token response")
+	}
+	return tokenResponse.IDToken, nil
+}
+
+// verifyIDToken checks the signature (RS256, with the keys of the issuer), the issuer, the audience, the expiry and the
+// nonce of the id token
+func (o *OIDC) verifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+	jwtParser := jwt.Parser{
+		ValidMethods: []string{jwt.SigningMethodRS256.Name},
+	}
+	token, err := jwtParser.Parse(idToken, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.getKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyIssuer(o.issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if !claims.VerifyAudience(o.clientID, true) && !containsString(getClaimValues(claims, "aud"), o.clientID) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("nonce doesn't match")
+	}
+	return claims, nil
+}
+
+// getKey returns the key of the issuer with the key id, the keys are retrieved again when the key is unknown (rotated)
+func (o *OIDC) getKey(kid string) (*rsa.PublicKey, error) {
+	o.keysMu.Lock()
+	defer o.keysMu.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	var jwks oidcJwks
+	if err := o.getJSON(o.discovery.JwksURI, &jwks); err != nil {
+		return nil, err
+	}
+	o.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		o.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %v", kid)
+}
+
+// getRoles returns the roles of the role mapping that match the claims, the email only matches when it's verified
+func (o *OIDC) getRoles(claims jwt.MapClaims) []string {
+	var roles []string
+	for _, m := range o.roleMapping {
+		if m.Claim == "email" && !isEmailVerified(claims) {
+			continue
+		}
+		if containsString(getClaimValues(claims, m.Claim), m.Value) && !containsString(roles, m.Role) {
+			roles = append(roles, m.Role)
+		}
+	}
+	return roles
+}
+
+// getClaimValues returns the value of a claim as a list of strings, for claims that are a string, a boolean or a list
+func getClaimValues(claims jwt.MapClaims, name string) []string {
+	values := []string{}
+	switch v := claims[name].(type) {
+	case string:
+		values = append(values, v)
+	case bool:
+		values = append(values, fmt.Sprint(v))
+	case []interface{}:
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// isEmailVerified returns true when the email_verified claim is true (a boolean, or a string for some providers)
+func isEmailVerified(claims jwt.MapClaims) bool {
+	return containsString(getClaimValues(claims, "email_verified"), "true")
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopbackURL returns true for http urls on localhost, used by the client to receive the token
+func isLoopbackURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
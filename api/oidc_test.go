@@ -0,0 +1,231 @@
+package api
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeIdentityProvider returns an openid connect provider that issues id tokens with the claims for any code
+func newFakeIdentityProvider(t *testing.T, clientID string, claims jwt.MapClaims) (*httptest.Server, *string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	var nonce string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/auth",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_id") != clientID || r.PostFormValue("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idClaims := jwt.MapClaims{
+			"iss":   server.URL,
+			"aud":   clientID,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": nonce,
+		}
+		for k, v := range claims {
+			idClaims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims)
+		token.Header["kid"] = "key1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	return server, &nonce
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp, nonce := newFakeIdentityProvider(t, "ecs-deploy", jwt.MapClaims{
+		"email":          "alice@mycompany.com",
+		"email_verified": true,
+		"groups":         []string{"platform", "developers"},
+		"hd":             "mycompany.com",
+	})
+	defer idp.Close()
+
+	o, err := newOIDC(idp.URL, "ecs-deploy", "secret", "https://ecs-deploy.mycompany.com/ecs-deploy/oidc/callback", []string{"openid", "email"})
+	if err != nil {
+		t.Fatalf("newOIDC: %v", err)
+	}
+	o.roleMapping, err = parseOIDCRoleMapping("groups:platform=admin, hd:mycompany.com=developer, hd:othercompany.com=admin")
+	if err != nil {
+		t.Fatalf("parseOIDCRoleMapping: %v", err)
+	}
+	r := gin.New()
+	r.Use(handleErrors())
+	r.GET("/oidc/login", o.oidcLoginHandler)
+	r.GET("/oidc/callback", o.oidcCallbackHandler)
+
+	// only localhost redirects are allowed
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/oidc/login?redirect="+url.QueryEscape("https://attacker.com/callback"), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad request for a redirect to another host, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/oidc/login?redirect="+url.QueryEscape("http://127.0.0.1:12345/callback"), nil))
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), idp.URL+"/auth?") {
+		t.Fatalf("Expected a redirect to the identity provider, got %v: %v", w.Code, w.Header().Get("Location"))
+	}
+	authURL, _ := url.Parse(w.Header().Get("Location"))
+	state := authURL.Query().Get("state")
+	*nonce = authURL.Query().Get("nonce")
+	cookie := w.Header().Get("Set-Cookie")
+
+	// a callback without the state cookie is rejected
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/oidc/callback?code=code&state="+state, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden without state cookie, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/oidc/callback?code=code&state="+state, nil)
+	req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+	r.ServeHTTP(w, req)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location == nil || location.Host != "127.0.0.1:12345" {
+		t.Fatalf("Expected a redirect to the client, got %v: %v", w.Code, w.Header().Get("Location"))
+	}
+	token, err := jwt.Parse(location.Query().Get("token"), func(t *jwt.Token) (interface{}, error) {
+		return []byte("unsecure secret key 8a045eb"), nil
+	})
+	if err != nil {
+		t.Fatalf("Could not parse the jwt: %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["id"] != "alice@mycompany.com" {
+		t.Errorf("Unexpected id: %v", claims["id"])
+	}
+	if groups := getClaimsStrings(claims, "groups"); !reflect.DeepEqual(groups, []string{"platform", "developers"}) {
+		t.Errorf("Unexpected groups: %v", groups)
+	}
+	if roles := getClaimsStrings(claims, "roles"); !reflect.DeepEqual(roles, []string{"admin", "developer"}) {
+		t.Errorf("Unexpected roles: %v", roles)
+	}
+
+	// the nonce of another login is rejected
+	*nonce = "other"
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/oidc/callback?code=code&state="+state, nil)
+	req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden for a nonce mismatch, got %v", w.Code)
+	}
+}
+
+func TestParseOIDCRoleMapping(t *testing.T) {
+	mapping, err := parseOIDCRoleMapping("email:alice@mycompany.com=admin,groups:https://mycompany.com/groups/dev=developer")
+	if err != nil {
+		t.Fatalf("parseOIDCRoleMapping: %v", err)
+	}
+	expected := []OIDCRoleMapping{
+		{Claim: "email", Value: "alice@mycompany.com", Role: "admin"},
+		{Claim: "groups", Value: "https://mycompany.com/groups/dev", Role: "developer"},
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Unexpected mapping: %+v", mapping)
+	}
+	if _, err = parseOIDCRoleMapping("groups=admin"); err == nil {
+		t.Errorf("Expected an error for a mapping without value")
+	}
+}
+
+func TestOIDCLoginUnverifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp, nonce := newFakeIdentityProvider(t, "ecs-deploy", jwt.MapClaims{
+		"sub":            "12345",
+		"email":          "deploy",
+		"email_verified": false,
+	})
+	defer idp.Close()
+
+	o, err := newOIDC(idp.URL, "ecs-deploy", "secret", "https://ecs-deploy.mycompany.com/ecs-deploy/oidc/callback", []string{"openid", "email"})
+	if err != nil {
+		t.Fatalf("newOIDC: %v", err)
+	}
+	o.roleMapping, err = parseOIDCRoleMapping("email:deploy=admin")
+	if err != nil {
+		t.Fatalf("parseOIDCRoleMapping: %v", err)
+	}
+	r := gin.New()
+	r.Use(handleErrors())
+	r.GET("/oidc/login", o.oidcLoginHandler)
+	r.GET("/oidc/callback", o.oidcCallbackHandler)
+
+	callback := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/oidc/login?redirect="+url.QueryEscape("http://127.0.0.1:12345/callback"), nil))
+		authURL, _ := url.Parse(w.Header().Get("Location"))
+		*nonce = authURL.Query().Get("nonce")
+		cookie := w.Header().Get("Set-Cookie")
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/oidc/callback?code=code&state="+authURL.Query().Get("state"), nil)
+		req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// the unverified email can't be used as user id
+	if w := callback(); w.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden for an unverified email, got %v: %v", w.Code, w.Header().Get("Location"))
+	}
+
+	// with another user claim the unverified email doesn't get the roles of the mapping
+	o.userClaim = "sub"
+	w := callback()
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location == nil {
+		t.Fatalf("Expected a redirect to the client, got %v: %v", w.Code, w.Header().Get("Location"))
+	}
+	token, err := jwt.Parse(location.Query().Get("token"), func(t *jwt.Token) (interface{}, error) {
+		return []byte("unsecure secret key 8a045eb"), nil
+	})
+	if err != nil {
+		t.Fatalf("Could not parse the jwt: %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["id"] != "12345" || len(getClaimsStrings(claims, "roles")) != 0 {
+		t.Errorf("Expected id 12345 without roles, got %v %v", claims["id"], claims["roles"])
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/validation"
+)
+
+// authorize returns a middleware that checks whether the user of the jwt has the permission on the service of the
+// route. The cluster of the service is looked up in the services, routes without a service need the permission on all
+// services
+func (a *API) authorize(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceName := c.Param("service")
+		if serviceName == "" {
+			serviceName = c.Param("repository")
+		}
+		var clusterName string
+		if serviceName != "" {
+			s := service.NewService()
+			s.ServiceName = serviceName
+			// the cluster isn't known yet when the service is not deployed
+			clusterName, _ = s.GetClusterName()
+		}
+		if !a.isAuthorized(c, permission, clusterName, serviceName) {
+			c.Error(newForbiddenError("You don't have the " + permission + " permission on " + serviceName))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAuthorized returns true if the roles of the user and the groups of the jwt grant the permission on the service
+func (a *API) isAuthorized(c *gin.Context, permission, clusterName, serviceName string) bool {
+	claims := jwt.ExtractClaims(c)
+	userId, _ := claims["id"].(string)
+	if t := getAPIToken(c); t != nil {
+		if !t.IsAuthorized(permission, clusterName, serviceName) {
+			apiLogger.Infof("Api token %v doesn't have the %v permission on %v (cluster: %v)", t.Name, permission, serviceName, clusterName)
+			return false
+		}
+		return true
+	}
+	s := service.NewService()
+	r, err := s.GetRBAC()
+	if err != nil {
+		apiLogger.Errorf("Could not get rbac: %v", err)
+		return false
+	}
+	if !r.IsAuthorized(userId, getClaimsStrings(claims, "groups"), getClaimsStrings(claims, "roles"), permission, clusterName, serviceName) {
+		apiLogger.Infof("User %v doesn't have the %v permission on %v (cluster: %v)", userId, permission, serviceName, clusterName)
+		return false
+	}
+	return true
+}
+
+// isAuthorizedToDeploy checks the deploy permission on the cluster the service runs in. The cluster of the deploy is
+// checked as well when the service doesn't exist yet or moves to another cluster
+func (a *API) isAuthorizedToDeploy(c *gin.Context, clusterName, serviceName string) bool {
+	s := service.NewService()
+	s.ServiceName = serviceName
+	storedClusterName, err := s.GetClusterName()
+	if err != nil && err != service.ErrServiceNotFound {
+		apiLogger.Errorf("Could not get cluster of %v: %v", serviceName, err)
+		return false
+	}
+	if err == nil && !a.isAuthorized(c, service.PermissionDeploy, storedClusterName, serviceName) {
+		return false
+	}
+	if err == nil && storedClusterName == clusterName {
+		return true
+	}
+	return a.isAuthorized(c, service.PermissionDeploy, clusterName, serviceName)
+}
+
+// getClaimsStrings returns a list of strings of the jwt, e.g. the groups (from the saml group attribute or the oidc
+// groups claim) or the roles (from the oidc role mapping)
+func getClaimsStrings(claims map[string]interface{}, name string) []string {
+	var result []string
+	values, _ := claims[name].([]interface{})
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// @summary Get rbac
+// @description Get the roles and the role bindings
+// @id rbac-get
+// @produce  json
+// @router /api/v1/rbac [get]
+func (a *API) getRBACHandler(c *gin.Context) {
+	s := newService(c)
+	r, err := s.GetRBAC()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"rbac": r,
+	})
+}
+
+// @summary Put rbac
+// @description Replace the roles and the role bindings. The version needs to be the version that was retrieved
+// @id rbac-put
+// @accept  json
+// @produce  json
+// @router /api/v1/rbac [post]
+func (a *API) putRBACHandler(c *gin.Context) {
+	var r service.DynamoRBAC
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.Error(newBadRequestError(err.Error()))
+		return
+	}
+	if errs := validation.ValidateRBAC(&r); len(errs) > 0 {
+		c.Error(errs)
+		return
+	}
+	s := newService(c)
+	err := s.PutRBAC(&r)
+	if err == service.ErrConditionalCheckFailed {
+		c.Error(newConflictError("The rbac was changed in the meantime, retrieve it again", nil))
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"rbac": r,
+	})
+}
@@ -118,31 +118,51 @@ func (s *SAML) samlResponseHandler(c *gin.Context) {
 		if parseErr, ok := err.(*saml.InvalidResponseError); ok {
 			samlLogger.Errorf("RESPONSE: ===\n%s\n===\nNOW: %s\nERROR: %s", parseErr.Response, parseErr.Now, parseErr.PrivateErr)
 		}
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": http.StatusText(http.StatusForbidden),
-		})
+		c.Error(newForbiddenError(http.StatusText(http.StatusForbidden)))
 		return
 	}
 	// auth OK, create jwt token
-	token := jwt.New(jwtSigningMethod)
-	claims := token.Claims.(jwt.MapClaims)
-	expire := s.TimeFunc().UTC().Add(time.Hour)
-	claims["id"] = assertion.Subject.NameID.Value
-	claims["exp"] = expire.Unix()
-	claims["orig_iat"] = s.TimeFunc().Unix()
-
-	tokenString, err := token.SignedString([]byte(util.GetEnv("JWT_SECRET", "unsecure secret key 8a045eb")))
-
+	c.Set("userID", assertion.Subject.NameID.Value)
+	groups := getAssertionGroups(assertion, util.GetEnv("SAML_GROUPS_ATTRIBUTE", "groups"))
+	tokenString, err := createToken(assertion.Subject.NameID.Value, groups, nil, s.TimeFunc())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 		return
 	}
 	// redirect to UI with jwt token
 	c.Redirect(http.StatusFound, util.GetEnv("URL_PREFIX", "")+"/webapp/saml?token="+tokenString)
 }
 
+// createToken creates the jwt of a single sign-on login, the same token the login handler returns
+func createToken(id string, groups, roles []string, now time.Time) (string, error) {
+	token := jwt.New(jwtSigningMethod)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["id"] = id
+	claims["exp"] = now.UTC().Add(time.Hour).Unix()
+	claims["orig_iat"] = now.Unix()
+	claims["groups"] = groups
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	return token.SignedString([]byte(util.GetEnv("JWT_SECRET", "unsecure secret key 8a045eb")))
+}
+
+// getAssertionGroups returns the values of the group attribute, used for the role bindings of the groups
+func getAssertionGroups(assertion *saml.Assertion, attributeName string) []string {
+	groups := []string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != attributeName && attribute.FriendlyName != attributeName {
+				continue
+			}
+			for _, value := range attribute.Values {
+				groups = append(groups, value.Value)
+			}
+		}
+	}
+	return groups
+}
+
 // samlsp/middleware.go adapted for gin gonic
 func (s *SAML) samlInitHandler(c *gin.Context) {
 	if c.PostForm("SAMLResponse") != "" {
@@ -160,9 +180,7 @@ func (s *SAML) samlInitHandler(c *gin.Context) {
 
 	req, err := s.sp.MakeAuthenticationRequest(bindingLocation)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 		return
 	}
 	relayState := base64.URLEncoding.EncodeToString(randomBytes(42))
@@ -174,9 +192,7 @@ func (s *SAML) samlInitHandler(c *gin.Context) {
 	claims["uri"] = url.Scheme + url.Host + url.Path
 	signedState, err := state.SignedString(secretBlock)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		c.Error(err)
 		return
 	}
 
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/in4it/ecs-deploy/provider/ecs"
+	"github.com/in4it/ecs-deploy/service"
+
+	"fmt"
+	"strings"
+	"time"
+)
+
+// interval between the polls of a deployment stream
+var deployStreamInterval = 5 * time.Second
+
+// number of polls of a deployment stream that can fail in a row (e.g. aws throttling) before the stream ends with an
+// error event
+var deployStreamRetries = 5
+
+// deploymentWatcher polls a deployment and the ecs services it deploys to, and returns what changed since the previous poll
+type deploymentWatcher struct {
+	serviceName  string
+	time         string
+	status       string
+	canaryWeight int64
+	events       map[string]bool
+	tasks        map[string]string
+	targets      map[string]string
+}
+
+func newDeploymentWatcher(serviceName, time string) *deploymentWatcher {
+	return &deploymentWatcher{
+		serviceName: serviceName,
+		time:        time,
+		events:      make(map[string]bool),
+		tasks:       make(map[string]string),
+		targets:     make(map[string]string),
+	}
+}
+
+// poll returns the new events and whether the deployment is finished
+func (w *deploymentWatcher) poll() ([]service.DeployEvent, bool, error) {
+	var events []service.DeployEvent
+	s := service.NewService()
+	dd, err := s.GetDeployment(w.serviceName, w.time)
+	if err != nil {
+		return events, false, err
+	}
+
+	// ecs services that receive the new task definition
+	serviceNames := []string{dd.ServiceName}
+	if dd.Color != "" {
+		serviceNames = []string{ecs.GetBlueGreenServiceName(dd.ServiceName, dd.Color)}
+	}
+	if dd.Canary != nil {
+		serviceNames = append(serviceNames, ecs.GetCanaryServiceName(dd.ServiceName))
+	}
+
+	e := ecs.ECS{}
+	runningServices, err := e.DescribeServicesWithOptions(dd.DeployData.Cluster, aws.StringSlice(serviceNames), true, true, true, map[string]string{})
+	if err != nil {
+		return events, false, err
+	}
+	for _, rs := range runningServices {
+		// the deployment record is written after the ecs service is updated
+		since := dd.Time
+		for _, deployment := range rs.Deployments {
+			if deployment.TaskDefinition == aws.StringValue(dd.TaskDefinitionArn) && deployment.CreatedAt.Before(since) {
+				since = deployment.CreatedAt.Truncate(time.Second)
+			}
+		}
+		events = append(events, w.serviceEvents(rs, since)...)
+		events = append(events, w.taskEvents(rs, aws.StringValue(dd.TaskDefinitionArn))...)
+	}
+	if strings.ToLower(dd.DeployData.ServiceProtocol) != "none" {
+		targetEvents, err := w.targetEvents(dd, serviceNames)
+		if err != nil {
+			return events, false, err
+		}
+		events = append(events, targetEvents...)
+	}
+
+	if dd.Canary != nil && dd.Canary.Weight != w.canaryWeight {
+		w.canaryWeight = dd.Canary.Weight
+		events = append(events, service.DeployEvent{
+			Time:        time.Now(),
+			Type:        "status",
+			ServiceName: dd.ServiceName,
+			Message:     fmt.Sprintf("canary receives %d%% of the traffic (step %d/%d)", dd.Canary.Weight, dd.Canary.Step, dd.Canary.Steps),
+			Status:      dd.Status,
+		})
+	}
+	if dd.Status != w.status {
+		w.status = dd.Status
+		message := "deployment " + dd.Status
+		if dd.DeployError != "" {
+			message += ": " + dd.DeployError
+		}
+		events = append(events, service.DeployEvent{
+			Time:        time.Now(),
+			Type:        "status",
+			ServiceName: dd.ServiceName,
+			Message:     message,
+			Status:      dd.Status,
+		})
+	}
+	return events, dd.Status != "running", nil
+}
+
+// isPollRetryable returns false for the errors that don't go away, e.g. a deployment that doesn't exist
+func isPollRetryable(err error) bool {
+	if _, ok := err.(*time.ParseError); ok {
+		return false
+	}
+	return err != service.ErrNoItemFound
+}
+
+// serviceEvents returns the ecs service events since the start of the deployment that weren't returned yet
+func (w *deploymentWatcher) serviceEvents(rs service.RunningService, since time.Time) []service.DeployEvent {
+	var events []service.DeployEvent
+	// ecs returns the newest event first
+	for i := len(rs.Events) - 1; i >= 0; i-- {
+		event := rs.Events[i]
+		if w.events[event.Id] || event.CreatedAt.Before(since) {
+			continue
+		}
+		w.events[event.Id] = true
+		events = append(events, service.DeployEvent{
+			Time:        event.CreatedAt,
+			Type:        "service",
+			ServiceName: rs.ServiceName,
+			Message:     event.Message,
+		})
+	}
+	return events
+}
+
+// taskEvents returns the status changes of the tasks of the service
+func (w *deploymentWatcher) taskEvents(rs service.RunningService, taskDefinitionArn string) []service.DeployEvent {
+	var events []service.DeployEvent
+	for _, task := range rs.Tasks {
+		lastStatus, seen := w.tasks[task.TaskArn]
+		if lastStatus == task.LastStatus {
+			continue
+		}
+		w.tasks[task.TaskArn] = task.LastStatus
+		// only report tasks of the previous deployment when they change
+		if !seen && task.TaskDefinitionArn != taskDefinitionArn {
+			continue
+		}
+		taskDefinition := task.TaskDefinitionArn[strings.LastIndex(task.TaskDefinitionArn, "/")+1:]
+		message := fmt.Sprintf("task %v (%v) is %v", task.TaskArn[strings.LastIndex(task.TaskArn, "/")+1:], taskDefinition, task.LastStatus)
+		if task.StoppedReason != "" {
+			message += ": " + task.StoppedReason
+		}
+		events = append(events, service.DeployEvent{
+			Time:        time.Now(),
+			Type:        "task",
+			ServiceName: rs.ServiceName,
+			Message:     message,
+		})
+	}
+	return events
+}
+
+// targetEvents returns the health changes of the targets of the target groups of the services
+func (w *deploymentWatcher) targetEvents(dd *service.DynamoDeployment, serviceNames []string) ([]service.DeployEvent, error) {
+	var events []service.DeployEvent
+	var alb *ecs.ALB
+	var err error
+	if dd.DeployData.LoadBalancer == "" {
+		alb, err = ecs.NewALB(dd.DeployData.Cluster)
+	} else {
+		alb, err = ecs.NewALB(dd.DeployData.LoadBalancer)
+	}
+	if err != nil {
+		return events, err
+	}
+	for _, serviceName := range serviceNames {
+		targetGroupArn, err := alb.FindTargetGroupArn(serviceName)
+		if err != nil {
+			return events, err
+		}
+		if targetGroupArn == nil {
+			continue
+		}
+		targets, err := alb.DescribeTargetHealth(*targetGroupArn)
+		if err != nil {
+			return events, err
+		}
+		for _, target := range targets {
+			key := fmt.Sprintf("%v/%v:%d", serviceName, target.Id, target.Port)
+			if w.targets[key] == target.State {
+				continue
+			}
+			w.targets[key] = target.State
+			message := fmt.Sprintf("target %v:%d is %v", target.Id, target.Port, target.State)
+			if target.Reason != "" {
+				message += ": " + target.Reason
+			}
+			events = append(events, service.DeployEvent{
+				Time:        time.Now(),
+				Type:        "target",
+				ServiceName: serviceName,
+				Message:     message,
+			})
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gin-gonic/gin"
+
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamDeployment(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	deployStreamInterval = 10 * time.Millisecond
+	defer func() { deployStreamInterval = 5 * time.Second }()
+
+	c := Controller{}
+	res, err := c.Deploy("myservice", newTestDeploy())
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	a := API{}
+	r := gin.New()
+	r.GET("/deploy/stream/:service/:time", a.streamDeploymentHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/deploy/stream/myservice/" + res.DeploymentTime.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		t.Errorf("Expected content type text/event-stream, got %v", contentType)
+	}
+	// the stream ends when the deployment is finished
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	for _, expected := range []string{
+		"event:service",
+		"has reached a steady state",
+		"event:task",
+		"is RUNNING",
+		"event:target",
+		"is healthy",
+		"event:status",
+		`"status":"success"`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected %v in stream, got: %v", expected, string(body))
+		}
+	}
+}
+
+func TestStreamDeploymentRetry(t *testing.T) {
+	f, teardown := newFakeEnvironment(t)
+	defer teardown()
+	deployStreamInterval = 10 * time.Millisecond
+	defer func() { deployStreamInterval = 5 * time.Second }()
+	defer func() { deployStreamRetries = 5 }()
+
+	c := Controller{}
+	res, err := c.Deploy("myservice", newTestDeploy())
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	a := API{}
+	r := gin.New()
+	r.GET("/deploy/stream/:service/:time", a.streamDeploymentHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	stream := func() string {
+		resp, err := http.Get(ts.URL + "/deploy/stream/myservice/" + res.DeploymentTime.UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		return string(body)
+	}
+
+	// the stream continues after a transient error
+	deployStreamRetries = 100
+	f.SetError("DescribeTargetHealth", awserr.New("Throttling", "Rate exceeded", nil))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.SetError("DescribeTargetHealth", nil)
+	}()
+	body := stream()
+	if strings.Contains(body, "event:error") || !strings.Contains(body, `"status":"success"`) {
+		t.Errorf("Expected the stream to end with the status of the deployment, got: %v", body)
+	}
+
+	// the stream ends with an error when the error doesn't go away
+	deployStreamRetries = 2
+	f.SetError("DescribeTargetHealth", awserr.New("Throttling", "Rate exceeded", nil))
+	defer f.SetError("DescribeTargetHealth", nil)
+	if body := stream(); !strings.Contains(body, "event:error") || !strings.Contains(body, "Rate exceeded") {
+		t.Errorf("Expected the stream to end with an error, got: %v", body)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/validation"
+	jwtgo "gopkg.in/dgrijalva/jwt-go.v3"
+
+	"net/http"
+	"strings"
+)
+
+// authenticate returns the middleware of the api routes: api tokens are checked against the stored tokens, everything
+// else is handled by the jwt middleware
+func (a *API) authenticate() gin.HandlerFunc {
+	jwtMiddleware := a.authMiddleware.MiddlewareFunc()
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !service.IsAPIToken(token) {
+			jwtMiddleware(c)
+			return
+		}
+		t, err := service.NewService().AuthenticateAPIToken(token)
+		if err != nil {
+			if err != service.ErrAPITokenInvalid && err != service.ErrAPITokenExpired {
+				apiLogger.Errorf("Could not authenticate api token: %v", err)
+			}
+			writeError(c, &APIError{Status: http.StatusUnauthorized, Code: ErrorCodeUnauthorized, Message: err.Error()})
+			return
+		}
+		// the id is used as role session name of the parameter store, which doesn't allow a colon
+		c.Set("JWT_PAYLOAD", jwtgo.MapClaims{"id": "token-" + t.Name})
+		c.Set("userID", "token-"+t.Name)
+		c.Set("apiToken", t)
+		c.Next()
+	}
+}
+
+// getAPIToken returns the api token the request was authenticated with, or nil for a jwt
+func getAPIToken(c *gin.Context) *service.DynamoAPIToken {
+	if t, ok := c.Get("apiToken"); ok {
+		return t.(*service.DynamoAPIToken)
+	}
+	return nil
+}
+
+// @summary List api tokens
+// @description List the api tokens, without the tokens themselves
+// @id token-list
+// @produce  json
+// @router /api/v1/token/list [get]
+func (a *API) listAPITokensHandler(c *gin.Context) {
+	tokens, err := newService(c).GetAPITokens()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"tokens": tokens,
+	})
+}
+
+// @summary Create api token
+// @description Create a named api token with permissions. The token is only returned once
+// @id token-create
+// @accept  json
+// @produce  json
+// @router /api/v1/token/create [post]
+func (a *API) createAPITokenHandler(c *gin.Context) {
+	// a token could otherwise create a token with more permissions than it has
+	if getAPIToken(c) != nil {
+		c.Error(newForbiddenError("api tokens can't be created with an api token"))
+		return
+	}
+	var r service.APITokenRequest
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.Error(newBadRequestError(err.Error()))
+		return
+	}
+	if errs := validation.ValidateAPIToken(&r); len(errs) > 0 {
+		c.Error(errs)
+		return
+	}
+	// the permissions of the token have to be granted to the user by the rbac roles
+	claims := jwt.ExtractClaims(c)
+	userId, _ := claims["id"].(string)
+	rbac, err := newService(c).GetRBAC()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	for _, p := range r.Permissions {
+		if !rbac.IsAuthorized(userId, getClaimsStrings(claims, "groups"), getClaimsStrings(claims, "roles"), p.Action, p.Cluster, p.Service) {
+			apiLogger.Infof("User %v can't create api token %v with the %v permission on %v (cluster: %v)", userId, r.Name, p.Action, p.Service, p.Cluster)
+			c.Error(newForbiddenError("You can't grant the " + p.Action + " permission on service \"" + p.Service + "\" in cluster \"" + p.Cluster + "\", you don't have it"))
+			return
+		}
+	}
+	token, t, err := newService(c).CreateAPIToken(r.Name, userId, r.Permissions, r.ExpiresAt)
+	if err == service.ErrAPITokenExists {
+		c.Error(newConflictError(err.Error(), nil))
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"token":    token,
+		"apiToken": t,
+	})
+}
+
+// @summary Revoke api token
+// @description Revoke the api token with the name
+// @id token-revoke
+// @produce  json
+// @router /api/v1/token/revoke/{name} [post]
+func (a *API) revokeAPITokenHandler(c *gin.Context) {
+	err := newService(c).RevokeAPIToken(c.Param("name"))
+	if err == service.ErrNoItemFound {
+		c.Error(newNotFoundError("api token " + c.Param("name") + " not found"))
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "api token " + c.Param("name") + " revoked",
+	})
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/in4it/ecs-deploy/provider/ecs"
+	"github.com/in4it/ecs-deploy/service"
+	jwtgo "gopkg.in/dgrijalva/jwt-go.v3"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateAPIToken(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	gin.SetMode(gin.TestMode)
+
+	token, _, err := service.NewService().CreateAPIToken("ci", "admin", []service.Permission{{Action: service.PermissionRead, Service: "myservice"}}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	a := &API{}
+	a.createAuthMiddleware()
+	r := gin.New()
+	r.Use(requestID(), handleErrors())
+	auth := r.Group("/api/v1")
+	auth.Use(a.authenticate())
+	auth.GET("/service/describe/:service", a.authorize(service.PermissionRead), func(c *gin.Context) {
+		c.JSON(200, gin.H{"service": c.Param("service")})
+	})
+	auth.GET("/whoami", func(c *gin.Context) {
+		c.JSON(200, gin.H{"id": jwt.ExtractClaims(c)["id"]})
+	})
+
+	requests := []struct {
+		token, path string
+		status      int
+	}{
+		{token, "/api/v1/service/describe/myservice", http.StatusOK},
+		{token, "/api/v1/service/describe/otherservice", http.StatusForbidden},
+		{service.APITokenPrefix + "invalid", "/api/v1/service/describe/myservice", http.StatusUnauthorized},
+	}
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", request.path, nil)
+		req.Header.Set("Authorization", "Bearer "+request.token)
+		r.ServeHTTP(w, req)
+		if w.Code != request.status {
+			t.Errorf("GET %v: expected status %v, got %v: %v", request.path, request.status, w.Code, w.Body.String())
+		}
+	}
+
+	// the id of the token is a valid role session name of the parameter store
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	var response struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Could not unmarshal the response: %v", err)
+	}
+	if response.Id != "token-ci" || ecs.GetRoleSessionName(response.Id) != response.Id {
+		t.Errorf("Expected id token-ci, got %v", response.Id)
+	}
+}
+
+func TestCreateAPITokenPermissions(t *testing.T) {
+	_, teardown := newFakeEnvironment(t)
+	defer teardown()
+	gin.SetMode(gin.TestMode)
+
+	err := service.NewService().PutRBAC(&service.DynamoRBAC{
+		Roles: []service.Role{{Name: "developer", Permissions: []service.Permission{
+			{Action: service.PermissionRead},
+			{Action: service.PermissionDeploy, Cluster: "staging", Service: "team-a-*"},
+			{Action: service.PermissionToken},
+		}}},
+		Bindings: []service.RoleBinding{{User: "developer", Roles: []string{"developer"}}},
+	})
+	if err != nil {
+		t.Fatalf("PutRBAC: %v", err)
+	}
+	a := &API{}
+	r := gin.New()
+	r.Use(requestID(), handleErrors(), func(c *gin.Context) {
+		c.Set("JWT_PAYLOAD", jwtgo.MapClaims{"id": "developer"})
+	})
+	r.POST("/api/v1/token/create", a.authorize(service.PermissionToken), a.createAPITokenHandler)
+
+	requests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"admin","permissions":[{"action":"*"}]}`, http.StatusForbidden},
+		{`{"name":"prod","permissions":[{"action":"deploy","cluster":"production","service":"team-a-*"}]}`, http.StatusForbidden},
+		{`{"name":"team-b","permissions":[{"action":"deploy","cluster":"staging","service":"team-*"}]}`, http.StatusForbidden},
+		{`{"name":"ci","permissions":[{"action":"read"},{"action":"deploy","cluster":"staging","service":"team-a-web"}]}`, http.StatusOK},
+	}
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/token/create", strings.NewReader(request.body)))
+		if w.Code != request.status {
+			t.Errorf("%v: expected status %v, got %v: %v", request.body, request.status, w.Code, w.Body.String())
+		}
+	}
+	tokens, err := service.NewService().GetAPITokens()
+	if err != nil {
+		t.Fatalf("GetAPITokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "ci" {
+		t.Errorf("Expected only the ci token, got %v", tokens)
+	}
+}
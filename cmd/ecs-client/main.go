@@ -7,15 +7,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/validation"
 	"github.com/juju/loggo"
 	"github.com/spf13/pflag"
 	"golang.org/x/crypto/ssh/terminal"
@@ -34,29 +39,56 @@ type Session struct {
 }
 
 type LoginFlags struct {
-	Url string
+	Url   string
+	Token string
+	SSO   bool
 }
 type DeployFlags struct {
 	ServiceName string
 	Filename    string
+	Plan        bool
 }
 
 type DeployResponse struct {
+	Id       string                 `json:"id"`
 	Errors   map[string]string      `json:"errors" binding:"required"`
 	Failures int64                  `json:"failures" binding:"required"`
 	Messages []service.DeployResult `json:"messages"`
+	Waves    [][]string             `json:"waves"`
+	Queued   []string               `json:"queued"`
+}
+type DeployPlanResponse struct {
+	Errors map[string]string     `json:"errors"`
+	Plans  []*service.DeployPlan `json:"plans"`
+	Waves  [][]string            `json:"waves"`
+}
+type WaveDeployResponse struct {
+	WaveDeploy service.DynamoWaveDeploy `json:"waveDeploy"`
+}
+type DeployListResponse struct {
+	Deployments []service.DynamoDeployment `json:"deployments"`
 }
 type DeployStatusResponse struct {
 	Service service.DeployResult `json:"service" binding:"required"`
 }
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Error     string `json:"error"`
+	RequestID string `json:"requestId"`
+}
 
 func addLoginFlags(f *LoginFlags, fs *pflag.FlagSet) {
 	fs.StringVar(&f.Url, "url", f.Url, "ecs-deploy url, e.g. https://127.0.0.1:8080/ecs-deploy")
+	fs.StringVar(&f.Token, "token", f.Token, "api token to use instead of a login and password (or set ECS_DEPLOY_TOKEN)")
+	fs.BoolVar(&f.SSO, "sso", f.SSO, "login with the identity provider (oidc) in the browser")
 }
 func addDeployFlags(f *DeployFlags, fs *pflag.FlagSet) {
 	fs.StringVar(&f.ServiceName, "service-name", f.ServiceName, "Service name to deploy")
 	fs.StringVarP(&f.Filename, "filename", "f", f.Filename, "filename to deploy")
 }
+func addPlanFlag(f *DeployFlags, fs *pflag.FlagSet) {
+	fs.BoolVar(&f.Plan, "plan", f.Plan, "show the changes of the deploy without deploying")
+}
 
 func main() {
 	var err error
@@ -100,10 +132,15 @@ func main() {
 		// deploy
 		deployFlags := &DeployFlags{}
 		addDeployFlags(deployFlags, pflag.CommandLine)
+		addPlanFlag(deployFlags, pflag.CommandLine)
 
 		if len(os.Args) > 2 && os.Args[2] != "" {
 			pflag.CommandLine.Parse(os.Args[2:])
-			failure, err := deploy(session, deployFlags)
+			deployFunc := deploy
+			if deployFlags.Plan {
+				deployFunc = plan
+			}
+			failure, err := deployFunc(session, deployFlags)
 			if failure {
 				if err != nil {
 					fmt.Printf("%v", err.Error())
@@ -114,6 +151,23 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Usage of %s deploy:\n", os.Args[0])
 			pflag.PrintDefaults()
 		}
+	} else if len(os.Args) > 1 && os.Args[1] == "validate" {
+		deployFlags := &DeployFlags{}
+		addDeployFlags(deployFlags, pflag.CommandLine)
+
+		if len(os.Args) > 2 && os.Args[2] != "" {
+			pflag.CommandLine.Parse(os.Args[2:])
+			failure, err := validate(deployFlags)
+			if failure {
+				if err != nil {
+					fmt.Printf("%v", err.Error())
+				}
+				os.Exit(1)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "Usage of %s validate:\n", os.Args[0])
+			pflag.PrintDefaults()
+		}
 	} else if len(os.Args) > 1 && os.Args[1] == "runtask" {
 		deployFlags := &DeployFlags{}
 		addDeployFlags(deployFlags, pflag.CommandLine)
@@ -136,6 +190,7 @@ func main() {
 		fmt.Printf("%v login        login\n", os.Args[0])
 		fmt.Printf("%v createrepo   create repository\n", os.Args[0])
 		fmt.Printf("%v deploy       deploy services\n", os.Args[0])
+		fmt.Printf("%v validate     validate services without deploying\n", os.Args[0])
 		fmt.Printf("%v runtask      run task on service\n", os.Args[0])
 	}
 	if err != nil {
@@ -193,50 +248,274 @@ func deploy(session Session, deployFlags *DeployFlags) (bool, error) {
 	}
 	return failure, nil
 }
+
+// validate checks the services with the validation rules of ecs-deploy, without contacting ecs-deploy. The listeners
+// of the loadbalancer are not checked
+func validate(deployFlags *DeployFlags) (bool, error) {
+	deployServices, err := getDeployServices(deployFlags)
+	if err != nil {
+		return true, err
+	}
+	var failure bool
+	for _, d := range deployServices.Services {
+		errs := validation.ValidateDeploy(d.ServiceName, d, nil)
+		if len(errs) == 0 {
+			fmt.Printf("Service %v: OK\n", d.ServiceName)
+			continue
+		}
+		failure = true
+		fmt.Printf("Service %v:\n", d.ServiceName)
+		for _, fieldError := range errs {
+			fmt.Printf("  %v\n", fieldError.Error())
+		}
+	}
+	return failure, nil
+}
+
+// plan shows what a deploy would change, without deploying
+func plan(session Session, deployFlags *DeployFlags) (bool, error) {
+	deployData, err := getDeployData(session, deployFlags)
+	if err != nil {
+		return true, err
+	}
+	response, err := doAPICall(session, "deploy?dryRun=true", deployData)
+	if err != nil {
+		return true, err
+	}
+	var planResponse DeployPlanResponse
+	err = json.Unmarshal(response, &planResponse)
+	if err != nil {
+		return true, err
+	}
+	for _, p := range planResponse.Plans {
+		fmt.Printf("Service %v: %v\n", p.ServiceName, p.Action)
+		for _, change := range p.Changes {
+			fmt.Printf("  %v: %v => %v (%v)\n", change.Field, change.Before, change.After, change.Effect)
+		}
+		for _, warning := range p.Warnings {
+			fmt.Printf("  warning: %v\n", warning)
+		}
+	}
+	if len(planResponse.Waves) > 1 {
+		for i, wave := range planResponse.Waves {
+			fmt.Printf("Wave %d: %v\n", i+1, strings.Join(wave, ", "))
+		}
+	}
+	for k, v := range planResponse.Errors {
+		fmt.Printf("Service %v: %v\n", k, v)
+	}
+	return len(planResponse.Errors) > 0, nil
+}
 func waitForDeploy(session Session, response []byte) (map[string]string, error) {
 	// api call returned info to follow-up on deployment
-	var deploymentsFinished bool
 	var deployResponse DeployResponse
-	var finished int64
 	deployed := make(map[string]string)
-	maxWait := 1200
 	err := json.Unmarshal(response, &deployResponse)
 	if err != nil {
 		return deployed, err
 	}
-	for _, v := range deployResponse.Messages {
-		deployed[v.ServiceName] = "running"
-	}
 	for k, v := range deployResponse.Errors {
 		fmt.Printf("Service %v: %v\n", k, v)
 		deployed[k] = "error"
-		finished++
 	}
-	if int64(len(deployed)) == finished {
-		deploymentsFinished = true
+	deploymentTimes := make(map[string]time.Time)
+	for _, v := range deployResponse.Messages {
+		deploymentTimes[v.ServiceName] = v.DeploymentTime
 	}
-	for i := 0; i < (maxWait/15) && !deploymentsFinished; i++ {
-		time.Sleep(15 * time.Second)
-		for _, v := range deployResponse.Messages {
-			if deployed[v.ServiceName] == "running" {
-				status, err := checkDeployStatus(session, v.ServiceName, v.DeploymentTime.Format("2006-01-02T15:04:05.999999999Z"))
-				if err != nil {
-					return deployed, err
-				}
-				fmt.Printf(".")
-				if status != "running" {
-					deployed[v.ServiceName] = status
-					fmt.Printf("%v=%v", v.ServiceName, status)
-					finished++
-				}
+	err = followDeploys(session, deploymentTimes, deployed)
+	if err != nil {
+		return deployed, err
+	}
+	if len(deployResponse.Queued) == 0 {
+		return deployed, nil
+	}
+	if deployResponse.Id == "" {
+		return deployed, fmt.Errorf("Can't follow the deployment of %v: ecs-deploy didn't return the id of the wave deploy", strings.Join(deployResponse.Queued, ", "))
+	}
+	// the queued services are deployed in waves, once all services of the previous wave are deployed successfully
+	for wave := int64(1); wave < int64(len(deployResponse.Waves)); wave++ {
+		services, err := waitForWave(session, deployResponse.Id, wave)
+		if err != nil {
+			return deployed, err
+		}
+		deploymentTimes = make(map[string]time.Time)
+		for _, ws := range services {
+			switch {
+			case ws.DeploymentTime != nil:
+				deploymentTimes[ws.ServiceName] = *ws.DeploymentTime
+			case ws.Status == service.WaveDeployStatusSkipped:
+				fmt.Printf("Service %v: not deployed, a service of a previous wave failed\n", ws.ServiceName)
+				deployed[ws.ServiceName] = "not deployed"
+			default:
+				fmt.Printf("Service %v: %v\n", ws.ServiceName, ws.Error)
+				deployed[ws.ServiceName] = "error"
 			}
 		}
-		if int64(len(deployed)) == finished {
-			deploymentsFinished = true
+		err = followDeploys(session, deploymentTimes, deployed)
+		if err != nil {
+			return deployed, err
 		}
 	}
 	return deployed, nil
 }
+
+// followDeploys follows the deployments at the same time, and stores the final status in deployed
+func followDeploys(session Session, deploymentTimes map[string]time.Time, deployed map[string]string) error {
+	var err error
+	type deployStatus struct {
+		serviceName string
+		status      string
+		err         error
+	}
+	statuses := make(chan deployStatus)
+	for serviceName, deploymentTime := range deploymentTimes {
+		go func(serviceName, deploymentTime string) {
+			status, err := followDeploy(session, serviceName, deploymentTime)
+			statuses <- deployStatus{serviceName: serviceName, status: status, err: err}
+		}(serviceName, deploymentTime.Format("2006-01-02T15:04:05.999999999Z"))
+	}
+	for range deploymentTimes {
+		s := <-statuses
+		if s.err != nil && err == nil {
+			err = s.err
+		}
+		deployed[s.serviceName] = s.status
+	}
+	return err
+}
+
+// waitForWave polls the wave deploy until ecs-deploy started the deployments of the wave, and returns the services
+// of the wave
+func waitForWave(session Session, id string, wave int64) ([]service.DynamoWaveDeployService, error) {
+	maxWait := 1200
+	for i := 0; i < (maxWait / 15); i++ {
+		var waveDeployResponse WaveDeployResponse
+		req, err := http.NewRequest("GET", session.Url+"/api/v1/deploy/waves/"+id, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		var client = &http.Client{
+			Timeout: time.Second * 15,
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 200 {
+			return nil, getAPIError(resp.StatusCode, body)
+		}
+		err = json.Unmarshal(body, &waveDeployResponse)
+		if err != nil {
+			return nil, err
+		}
+		var services []service.DynamoWaveDeployService
+		started := true
+		for _, ws := range waveDeployResponse.WaveDeploy.Services {
+			if ws.Wave != wave {
+				continue
+			}
+			if ws.Status == service.WaveDeployStatusQueued {
+				started = false
+			}
+			services = append(services, ws)
+		}
+		if started {
+			return services, nil
+		}
+		time.Sleep(15 * time.Second)
+	}
+	return nil, fmt.Errorf("Wave %d of wave deploy %v didn't start", wave+1, id)
+}
+
+// followDeploy prints the progress of a deployment from the event stream, and falls back to polling the status
+// when the stream is not available (e.g. older ecs-deploy version or a proxy that doesn't support streaming) or breaks
+func followDeploy(session Session, serviceName, deploymentTime string) (string, error) {
+	status, err := streamDeploy(session, serviceName, deploymentTime)
+	if err == nil {
+		return status, nil
+	}
+	if err != errStreamNotAvailable {
+		return status, err
+	}
+	clientLogger.Debugf("Event stream of %v not available, polling status", serviceName)
+	return pollDeploy(session, serviceName, deploymentTime)
+}
+
+func pollDeploy(session Session, serviceName, deploymentTime string) (string, error) {
+	maxWait := 1200
+	for i := 0; i < (maxWait / 15); i++ {
+		time.Sleep(15 * time.Second)
+		status, err := checkDeployStatus(session, serviceName, deploymentTime)
+		if err != nil {
+			return status, err
+		}
+		fmt.Printf(".")
+		if status != "running" {
+			fmt.Printf("%v=%v", serviceName, status)
+			return status, nil
+		}
+	}
+	return "running", nil
+}
+
+var errStreamNotAvailable = errors.New("event stream not available")
+
+func streamDeploy(session Session, serviceName, deploymentTime string) (string, error) {
+	req, err := http.NewRequest("GET", session.Url+"/api/v1/deploy/stream/"+serviceName+"/"+deploymentTime, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	var client = &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errStreamNotAvailable
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 401 {
+		return "", fmt.Errorf("Invalid credentials: use %v login --url <url> to login again\n", os.Args[0])
+	}
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return "", errStreamNotAvailable
+	}
+	var eventType, data string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event:") {
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		} else if strings.HasPrefix(line, "data:") {
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		} else if line == "" && data != "" {
+			// the deployment is still running when the stream breaks, its status is polled instead
+			if eventType == "error" {
+				var streamError map[string]string
+				json.Unmarshal([]byte(data), &streamError)
+				fmt.Printf("Event stream of %v ended: %v\n", serviceName, streamError["error"])
+				return "", errStreamNotAvailable
+			}
+			var event service.DeployEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				return "", errStreamNotAvailable
+			}
+			fmt.Printf("%v %v: %v\n", event.Time.Local().Format("15:04:05"), event.ServiceName, event.Message)
+			if event.Type == "status" && event.Status != "running" {
+				return event.Status, nil
+			}
+			eventType, data = "", ""
+		}
+	}
+	// stream ended before the deployment finished
+	return "", errStreamNotAvailable
+}
 func checkDeployStatus(session Session, serviceName, deploymentTime string) (string, error) {
 	var status string
 	var deployStatusResponse DeployStatusResponse
@@ -261,7 +540,7 @@ func checkDeployStatus(session Session, serviceName, deploymentTime string) (str
 		if resp.StatusCode == 401 {
 			return status, fmt.Errorf("Invalid credentials: use %v login --url <url> to login again\n", os.Args[0])
 		} else {
-			return status, fmt.Errorf("Error %d: %v", resp.StatusCode, string(body))
+			return status, getAPIError(resp.StatusCode, body)
 		}
 	}
 	err = json.Unmarshal(body, &deployStatusResponse)
@@ -288,8 +567,9 @@ func doAPICall(session Session, url string, deployData string) ([]byte, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+session.Token)
+	// a deploy can wait for the deploy lock of a running deployment (DEPLOY_LOCK_QUEUE_TIMEOUT)
 	var client = &http.Client{
-		Timeout: time.Second * 120,
+		Timeout: time.Second * 900,
 	}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -300,35 +580,50 @@ func doAPICall(session Session, url string, deployData string) ([]byte, error) {
 	if err != nil {
 		return body, err
 	}
-	if resp.StatusCode != 200 {
+	// a deploy of multiple services returns 207 when some services failed, the errors are in the response
+	if resp.StatusCode != 200 && resp.StatusCode != http.StatusMultiStatus {
 		if resp.StatusCode == 401 {
 			return body, fmt.Errorf("Invalid credentials: use %v login --url <url> to login again\n", os.Args[0])
 		} else {
-			return body, fmt.Errorf("Error %d: %v", resp.StatusCode, string(body))
+			return body, getAPIError(resp.StatusCode, body)
 		}
 	}
+	// older versions of ecs-deploy return errors with status 200
+	var response ErrorResponse
+	if json.Unmarshal(body, &response) == nil && response.Error != "" {
+		return body, getAPIError(resp.StatusCode, body)
+	}
 	return body, nil
 }
-func getDeployData(session Session, deployFlags *DeployFlags) (string, error) {
-	var deployData string
-	var deployServices service.DeployServices
-	var err error
+
+// getAPIError returns the message of the error response, with the request id to find the error in the logs
+func getAPIError(statusCode int, body []byte) error {
+	var response ErrorResponse
+	if json.Unmarshal(body, &response) != nil || response.Error == "" {
+		return fmt.Errorf("Error %d: %v", statusCode, string(body))
+	}
+	if response.RequestID == "" {
+		return fmt.Errorf("Error %d: %v", statusCode, response.Error)
+	}
+	return fmt.Errorf("Error %d: %v (request id: %v)", statusCode, response.Error, response.RequestID)
+}
+func getDeployServices(deployFlags *DeployFlags) (service.DeployServices, error) {
 	if deployFlags.ServiceName != "" {
 		// serviceName is set
-		deployServices, err = getDeployDataWithService(deployFlags.ServiceName, deployFlags.Filename)
-		if err != nil {
-			return deployData, err
-		}
+		return getDeployDataWithService(deployFlags.ServiceName, deployFlags.Filename)
 	} else if deployFlags.ServiceName == "" && deployFlags.Filename != "" {
 		// serviceName is not set
-		deployServices, err = getDeployDataWithoutService(deployFlags.ServiceName, deployFlags.Filename)
-		if err != nil {
-			return deployData, err
-		}
-	} else {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		pflag.PrintDefaults()
-		return deployData, errors.New("InvalidFlags")
+		return getDeployDataWithoutService(deployFlags.ServiceName, deployFlags.Filename)
+	}
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	pflag.PrintDefaults()
+	return service.DeployServices{}, errors.New("InvalidFlags")
+}
+func getDeployData(session Session, deployFlags *DeployFlags) (string, error) {
+	var deployData string
+	deployServices, err := getDeployServices(deployFlags)
+	if err != nil {
+		return deployData, err
 	}
 	// convert to JSON
 	deployData, err = convertDeployServiceToJson(deployServices)
@@ -523,18 +818,21 @@ func createRepository(session Session, repository string) (string, error) {
 	if err != nil {
 		return res, err
 	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return res, err
+	}
 	if resp.StatusCode != 200 {
 		if resp.StatusCode == 401 {
 			return res, fmt.Errorf("Invalid credentials: use %v login --url <url> to login again\n", os.Args[0])
+		} else if resp.StatusCode == 409 {
+			// the repository is created on every build
+			return "Repository " + repository + " already exists", nil
 		} else {
-			return res, fmt.Errorf("ecr create return http error %d", resp.StatusCode)
+			return res, getAPIError(resp.StatusCode, body)
 		}
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return res, err
-	}
 	res = string(body)
 	return res, nil
 }
@@ -559,7 +857,22 @@ func login(loginFlags *LoginFlags) error {
 	var username, password string
 
 	session.Url = loginFlags.Url
-	if os.Getenv("ECS_DEPLOY_LOGIN") != "" && os.Getenv("ECS_DEPLOY_PASSWORD") != "" {
+	if loginFlags.Token == "" {
+		loginFlags.Token = os.Getenv("ECS_DEPLOY_TOKEN")
+	}
+	if loginFlags.Token != "" {
+		// api tokens are used as is, they don't need a login
+		if !service.IsAPIToken(loginFlags.Token) {
+			return fmt.Errorf("Invalid api token: api tokens start with %v\n", service.APITokenPrefix)
+		}
+		return writeSession(session, Token{Token: loginFlags.Token})
+	} else if loginFlags.SSO {
+		token, err := ssoLogin(session.Url)
+		if err != nil {
+			return err
+		}
+		return writeSession(session, token)
+	} else if os.Getenv("ECS_DEPLOY_LOGIN") != "" && os.Getenv("ECS_DEPLOY_PASSWORD") != "" {
 		username = os.Getenv("ECS_DEPLOY_LOGIN")
 		password = os.Getenv("ECS_DEPLOY_PASSWORD")
 	} else {
@@ -572,6 +885,9 @@ func login(loginFlags *LoginFlags) error {
 	if err != nil {
 		return err
 	}
+	return writeSession(session, token)
+}
+func writeSession(session Session, token Token) error {
 	newpath := filepath.Join(os.Getenv("HOME"), ".ecsdeploy")
 	os.MkdirAll(newpath, os.ModePerm)
 
@@ -589,6 +905,53 @@ func login(loginFlags *LoginFlags) error {
 	fmt.Println("Authentication successful")
 	return nil
 }
+
+// ssoLogin opens the oidc login in the browser, the token is sent to a callback on localhost
+func ssoLogin(serverUrl string) (Token, error) {
+	var token Token
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return token, err
+	}
+	tokens := make(chan string, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" || r.URL.Query().Get("token") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "Authentication successful, you can close this window")
+		select {
+		case tokens <- r.URL.Query().Get("token"):
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	loginUrl := serverUrl + "/oidc/login?redirect=" + url.QueryEscape("http://"+listener.Addr().String()+"/callback")
+	fmt.Printf("Open the following url in your browser to login: %v\n", loginUrl)
+	openBrowser(loginUrl)
+	select {
+	case token.Token = <-tokens:
+		return token, nil
+	case <-time.After(5 * time.Minute):
+		return token, errors.New("Timeout waiting for the login in the browser")
+	}
+}
+func openBrowser(loginUrl string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", loginUrl)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", loginUrl)
+	default:
+		cmd = exec.Command("xdg-open", loginUrl)
+	}
+	if err := cmd.Start(); err != nil {
+		clientLogger.Debugf("Could not open the browser: %v", err)
+	}
+}
 func readCredentials() (string, string, error) {
 	reader := bufio.NewReader(os.Stdin)
 
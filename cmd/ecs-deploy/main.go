@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/in4it/ecs-deploy/api"
 	"github.com/in4it/ecs-deploy/provider/ecs"
+	"github.com/in4it/ecs-deploy/service"
 	"github.com/in4it/ecs-deploy/util"
 	"github.com/juju/loggo"
 	"github.com/spf13/pflag"
@@ -29,6 +30,7 @@ func startup_checks() {
 			os.Exit(1)
 		}
 	}
+	checkStorageBackend()
 	// start controller, check database and pick up any remaining work
 	controller := api.Controller{}
 	err = controller.Resume()
@@ -38,6 +40,15 @@ func startup_checks() {
 	}
 }
 
+// checkStorageBackend exits when the storage backend of STORAGE_BACKEND can't be initialized
+func checkStorageBackend() {
+	_, err := service.NewStore()
+	if err != nil {
+		fmt.Printf("Couldn't initialize storage backend: %v\n", err.Error())
+		os.Exit(1)
+	}
+}
+
 func addFlags(f *api.Flags, fs *pflag.FlagSet) {
 	fs.BoolVar(&f.Bootstrap, "bootstrap", f.Bootstrap, "bootstrap ECS cluster")
 	fs.StringVar(&f.Profile, "profile", f.Profile, "AWS Profile")
@@ -93,6 +104,7 @@ func main() {
 	}
 	if flags.Bootstrap {
 		if ok, _ := util.AskForConfirmation("Bootstrap ECS Cluster?"); ok {
+			checkStorageBackend()
 			controller := api.Controller{}
 			err := controller.Bootstrap(flags)
 			if err != nil {
@@ -3,7 +3,6 @@ package ecs
 import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/aws/aws-sdk-go/service/elbv2"
 	"github.com/in4it/ecs-deploy/service"
@@ -22,18 +21,27 @@ var albLogger = loggo.GetLogger("alb")
 type ALB struct {
 	loadBalancerName string
 	loadBalancerArn  string
+	Type             string
 	VpcId            string
 	Listeners        []*elbv2.Listener
 	Domain           string
 	Rules            map[string][]*elbv2.Rule
 	DnsName          string
+	Clients          Clients
+}
+
+type TargetHealth struct {
+	Id     string `json:"id"`
+	Port   int64  `json:"port"`
+	State  string `json:"state"`
+	Reason string `json:"reason"`
 }
 
 func NewALB(loadBalancerName string) (*ALB, error) {
 	a := ALB{}
 	a.loadBalancerName = loadBalancerName
 	// retrieve vpcId and loadBalancerArn
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DescribeLoadBalancersInput{
 		Names: []*string{
 			aws.String(loadBalancerName),
@@ -61,6 +69,7 @@ func NewALB(loadBalancerName string) (*ALB, error) {
 	a.loadBalancerArn = *result.LoadBalancers[0].LoadBalancerArn
 	a.loadBalancerName = *result.LoadBalancers[0].LoadBalancerName
 	a.VpcId = *result.LoadBalancers[0].VpcId
+	a.Type = aws.StringValue(result.LoadBalancers[0].Type)
 
 	// get listeners
 	err = a.GetListeners()
@@ -81,7 +90,7 @@ func NewALB(loadBalancerName string) (*ALB, error) {
 // get the listeners for the loadbalancer
 func NewALBAndCreate(loadBalancerName, ipAddressType string, scheme string, securityGroups []string, subnets []string, lbType string) (*ALB, error) {
 	a := ALB{}
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.CreateLoadBalancerInput{
 		IpAddressType:  aws.String(ipAddressType),
 		Name:           aws.String(loadBalancerName),
@@ -106,11 +115,17 @@ func NewALBAndCreate(loadBalancerName, ipAddressType string, scheme string, secu
 	a.loadBalancerArn = aws.StringValue(result.LoadBalancers[0].LoadBalancerArn)
 	a.DnsName = aws.StringValue(result.LoadBalancers[0].DNSName)
 	a.VpcId = aws.StringValue(result.LoadBalancers[0].VpcId)
+	a.Type = aws.StringValue(result.LoadBalancers[0].Type)
 	return &a, nil
 }
 
+// IsNetwork returns true if the loadbalancer is a network loadbalancer
+func (a *ALB) IsNetwork() bool {
+	return a.Type == elbv2.LoadBalancerTypeEnumNetwork
+}
+
 func (a *ALB) DeleteLoadBalancer() error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DeleteLoadBalancerInput{
 		LoadBalancerArn: aws.String(a.loadBalancerArn),
 	}
@@ -127,8 +142,12 @@ func (a *ALB) DeleteLoadBalancer() error {
 }
 
 func (a *ALB) CreateListener(protocol string, port int64, targetGroupArn string) error {
-	// only HTTP is supported for now
-	svc := elbv2.New(session.New())
+	return a.CreateListenerWithCertificate(protocol, port, targetGroupArn, "")
+}
+
+// create a listener forwarding to the target group, the certificate is required for HTTPS and TLS listeners
+func (a *ALB) CreateListenerWithCertificate(protocol string, port int64, targetGroupArn string, certificateArn string) error {
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.CreateListenerInput{
 		LoadBalancerArn: aws.String(a.loadBalancerArn),
 		Port:            aws.Int64(port),
@@ -137,11 +156,19 @@ func (a *ALB) CreateListener(protocol string, port int64, targetGroupArn string)
 			{Type: aws.String("forward"), TargetGroupArn: aws.String(targetGroupArn)},
 		},
 	}
+	if certificateArn != "" {
+		input.SetCertificates([]*elbv2.Certificate{{CertificateArn: aws.String(certificateArn)}})
+	}
 
 	result, err := svc.CreateListener(input)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
-			albLogger.Errorf(aerr.Error())
+			switch aerr.Code() {
+			case elbv2.ErrCodeDuplicateListenerException:
+				albLogger.Errorf(elbv2.ErrCodeDuplicateListenerException+": %v", aerr.Error())
+			default:
+				albLogger.Errorf(aerr.Error())
+			}
 		} else {
 			albLogger.Errorf(err.Error())
 		}
@@ -154,7 +181,7 @@ func (a *ALB) CreateListener(protocol string, port int64, targetGroupArn string)
 	return nil
 }
 func (a *ALB) DeleteListener(listenerArn string) error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DeleteListenerInput{
 		ListenerArn: aws.String(listenerArn),
 	}
@@ -171,9 +198,32 @@ func (a *ALB) DeleteListener(listenerArn string) error {
 	return nil
 }
 
+// get the listener on a port of the loadbalancer, returns nil if the port has no listener
+func (a *ALB) GetListenerByPort(port int64) *elbv2.Listener {
+	for _, l := range a.Listeners {
+		if aws.Int64Value(l.Port) == port {
+			return l
+		}
+	}
+	return nil
+}
+
+// get the arns of the listeners that forward to a target group by default
+func (a *ALB) GetListenersByTargetGroupArn(targetGroupArn string) []string {
+	var listenerArns []string
+	for _, l := range a.Listeners {
+		for _, action := range l.DefaultActions {
+			if aws.StringValue(action.TargetGroupArn) == targetGroupArn {
+				listenerArns = append(listenerArns, aws.StringValue(l.ListenerArn))
+			}
+		}
+	}
+	return listenerArns
+}
+
 // get the listeners for the loadbalancer
 func (a *ALB) GetListeners() error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DescribeListenersInput{LoadBalancerArn: aws.String(a.loadBalancerArn)}
 
 	result, err := svc.DescribeListeners(input)
@@ -200,7 +250,7 @@ func (a *ALB) GetListeners() error {
 
 // get the domain using certificates
 func (a *ALB) GetDomainUsingCertificate() error {
-	svc := acm.New(session.New())
+	svc := getClients(a.Clients).ACM()
 	for _, l := range a.Listeners {
 		for _, c := range l.Certificates {
 			albLogger.Debugf("ALB Certificate found with arn: %v", *c.CertificateArn)
@@ -236,7 +286,7 @@ func (a *ALB) GetDomainUsingCertificate() error {
 }
 
 func (a *ALB) CreateTargetGroup(serviceName string, d service.Deploy) (*string, error) {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.CreateTargetGroupInput{
 		Name:     aws.String(serviceName),
 		VpcId:    aws.String(a.VpcId),
@@ -257,6 +307,9 @@ func (a *ALB) CreateTargetGroup(serviceName string, d service.Deploy) (*string,
 	}
 	if d.HealthCheck.Protocol != "" {
 		input.SetHealthCheckProtocol(d.HealthCheck.Protocol)
+	} else if service.IsNetworkProtocol(d.ServiceProtocol) {
+		// network loadbalancers check whether a tcp connection can be made, unless http health checks are configured
+		input.SetHealthCheckProtocol(elbv2.ProtocolEnumTcp)
 	}
 	if d.HealthCheck.Interval != 0 {
 		input.SetHealthCheckIntervalSeconds(d.HealthCheck.Interval)
@@ -296,7 +349,7 @@ func (a *ALB) CreateTargetGroup(serviceName string, d service.Deploy) (*string,
 	return result.TargetGroups[0].TargetGroupArn, nil
 }
 func (a *ALB) DeleteTargetGroup(targetGroupArn string) error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DeleteTargetGroupInput{
 		TargetGroupArn: aws.String(targetGroupArn),
 	}
@@ -314,7 +367,7 @@ func (a *ALB) DeleteTargetGroup(targetGroupArn string) error {
 
 func (a *ALB) GetHighestRule() (int64, error) {
 	var highest int64
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 
 	for _, listener := range a.Listeners {
 		input := &elbv2.DescribeRulesInput{ListenerArn: listener.ListenerArn}
@@ -390,7 +443,6 @@ func (a *ALB) CreateRuleForListeners(ruleType string, listeners []string, target
 }
 
 func (a *ALB) CreateRule(ruleType string, listenerArn string, targetGroupArn string, rules []string, priority int64) error {
-	svc := elbv2.New(session.New())
 	input := &elbv2.CreateRuleInput{
 		Actions: []*elbv2.Action{
 			{
@@ -440,13 +492,20 @@ func (a *ALB) CreateRule(ruleType string, listenerArn string, targetGroupArn str
 	} else {
 		return errors.New("ruleType not recognized: " + ruleType)
 	}
+	_, err := a.createRule(input)
+	return err
+}
 
-	_, err := svc.CreateRule(input)
+// createRule creates a rule and returns its arn. Returns ErrRulePriorityInUse when the priority is taken
+func (a *ALB) createRule(input *elbv2.CreateRuleInput) (string, error) {
+	svc := getClients(a.Clients).ELBV2()
+	result, err := svc.CreateRule(input)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case elbv2.ErrCodePriorityInUseException:
-				albLogger.Errorf(elbv2.ErrCodePriorityInUseException+": %v", aerr.Error())
+				albLogger.Debugf(elbv2.ErrCodePriorityInUseException+": %v", aerr.Error())
+				return "", ErrRulePriorityInUse
 			case elbv2.ErrCodeTooManyTargetGroupsException:
 				albLogger.Errorf(elbv2.ErrCodeTooManyTargetGroupsException+": %v", aerr.Error())
 			case elbv2.ErrCodeTooManyRulesException:
@@ -473,15 +532,18 @@ func (a *ALB) CreateRule(ruleType string, listenerArn string, targetGroupArn str
 			// Message from an error.
 			albLogger.Errorf(err.Error())
 		}
-		return errors.New("Could not create alb rule")
+		return "", errors.New("Could not create alb rule")
 	}
-	return nil
+	if len(result.Rules) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(result.Rules[0].RuleArn), nil
 }
 
 // get rules by listener
 func (a *ALB) GetRulesForAllListeners() error {
 	a.Rules = make(map[string][]*elbv2.Rule)
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 
 	for _, l := range a.Listeners {
 		input := &elbv2.DescribeRulesInput{ListenerArn: aws.String(*l.ListenerArn)}
@@ -516,7 +578,7 @@ func (a *ALB) GetRulesByTargetGroupArn(targetGroupArn string) []string {
 	for _, rules := range a.Rules {
 		for _, rule := range rules {
 			for _, ruleAction := range rule.Actions {
-				if aws.StringValue(ruleAction.TargetGroupArn) == targetGroupArn {
+				if forwardsToTargetGroup(ruleAction, targetGroupArn) {
 					result = append(result, aws.StringValue(rule.RuleArn))
 				}
 			}
@@ -524,8 +586,26 @@ func (a *ALB) GetRulesByTargetGroupArn(targetGroupArn string) []string {
 	}
 	return result
 }
+
+// forwardsToTargetGroup returns true if a forward action sends (a part of) the traffic to the target group
+func forwardsToTargetGroup(action *elbv2.Action, targetGroupArn string) bool {
+	if aws.StringValue(action.Type) != "forward" {
+		return false
+	}
+	if aws.StringValue(action.TargetGroupArn) == targetGroupArn {
+		return true
+	}
+	if action.ForwardConfig != nil {
+		for _, tg := range action.ForwardConfig.TargetGroups {
+			if aws.StringValue(tg.TargetGroupArn) == targetGroupArn {
+				return true
+			}
+		}
+	}
+	return false
+}
 func (a *ALB) GetTargetGroupArn(serviceName string) (*string, error) {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DescribeTargetGroupsInput{
 		Names: []*string{aws.String(serviceName)},
 	}
@@ -567,7 +647,7 @@ func (a *ALB) FindRule(listener string, targetGroupArn string, conditionField []
 	if rules, ok := a.Rules[listener]; ok {
 		for _, r := range rules {
 			for _, a := range r.Actions {
-				if *a.Type == "forward" && *a.TargetGroupArn == targetGroupArn {
+				if forwardsToTargetGroup(a, targetGroupArn) {
 					// target group found, loop over conditions
 					priorityFound := false
 					skip := false
@@ -598,7 +678,7 @@ func (a *ALB) FindRule(listener string, targetGroupArn string, conditionField []
 }
 
 func (a *ALB) UpdateHealthCheck(targetGroupArn string, healthCheck service.DeployHealthCheck) error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.ModifyTargetGroupInput{
 		TargetGroupArn: aws.String(targetGroupArn),
 	}
@@ -639,7 +719,7 @@ func (a *ALB) UpdateHealthCheck(targetGroupArn string, healthCheck service.Deplo
 }
 
 func (a *ALB) ModifyTargetGroupAttributes(targetGroupArn string, d service.Deploy) error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.ModifyTargetGroupAttributesInput{
 		TargetGroupArn: aws.String(targetGroupArn),
 		Attributes:     []*elbv2.TargetGroupAttribute{},
@@ -652,8 +732,13 @@ func (a *ALB) ModifyTargetGroupAttributes(targetGroupArn string, d service.Deplo
 
 	if d.Stickiness.Enabled {
 		input.Attributes = append(input.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String("stickiness.enabled"), Value: aws.String("true")})
-		input.Attributes = append(input.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String("stickiness.type"), Value: aws.String("lb_cookie")})
-		if d.Stickiness.Duration != -1 {
+		if service.IsNetworkProtocol(d.ServiceProtocol) {
+			// network loadbalancers can't set cookies
+			input.Attributes = append(input.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String("stickiness.type"), Value: aws.String("source_ip")})
+		} else {
+			input.Attributes = append(input.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String("stickiness.type"), Value: aws.String("lb_cookie")})
+		}
+		if d.Stickiness.Duration != -1 && !service.IsNetworkProtocol(d.ServiceProtocol) {
 			sd := strconv.FormatInt(d.Stickiness.Duration, 10)
 			input.Attributes = append(input.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String("stickiness.lb_cookie.duration_seconds"), Value: aws.String(sd)})
 		}
@@ -676,7 +761,7 @@ func (a *ALB) ModifyTargetGroupAttributes(targetGroupArn string, d service.Deplo
 	return nil
 }
 func (a *ALB) DeleteRule(ruleArn string) error {
-	svc := elbv2.New(session.New())
+	svc := getClients(a.Clients).ELBV2()
 	input := &elbv2.DeleteRuleInput{
 		RuleArn: aws.String(ruleArn),
 	}
@@ -693,3 +778,35 @@ func (a *ALB) DeleteRule(ruleArn string) error {
 	}
 	return nil
 }
+
+// DescribeTargetHealth returns the health of the targets registered in the target group
+func (a *ALB) DescribeTargetHealth(targetGroupArn string) ([]TargetHealth, error) {
+	var targets []TargetHealth
+	svc := getClients(a.Clients).ELBV2()
+	input := &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupArn),
+	}
+
+	result, err := svc.DescribeTargetHealth(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			albLogger.Errorf(aerr.Error())
+		} else {
+			albLogger.Errorf(err.Error())
+		}
+		return targets, err
+	}
+	for _, v := range result.TargetHealthDescriptions {
+		target := TargetHealth{}
+		if v.Target != nil {
+			target.Id = aws.StringValue(v.Target.Id)
+			target.Port = aws.Int64Value(v.Target.Port)
+		}
+		if v.TargetHealth != nil {
+			target.State = aws.StringValue(v.TargetHealth.State)
+			target.Reason = aws.StringValue(v.TargetHealth.Description)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
@@ -3,7 +3,6 @@ package ecs
 import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/in4it/ecs-deploy/service"
@@ -19,10 +18,11 @@ var autoscalingLogger = loggo.GetLogger("autoscaling")
 
 // ECR struct
 type AutoScaling struct {
+	Clients Clients
 }
 
 func (a *AutoScaling) CompleteLifecycleAction(autoScalingGroupName, instanceId, action, lifecycleHookName, lifecycleToken string) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.CompleteLifecycleActionInput{
 		AutoScalingGroupName:  aws.String(autoScalingGroupName),
 		InstanceId:            aws.String(instanceId),
@@ -43,7 +43,7 @@ func (a *AutoScaling) CompleteLifecycleAction(autoScalingGroupName, instanceId,
 	return nil
 }
 func (a *AutoScaling) CompletePendingLifecycleAction(autoScalingGroupName, instanceId, action, lifecycleHookName string) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.CompleteLifecycleActionInput{
 		AutoScalingGroupName:  aws.String(autoScalingGroupName),
 		InstanceId:            aws.String(instanceId),
@@ -66,7 +66,7 @@ func (a *AutoScaling) CompletePendingLifecycleAction(autoScalingGroupName, insta
 }
 func (a *AutoScaling) GetLifecycleHookNames(autoScalingGroupName, lifecycleHookType string) ([]string, error) {
 	var lifecycleHookNames []string
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DescribeLifecycleHooksInput{
 		AutoScalingGroupName: aws.String(autoScalingGroupName),
 	}
@@ -93,7 +93,7 @@ func (a *AutoScaling) GetLifecycleHookNames(autoScalingGroupName, lifecycleHookT
 
 func (a *AutoScaling) CreateLaunchConfiguration(clusterName string, keyName string, instanceType string, instanceProfile string, securitygroups []string) error {
 	ecs := ECS{}
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	amiId, err := ecs.GetECSAMI()
 	if err != nil {
 		return err
@@ -125,7 +125,7 @@ func (a *AutoScaling) CreateLaunchConfiguration(clusterName string, keyName stri
 	return nil
 }
 func (a *AutoScaling) DeleteLaunchConfiguration(clusterName string) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DeleteLaunchConfigurationInput{
 		LaunchConfigurationName: aws.String(clusterName),
 	}
@@ -141,7 +141,7 @@ func (a *AutoScaling) DeleteLaunchConfiguration(clusterName string) error {
 	return nil
 }
 func (a *AutoScaling) CreateAutoScalingGroup(clusterName string, desiredCapacity int64, maxSize int64, minSize int64, subnets []string) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.CreateAutoScalingGroupInput{
 		AutoScalingGroupName:    aws.String(clusterName),
 		DesiredCapacity:         aws.Int64(desiredCapacity),
@@ -168,7 +168,7 @@ func (a *AutoScaling) CreateAutoScalingGroup(clusterName string, desiredCapacity
 	return nil
 }
 func (a *AutoScaling) WaitForAutoScalingGroupInService(clusterName string) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{aws.String(clusterName)},
 	}
@@ -184,7 +184,7 @@ func (a *AutoScaling) WaitForAutoScalingGroupInService(clusterName string) error
 	return nil
 }
 func (a *AutoScaling) WaitForAutoScalingGroupNotExists(clusterName string) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{aws.String(clusterName)},
 	}
@@ -200,7 +200,7 @@ func (a *AutoScaling) WaitForAutoScalingGroupNotExists(clusterName string) error
 	return nil
 }
 func (a *AutoScaling) DeleteAutoScalingGroup(clusterName string, forceDelete bool) error {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DeleteAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(clusterName),
 		ForceDelete:          aws.Bool(forceDelete),
@@ -228,7 +228,7 @@ func (a *AutoScaling) ScaleClusterNodes(autoScalingGroupName string, change int6
 		return errors.New("Cluster is at minimum capacity")
 	}
 
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(autoScalingGroupName),
 		DesiredCapacity:      aws.Int64(desiredCapacity + change),
@@ -245,7 +245,7 @@ func (a *AutoScaling) ScaleClusterNodes(autoScalingGroupName string, change int6
 	return nil
 }
 func (a *AutoScaling) GetClusterNodeDesiredCount(autoScalingGroupName string) (int64, int64, int64, error) {
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{aws.String(autoScalingGroupName)},
 	}
@@ -269,7 +269,7 @@ func (a *AutoScaling) GetClusterNodeDesiredCount(autoScalingGroupName string) (i
 }
 func (a *AutoScaling) GetAutoScalingGroupByTag(clusterName string) (string, error) {
 	var result string
-	svc := autoscaling.New(session.New())
+	svc := getClients(a.Clients).AutoScaling()
 	input := &autoscaling.DescribeAutoScalingGroupsInput{}
 	pageNum := 0
 	err := svc.DescribeAutoScalingGroupsPages(input,
@@ -299,7 +299,7 @@ func (a *AutoScaling) GetAutoScalingGroupByTag(clusterName string) (string, erro
 }
 
 func (a *AutoScaling) RegisterScalableTarget(minCapacity, maxCapacity int64, resourceId, roleArn string) error {
-	svc := applicationautoscaling.New(session.New())
+	svc := getClients(a.Clients).ApplicationAutoScaling()
 	input := &applicationautoscaling.RegisterScalableTargetInput{
 		MinCapacity:       aws.Int64(minCapacity),
 		MaxCapacity:       aws.Int64(maxCapacity),
@@ -320,7 +320,7 @@ func (a *AutoScaling) RegisterScalableTarget(minCapacity, maxCapacity int64, res
 	return nil
 }
 func (a *AutoScaling) DeregisterScalableTarget(resourceId string) error {
-	svc := applicationautoscaling.New(session.New())
+	svc := getClients(a.Clients).ApplicationAutoScaling()
 	input := &applicationautoscaling.DeregisterScalableTargetInput{
 		ResourceId:        aws.String(resourceId), // serviceName/clusterName/app
 		ScalableDimension: aws.String("ecs:service:DesiredCount"),
@@ -338,7 +338,7 @@ func (a *AutoScaling) DeregisterScalableTarget(resourceId string) error {
 	return nil
 }
 func (a *AutoScaling) PutScalingPolicy(policyName, resourceId string, cooldown, scalingAdjustment int64) (string, error) {
-	svc := applicationautoscaling.New(session.New())
+	svc := getClients(a.Clients).ApplicationAutoScaling()
 	input := &applicationautoscaling.PutScalingPolicyInput{
 		PolicyName:        aws.String(policyName),
 		PolicyType:        aws.String("StepScaling"),
@@ -371,7 +371,7 @@ func (a *AutoScaling) PutScalingPolicy(policyName, resourceId string, cooldown,
 func (a *AutoScaling) DescribeScalableTargets(resourceIds []string) ([]service.Autoscaling, error) {
 	var as []service.Autoscaling
 	var scalableTargets []*applicationautoscaling.ScalableTarget
-	svc := applicationautoscaling.New(session.New())
+	svc := getClients(a.Clients).ApplicationAutoScaling()
 	input := &applicationautoscaling.DescribeScalableTargetsInput{
 		ResourceIds:       aws.StringSlice(resourceIds), // serviceName/clusterName/app
 		ScalableDimension: aws.String("ecs:service:DesiredCount"),
@@ -406,7 +406,7 @@ func (a *AutoScaling) DescribeScalableTargets(resourceIds []string) ([]service.A
 func (a *AutoScaling) DescribeScalingPolicies(policyNames []string, resourceId string) ([]service.AutoscalingPolicy, error) {
 	var aps []service.AutoscalingPolicy
 	var scalingPolicies []*applicationautoscaling.ScalingPolicy
-	svc := applicationautoscaling.New(session.New())
+	svc := getClients(a.Clients).ApplicationAutoScaling()
 	input := &applicationautoscaling.DescribeScalingPoliciesInput{
 		PolicyNames:       aws.StringSlice(policyNames),
 		ResourceId:        aws.String(resourceId), // serviceName/clusterName/app
@@ -443,7 +443,7 @@ func (a *AutoScaling) DescribeScalingPolicies(policyNames []string, resourceId s
 }
 
 func (a *AutoScaling) DeleteScalingPolicy(policyName, resourceId string) error {
-	svc := applicationautoscaling.New(session.New())
+	svc := getClients(a.Clients).ApplicationAutoScaling()
 
 	input := &applicationautoscaling.DeleteScalingPolicyInput{
 		PolicyName:        aws.String(policyName),
@@ -0,0 +1,284 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/in4it/ecs-deploy/service"
+
+	"errors"
+	"time"
+)
+
+// blue/green colors. The blue color uses the ecs service and target group with the name of the service,
+// the green color has the name of the service with a -green suffix
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+// GetBlueGreenServiceName returns the ecs service and target group name of the color
+func GetBlueGreenServiceName(serviceName, color string) string {
+	if color == ColorGreen {
+		return serviceName + "-green"
+	}
+	return serviceName
+}
+
+// GetOtherColor returns the opposite color
+func GetOtherColor(color string) string {
+	if color == ColorGreen {
+		return ColorBlue
+	}
+	return ColorGreen
+}
+
+// find target group by name, returns nil if the target group doesn't exist
+func (a *ALB) FindTargetGroupArn(name string) (*string, error) {
+	svc := getClients(a.Clients).ELBV2()
+	input := &elbv2.DescribeTargetGroupsInput{
+		Names: []*string{aws.String(name)},
+	}
+	result, err := svc.DescribeTargetGroups(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == elbv2.ErrCodeTargetGroupNotFoundException {
+				return nil, nil
+			}
+			albLogger.Errorf(aerr.Error())
+		} else {
+			albLogger.Errorf(err.Error())
+		}
+		return nil, err
+	}
+	if len(result.TargetGroups) == 0 {
+		return nil, nil
+	}
+	return result.TargetGroups[0].TargetGroupArn, nil
+}
+
+// GetActiveColor returns the color the listener rules of the service forward the traffic to
+func (a *ALB) GetActiveColor(serviceName string) (string, error) {
+	greenTargetGroupArn, err := a.FindTargetGroupArn(GetBlueGreenServiceName(serviceName, ColorGreen))
+	if err != nil {
+		return "", err
+	}
+	if greenTargetGroupArn == nil {
+		return ColorBlue, nil
+	}
+	err = a.GetRulesForAllListeners()
+	if err != nil {
+		return "", err
+	}
+	if len(a.GetRulesByTargetGroupArn(*greenTargetGroupArn)) > 0 {
+		return ColorGreen, nil
+	}
+	return ColorBlue, nil
+}
+
+// modify the target group a rule forwards to
+func (a *ALB) ModifyRuleTargetGroup(ruleArn, targetGroupArn string) error {
+	albLogger.Debugf("Modifying ALB Rule %v to forward to %v", ruleArn, targetGroupArn)
+	return a.modifyRuleActions(ruleArn, []*elbv2.Action{
+		{
+			TargetGroupArn: aws.String(targetGroupArn),
+			Type:           aws.String("forward"),
+		},
+	})
+}
+
+func (a *ALB) modifyRuleActions(ruleArn string, actions []*elbv2.Action) error {
+	// keep the authentication in front of the forward
+	if authenticateActions := a.getRuleAuthenticateActions(ruleArn); len(authenticateActions) > 0 {
+		actions = append(authenticateActions, actions...)
+		for i, action := range actions {
+			action.SetOrder(int64(i + 1))
+		}
+	}
+	return a.modifyRule(ruleArn, actions)
+}
+
+// modifyRule replaces the actions of a rule
+func (a *ALB) modifyRule(ruleArn string, actions []*elbv2.Action) error {
+	svc := getClients(a.Clients).ELBV2()
+	input := &elbv2.ModifyRuleInput{
+		RuleArn: aws.String(ruleArn),
+		Actions: actions,
+	}
+
+	_, err := svc.ModifyRule(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			albLogger.Errorf(aerr.Error())
+		} else {
+			albLogger.Errorf(err.Error())
+		}
+		return errors.New("Could not modify alb rule")
+	}
+	return nil
+}
+
+// SwitchTargetGroup moves all the rules that forward to a target group to another target group
+func (a *ALB) SwitchTargetGroup(fromTargetGroupArn, toTargetGroupArn string) error {
+	err := a.GetRulesForAllListeners()
+	if err != nil {
+		return err
+	}
+	ruleArns := a.GetRulesByTargetGroupArn(fromTargetGroupArn)
+	if len(ruleArns) == 0 {
+		return errors.New("No rules found that forward to target group " + fromTargetGroupArn)
+	}
+	for _, ruleArn := range ruleArns {
+		err = a.ModifyRuleTargetGroup(ruleArn, toTargetGroupArn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LaunchWaitUntilBlueGreenStable waits until the new color of a blueGreen deployment is stable, moves the
+// traffic to the new color and scales down the previous color after the bake time (see LaunchBlueGreenBake)
+func (e *ECS) LaunchWaitUntilBlueGreenStable(dd *service.DynamoDeployment) error {
+	s := service.NewService()
+	clusterName := dd.DeployData.Cluster
+	newServiceName := GetBlueGreenServiceName(dd.ServiceName, dd.Color)
+
+	waitErr := e.WaitUntilServicesStable(clusterName, newServiceName, e.getMaxWaitMinutes(dd))
+	if waitErr != nil {
+		ecsLogger.Debugf("waitUntilServiceStable didn't succeed: %v", waitErr)
+	}
+	reason, err := e.getDeploymentFailure(clusterName, newServiceName, *dd.TaskDefinitionArn)
+	if err != nil {
+		return err
+	}
+	if reason == "" && waitErr != nil {
+		reason = "Deployment timed out"
+	}
+	if reason != "" {
+		// the traffic still goes to the previous color, only the new color needs to be scaled down
+		ecsLogger.Debugf(reason)
+		err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
+		if err != nil {
+			return err
+		}
+		return e.ManualScaleService(clusterName, newServiceName, 0)
+	}
+
+	// move the traffic to the new color
+	alb, err := newALBForDeploy(*dd.DeployData)
+	if err != nil {
+		return err
+	}
+	activeColor, err := alb.GetActiveColor(dd.ServiceName)
+	if err != nil {
+		return err
+	}
+	if activeColor != dd.Color {
+		err = e.switchColor(alb, dd.ServiceName, activeColor, dd.Color)
+		if err != nil {
+			s.SetDeploymentStatusWithReason(dd, "failed", "Could not move traffic to "+dd.Color+": "+err.Error())
+			return err
+		}
+		ecsLogger.Infof("Moved traffic of %v from %v to %v", dd.ServiceName, activeColor, dd.Color)
+	}
+	// keep the previous color running during the bake time, to be able to roll back instantly. The bake deadline is
+	// stored with the deployment, so the previous color is scaled down after a restart as well
+	bakeDeadline := time.Now().UTC().Add(time.Duration(dd.DeployData.BakeTime) * time.Second)
+	dd.BakeDeadline = &bakeDeadline
+	err = s.SetDeploymentStatus(dd, "success")
+	if err != nil {
+		return err
+	}
+	return e.LaunchBlueGreenBake(dd)
+}
+
+// LaunchBlueGreenBake waits until the bake deadline of a blueGreen deployment is over, scales down the previous color
+// and clears the bake deadline
+func (e *ECS) LaunchBlueGreenBake(dd *service.DynamoDeployment) error {
+	if dd.BakeDeadline == nil {
+		return nil
+	}
+	time.Sleep(time.Until(*dd.BakeDeadline))
+	err := e.scaleDownInactiveColor(dd)
+	if err != nil {
+		return err
+	}
+	return service.NewService().SetBakeDeadline(dd, nil)
+}
+
+// switchColor moves the rules from the target group of one color to the target group of the other color
+func (e *ECS) switchColor(alb *ALB, serviceName, fromColor, toColor string) error {
+	fromTargetGroupArn, err := alb.GetTargetGroupArn(GetBlueGreenServiceName(serviceName, fromColor))
+	if err != nil {
+		return err
+	}
+	toTargetGroupArn, err := alb.GetTargetGroupArn(GetBlueGreenServiceName(serviceName, toColor))
+	if err != nil {
+		return err
+	}
+	return alb.SwitchTargetGroup(*fromTargetGroupArn, *toTargetGroupArn)
+}
+
+// scaleDownInactiveColor scales the color without traffic down to 0, unless a newer deployment has started
+func (e *ECS) scaleDownInactiveColor(dd *service.DynamoDeployment) error {
+	s := service.NewService()
+	s.ServiceName = dd.ServiceName
+	ddLast, err := s.GetLastDeploy()
+	if err != nil {
+		return err
+	}
+	if !ddLast.Time.Equal(dd.Time) {
+		ecsLogger.Debugf("Newer deployment found for %v, not scaling down inactive color", dd.ServiceName)
+		return nil
+	}
+	alb, err := newALBForDeploy(*dd.DeployData)
+	if err != nil {
+		return err
+	}
+	activeColor, err := alb.GetActiveColor(dd.ServiceName)
+	if err != nil {
+		return err
+	}
+	inactiveServiceName := GetBlueGreenServiceName(dd.ServiceName, GetOtherColor(activeColor))
+	c := ECS{ClusterName: dd.DeployData.Cluster, Clients: e.Clients}
+	exists, err := c.ServiceExists(inactiveServiceName)
+	if err != nil || !exists {
+		return err
+	}
+	ecsLogger.Infof("Bake time of %v is over, scaling down %v", dd.ServiceName, inactiveServiceName)
+	return e.ManualScaleService(dd.DeployData.Cluster, inactiveServiceName, 0)
+}
+
+// RollbackBlueGreen moves the traffic back to the previous color, as long as the previous color is not scaled down
+func (e *ECS) RollbackBlueGreen(dd *service.DynamoDeployment) (string, error) {
+	alb, err := newALBForDeploy(*dd.DeployData)
+	if err != nil {
+		return "", err
+	}
+	activeColor, err := alb.GetActiveColor(dd.ServiceName)
+	if err != nil {
+		return "", err
+	}
+	previousColor := GetOtherColor(activeColor)
+	previousService, err := e.DescribeService(dd.DeployData.Cluster, GetBlueGreenServiceName(dd.ServiceName, previousColor), false, false, false)
+	if err != nil {
+		return "", err
+	}
+	if previousService.RunningCount == 0 {
+		return "", errors.New("Could not rollback, " + previousColor + " has no running tasks (bake time is over)")
+	}
+	err = e.switchColor(alb, dd.ServiceName, activeColor, previousColor)
+	if err != nil {
+		return "", err
+	}
+	ecsLogger.Infof("Rolled back traffic of %v from %v to %v", dd.ServiceName, activeColor, previousColor)
+	return previousColor, nil
+}
+
+func newALBForDeploy(d service.Deploy) (*ALB, error) {
+	if d.LoadBalancer != "" {
+		return NewALB(d.LoadBalancer)
+	}
+	return NewALB(d.Cluster)
+}
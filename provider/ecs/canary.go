@@ -0,0 +1,242 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/in4it/ecs-deploy/service"
+
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// default canary steps (percentage of the traffic going to the canary) and interval between the steps in seconds
+var (
+	DefaultCanarySteps    = []int64{5, 25, 100}
+	DefaultCanaryInterval = int64(300)
+)
+
+// GetCanaryServiceName returns the ecs service and target group name of the canary
+func GetCanaryServiceName(serviceName string) string {
+	return serviceName + "-canary"
+}
+
+// GetCanarySteps returns the traffic percentages of the canary steps
+func GetCanarySteps(canary service.DeployCanary) []int64 {
+	if len(canary.Steps) == 0 {
+		return DefaultCanarySteps
+	}
+	return canary.Steps
+}
+
+func getCanaryInterval(canary service.DeployCanary) time.Duration {
+	if canary.Interval == 0 {
+		return time.Duration(DefaultCanaryInterval) * time.Second
+	}
+	return time.Duration(canary.Interval) * time.Second
+}
+
+// SetCanaryWeight sends weight percent of the traffic of the rules that forward to the primary target group
+// to the canary target group. A weight of 0 changes the rules back to a plain forward to the primary target group
+func (a *ALB) SetCanaryWeight(primaryTargetGroupArn, canaryTargetGroupArn string, weight int64) error {
+	err := a.GetRulesForAllListeners()
+	if err != nil {
+		return err
+	}
+	ruleArns := a.GetRulesByTargetGroupArn(primaryTargetGroupArn)
+	if len(ruleArns) == 0 {
+		return errors.New("No rules found that forward to target group " + primaryTargetGroupArn)
+	}
+	action := &elbv2.Action{
+		Type:           aws.String("forward"),
+		TargetGroupArn: aws.String(primaryTargetGroupArn),
+	}
+	if weight > 0 {
+		action = &elbv2.Action{
+			Type: aws.String("forward"),
+			ForwardConfig: &elbv2.ForwardActionConfig{
+				TargetGroups: []*elbv2.TargetGroupTuple{
+					{TargetGroupArn: aws.String(primaryTargetGroupArn), Weight: aws.Int64(100 - weight)},
+					{TargetGroupArn: aws.String(canaryTargetGroupArn), Weight: aws.Int64(weight)},
+				},
+			},
+		}
+	}
+	for _, ruleArn := range ruleArns {
+		albLogger.Debugf("Modifying ALB Rule %v to forward %d%% to %v", ruleArn, weight, canaryTargetGroupArn)
+		err = a.modifyRuleActions(ruleArn, []*elbv2.Action{action})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMetricDimensions returns the cloudwatch dimensions of a target group of the loadbalancer
+func (a *ALB) getMetricDimensions(targetGroupArn string) map[string]string {
+	// arn:aws:elasticloadbalancing:region:account:loadbalancer/app/name/id => app/name/id
+	loadBalancer := a.loadBalancerArn[strings.Index(a.loadBalancerArn, ":loadbalancer/")+len(":loadbalancer/"):]
+	// arn:aws:elasticloadbalancing:region:account:targetgroup/name/id => targetgroup/name/id
+	targetGroup := targetGroupArn[strings.Index(targetGroupArn, ":targetgroup/")+1:]
+	return map[string]string{
+		"LoadBalancer": loadBalancer,
+		"TargetGroup":  targetGroup,
+	}
+}
+
+// LaunchCanaryDeployment waits until the canary is stable, shifts the traffic to the canary in steps while checking
+// the metrics of the canary target group, and promotes the new task definition to the primary service
+func (e *ECS) LaunchCanaryDeployment(dd *service.DynamoDeployment) error {
+	s := service.NewService()
+	clusterName := dd.DeployData.Cluster
+	canaryServiceName := GetCanaryServiceName(dd.ServiceName)
+	c := ECS{ClusterName: clusterName, Clients: e.Clients}
+
+	waitErr := c.WaitUntilServicesStable(clusterName, canaryServiceName, c.getMaxWaitMinutes(dd))
+	if waitErr != nil {
+		ecsLogger.Debugf("waitUntilServiceStable didn't succeed: %v", waitErr)
+	}
+	reason, err := c.getDeploymentFailure(clusterName, canaryServiceName, *dd.TaskDefinitionArn)
+	if err != nil {
+		return err
+	}
+	if reason == "" && waitErr != nil {
+		reason = "Deployment timed out"
+	}
+	if reason != "" {
+		// the canary didn't receive any traffic yet
+		ecsLogger.Debugf(reason)
+		err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
+		if err != nil {
+			return err
+		}
+		return c.ManualScaleService(clusterName, canaryServiceName, 0)
+	}
+
+	alb, err := newALBForDeploy(*dd.DeployData)
+	if err != nil {
+		return err
+	}
+	primaryTargetGroupArn, err := alb.GetTargetGroupArn(dd.ServiceName)
+	if err != nil {
+		return err
+	}
+	canaryTargetGroupArn, err := alb.GetTargetGroupArn(canaryServiceName)
+	if err != nil {
+		return err
+	}
+
+	// shift the traffic, resuming at the last recorded step
+	steps := GetCanarySteps(dd.DeployData.Canary)
+	start := 0
+	if dd.Canary != nil && dd.Canary.Step > 0 {
+		start = int(dd.Canary.Step) - 1
+	}
+	for i := start; i < len(steps); i++ {
+		// the conditional write fails when the deployment has been superseded
+		err = s.SetCanaryProgress(dd, service.DynamoDeploymentCanary{Step: int64(i + 1), Steps: int64(len(steps)), Weight: steps[i]})
+		if err != nil {
+			return err
+		}
+		err = alb.SetCanaryWeight(*primaryTargetGroupArn, *canaryTargetGroupArn, steps[i])
+		if err != nil {
+			return c.failCanary(dd, alb, *primaryTargetGroupArn, *canaryTargetGroupArn, "Could not move traffic to canary: "+err.Error())
+		}
+		ecsLogger.Infof("Moved %d%% of the traffic of %v to the canary", steps[i], dd.ServiceName)
+		stepStart := time.Now()
+		time.Sleep(getCanaryInterval(dd.DeployData.Canary))
+		reason, err = c.checkCanaryMetrics(alb, *canaryTargetGroupArn, dd.DeployData.Canary, stepStart, time.Now())
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return c.failCanary(dd, alb, *primaryTargetGroupArn, *canaryTargetGroupArn, fmt.Sprintf("Canary failed at %d%%: %v", steps[i], reason))
+		}
+	}
+
+	// promote the new task definition to the primary service
+	_, err = c.UpdateService(dd.ServiceName, dd.TaskDefinitionArn, *dd.DeployData)
+	if err != nil {
+		return c.failCanary(dd, alb, *primaryTargetGroupArn, *canaryTargetGroupArn, "Could not promote canary: "+err.Error())
+	}
+	waitErr = c.WaitUntilServicesStable(clusterName, dd.ServiceName, c.getMaxWaitMinutes(dd))
+	if waitErr != nil {
+		ecsLogger.Debugf("waitUntilServiceStable didn't succeed: %v", waitErr)
+	}
+	reason, err = c.getDeploymentFailure(clusterName, dd.ServiceName, *dd.TaskDefinitionArn)
+	if err != nil {
+		return err
+	}
+	if reason == "" && waitErr != nil {
+		reason = "Deployment timed out"
+	}
+	if reason != "" {
+		err = c.failCanary(dd, alb, *primaryTargetGroupArn, *canaryTargetGroupArn, "Could not promote canary: "+reason)
+		if err != nil {
+			return err
+		}
+		return c.Rollback(clusterName, dd.ServiceName)
+	}
+	err = s.SetDeploymentStatus(dd, "success")
+	if err != nil {
+		return err
+	}
+	err = alb.SetCanaryWeight(*primaryTargetGroupArn, *canaryTargetGroupArn, 0)
+	if err != nil {
+		return err
+	}
+	ecsLogger.Infof("Promoted canary of %v, scaling down %v", dd.ServiceName, canaryServiceName)
+	return c.ManualScaleService(clusterName, canaryServiceName, 0)
+}
+
+// checkCanaryMetrics returns the reason why the canary target group breached the thresholds, or an empty string if it didn't
+func (e *ECS) checkCanaryMetrics(alb *ALB, canaryTargetGroupArn string, canary service.DeployCanary, startTime, endTime time.Time) (string, error) {
+	cw := CloudWatch{Clients: e.Clients}
+	dimensions := alb.getMetricDimensions(canaryTargetGroupArn)
+	// loadbalancer metrics have a granularity of 1 minute
+	startTime = startTime.Truncate(time.Minute)
+	endTime = endTime.Truncate(time.Minute).Add(time.Minute)
+	if canary.MaxErrorRate > 0 {
+		requests, err := cw.GetMetricStatistic("AWS/ApplicationELB", "RequestCount", "Sum", dimensions, startTime, endTime)
+		if err != nil {
+			return "", err
+		}
+		errorCount, err := cw.GetMetricStatistic("AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", "Sum", dimensions, startTime, endTime)
+		if err != nil {
+			return "", err
+		}
+		if requests > 0 && errorCount > 0 {
+			errorRate := errorCount / requests * 100
+			if errorRate > canary.MaxErrorRate {
+				return fmt.Sprintf("error rate of %.2f%% exceeds maxErrorRate of %.2f%%", errorRate, canary.MaxErrorRate), nil
+			}
+		}
+	}
+	if canary.MaxLatency > 0 {
+		latency, err := cw.GetMetricStatistic("AWS/ApplicationELB", "TargetResponseTime", "Average", dimensions, startTime, endTime)
+		if err != nil {
+			return "", err
+		}
+		if latency > canary.MaxLatency {
+			return fmt.Sprintf("latency of %.3fs exceeds maxLatency of %.3fs", latency, canary.MaxLatency), nil
+		}
+	}
+	return "", nil
+}
+
+// failCanary marks the deployment as failed, moves all the traffic back to the primary service and scales down the canary
+func (e *ECS) failCanary(dd *service.DynamoDeployment, alb *ALB, primaryTargetGroupArn, canaryTargetGroupArn, reason string) error {
+	s := service.NewService()
+	ecsLogger.Infof("%v: %v", dd.ServiceName, reason)
+	// the conditional write fails when the deployment has been superseded, the new deployment takes care of the traffic
+	err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
+	if err != nil {
+		return err
+	}
+	err = alb.SetCanaryWeight(primaryTargetGroupArn, canaryTargetGroupArn, 0)
+	if err != nil {
+		return err
+	}
+	return e.ManualScaleService(dd.DeployData.Cluster, GetCanaryServiceName(dd.ServiceName), 0)
+}
@@ -0,0 +1,100 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// Clients returns the AWS SDK clients used by the provider
+type Clients interface {
+	ECS() ecsiface.ECSAPI
+	EC2() ec2iface.EC2API
+	ELBV2() elbv2iface.ELBV2API
+	ACM() acmiface.ACMAPI
+	IAM() iamiface.IAMAPI
+	STS() stsiface.STSAPI
+	SSM() ssmiface.SSMAPI
+	CloudWatch() cloudwatchiface.CloudWatchAPI
+	CloudWatchLogs() cloudwatchlogsiface.CloudWatchLogsAPI
+	AutoScaling() autoscalingiface.AutoScalingAPI
+	ApplicationAutoScaling() applicationautoscalingiface.ApplicationAutoScalingAPI
+	ECR() ecriface.ECRAPI
+}
+
+// AWSClients returns real AWS clients, every call creates a new session
+type AWSClients struct{}
+
+func (c AWSClients) ECS() ecsiface.ECSAPI {
+	return ecs.New(session.New())
+}
+func (c AWSClients) EC2() ec2iface.EC2API {
+	return ec2.New(session.New())
+}
+func (c AWSClients) ELBV2() elbv2iface.ELBV2API {
+	return elbv2.New(session.New())
+}
+func (c AWSClients) ACM() acmiface.ACMAPI {
+	return acm.New(session.New())
+}
+func (c AWSClients) IAM() iamiface.IAMAPI {
+	return iam.New(session.New())
+}
+func (c AWSClients) STS() stsiface.STSAPI {
+	return sts.New(session.New())
+}
+func (c AWSClients) SSM() ssmiface.SSMAPI {
+	return ssm.New(session.New())
+}
+func (c AWSClients) CloudWatch() cloudwatchiface.CloudWatchAPI {
+	return cloudwatch.New(session.New())
+}
+func (c AWSClients) CloudWatchLogs() cloudwatchlogsiface.CloudWatchLogsAPI {
+	return cloudwatchlogs.New(session.New())
+}
+func (c AWSClients) AutoScaling() autoscalingiface.AutoScalingAPI {
+	return autoscaling.New(session.New())
+}
+func (c AWSClients) ApplicationAutoScaling() applicationautoscalingiface.ApplicationAutoScalingAPI {
+	return applicationautoscaling.New(session.New())
+}
+func (c AWSClients) ECR() ecriface.ECRAPI {
+	return ecr.New(session.New())
+}
+
+// clients used when a struct doesn't have its own clients set
+var defaultClients Clients = AWSClients{}
+
+// SetDefaultClients replaces the clients used by all provider structs without clients set (e.g. with the in-memory fake)
+func SetDefaultClients(c Clients) {
+	defaultClients = c
+}
+
+func getClients(c Clients) Clients {
+	if c != nil {
+		return c
+	}
+	return defaultClients
+}
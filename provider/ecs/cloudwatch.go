@@ -3,12 +3,12 @@ package ecs
 import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/in4it/ecs-deploy/service"
 	"github.com/juju/loggo"
 
+	"math"
 	"time"
 )
 
@@ -26,10 +26,12 @@ type CloudWatchLogEvent struct {
 // logging
 var cloudwatchLogger = loggo.GetLogger("cloudwatch")
 
-type CloudWatch struct{}
+type CloudWatch struct {
+	Clients Clients
+}
 
 func (cloudwatch *CloudWatch) CreateLogGroup(clusterName, logGroup string) error {
-	svc := cloudwatchlogs.New(session.New())
+	svc := getClients(cloudwatch.Clients).CloudWatchLogs()
 	input := &cloudwatchlogs.CreateLogGroupInput{
 		LogGroupName: aws.String(logGroup),
 	}
@@ -51,7 +53,7 @@ func (cloudwatch *CloudWatch) CreateLogGroup(clusterName, logGroup string) error
 }
 
 func (cloudwatch *CloudWatch) DeleteLogGroup(logGroup string) error {
-	svc := cloudwatchlogs.New(session.New())
+	svc := getClients(cloudwatch.Clients).CloudWatchLogs()
 	input := &cloudwatchlogs.DeleteLogGroupInput{
 		LogGroupName: aws.String(logGroup),
 	}
@@ -70,7 +72,7 @@ func (cloudwatch *CloudWatch) DeleteLogGroup(logGroup string) error {
 
 func (cloudwatch *CloudWatch) GetLogEventsByTime(logGroup, logStream string, startTime, endTime time.Time, nextToken string) (CloudWatchLog, error) {
 	var logEvents CloudWatchLog
-	svc := cloudwatchlogs.New(session.New())
+	svc := getClients(cloudwatch.Clients).CloudWatchLogs()
 	input := &cloudwatchlogs.GetLogEventsInput{
 		LogGroupName:  aws.String(logGroup),
 		LogStreamName: aws.String(logStream),
@@ -102,8 +104,39 @@ func (cloudwatch *CloudWatch) GetLogEventsByTime(logGroup, logStream string, sta
 	return logEvents, nil
 }
 
+// PutLogEvent writes a message to the log stream, the log stream is created when it doesn't exist yet
+func (cloudwatch *CloudWatch) PutLogEvent(logGroup, logStream, message string, t time.Time) error {
+	svc := getClients(cloudwatch.Clients).CloudWatchLogs()
+	input := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(logGroup),
+		LogStreamName: aws.String(logStream),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{
+			{Message: aws.String(message), Timestamp: aws.Int64(t.UnixNano() / 1000000)},
+		},
+	}
+	_, err := svc.PutLogEvents(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+		_, err = svc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String(logGroup),
+			LogStreamName: aws.String(logStream),
+		})
+		if aerr, ok := err.(awserr.Error); err == nil || (ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException) {
+			_, err = svc.PutLogEvents(input)
+		}
+	}
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			cloudwatchLogger.Errorf("%v", aerr.Error())
+		} else {
+			cloudwatchLogger.Errorf("%v", err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
 func (c *CloudWatch) PutMetricAlarm(serviceName, clusterName, alarmName string, alarmActions []string, alarmDescription string, datapointsToAlarm int64, metricName string, namespace string, period int64, threshold float64, comparisonOperator string, statistic string, evaluationPeriods int64) error {
-	svc := cloudwatch.New(session.New())
+	svc := getClients(c.Clients).CloudWatch()
 	input := &cloudwatch.PutMetricAlarmInput{
 		ActionsEnabled:     aws.Bool(true),
 		AlarmActions:       aws.StringSlice(alarmActions),
@@ -138,7 +171,7 @@ func (c *CloudWatch) PutMetricAlarm(serviceName, clusterName, alarmName string,
 func (c *CloudWatch) DescribeAlarms(alarmNames []string) ([]service.AutoscalingPolicy, error) {
 	var metricAlarms []*cloudwatch.MetricAlarm
 	var aps []service.AutoscalingPolicy
-	svc := cloudwatch.New(session.New())
+	svc := getClients(c.Clients).CloudWatch()
 	input := &cloudwatch.DescribeAlarmsInput{
 		AlarmNames: aws.StringSlice(alarmNames),
 	}
@@ -176,7 +209,7 @@ func (c *CloudWatch) DescribeAlarms(alarmNames []string) ([]service.AutoscalingP
 }
 
 func (c *CloudWatch) DeleteAlarms(alarmNames []string) error {
-	svc := cloudwatch.New(session.New())
+	svc := getClients(c.Clients).CloudWatch()
 
 	input := &cloudwatch.DeleteAlarmsInput{
 		AlarmNames: aws.StringSlice(alarmNames),
@@ -193,3 +226,84 @@ func (c *CloudWatch) DeleteAlarms(alarmNames []string) error {
 	}
 	return nil
 }
+
+// GetMetricStatistic returns the statistic of a metric over the time range, aggregated over all datapoints.
+// Returns -1 when there are no datapoints
+func (c *CloudWatch) GetMetricStatistic(namespace, metricName, statistic string, dimensions map[string]string, startTime, endTime time.Time) (float64, error) {
+	svc := getClients(c.Clients).CloudWatch()
+	period := int64(math.Ceil(endTime.Sub(startTime).Seconds()/60)) * 60
+	if period < 60 {
+		period = 60
+	}
+	input := &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metricName),
+		StartTime:  aws.Time(startTime),
+		EndTime:    aws.Time(endTime),
+		Period:     aws.Int64(period),
+		Statistics: []*string{aws.String(statistic)},
+	}
+	for k, v := range dimensions {
+		input.Dimensions = append(input.Dimensions, &cloudwatch.Dimension{Name: aws.String(k), Value: aws.String(v)})
+	}
+
+	result, err := svc.GetMetricStatistics(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			cloudwatchLogger.Errorf("%v", aerr.Error())
+		} else {
+			cloudwatchLogger.Errorf("%v", err.Error())
+		}
+		return 0, err
+	}
+	if len(result.Datapoints) == 0 {
+		return -1, nil
+	}
+	var value float64
+	for i, datapoint := range result.Datapoints {
+		switch statistic {
+		case "Sum":
+			value += aws.Float64Value(datapoint.Sum)
+		case "SampleCount":
+			value += aws.Float64Value(datapoint.SampleCount)
+		case "Maximum":
+			if i == 0 || aws.Float64Value(datapoint.Maximum) > value {
+				value = aws.Float64Value(datapoint.Maximum)
+			}
+		case "Minimum":
+			if i == 0 || aws.Float64Value(datapoint.Minimum) < value {
+				value = aws.Float64Value(datapoint.Minimum)
+			}
+		default:
+			value += aws.Float64Value(datapoint.Average) / float64(len(result.Datapoints))
+		}
+	}
+	return value, nil
+}
+
+// GetAlarmStates returns the state (OK, ALARM or INSUFFICIENT_DATA) of the alarms that exist
+func (c *CloudWatch) GetAlarmStates(alarmNames []string) (map[string]string, error) {
+	states := make(map[string]string)
+	svc := getClients(c.Clients).CloudWatch()
+	input := &cloudwatch.DescribeAlarmsInput{
+		AlarmNames: aws.StringSlice(alarmNames),
+	}
+
+	err := svc.DescribeAlarmsPages(input,
+		func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+			for _, v := range page.MetricAlarms {
+				states[aws.StringValue(v.AlarmName)] = aws.StringValue(v.StateValue)
+			}
+			return !lastPage
+		})
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			cloudwatchLogger.Errorf("%v", aerr.Error())
+		} else {
+			cloudwatchLogger.Errorf("%v", err.Error())
+		}
+		return states, err
+	}
+	return states, nil
+}
@@ -3,7 +3,6 @@ package ecs
 import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/juju/loggo"
 )
@@ -14,11 +13,12 @@ var ecrLogger = loggo.GetLogger("ecr")
 // ECR struct
 type ECR struct {
 	RepositoryName, RepositoryURI string
+	Clients                       Clients
 }
 
 // Creates ECR repository
 func (e *ECR) CreateRepository() error {
-	svc := ecr.New(session.New())
+	svc := getClients(e.Clients).ECR()
 	input := &ecr.CreateRepositoryInput{
 		RepositoryName: aws.String(e.RepositoryName),
 	}
@@ -57,7 +57,7 @@ func (e *ECR) CreateRepository() error {
 	}
 }
 func (e *ECR) ListImagesWithTag(repositoryName string) (map[string]string, error) {
-	svc := ecr.New(session.New())
+	svc := getClients(e.Clients).ECR()
 
 	images := make(map[string]string)
 
@@ -89,7 +89,7 @@ func (e *ECR) ListImagesWithTag(repositoryName string) (map[string]string, error
 }
 
 func (e *ECR) RepositoryExists(repositoryName string) (bool, error) {
-	svc := ecr.New(session.New())
+	svc := getClients(e.Clients).ECR()
 
 	var exists bool
 
@@ -4,7 +4,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/in4it/ecs-deploy/service"
@@ -29,12 +28,18 @@ var ecsLogger = loggo.GetLogger("ecs")
 
 // ECS struct
 type ECS struct {
-	ClusterName    string
-	ServiceName    string
-	IamRoleArn     string
-	TaskDefinition *ecs.RegisterTaskDefinitionInput
-	TaskDefArn     *string
-	TargetGroupArn *string
+	ClusterName      string
+	ServiceName      string
+	IamRoleArn       string
+	ExecutionRoleArn string
+	TaskDefinition   *ecs.RegisterTaskDefinitionInput
+	TaskDefArn       *string
+	TargetGroupArn   *string
+	TargetGroupArns  []*string // target groups of the loadBalancers of the deploy, in the same order
+	ContainerName    string    // container attached to the target group, defaults to ServiceName
+	Clients          Clients
+	// update the load balancers of the ecs service, when the loadBalancers of the deploy changed
+	UpdateLoadBalancers bool
 }
 
 // Task definition and Container definition
@@ -129,7 +134,7 @@ type EcsTaskMetadataItemContainerNetworks struct {
 
 // create cluster
 func (e *ECS) CreateCluster(clusterName string) (*string, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	createClusterInput := &ecs.CreateClusterInput{
 		ClusterName: aws.String(clusterName),
 	}
@@ -147,7 +152,7 @@ func (e *ECS) CreateCluster(clusterName string) (*string, error) {
 }
 func (e *ECS) GetECSAMI() (string, error) {
 	var amiId string
-	svc := ec2.New(session.New())
+	svc := getClients(e.Clients).EC2()
 	input := &ec2.DescribeImagesInput{
 		Owners: []*string{aws.String("591542846629")}, // AWS
 		Filters: []*ec2.Filter{
@@ -182,7 +187,7 @@ func (e *ECS) GetECSAMI() (string, error) {
 	return amiId, nil
 }
 func (e *ECS) ImportKeyPair(keyName string, publicKey []byte) error {
-	svc := ec2.New(session.New())
+	svc := getClients(e.Clients).EC2()
 	input := &ec2.ImportKeyPairInput{
 		KeyName:           aws.String(keyName),
 		PublicKeyMaterial: publicKey,
@@ -219,7 +224,7 @@ func (e *ECS) GetPubKeyFromPrivateKey(privateKey string) ([]byte, error) {
 	return []byte(base64.StdEncoding.EncodeToString(pubASN1)), nil
 }
 func (e *ECS) DeleteKeyPair(keyName string) error {
-	svc := ec2.New(session.New())
+	svc := getClients(e.Clients).EC2()
 	input := &ec2.DeleteKeyPairInput{
 		KeyName: aws.String(keyName),
 	}
@@ -237,7 +242,7 @@ func (e *ECS) DeleteKeyPair(keyName string) error {
 
 // delete cluster
 func (e *ECS) DeleteCluster(clusterName string) error {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	deleteClusterInput := &ecs.DeleteClusterInput{
 		Cluster: aws.String(clusterName),
 	}
@@ -256,17 +261,37 @@ func (e *ECS) DeleteCluster(clusterName string) error {
 
 // Creates ECS repository
 func (e *ECS) CreateTaskDefinition(d service.Deploy) (*string, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	e.TaskDefinition = &ecs.RegisterTaskDefinitionInput{
 		Family:      aws.String(e.ServiceName),
 		TaskRoleArn: aws.String(e.IamRoleArn),
 	}
 
+	// the execution role pulls the images and retrieves the secrets and repository credentials
+	if e.ExecutionRoleArn != "" {
+		e.TaskDefinition.SetExecutionRoleArn(e.ExecutionRoleArn)
+	}
+
 	// set network mode if set
 	if d.NetworkMode != "" {
 		e.TaskDefinition.SetNetworkMode(d.NetworkMode)
 	}
 
+	// task size, required for fargate
+	if service.IsFargate(d) {
+		e.TaskDefinition.SetRequiresCompatibilities([]*string{aws.String("FARGATE")})
+		cpu, memory := service.GetTaskSize(d)
+		e.TaskDefinition.SetCpu(strconv.FormatInt(cpu, 10))
+		e.TaskDefinition.SetMemory(strconv.FormatInt(memory, 10))
+	} else {
+		if d.CPU > 0 {
+			e.TaskDefinition.SetCpu(strconv.FormatInt(d.CPU, 10))
+		}
+		if d.Memory > 0 {
+			e.TaskDefinition.SetMemory(strconv.FormatInt(d.Memory, 10))
+		}
+	}
+
 	// placement constraints
 	if len(d.PlacementConstraints) > 0 {
 		var pcs []*ecs.TaskDefinitionPlacementConstraint
@@ -364,13 +389,56 @@ func (e *ECS) CreateTaskDefinition(d service.Deploy) (*string, error) {
 			}
 			containerDefinition.SetHealthCheck(healthCheck)
 		}
-		// set containerPort if not empty
-		if container.ContainerPort > 0 {
-			containerDefinition.SetPortMappings([]*ecs.PortMapping{
-				{
-					ContainerPort: aws.Int64(container.ContainerPort),
-				},
-			})
+		// set containerPort and the port mappings if not empty
+		var portMappings []*ecs.PortMapping
+		var containerPortMapped bool
+		for _, pm := range container.PortMappings {
+			portMapping := &ecs.PortMapping{ContainerPort: aws.Int64(pm.ContainerPort)}
+			if pm.HostPort > 0 {
+				portMapping.SetHostPort(pm.HostPort)
+			}
+			if pm.Protocol != "" {
+				portMapping.SetProtocol(strings.ToLower(pm.Protocol))
+			}
+			if pm.ContainerPort == container.ContainerPort {
+				containerPortMapped = true
+			}
+			portMappings = append(portMappings, portMapping)
+		}
+		if container.ContainerPort > 0 && !containerPortMapped {
+			portMappings = append([]*ecs.PortMapping{{ContainerPort: aws.Int64(container.ContainerPort)}}, portMappings...)
+		}
+		if len(portMappings) > 0 {
+			containerDefinition.SetPortMappings(portMappings)
+		}
+		// start order of the containers
+		if len(container.DependsOn) > 0 {
+			var dependsOn []*ecs.ContainerDependency
+			for _, dependency := range container.DependsOn {
+				condition := dependency.Condition
+				if condition == "" {
+					condition = "START"
+				}
+				dependsOn = append(dependsOn, &ecs.ContainerDependency{
+					ContainerName: aws.String(dependency.ContainerName),
+					Condition:     aws.String(strings.ToUpper(condition)),
+				})
+			}
+			containerDefinition.SetDependsOn(dependsOn)
+		}
+		if container.StartTimeout > 0 {
+			containerDefinition.SetStartTimeout(container.StartTimeout)
+		}
+		if container.StopTimeout > 0 {
+			containerDefinition.SetStopTimeout(container.StopTimeout)
+		}
+		// log router (fluentd or fluent bit)
+		if container.FirelensConfiguration.Type != "" {
+			firelensConfiguration := &ecs.FirelensConfiguration{Type: aws.String(container.FirelensConfiguration.Type)}
+			if len(container.FirelensConfiguration.Options) > 0 {
+				firelensConfiguration.SetOptions(aws.StringMap(container.FirelensConfiguration.Options))
+			}
+			containerDefinition.SetFirelensConfiguration(firelensConfiguration)
 		}
 		// set containerCommand if not empty
 		if len(container.ContainerCommand) > 0 {
@@ -437,6 +505,30 @@ func (e *ECS) CreateTaskDefinition(d service.Deploy) (*string, error) {
 			containerDefinition.SetEnvironment(environment)
 		}
 
+		// secrets from the parameter store or Secrets Manager
+		if len(container.Secrets) > 0 {
+			ps := Paramstore{}
+			namespace := d.EnvNamespace
+			if namespace == "" {
+				namespace = e.ServiceName
+			}
+			var secrets []*ecs.Secret
+			for _, secret := range container.Secrets {
+				secrets = append(secrets, &ecs.Secret{
+					Name:      aws.String(secret.Name),
+					ValueFrom: aws.String(ps.GetSecretArn(secret.ValueFrom, namespace, iam.AccountId)),
+				})
+			}
+			containerDefinition.SetSecrets(secrets)
+		}
+
+		// credentials of a private registry, stored in Secrets Manager
+		if container.RepositoryCredentials.CredentialsParameter != "" {
+			containerDefinition.SetRepositoryCredentials(&ecs.RepositoryCredentials{
+				CredentialsParameter: aws.String(container.RepositoryCredentials.CredentialsParameter),
+			})
+		}
+
 		// ulimits
 		if len(container.Ulimits) > 0 {
 			var us []*ecs.Ulimit
@@ -497,7 +589,7 @@ func (e *ECS) CreateTaskDefinition(d service.Deploy) (*string, error) {
 
 // check whether service exists
 func (e *ECS) ServiceExists(serviceName string) (bool, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.DescribeServicesInput{
 		Cluster: aws.String(e.ClusterName),
 		Services: []*string{
@@ -538,7 +630,7 @@ func (e *ECS) ServiceExists(serviceName string) (bool, error) {
 
 // Update ECS service
 func (e *ECS) UpdateService(serviceName string, taskDefArn *string, d service.Deploy) (*string, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.UpdateServiceInput{
 		Cluster:        aws.String(e.ClusterName),
 		Service:        aws.String(serviceName),
@@ -550,6 +642,23 @@ func (e *ECS) UpdateService(serviceName string, taskDefArn *string, d service.De
 		input.SetNetworkConfiguration(e.getNetworkConfiguration(d))
 	}
 
+	if service.IsFargate(d) && d.PlatformVersion != "" {
+		input.SetPlatformVersion(d.PlatformVersion)
+	}
+
+	if e.UpdateLoadBalancers {
+		input.SetLoadBalancers(e.getLoadBalancers(d))
+	}
+
+	// a new deployment is needed to move the tasks to the capacity providers
+	if len(d.CapacityProviderStrategy) > 0 {
+		input.SetCapacityProviderStrategy(e.getCapacityProviderStrategy(d))
+		input.SetForceNewDeployment(true)
+	}
+	if len(d.PlacementStrategy) > 0 {
+		input.SetPlacementStrategy(e.getPlacementStrategy(d))
+	}
+
 	// set gracePeriodSeconds
 	if d.HealthCheck.GracePeriodSeconds > 0 {
 		input.SetHealthCheckGracePeriodSeconds(d.HealthCheck.GracePeriodSeconds)
@@ -591,7 +700,7 @@ func (e *ECS) UpdateService(serviceName string, taskDefArn *string, d service.De
 // delete ECS service
 func (e *ECS) DeleteService(clusterName, serviceName string) error {
 	// first set desiredCount to 0
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.UpdateServiceInput{
 		Cluster:      aws.String(clusterName),
 		Service:      aws.String(serviceName),
@@ -627,7 +736,7 @@ func (e *ECS) DeleteService(clusterName, serviceName string) error {
 
 // create service
 func (e *ECS) CreateService(d service.Deploy) error {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 
 	// sanity checks
 	if len(d.Containers) == 0 {
@@ -639,37 +748,34 @@ func (e *ECS) CreateService(d service.Deploy) error {
 		DesiredCount:   aws.Int64(d.DesiredCount),
 		ServiceName:    aws.String(e.ServiceName),
 		TaskDefinition: aws.String(*e.TaskDefArn),
-		PlacementStrategy: []*ecs.PlacementStrategy{
-			{
-				Field: aws.String("attribute:ecs.availability-zone"),
-				Type:  aws.String("spread"),
-			},
-			{
-				Field: aws.String("memory"),
-				Type:  aws.String("binpack"),
-			},
-		},
 	}
 
-	if strings.ToLower(d.ServiceProtocol) != "none" {
-		input.SetLoadBalancers([]*ecs.LoadBalancer{
-			{
-				ContainerName:  aws.String(e.ServiceName),
-				ContainerPort:  aws.Int64(d.ServicePort),
-				TargetGroupArn: aws.String(*e.TargetGroupArn),
-			},
-		})
+	// the launch type can't be set together with a capacity provider strategy
+	if len(d.CapacityProviderStrategy) > 0 {
+		input.SetCapacityProviderStrategy(e.getCapacityProviderStrategy(d))
+	} else if strings.ToUpper(d.LaunchType) == "FARGATE" {
+		input.SetLaunchType("FARGATE")
+	}
+	if service.IsFargate(d) {
+		if d.PlatformVersion != "" {
+			input.SetPlatformVersion(d.PlatformVersion)
+		}
+	} else {
+		// fargate doesn't support placement strategies
+		input.SetPlacementStrategy(e.getPlacementStrategy(d))
+	}
+
+	loadBalancers := e.getLoadBalancers(d)
+	if len(loadBalancers) > 0 {
+		input.SetLoadBalancers(loadBalancers)
 	}
 
 	// network configuration
 	if d.NetworkMode == "awsvpc" && len(d.NetworkConfiguration.Subnets) > 0 {
-		if strings.ToUpper(d.LaunchType) == "FARGATE" {
-			input.SetLaunchType("FARGATE")
-		}
 		input.SetNetworkConfiguration(e.getNetworkConfiguration(d))
 	} else {
 		// only set role if network mode is not awsvpc (it will be set automatically)
-		if strings.ToLower(d.ServiceProtocol) != "none" { // only set the role if there's a loadbalancer necessary
+		if len(loadBalancers) > 0 { // only set the role if there's a loadbalancer necessary
 			input.SetRole(util.GetEnv("AWS_ECS_SERVICE_ROLE", "ecs-service-role"))
 		}
 	}
@@ -717,7 +823,7 @@ func (e *ECS) CreateService(d service.Deploy) error {
 
 // wait until service is inactive
 func (e *ECS) WaitUntilServicesInactive(clusterName, serviceName string) error {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.DescribeServicesInput{
 		Cluster:  aws.String(clusterName),
 		Services: []*string{aws.String(serviceName)},
@@ -739,7 +845,7 @@ func (e *ECS) WaitUntilServicesInactive(clusterName, serviceName string) error {
 
 // wait until service is stable
 func (e *ECS) WaitUntilServicesStable(clusterName, serviceName string, maxWaitMinutes int) error {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	maxAttempts := maxWaitMinutes * 4
 	input := &ecs.DescribeServicesInput{
 		Cluster:  aws.String(clusterName),
@@ -761,26 +867,19 @@ func (e *ECS) WaitUntilServicesStable(clusterName, serviceName string, maxWaitMi
 }
 func (e *ECS) LaunchWaitUntilServicesStable(dd *service.DynamoDeployment) error {
 	var failed bool
-	var maxWaitMinutes int
 	s := service.NewService()
 	// check whether service exists, otherwise wait might give error
-	if dd.DeployData.HealthCheck.GracePeriodSeconds > 0 {
-		maxWaitMinutes = (1 + int(math.Ceil(float64(dd.DeployData.HealthCheck.GracePeriodSeconds)/60/10))) * 10
-	} else {
-		maxWaitMinutes = 15
-	}
-	err := e.WaitUntilServicesStable(dd.DeployData.Cluster, dd.ServiceName, maxWaitMinutes)
+	err := e.WaitUntilServicesStable(dd.DeployData.Cluster, dd.ServiceName, e.getMaxWaitMinutes(dd))
 	if err != nil {
 		ecsLogger.Debugf("waitUntilServiceStable didn't succeed: %v", err)
 		failed = true
 	}
 	// check whether deployment has latest task definition
-	runningService, err := e.DescribeService(dd.DeployData.Cluster, dd.ServiceName, false, true, true)
+	reason, err := e.getDeploymentFailure(dd.DeployData.Cluster, dd.ServiceName, *dd.TaskDefinitionArn)
 	if err != nil {
 		return err
 	}
-	if len(runningService.Deployments) != 1 {
-		reason := "Deployment failed: deployment was still running after 10 minutes"
+	if reason != "" {
 		ecsLogger.Debugf(reason)
 		err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
 		if err != nil {
@@ -792,40 +891,55 @@ func (e *ECS) LaunchWaitUntilServicesStable(dd *service.DynamoDeployment) error
 		}
 		return nil
 	}
-	if runningService.Deployments[0].TaskDefinition != *dd.TaskDefinitionArn {
-		reason := "Deployment failed: Still running old task definition"
-		ecsLogger.Debugf(reason)
-		err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
-		if err != nil {
-			return err
-		}
-		err = e.Rollback(dd.DeployData.Cluster, dd.ServiceName)
-		if err != nil {
-			return err
-		}
+	if failed {
+		s.SetDeploymentStatusWithReason(dd, "failed", "Deployment timed out")
 		return nil
 	}
-	if len(runningService.Tasks) == 0 {
-		reason := "Deployment failed: no tasks running"
-		ecsLogger.Debugf(reason)
-		err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
+	// the service is stable, check whether the health gates stay ok
+	if HasHealthGates(dd.DeployData.HealthGates) {
+		reason, err = e.waitForHealthGates(dd)
 		if err != nil {
 			return err
 		}
-		err = e.Rollback(dd.DeployData.Cluster, dd.ServiceName)
-		if err != nil {
-			return err
+		if reason != "" {
+			ecsLogger.Infof("%v: %v", dd.ServiceName, reason)
+			err := s.SetDeploymentStatusWithReason(dd, "failed", reason)
+			if err != nil {
+				return err
+			}
+			return e.Rollback(dd.DeployData.Cluster, dd.ServiceName)
 		}
-		return nil
-	}
-	if failed {
-		s.SetDeploymentStatusWithReason(dd, "failed", "Deployment timed out")
-		return nil
 	}
 	// set success
 	s.SetDeploymentStatus(dd, "success")
 	return nil
 }
+
+// max minutes to wait for a deployment to become stable
+func (e *ECS) getMaxWaitMinutes(dd *service.DynamoDeployment) int {
+	if dd.DeployData.HealthCheck.GracePeriodSeconds > 0 {
+		return (1 + int(math.Ceil(float64(dd.DeployData.HealthCheck.GracePeriodSeconds)/60/10))) * 10
+	}
+	return 15
+}
+
+// getDeploymentFailure returns the reason why the service is not running the task definition, or an empty string if it is
+func (e *ECS) getDeploymentFailure(clusterName, serviceName, taskDefinitionArn string) (string, error) {
+	runningService, err := e.DescribeService(clusterName, serviceName, false, true, true)
+	if err != nil {
+		return "", err
+	}
+	if len(runningService.Deployments) != 1 {
+		return "Deployment failed: deployment was still running after 10 minutes", nil
+	}
+	if runningService.Deployments[0].TaskDefinition != taskDefinitionArn {
+		return "Deployment failed: Still running old task definition", nil
+	}
+	if len(runningService.Tasks) == 0 {
+		return "Deployment failed: no tasks running", nil
+	}
+	return "", nil
+}
 func (e *ECS) Rollback(clusterName, serviceName string) error {
 	ecsLogger.Debugf("Starting rollback")
 	s := service.NewService()
@@ -873,7 +987,7 @@ func (e *ECS) DescribeServices(clusterName string, serviceNames []*string, showE
 }
 func (e *ECS) DescribeServicesWithOptions(clusterName string, serviceNames []*string, showEvents bool, showTasks bool, showStoppedTasks bool, options map[string]string) ([]service.RunningService, error) {
 	var rss []service.RunningService
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 
 	// fetch per 10
 	var y float64 = float64(len(serviceNames)) / 10
@@ -959,7 +1073,7 @@ func (e *ECS) DescribeServicesWithOptions(clusterName string, serviceNames []*st
 
 // list tasks
 func (e *ECS) ListTasks(clusterName, name, desiredStatus, filterBy string) ([]*string, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	var tasks []*string
 
 	input := &ecs.ListTasksInput{
@@ -995,7 +1109,7 @@ func (e *ECS) ListTasks(clusterName, name, desiredStatus, filterBy string) ([]*s
 }
 func (e *ECS) DescribeTasks(clusterName string, tasks []*string) ([]service.RunningTask, error) {
 	var rts []service.RunningTask
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 
 	// fetch per 100
 	var y float64 = float64(len(tasks)) / 100
@@ -1079,7 +1193,7 @@ func (e *ECS) DescribeTasks(clusterName string, tasks []*string) ([]service.Runn
 }
 
 func (e *ECS) ListContainerInstances(clusterName string) ([]string, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.ListContainerInstancesInput{
 		Cluster: aws.String(clusterName),
 	}
@@ -1107,7 +1221,7 @@ func (e *ECS) ListContainerInstances(clusterName string) ([]string, error) {
 // describe container instances
 func (e *ECS) DescribeContainerInstances(clusterName string, containerInstances []string) ([]ContainerInstance, error) {
 	var cis []ContainerInstance
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.DescribeContainerInstancesInput{
 		Cluster:            aws.String(clusterName),
 		ContainerInstances: aws.StringSlice(containerInstances),
@@ -1179,7 +1293,7 @@ func (e *ECS) DescribeContainerInstances(clusterName string, containerInstances
 
 // manual scale ECS service
 func (e *ECS) ManualScaleService(clusterName, serviceName string, desiredCount int64) error {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.UpdateServiceInput{
 		Cluster:      aws.String(clusterName),
 		Service:      aws.String(serviceName),
@@ -1222,10 +1336,75 @@ func (e *ECS) getNetworkConfiguration(d service.Deploy) *ecs.NetworkConfiguratio
 	return nc
 }
 
+// getLoadBalancers returns the target group of the service and the target groups of the loadBalancers of the deploy
+func (e *ECS) getLoadBalancers(d service.Deploy) []*ecs.LoadBalancer {
+	var loadBalancers []*ecs.LoadBalancer
+	if strings.ToLower(d.ServiceProtocol) != "none" && e.TargetGroupArn != nil {
+		containerName := e.ContainerName
+		if containerName == "" {
+			containerName = e.ServiceName
+		}
+		loadBalancers = append(loadBalancers, &ecs.LoadBalancer{
+			ContainerName:  aws.String(containerName),
+			ContainerPort:  aws.Int64(d.ServicePort),
+			TargetGroupArn: aws.String(*e.TargetGroupArn),
+		})
+	}
+	for i, lb := range d.LoadBalancers {
+		if i >= len(e.TargetGroupArns) {
+			break
+		}
+		loadBalancers = append(loadBalancers, &ecs.LoadBalancer{
+			ContainerName:  aws.String(lb.ContainerName),
+			ContainerPort:  aws.Int64(lb.ContainerPort),
+			TargetGroupArn: aws.String(aws.StringValue(e.TargetGroupArns[i])),
+		})
+	}
+	return loadBalancers
+}
+
+func (e *ECS) getCapacityProviderStrategy(d service.Deploy) []*ecs.CapacityProviderStrategyItem {
+	var items []*ecs.CapacityProviderStrategyItem
+	for _, item := range d.CapacityProviderStrategy {
+		items = append(items, &ecs.CapacityProviderStrategyItem{
+			CapacityProvider: aws.String(item.CapacityProvider),
+			Weight:           aws.Int64(item.Weight),
+			Base:             aws.Int64(item.Base),
+		})
+	}
+	return items
+}
+
+// getPlacementStrategy returns the placement strategy of the deploy, or spread over the availability zones and
+// binpack on memory when it's not set
+func (e *ECS) getPlacementStrategy(d service.Deploy) []*ecs.PlacementStrategy {
+	if len(d.PlacementStrategy) == 0 {
+		return []*ecs.PlacementStrategy{
+			{
+				Field: aws.String("attribute:ecs.availability-zone"),
+				Type:  aws.String("spread"),
+			},
+			{
+				Field: aws.String("memory"),
+				Type:  aws.String("binpack"),
+			},
+		}
+	}
+	var pss []*ecs.PlacementStrategy
+	for _, ps := range d.PlacementStrategy {
+		placementStrategy := &ecs.PlacementStrategy{Type: aws.String(ps.Type)}
+		if ps.Field != "" {
+			placementStrategy.SetField(ps.Field)
+		}
+		pss = append(pss, placementStrategy)
+	}
+	return pss
+}
+
 // run one-off task
 func (e *ECS) RunTask(clusterName, taskDefinition string, runTask service.RunTask, d service.Deploy) (string, error) {
 	var taskArn string
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.RunTaskInput{
 		Cluster:        aws.String(clusterName),
 		TaskDefinition: aws.String(taskDefinition),
@@ -1252,11 +1431,22 @@ func (e *ECS) RunTask(clusterName, taskDefinition string, runTask service.RunTas
 	taskOverride.SetContainerOverrides(containerOverrides)
 	input.SetOverrides(taskOverride)
 
+	// launch type or capacity providers of the service
+	if len(d.CapacityProviderStrategy) > 0 {
+		input.SetCapacityProviderStrategy(e.getCapacityProviderStrategy(d))
+	} else if d.LaunchType != "" {
+		input.SetLaunchType(strings.ToUpper(d.LaunchType))
+	}
+	if service.IsFargate(d) {
+		if d.PlatformVersion != "" {
+			input.SetPlatformVersion(d.PlatformVersion)
+		}
+	} else if len(d.PlacementStrategy) > 0 {
+		input.SetPlacementStrategy(e.getPlacementStrategy(d))
+	}
+
 	// network configuration
 	if d.NetworkMode == "awsvpc" && len(d.NetworkConfiguration.Subnets) > 0 {
-		if strings.ToUpper(d.LaunchType) == "FARGATE" {
-			input.SetLaunchType("FARGATE")
-		}
 		input.SetNetworkConfiguration(e.getNetworkConfiguration(d))
 	}
 
@@ -1291,7 +1481,7 @@ func (e *ECS) GetTaskDefinition(clusterName, serviceName string) (string, error)
 }
 func (e *ECS) DescribeTaskDefinition(taskDefinitionNameOrArn string) (TaskDefinition, error) {
 	var taskDefinition TaskDefinition
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: aws.String(taskDefinitionNameOrArn),
 	}
@@ -1420,7 +1610,7 @@ func (e *ECS) ConvertResourceToRir(cir []ContainerInstanceResource) (RegisteredI
 }
 
 func (e *ECS) DrainNode(clusterName, instance string) error {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	input := &ecs.UpdateContainerInstancesStateInput{
 		Cluster:            aws.String(clusterName),
 		ContainerInstances: aws.StringSlice([]string{instance}),
@@ -1439,7 +1629,7 @@ func (e *ECS) DrainNode(clusterName, instance string) error {
 }
 func (e *ECS) GetClusterNameByInstanceId(instance string) (string, error) {
 	var clusterName string
-	svc := ec2.New(session.New())
+	svc := getClients(e.Clients).EC2()
 	input := &ec2.DescribeTagsInput{
 		Filters: []*ec2.Filter{
 			{
@@ -1522,7 +1712,7 @@ func (e *ECS) LaunchWaitForDrainedNode(clusterName, containerInstanceArn, instan
 
 // list services
 func (e *ECS) ListServices(clusterName string) ([]*string, error) {
-	svc := ecs.New(session.New())
+	svc := getClients(e.Clients).ECS()
 	var services []*string
 
 	input := &ecs.ListServicesInput{
@@ -0,0 +1,44 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+)
+
+type acmState struct {
+	certificates map[string]*acm.CertificateDetail
+}
+
+func (s *acmState) init() {
+	s.certificates = make(map[string]*acm.CertificateDetail)
+}
+
+// AddCertificate adds a certificate for the domain and returns its arn, the arn can be attached to a listener
+func (f *AWS) AddCertificate(domainName string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arn := f.arn("acm", "certificate")
+	f.acm.certificates[arn] = &acm.CertificateDetail{
+		CertificateArn: aws.String(arn),
+		DomainName:     aws.String(domainName),
+		Status:         aws.String("ISSUED"),
+	}
+	return arn
+}
+
+type fakeACM struct {
+	acmiface.ACMAPI
+	f *AWS
+}
+
+func (a *fakeACM) DescribeCertificate(input *acm.DescribeCertificateInput) (*acm.DescribeCertificateOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	c, ok := a.f.acm.certificates[aws.StringValue(input.CertificateArn)]
+	if !ok {
+		return nil, awsError(acm.ErrCodeResourceNotFoundException, "Could not find certificate "+aws.StringValue(input.CertificateArn)+".")
+	}
+	return &acm.DescribeCertificateOutput{Certificate: awsutil.CopyOf(c).(*acm.CertificateDetail)}, nil
+}
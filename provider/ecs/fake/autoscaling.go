@@ -0,0 +1,306 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+
+	"sort"
+	"time"
+)
+
+type autoscalingState struct {
+	groups               map[string]*autoscaling.Group
+	launchConfigurations map[string]*autoscaling.LaunchConfiguration
+	scalableTargets      map[string]*applicationautoscaling.ScalableTarget // resource id
+	scalingPolicies      map[string]*applicationautoscaling.ScalingPolicy  // resource id + policy name
+	lifecycleActions     []*autoscaling.CompleteLifecycleActionInput
+}
+
+func (s *autoscalingState) init() {
+	s.groups = make(map[string]*autoscaling.Group)
+	s.launchConfigurations = make(map[string]*autoscaling.LaunchConfiguration)
+	s.scalableTargets = make(map[string]*applicationautoscaling.ScalableTarget)
+	s.scalingPolicies = make(map[string]*applicationautoscaling.ScalingPolicy)
+}
+
+// AddAutoScalingGroup creates an autoscaling group tagged with the cluster name
+func (f *AWS) AddAutoScalingGroup(autoScalingGroupName, clusterName string, minSize, desiredCapacity, maxSize int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.autoscaling.groups[autoScalingGroupName] = &autoscaling.Group{
+		AutoScalingGroupName: aws.String(autoScalingGroupName),
+		AutoScalingGroupARN:  aws.String(f.arn("autoscaling", "autoScalingGroupName/"+autoScalingGroupName)),
+		MinSize:              aws.Int64(minSize),
+		DesiredCapacity:      aws.Int64(desiredCapacity),
+		MaxSize:              aws.Int64(maxSize),
+		CreatedTime:          aws.Time(time.Now()),
+		Tags: []*autoscaling.TagDescription{
+			{Key: aws.String("Cluster"), Value: aws.String(clusterName), ResourceId: aws.String(autoScalingGroupName), ResourceType: aws.String("auto-scaling-group")},
+		},
+	}
+}
+
+// GetAutoScalingGroup returns a copy of the autoscaling group, or nil when the group doesn't exist
+func (f *AWS) GetAutoScalingGroup(autoScalingGroupName string) *autoscaling.Group {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if g, ok := f.autoscaling.groups[autoScalingGroupName]; ok {
+		return awsutil.CopyOf(g).(*autoscaling.Group)
+	}
+	return nil
+}
+
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	f *AWS
+}
+
+func (a *fakeAutoScaling) describeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) *autoscaling.DescribeAutoScalingGroupsOutput {
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	var names []string
+	if len(input.AutoScalingGroupNames) == 0 {
+		for name := range a.f.autoscaling.groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		names = aws.StringValueSlice(input.AutoScalingGroupNames)
+	}
+	for _, name := range names {
+		if g, ok := a.f.autoscaling.groups[name]; ok {
+			output.AutoScalingGroups = append(output.AutoScalingGroups, awsutil.CopyOf(g).(*autoscaling.Group))
+		}
+	}
+	return output
+}
+
+func (a *fakeAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	return a.describeAutoScalingGroups(input), nil
+}
+
+func (a *fakeAutoScaling) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	a.f.mu.Lock()
+	output := a.describeAutoScalingGroups(input)
+	a.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (a *fakeAutoScaling) CreateAutoScalingGroup(input *autoscaling.CreateAutoScalingGroupInput) (*autoscaling.CreateAutoScalingGroupOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	name := aws.StringValue(input.AutoScalingGroupName)
+	if _, ok := a.f.autoscaling.groups[name]; ok {
+		return nil, awsError(autoscaling.ErrCodeAlreadyExistsFault, "AutoScalingGroup by this name already exists")
+	}
+	g := &autoscaling.Group{
+		AutoScalingGroupName:    aws.String(name),
+		AutoScalingGroupARN:     aws.String(a.f.arn("autoscaling", "autoScalingGroupName/"+name)),
+		LaunchConfigurationName: aws.String(aws.StringValue(input.LaunchConfigurationName)),
+		MinSize:                 aws.Int64(aws.Int64Value(input.MinSize)),
+		DesiredCapacity:         aws.Int64(aws.Int64Value(input.DesiredCapacity)),
+		MaxSize:                 aws.Int64(aws.Int64Value(input.MaxSize)),
+		VPCZoneIdentifier:       aws.String(aws.StringValue(input.VPCZoneIdentifier)),
+		CreatedTime:             aws.Time(time.Now()),
+	}
+	for _, tag := range input.Tags {
+		g.Tags = append(g.Tags, &autoscaling.TagDescription{
+			Key:               aws.String(aws.StringValue(tag.Key)),
+			Value:             aws.String(aws.StringValue(tag.Value)),
+			PropagateAtLaunch: aws.Bool(aws.BoolValue(tag.PropagateAtLaunch)),
+			ResourceId:        aws.String(name),
+			ResourceType:      aws.String("auto-scaling-group"),
+		})
+	}
+	a.f.autoscaling.groups[name] = g
+	return &autoscaling.CreateAutoScalingGroupOutput{}, nil
+}
+
+func (a *fakeAutoScaling) UpdateAutoScalingGroup(input *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	g, ok := a.f.autoscaling.groups[aws.StringValue(input.AutoScalingGroupName)]
+	if !ok {
+		return nil, awsError("ValidationError", "AutoScalingGroup name not found")
+	}
+	if input.MinSize != nil {
+		g.MinSize = aws.Int64(aws.Int64Value(input.MinSize))
+	}
+	if input.MaxSize != nil {
+		g.MaxSize = aws.Int64(aws.Int64Value(input.MaxSize))
+	}
+	if input.DesiredCapacity != nil {
+		desiredCapacity := aws.Int64Value(input.DesiredCapacity)
+		if desiredCapacity < aws.Int64Value(g.MinSize) || desiredCapacity > aws.Int64Value(g.MaxSize) {
+			return nil, awsError("ValidationError", "Desired capacity must be between the min and max size")
+		}
+		g.DesiredCapacity = aws.Int64(desiredCapacity)
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (a *fakeAutoScaling) DeleteAutoScalingGroup(input *autoscaling.DeleteAutoScalingGroupInput) (*autoscaling.DeleteAutoScalingGroupOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	name := aws.StringValue(input.AutoScalingGroupName)
+	if _, ok := a.f.autoscaling.groups[name]; !ok {
+		return nil, awsError("ValidationError", "AutoScalingGroup name not found")
+	}
+	delete(a.f.autoscaling.groups, name)
+	return &autoscaling.DeleteAutoScalingGroupOutput{}, nil
+}
+
+func (a *fakeAutoScaling) CreateLaunchConfiguration(input *autoscaling.CreateLaunchConfigurationInput) (*autoscaling.CreateLaunchConfigurationOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	name := aws.StringValue(input.LaunchConfigurationName)
+	if _, ok := a.f.autoscaling.launchConfigurations[name]; ok {
+		return nil, awsError(autoscaling.ErrCodeAlreadyExistsFault, "Launch Configuration by this name already exists")
+	}
+	a.f.autoscaling.launchConfigurations[name] = &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName: aws.String(name),
+		ImageId:                 aws.String(aws.StringValue(input.ImageId)),
+		InstanceType:            aws.String(aws.StringValue(input.InstanceType)),
+		KeyName:                 aws.String(aws.StringValue(input.KeyName)),
+		IamInstanceProfile:      aws.String(aws.StringValue(input.IamInstanceProfile)),
+		CreatedTime:             aws.Time(time.Now()),
+	}
+	return &autoscaling.CreateLaunchConfigurationOutput{}, nil
+}
+
+func (a *fakeAutoScaling) DeleteLaunchConfiguration(input *autoscaling.DeleteLaunchConfigurationInput) (*autoscaling.DeleteLaunchConfigurationOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	delete(a.f.autoscaling.launchConfigurations, aws.StringValue(input.LaunchConfigurationName))
+	return &autoscaling.DeleteLaunchConfigurationOutput{}, nil
+}
+
+func (a *fakeAutoScaling) WaitUntilGroupInService(input *autoscaling.DescribeAutoScalingGroupsInput) error {
+	return nil
+}
+func (a *fakeAutoScaling) WaitUntilGroupNotExists(input *autoscaling.DescribeAutoScalingGroupsInput) error {
+	return nil
+}
+
+func (a *fakeAutoScaling) DescribeLifecycleHooks(input *autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	return &autoscaling.DescribeLifecycleHooksOutput{}, nil
+}
+
+func (a *fakeAutoScaling) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	a.f.autoscaling.lifecycleActions = append(a.f.autoscaling.lifecycleActions, awsutil.CopyOf(input).(*autoscaling.CompleteLifecycleActionInput))
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+type fakeApplicationAutoScaling struct {
+	applicationautoscalingiface.ApplicationAutoScalingAPI
+	f *AWS
+}
+
+func (a *fakeApplicationAutoScaling) RegisterScalableTarget(input *applicationautoscaling.RegisterScalableTargetInput) (*applicationautoscaling.RegisterScalableTargetOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	resourceId := aws.StringValue(input.ResourceId)
+	st, ok := a.f.autoscaling.scalableTargets[resourceId]
+	if !ok {
+		st = &applicationautoscaling.ScalableTarget{
+			ResourceId:        aws.String(resourceId),
+			ScalableDimension: aws.String(aws.StringValue(input.ScalableDimension)),
+			ServiceNamespace:  aws.String(aws.StringValue(input.ServiceNamespace)),
+			CreationTime:      aws.Time(time.Now()),
+		}
+		a.f.autoscaling.scalableTargets[resourceId] = st
+	}
+	if input.MinCapacity != nil {
+		st.MinCapacity = aws.Int64(aws.Int64Value(input.MinCapacity))
+	}
+	if input.MaxCapacity != nil {
+		st.MaxCapacity = aws.Int64(aws.Int64Value(input.MaxCapacity))
+	}
+	if input.RoleARN != nil {
+		st.RoleARN = aws.String(aws.StringValue(input.RoleARN))
+	}
+	return &applicationautoscaling.RegisterScalableTargetOutput{}, nil
+}
+
+func (a *fakeApplicationAutoScaling) DeregisterScalableTarget(input *applicationautoscaling.DeregisterScalableTargetInput) (*applicationautoscaling.DeregisterScalableTargetOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	resourceId := aws.StringValue(input.ResourceId)
+	if _, ok := a.f.autoscaling.scalableTargets[resourceId]; !ok {
+		return nil, awsError(applicationautoscaling.ErrCodeObjectNotFoundException, "No scalable target registered for "+resourceId)
+	}
+	delete(a.f.autoscaling.scalableTargets, resourceId)
+	for k, p := range a.f.autoscaling.scalingPolicies {
+		if aws.StringValue(p.ResourceId) == resourceId {
+			delete(a.f.autoscaling.scalingPolicies, k)
+		}
+	}
+	return &applicationautoscaling.DeregisterScalableTargetOutput{}, nil
+}
+
+func (a *fakeApplicationAutoScaling) DescribeScalableTargetsPages(input *applicationautoscaling.DescribeScalableTargetsInput, fn func(*applicationautoscaling.DescribeScalableTargetsOutput, bool) bool) error {
+	a.f.mu.Lock()
+	output := &applicationautoscaling.DescribeScalableTargetsOutput{}
+	for _, resourceId := range input.ResourceIds {
+		if st, ok := a.f.autoscaling.scalableTargets[aws.StringValue(resourceId)]; ok {
+			output.ScalableTargets = append(output.ScalableTargets, awsutil.CopyOf(st).(*applicationautoscaling.ScalableTarget))
+		}
+	}
+	a.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (a *fakeApplicationAutoScaling) PutScalingPolicy(input *applicationautoscaling.PutScalingPolicyInput) (*applicationautoscaling.PutScalingPolicyOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	resourceId := aws.StringValue(input.ResourceId)
+	if _, ok := a.f.autoscaling.scalableTargets[resourceId]; !ok {
+		return nil, awsError(applicationautoscaling.ErrCodeObjectNotFoundException, "No scalable target registered for "+resourceId)
+	}
+	in := awsutil.CopyOf(input).(*applicationautoscaling.PutScalingPolicyInput)
+	p := &applicationautoscaling.ScalingPolicy{
+		PolicyARN:                      aws.String(a.f.arn("autoscaling", "scalingPolicy/"+aws.StringValue(in.PolicyName))),
+		PolicyName:                     in.PolicyName,
+		PolicyType:                     in.PolicyType,
+		ResourceId:                     in.ResourceId,
+		ScalableDimension:              in.ScalableDimension,
+		ServiceNamespace:               in.ServiceNamespace,
+		StepScalingPolicyConfiguration: in.StepScalingPolicyConfiguration,
+		CreationTime:                   aws.Time(time.Now()),
+	}
+	a.f.autoscaling.scalingPolicies[resourceId+"/"+aws.StringValue(in.PolicyName)] = p
+	return &applicationautoscaling.PutScalingPolicyOutput{PolicyARN: aws.String(aws.StringValue(p.PolicyARN))}, nil
+}
+
+func (a *fakeApplicationAutoScaling) DescribeScalingPoliciesPages(input *applicationautoscaling.DescribeScalingPoliciesInput, fn func(*applicationautoscaling.DescribeScalingPoliciesOutput, bool) bool) error {
+	a.f.mu.Lock()
+	output := &applicationautoscaling.DescribeScalingPoliciesOutput{}
+	for _, policyName := range input.PolicyNames {
+		if p, ok := a.f.autoscaling.scalingPolicies[aws.StringValue(input.ResourceId)+"/"+aws.StringValue(policyName)]; ok {
+			output.ScalingPolicies = append(output.ScalingPolicies, awsutil.CopyOf(p).(*applicationautoscaling.ScalingPolicy))
+		}
+	}
+	a.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (a *fakeApplicationAutoScaling) DeleteScalingPolicy(input *applicationautoscaling.DeleteScalingPolicyInput) (*applicationautoscaling.DeleteScalingPolicyOutput, error) {
+	a.f.mu.Lock()
+	defer a.f.mu.Unlock()
+	key := aws.StringValue(input.ResourceId) + "/" + aws.StringValue(input.PolicyName)
+	if _, ok := a.f.autoscaling.scalingPolicies[key]; !ok {
+		return nil, awsError(applicationautoscaling.ErrCodeObjectNotFoundException, "No scaling policy found for "+aws.StringValue(input.PolicyName))
+	}
+	delete(a.f.autoscaling.scalingPolicies, key)
+	return &applicationautoscaling.DeleteScalingPolicyOutput{}, nil
+}
@@ -0,0 +1,259 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+
+	"time"
+)
+
+type cloudwatchState struct {
+	alarms    map[string]*cloudwatch.MetricAlarm
+	logGroups map[string]map[string][]*cloudwatchlogs.OutputLogEvent // log group -> log stream
+	metrics   []metricDatapoint
+}
+
+type metricDatapoint struct {
+	namespace  string
+	metricName string
+	dimensions map[string]string
+	value      float64
+	timestamp  time.Time
+}
+
+func (s *cloudwatchState) init() {
+	s.alarms = make(map[string]*cloudwatch.MetricAlarm)
+	s.logGroups = make(map[string]map[string][]*cloudwatchlogs.OutputLogEvent)
+}
+
+// AddMetricDatapoint adds a value to a metric, GetMetricStatistics aggregates all values within the requested time range
+func (f *AWS) AddMetricDatapoint(namespace, metricName string, dimensions map[string]string, value float64, timestamp time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cloudwatch.metrics = append(f.cloudwatch.metrics, metricDatapoint{
+		namespace:  namespace,
+		metricName: metricName,
+		dimensions: dimensions,
+		value:      value,
+		timestamp:  timestamp,
+	})
+}
+
+// AddLogEvent adds a log message to the log stream, the log group needs to exist
+func (f *AWS) AddLogEvent(logGroup, logStream, message string, timestamp time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if streams, ok := f.cloudwatch.logGroups[logGroup]; ok {
+		streams[logStream] = append(streams[logStream], &cloudwatchlogs.OutputLogEvent{
+			Message:       aws.String(message),
+			Timestamp:     aws.Int64(timestamp.UnixNano() / int64(time.Millisecond)),
+			IngestionTime: aws.Int64(time.Now().UnixNano() / int64(time.Millisecond)),
+		})
+	}
+}
+
+// SetAlarmState sets the state of an alarm, the alarm is created when it doesn't exist
+func (f *AWS) SetAlarmState(alarmName, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	alarm, ok := f.cloudwatch.alarms[alarmName]
+	if !ok {
+		alarm = &cloudwatch.MetricAlarm{
+			AlarmName: aws.String(alarmName),
+			AlarmArn:  aws.String("arn:aws:cloudwatch:" + f.Region + ":" + f.AccountId + ":alarm:" + alarmName),
+		}
+		f.cloudwatch.alarms[alarmName] = alarm
+	}
+	alarm.StateValue = aws.String(state)
+}
+
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	f *AWS
+}
+
+func (c *fakeCloudWatch) PutMetricAlarm(input *cloudwatch.PutMetricAlarmInput) (*cloudwatch.PutMetricAlarmOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	in := awsutil.CopyOf(input).(*cloudwatch.PutMetricAlarmInput)
+	c.f.cloudwatch.alarms[aws.StringValue(in.AlarmName)] = &cloudwatch.MetricAlarm{
+		AlarmName:          in.AlarmName,
+		AlarmArn:           aws.String("arn:aws:cloudwatch:" + c.f.Region + ":" + c.f.AccountId + ":alarm:" + aws.StringValue(in.AlarmName)),
+		AlarmDescription:   in.AlarmDescription,
+		AlarmActions:       in.AlarmActions,
+		ActionsEnabled:     in.ActionsEnabled,
+		ComparisonOperator: in.ComparisonOperator,
+		DatapointsToAlarm:  in.DatapointsToAlarm,
+		Dimensions:         in.Dimensions,
+		EvaluationPeriods:  in.EvaluationPeriods,
+		MetricName:         in.MetricName,
+		Namespace:          in.Namespace,
+		Period:             in.Period,
+		Statistic:          in.Statistic,
+		Threshold:          in.Threshold,
+		StateValue:         aws.String("INSUFFICIENT_DATA"),
+	}
+	return &cloudwatch.PutMetricAlarmOutput{}, nil
+}
+
+func (c *fakeCloudWatch) DescribeAlarmsPages(input *cloudwatch.DescribeAlarmsInput, fn func(*cloudwatch.DescribeAlarmsOutput, bool) bool) error {
+	c.f.mu.Lock()
+	output := &cloudwatch.DescribeAlarmsOutput{}
+	for _, name := range input.AlarmNames {
+		if alarm, ok := c.f.cloudwatch.alarms[aws.StringValue(name)]; ok {
+			output.MetricAlarms = append(output.MetricAlarms, awsutil.CopyOf(alarm).(*cloudwatch.MetricAlarm))
+		}
+	}
+	c.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (c *fakeCloudWatch) DeleteAlarms(input *cloudwatch.DeleteAlarmsInput) (*cloudwatch.DeleteAlarmsOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	for _, name := range input.AlarmNames {
+		if _, ok := c.f.cloudwatch.alarms[aws.StringValue(name)]; !ok {
+			return nil, awsError(cloudwatch.ErrCodeResourceNotFound, "Alarm "+aws.StringValue(name)+" not found")
+		}
+	}
+	for _, name := range input.AlarmNames {
+		delete(c.f.cloudwatch.alarms, aws.StringValue(name))
+	}
+	return &cloudwatch.DeleteAlarmsOutput{}, nil
+}
+
+func (c *fakeCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	var values []float64
+	for _, m := range c.f.cloudwatch.metrics {
+		if m.namespace != aws.StringValue(input.Namespace) || m.metricName != aws.StringValue(input.MetricName) {
+			continue
+		}
+		if m.timestamp.Before(aws.TimeValue(input.StartTime)) || !m.timestamp.Before(aws.TimeValue(input.EndTime)) {
+			continue
+		}
+		match := len(m.dimensions) == len(input.Dimensions)
+		for _, d := range input.Dimensions {
+			if v, ok := m.dimensions[aws.StringValue(d.Name)]; !ok || v != aws.StringValue(d.Value) {
+				match = false
+			}
+		}
+		if match {
+			values = append(values, m.value)
+		}
+	}
+	output := &cloudwatch.GetMetricStatisticsOutput{Label: input.MetricName}
+	if len(values) == 0 {
+		return output, nil
+	}
+	// all values are returned as one datapoint
+	datapoint := &cloudwatch.Datapoint{
+		Timestamp:   input.StartTime,
+		SampleCount: aws.Float64(float64(len(values))),
+		Minimum:     aws.Float64(values[0]),
+		Maximum:     aws.Float64(values[0]),
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+		if v < aws.Float64Value(datapoint.Minimum) {
+			datapoint.Minimum = aws.Float64(v)
+		}
+		if v > aws.Float64Value(datapoint.Maximum) {
+			datapoint.Maximum = aws.Float64(v)
+		}
+	}
+	datapoint.Sum = aws.Float64(sum)
+	datapoint.Average = aws.Float64(sum / float64(len(values)))
+	output.Datapoints = []*cloudwatch.Datapoint{datapoint}
+	return output, nil
+}
+
+type fakeCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	f *AWS
+}
+
+func (c *fakeCloudWatchLogs) CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	name := aws.StringValue(input.LogGroupName)
+	if _, ok := c.f.cloudwatch.logGroups[name]; ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "The specified log group already exists")
+	}
+	c.f.cloudwatch.logGroups[name] = make(map[string][]*cloudwatchlogs.OutputLogEvent)
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (c *fakeCloudWatchLogs) DeleteLogGroup(input *cloudwatchlogs.DeleteLogGroupInput) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	name := aws.StringValue(input.LogGroupName)
+	if _, ok := c.f.cloudwatch.logGroups[name]; !ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.")
+	}
+	delete(c.f.cloudwatch.logGroups, name)
+	return &cloudwatchlogs.DeleteLogGroupOutput{}, nil
+}
+
+func (c *fakeCloudWatchLogs) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	streams, ok := c.f.cloudwatch.logGroups[aws.StringValue(input.LogGroupName)]
+	if !ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.")
+	}
+	events, ok := streams[aws.StringValue(input.LogStreamName)]
+	if !ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.")
+	}
+	output := &cloudwatchlogs.GetLogEventsOutput{}
+	for _, event := range events {
+		if input.StartTime != nil && aws.Int64Value(event.Timestamp) < aws.Int64Value(input.StartTime) {
+			continue
+		}
+		if input.EndTime != nil && aws.Int64Value(event.Timestamp) >= aws.Int64Value(input.EndTime) {
+			continue
+		}
+		output.Events = append(output.Events, awsutil.CopyOf(event).(*cloudwatchlogs.OutputLogEvent))
+	}
+	return output, nil
+}
+
+func (c *fakeCloudWatchLogs) CreateLogStream(input *cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	streams, ok := c.f.cloudwatch.logGroups[aws.StringValue(input.LogGroupName)]
+	if !ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.")
+	}
+	if _, ok := streams[aws.StringValue(input.LogStreamName)]; ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "The specified log stream already exists")
+	}
+	streams[aws.StringValue(input.LogStreamName)] = []*cloudwatchlogs.OutputLogEvent{}
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (c *fakeCloudWatchLogs) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	streams, ok := c.f.cloudwatch.logGroups[aws.StringValue(input.LogGroupName)]
+	if !ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.")
+	}
+	events, ok := streams[aws.StringValue(input.LogStreamName)]
+	if !ok {
+		return nil, awsError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.")
+	}
+	for _, event := range input.LogEvents {
+		events = append(events, &cloudwatchlogs.OutputLogEvent{Message: event.Message, Timestamp: event.Timestamp, IngestionTime: event.Timestamp})
+	}
+	streams[aws.StringValue(input.LogStreamName)] = events
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
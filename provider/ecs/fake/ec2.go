@@ -0,0 +1,16 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// EC2 isn't modelled, it's only used to bootstrap clusters
+type fakeEC2 struct {
+	ec2iface.EC2API
+	f *AWS
+}
+
+func (e *fakeEC2) DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	return &ec2.DescribeTagsOutput{}, nil
+}
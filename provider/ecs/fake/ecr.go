@@ -0,0 +1,100 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+
+	"time"
+)
+
+type ecrState struct {
+	repositories map[string]*ecr.Repository
+	images       map[string][]*ecr.ImageIdentifier // repository name
+}
+
+func (s *ecrState) init() {
+	s.repositories = make(map[string]*ecr.Repository)
+	s.images = make(map[string][]*ecr.ImageIdentifier)
+}
+
+// AddImage adds an image with tag to the repository, the repository needs to exist
+func (f *AWS) AddImage(repositoryName, imageTag, imageDigest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ecr.repositories[repositoryName]; ok {
+		f.ecr.images[repositoryName] = append(f.ecr.images[repositoryName], &ecr.ImageIdentifier{
+			ImageTag:    aws.String(imageTag),
+			ImageDigest: aws.String(imageDigest),
+		})
+	}
+}
+
+type fakeECR struct {
+	ecriface.ECRAPI
+	f *AWS
+}
+
+func (e *fakeECR) CreateRepository(input *ecr.CreateRepositoryInput) (*ecr.CreateRepositoryOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	name := aws.StringValue(input.RepositoryName)
+	if _, ok := e.f.ecr.repositories[name]; ok {
+		return nil, awsError(ecr.ErrCodeRepositoryAlreadyExistsException, "The repository with name '"+name+"' already exists")
+	}
+	repository := &ecr.Repository{
+		RepositoryName: aws.String(name),
+		RepositoryArn:  aws.String("arn:aws:ecr:" + e.f.Region + ":" + e.f.AccountId + ":repository/" + name),
+		RepositoryUri:  aws.String(e.f.AccountId + ".dkr.ecr." + e.f.Region + ".amazonaws.com/" + name),
+		RegistryId:     aws.String(e.f.AccountId),
+		CreatedAt:      aws.Time(time.Now()),
+	}
+	e.f.ecr.repositories[name] = repository
+	return &ecr.CreateRepositoryOutput{Repository: awsutil.CopyOf(repository).(*ecr.Repository)}, nil
+}
+
+func (e *fakeECR) PutLifecyclePolicy(input *ecr.PutLifecyclePolicyInput) (*ecr.PutLifecyclePolicyOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if _, ok := e.f.ecr.repositories[aws.StringValue(input.RepositoryName)]; !ok {
+		return nil, awsError(ecr.ErrCodeRepositoryNotFoundException, "The repository with name '"+aws.StringValue(input.RepositoryName)+"' does not exist")
+	}
+	return &ecr.PutLifecyclePolicyOutput{
+		RepositoryName:      aws.String(aws.StringValue(input.RepositoryName)),
+		RegistryId:          aws.String(e.f.AccountId),
+		LifecyclePolicyText: aws.String(aws.StringValue(input.LifecyclePolicyText)),
+	}, nil
+}
+
+func (e *fakeECR) DescribeRepositoriesPages(input *ecr.DescribeRepositoriesInput, fn func(*ecr.DescribeRepositoriesOutput, bool) bool) error {
+	e.f.mu.Lock()
+	output := &ecr.DescribeRepositoriesOutput{}
+	for _, name := range input.RepositoryNames {
+		repository, ok := e.f.ecr.repositories[aws.StringValue(name)]
+		if !ok {
+			e.f.mu.Unlock()
+			return awsError(ecr.ErrCodeRepositoryNotFoundException, "The repository with name '"+aws.StringValue(name)+"' does not exist")
+		}
+		output.Repositories = append(output.Repositories, awsutil.CopyOf(repository).(*ecr.Repository))
+	}
+	e.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (e *fakeECR) ListImagesPages(input *ecr.ListImagesInput, fn func(*ecr.ListImagesOutput, bool) bool) error {
+	e.f.mu.Lock()
+	name := aws.StringValue(input.RepositoryName)
+	if _, ok := e.f.ecr.repositories[name]; !ok {
+		e.f.mu.Unlock()
+		return awsError(ecr.ErrCodeRepositoryNotFoundException, "The repository with name '"+name+"' does not exist")
+	}
+	output := &ecr.ListImagesOutput{}
+	for _, image := range e.f.ecr.images[name] {
+		output.ImageIds = append(output.ImageIds, awsutil.CopyOf(image).(*ecr.ImageIdentifier))
+	}
+	e.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
@@ -0,0 +1,570 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ecsState struct {
+	services           map[string]map[string]*ecs.Service // cluster -> service name
+	taskDefinitions    map[string]*ecs.TaskDefinition     // task definition arn
+	revisions          map[string]int64                   // family -> latest revision
+	tasks              map[string][]*ecs.Task             // cluster
+	containerInstances map[string][]*ecs.ContainerInstance
+	startTasks         bool
+}
+
+func (s *ecsState) init() {
+	s.services = make(map[string]map[string]*ecs.Service)
+	s.taskDefinitions = make(map[string]*ecs.TaskDefinition)
+	s.revisions = make(map[string]int64)
+	s.tasks = make(map[string][]*ecs.Task)
+	s.containerInstances = make(map[string][]*ecs.ContainerInstance)
+	s.startTasks = true
+}
+
+// SetStartTasks controls whether services start their tasks, when false new deployments never finish
+func (f *AWS) SetStartTasks(start bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ecs.startTasks = start
+}
+
+// AddContainerInstance registers an EC2 instance with the given free and registered cpu/memory in the cluster
+func (f *AWS) AddContainerInstance(clusterName, instanceId, availabilityZone string, cpu, memory, freeCpu, freeMemory int64) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arn := f.arn("ecs", "container-instance")
+	f.ecs.containerInstances[clusterName] = append(f.ecs.containerInstances[clusterName], &ecs.ContainerInstance{
+		ContainerInstanceArn: aws.String(arn),
+		Ec2InstanceId:        aws.String(instanceId),
+		Status:               aws.String("ACTIVE"),
+		PendingTasksCount:    aws.Int64(0),
+		RunningTasksCount:    aws.Int64(0),
+		RegisteredAt:         aws.Time(time.Now()),
+		Version:              aws.Int64(1),
+		RegisteredResources: []*ecs.Resource{
+			{Name: aws.String("CPU"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(cpu)},
+			{Name: aws.String("MEMORY"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(memory)},
+		},
+		RemainingResources: []*ecs.Resource{
+			{Name: aws.String("CPU"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(freeCpu)},
+			{Name: aws.String("MEMORY"), Type: aws.String("INTEGER"), IntegerValue: aws.Int64(freeMemory)},
+		},
+		Attributes: []*ecs.Attribute{
+			{Name: aws.String("ecs.availability-zone"), Value: aws.String(availabilityZone)},
+		},
+	})
+	return arn
+}
+
+// GetService returns a copy of the ecs service, or nil when the service doesn't exist
+func (f *AWS) GetService(clusterName, serviceName string) *ecs.Service {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if svc, ok := f.ecs.services[clusterName][serviceName]; ok {
+		return awsutil.CopyOf(svc).(*ecs.Service)
+	}
+	return nil
+}
+
+// GetTaskDefinition returns a copy of the task definition, or nil when the task definition doesn't exist
+func (f *AWS) GetTaskDefinition(taskDefinition string) *ecs.TaskDefinition {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if td := f.ecs.findTaskDefinition(taskDefinition); td != nil {
+		return awsutil.CopyOf(td).(*ecs.TaskDefinition)
+	}
+	return nil
+}
+
+// findTaskDefinition looks up a task definition by arn, family:revision or family (latest revision)
+func (s *ecsState) findTaskDefinition(name string) *ecs.TaskDefinition {
+	if td, ok := s.taskDefinitions[name]; ok {
+		return td
+	}
+	family, revision := name, int64(0)
+	if i := strings.LastIndex(name, ":"); i != -1 && !strings.HasPrefix(name, "arn:") {
+		family = name[:i]
+		revision, _ = strconv.ParseInt(name[i+1:], 10, 64)
+	}
+	if revision == 0 {
+		revision = s.revisions[family]
+	}
+	for _, td := range s.taskDefinitions {
+		if aws.StringValue(td.Family) == family && aws.Int64Value(td.Revision) == revision {
+			return td
+		}
+	}
+	return nil
+}
+
+// newTask creates a running task for the task definition
+func (f *AWS) newTask(clusterName, group, startedBy string, td *ecs.TaskDefinition) *ecs.Task {
+	var cpu, memory int64
+	var containers []*ecs.Container
+	taskArn := f.arn("ecs", "task")
+	for _, cd := range td.ContainerDefinitions {
+		cpu += aws.Int64Value(cd.Cpu)
+		if cd.Memory != nil {
+			memory += aws.Int64Value(cd.Memory)
+		} else {
+			memory += aws.Int64Value(cd.MemoryReservation)
+		}
+		containers = append(containers, &ecs.Container{
+			ContainerArn: aws.String(f.arn("ecs", "container")),
+			Name:         cd.Name,
+			LastStatus:   aws.String("RUNNING"),
+			TaskArn:      aws.String(taskArn),
+		})
+	}
+	containerInstanceArn := "arn:aws:ecs:" + f.Region + ":" + f.AccountId + ":container-instance/fake"
+	if cis := f.ecs.containerInstances[clusterName]; len(cis) > 0 {
+		containerInstanceArn = aws.StringValue(cis[int(f.counter)%len(cis)].ContainerInstanceArn)
+	}
+	launchType := "EC2"
+	for _, c := range td.RequiresCompatibilities {
+		if aws.StringValue(c) == "FARGATE" {
+			launchType = "FARGATE"
+		}
+	}
+	now := time.Now()
+	return &ecs.Task{
+		TaskArn:              aws.String(taskArn),
+		ClusterArn:           aws.String(f.clusterArn(clusterName)),
+		ContainerInstanceArn: aws.String(containerInstanceArn),
+		TaskDefinitionArn:    td.TaskDefinitionArn,
+		Group:                aws.String(group),
+		StartedBy:            aws.String(startedBy),
+		Cpu:                  aws.String(strconv.FormatInt(cpu, 10)),
+		Memory:               aws.String(strconv.FormatInt(memory, 10)),
+		DesiredStatus:        aws.String("RUNNING"),
+		LastStatus:           aws.String("RUNNING"),
+		LaunchType:           aws.String(launchType),
+		CreatedAt:            aws.Time(now),
+		StartedAt:            aws.Time(now),
+		Version:              aws.Int64(1),
+		Containers:           containers,
+	}
+}
+
+func (f *AWS) clusterArn(clusterName string) string {
+	return "arn:aws:ecs:" + f.Region + ":" + f.AccountId + ":cluster/" + clusterName
+}
+
+// stopTasksExcept stops the running tasks of the group, except the tasks of the given task definition
+func (f *AWS) stopTasksExcept(clusterName, group, taskDefinitionArn, reason string) {
+	for _, task := range f.ecs.tasks[clusterName] {
+		if aws.StringValue(task.TaskDefinitionArn) == taskDefinitionArn {
+			continue
+		}
+		if aws.StringValue(task.Group) == group && aws.StringValue(task.DesiredStatus) == "RUNNING" {
+			task.DesiredStatus = aws.String("STOPPED")
+			task.LastStatus = aws.String("STOPPED")
+			task.StoppedAt = aws.Time(time.Now())
+			task.StoppedReason = aws.String(reason)
+		}
+	}
+}
+
+// deployService replaces the running tasks of the service with tasks of the current task definition.
+// When tasks can't be started, the new deployment stays in progress next to the previous one, like ECS
+// does when the new tasks never become healthy.
+func (f *AWS) deployService(clusterName string, svc *ecs.Service) {
+	group := "service:" + aws.StringValue(svc.ServiceName)
+	taskDefinition := aws.StringValue(svc.TaskDefinition)
+	now := time.Now()
+	td := f.ecs.findTaskDefinition(taskDefinition)
+	if !f.ecs.startTasks || td == nil {
+		deployments := []*ecs.Deployment{}
+		for _, deployment := range svc.Deployments {
+			if aws.Int64Value(deployment.RunningCount) > 0 && aws.StringValue(deployment.TaskDefinition) == taskDefinition {
+				// the task definition is still running, it becomes the primary deployment again
+				deployment.Status = aws.String("PRIMARY")
+				deployment.UpdatedAt = aws.Time(now)
+				svc.Deployments = []*ecs.Deployment{deployment}
+				f.stopTasksExcept(clusterName, group, taskDefinition, "Task stopped by deployment")
+				svc.RunningCount = deployment.RunningCount
+				return
+			}
+			if aws.Int64Value(deployment.RunningCount) > 0 {
+				deployment.Status = aws.String("ACTIVE")
+				deployments = append(deployments, deployment)
+			}
+		}
+		svc.Deployments = append([]*ecs.Deployment{f.newDeployment(svc, 0)}, deployments...)
+		return
+	}
+	f.stopTasksExcept(clusterName, group, "", "Task stopped by deployment")
+	var running int64
+	for i := int64(0); i < aws.Int64Value(svc.DesiredCount); i++ {
+		task := f.newTask(clusterName, group, "ecs-svc/"+aws.StringValue(svc.ServiceName), td)
+		f.ecs.tasks[clusterName] = append(f.ecs.tasks[clusterName], task)
+		running++
+	}
+	svc.RunningCount = aws.Int64(running)
+	svc.PendingCount = aws.Int64(0)
+	svc.Deployments = []*ecs.Deployment{f.newDeployment(svc, running)}
+	svc.Events = append([]*ecs.ServiceEvent{
+		{
+			Id:        aws.String(f.arn("ecs", "event")),
+			CreatedAt: aws.Time(now),
+			Message:   aws.String("(service " + aws.StringValue(svc.ServiceName) + ") has reached a steady state."),
+		},
+	}, svc.Events...)
+}
+
+func (f *AWS) newDeployment(svc *ecs.Service, running int64) *ecs.Deployment {
+	now := time.Now()
+	return &ecs.Deployment{
+		Id:             aws.String(f.arn("ecs", "deployment")),
+		Status:         aws.String("PRIMARY"),
+		TaskDefinition: aws.String(aws.StringValue(svc.TaskDefinition)),
+		DesiredCount:   aws.Int64(aws.Int64Value(svc.DesiredCount)),
+		RunningCount:   aws.Int64(running),
+		PendingCount:   aws.Int64(0),
+		CreatedAt:      aws.Time(now),
+		UpdatedAt:      aws.Time(now),
+	}
+}
+
+type fakeECS struct {
+	ecsiface.ECSAPI
+	f *AWS
+}
+
+func (e *fakeECS) CreateCluster(input *ecs.CreateClusterInput) (*ecs.CreateClusterOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	clusterName := aws.StringValue(input.ClusterName)
+	if _, ok := e.f.ecs.services[clusterName]; !ok {
+		e.f.ecs.services[clusterName] = make(map[string]*ecs.Service)
+	}
+	return &ecs.CreateClusterOutput{Cluster: &ecs.Cluster{
+		ClusterName: input.ClusterName,
+		ClusterArn:  aws.String(e.f.clusterArn(clusterName)),
+		Status:      aws.String("ACTIVE"),
+	}}, nil
+}
+
+func (e *fakeECS) DeleteCluster(input *ecs.DeleteClusterInput) (*ecs.DeleteClusterOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	clusterName := aws.StringValue(input.Cluster)
+	delete(e.f.ecs.services, clusterName)
+	delete(e.f.ecs.tasks, clusterName)
+	delete(e.f.ecs.containerInstances, clusterName)
+	return &ecs.DeleteClusterOutput{Cluster: &ecs.Cluster{
+		ClusterName: input.Cluster,
+		ClusterArn:  aws.String(e.f.clusterArn(clusterName)),
+		Status:      aws.String("INACTIVE"),
+	}}, nil
+}
+
+func (e *fakeECS) RegisterTaskDefinition(input *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	family := aws.StringValue(input.Family)
+	e.f.ecs.revisions[family]++
+	revision := e.f.ecs.revisions[family]
+	arn := "arn:aws:ecs:" + e.f.Region + ":" + e.f.AccountId + ":task-definition/" + family + ":" + strconv.FormatInt(revision, 10)
+	in := awsutil.CopyOf(input).(*ecs.RegisterTaskDefinitionInput)
+	td := &ecs.TaskDefinition{
+		TaskDefinitionArn:       aws.String(arn),
+		Family:                  in.Family,
+		Revision:                aws.Int64(revision),
+		Status:                  aws.String("ACTIVE"),
+		TaskRoleArn:             in.TaskRoleArn,
+		ExecutionRoleArn:        in.ExecutionRoleArn,
+		NetworkMode:             in.NetworkMode,
+		ContainerDefinitions:    in.ContainerDefinitions,
+		Volumes:                 in.Volumes,
+		PlacementConstraints:    in.PlacementConstraints,
+		RequiresCompatibilities: in.RequiresCompatibilities,
+		Cpu:                     in.Cpu,
+		Memory:                  in.Memory,
+	}
+	e.f.ecs.taskDefinitions[arn] = td
+	return &ecs.RegisterTaskDefinitionOutput{TaskDefinition: awsutil.CopyOf(td).(*ecs.TaskDefinition)}, nil
+}
+
+func (e *fakeECS) DescribeTaskDefinition(input *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	td := e.f.ecs.findTaskDefinition(aws.StringValue(input.TaskDefinition))
+	if td == nil {
+		return nil, awsError(ecs.ErrCodeClientException, "Unable to describe task definition.")
+	}
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: awsutil.CopyOf(td).(*ecs.TaskDefinition)}, nil
+}
+
+func (e *fakeECS) CreateService(input *ecs.CreateServiceInput) (*ecs.CreateServiceOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	clusterName := aws.StringValue(input.Cluster)
+	serviceName := aws.StringValue(input.ServiceName)
+	if e.f.ecs.services[clusterName] == nil {
+		e.f.ecs.services[clusterName] = make(map[string]*ecs.Service)
+	}
+	if svc, ok := e.f.ecs.services[clusterName][serviceName]; ok && aws.StringValue(svc.Status) != "INACTIVE" {
+		return nil, awsError(ecs.ErrCodeInvalidParameterException, "Creation of service was not idempotent.")
+	}
+	td := e.f.ecs.findTaskDefinition(aws.StringValue(input.TaskDefinition))
+	if td == nil {
+		return nil, awsError(ecs.ErrCodeClientException, "TaskDefinition not found.")
+	}
+	in := awsutil.CopyOf(input).(*ecs.CreateServiceInput)
+	svc := &ecs.Service{
+		ServiceName:                   in.ServiceName,
+		ServiceArn:                    aws.String("arn:aws:ecs:" + e.f.Region + ":" + e.f.AccountId + ":service/" + serviceName),
+		ClusterArn:                    aws.String(e.f.clusterArn(clusterName)),
+		Status:                        aws.String("ACTIVE"),
+		TaskDefinition:                aws.String(aws.StringValue(td.TaskDefinitionArn)),
+		DesiredCount:                  aws.Int64(aws.Int64Value(in.DesiredCount)),
+		LaunchType:                    in.LaunchType,
+		PlatformVersion:               in.PlatformVersion,
+		CapacityProviderStrategy:      in.CapacityProviderStrategy,
+		LoadBalancers:                 in.LoadBalancers,
+		NetworkConfiguration:          in.NetworkConfiguration,
+		PlacementStrategy:             in.PlacementStrategy,
+		PlacementConstraints:          in.PlacementConstraints,
+		DeploymentConfiguration:       in.DeploymentConfiguration,
+		HealthCheckGracePeriodSeconds: in.HealthCheckGracePeriodSeconds,
+		RoleArn:                       in.Role,
+		CreatedAt:                     aws.Time(time.Now()),
+	}
+	e.f.deployService(clusterName, svc)
+	e.f.ecs.services[clusterName][serviceName] = svc
+	return &ecs.CreateServiceOutput{Service: awsutil.CopyOf(svc).(*ecs.Service)}, nil
+}
+
+func (e *fakeECS) UpdateService(input *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	clusterName := aws.StringValue(input.Cluster)
+	svc, ok := e.f.ecs.services[clusterName][aws.StringValue(input.Service)]
+	if !ok {
+		return nil, awsError(ecs.ErrCodeServiceNotFoundException, "Service not found.")
+	}
+	if aws.StringValue(svc.Status) != "ACTIVE" {
+		return nil, awsError(ecs.ErrCodeServiceNotActiveException, "Service was not ACTIVE.")
+	}
+	if input.TaskDefinition != nil {
+		td := e.f.ecs.findTaskDefinition(aws.StringValue(input.TaskDefinition))
+		if td == nil {
+			return nil, awsError(ecs.ErrCodeClientException, "TaskDefinition not found.")
+		}
+		svc.TaskDefinition = td.TaskDefinitionArn
+	}
+	if input.DesiredCount != nil {
+		svc.DesiredCount = aws.Int64(aws.Int64Value(input.DesiredCount))
+	}
+	if input.NetworkConfiguration != nil {
+		svc.NetworkConfiguration = awsutil.CopyOf(input.NetworkConfiguration).(*ecs.NetworkConfiguration)
+	}
+	if input.HealthCheckGracePeriodSeconds != nil {
+		svc.HealthCheckGracePeriodSeconds = aws.Int64(aws.Int64Value(input.HealthCheckGracePeriodSeconds))
+	}
+	if input.PlatformVersion != nil {
+		svc.PlatformVersion = aws.String(aws.StringValue(input.PlatformVersion))
+	}
+	in := awsutil.CopyOf(input).(*ecs.UpdateServiceInput)
+	if in.CapacityProviderStrategy != nil {
+		svc.CapacityProviderStrategy = in.CapacityProviderStrategy
+	}
+	if in.PlacementStrategy != nil {
+		svc.PlacementStrategy = in.PlacementStrategy
+	}
+	if in.LoadBalancers != nil {
+		svc.LoadBalancers = in.LoadBalancers
+	}
+	e.f.deployService(clusterName, svc)
+	return &ecs.UpdateServiceOutput{Service: awsutil.CopyOf(svc).(*ecs.Service)}, nil
+}
+
+func (e *fakeECS) DeleteService(input *ecs.DeleteServiceInput) (*ecs.DeleteServiceOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	clusterName := aws.StringValue(input.Cluster)
+	svc, ok := e.f.ecs.services[clusterName][aws.StringValue(input.Service)]
+	if !ok || aws.StringValue(svc.Status) == "INACTIVE" {
+		return nil, awsError(ecs.ErrCodeServiceNotFoundException, "Service not found.")
+	}
+	if aws.Int64Value(svc.DesiredCount) > 0 && !aws.BoolValue(input.Force) {
+		return nil, awsError(ecs.ErrCodeInvalidParameterException, "The service cannot be stopped while it is scaled above 0.")
+	}
+	e.f.stopTasksExcept(clusterName, "service:"+aws.StringValue(svc.ServiceName), "", "Service deleted")
+	svc.Status = aws.String("INACTIVE")
+	svc.RunningCount = aws.Int64(0)
+	svc.Deployments = nil
+	return &ecs.DeleteServiceOutput{Service: awsutil.CopyOf(svc).(*ecs.Service)}, nil
+}
+
+func (e *fakeECS) DescribeServices(input *ecs.DescribeServicesInput) (*ecs.DescribeServicesOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &ecs.DescribeServicesOutput{}
+	for _, name := range input.Services {
+		if svc, ok := e.f.ecs.services[aws.StringValue(input.Cluster)][aws.StringValue(name)]; ok {
+			output.Services = append(output.Services, awsutil.CopyOf(svc).(*ecs.Service))
+		} else {
+			output.Failures = append(output.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+		}
+	}
+	return output, nil
+}
+
+func (e *fakeECS) ListServicesPages(input *ecs.ListServicesInput, fn func(*ecs.ListServicesOutput, bool) bool) error {
+	e.f.mu.Lock()
+	output := &ecs.ListServicesOutput{}
+	for _, svc := range e.f.ecs.services[aws.StringValue(input.Cluster)] {
+		if aws.StringValue(svc.Status) == "ACTIVE" {
+			output.ServiceArns = append(output.ServiceArns, aws.String(aws.StringValue(svc.ServiceArn)))
+		}
+	}
+	e.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+// the fake applies changes immediately, so the services are always stable
+// the services are updated synchronously, so waiting is not necessary. Like the real waiter, an error is
+// returned when a service has a deployment in progress or isn't running the desired amount of tasks
+func (e *fakeECS) WaitUntilServicesStable(input *ecs.DescribeServicesInput) error {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	for _, name := range input.Services {
+		svc, ok := e.f.ecs.services[aws.StringValue(input.Cluster)][aws.StringValue(name)]
+		if !ok {
+			return awsError(request.WaiterResourceNotReadyErrorCode, "failed waiting for successful resource state")
+		}
+		if len(svc.Deployments) != 1 || aws.Int64Value(svc.RunningCount) != aws.Int64Value(svc.DesiredCount) {
+			return awsError(request.WaiterResourceNotReadyErrorCode, "exceeded wait attempts")
+		}
+	}
+	return nil
+}
+func (e *fakeECS) WaitUntilServicesStableWithContext(ctx aws.Context, input *ecs.DescribeServicesInput, opts ...request.WaiterOption) error {
+	return e.WaitUntilServicesStable(input)
+}
+func (e *fakeECS) WaitUntilServicesInactive(input *ecs.DescribeServicesInput) error {
+	return nil
+}
+
+func (e *fakeECS) RunTask(input *ecs.RunTaskInput) (*ecs.RunTaskOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	clusterName := aws.StringValue(input.Cluster)
+	td := e.f.ecs.findTaskDefinition(aws.StringValue(input.TaskDefinition))
+	if td == nil {
+		return nil, awsError(ecs.ErrCodeClientException, "TaskDefinition not found.")
+	}
+	group := aws.StringValue(input.Group)
+	if group == "" {
+		group = "family:" + aws.StringValue(td.Family)
+	}
+	count := aws.Int64Value(input.Count)
+	if count == 0 {
+		count = 1
+	}
+	output := &ecs.RunTaskOutput{}
+	for i := int64(0); i < count; i++ {
+		task := e.f.newTask(clusterName, group, aws.StringValue(input.StartedBy), td)
+		e.f.ecs.tasks[clusterName] = append(e.f.ecs.tasks[clusterName], task)
+		output.Tasks = append(output.Tasks, awsutil.CopyOf(task).(*ecs.Task))
+	}
+	return output, nil
+}
+
+func (e *fakeECS) ListTasksPages(input *ecs.ListTasksInput, fn func(*ecs.ListTasksOutput, bool) bool) error {
+	e.f.mu.Lock()
+	desiredStatus := aws.StringValue(input.DesiredStatus)
+	if desiredStatus == "" {
+		desiredStatus = "RUNNING"
+	}
+	output := &ecs.ListTasksOutput{}
+	for _, task := range e.f.ecs.tasks[aws.StringValue(input.Cluster)] {
+		if aws.StringValue(task.DesiredStatus) != desiredStatus {
+			continue
+		}
+		if input.ServiceName != nil && aws.StringValue(task.Group) != "service:"+aws.StringValue(input.ServiceName) {
+			continue
+		}
+		if input.Family != nil {
+			td := e.f.ecs.taskDefinitions[aws.StringValue(task.TaskDefinitionArn)]
+			if td == nil || aws.StringValue(td.Family) != aws.StringValue(input.Family) {
+				continue
+			}
+		}
+		output.TaskArns = append(output.TaskArns, aws.String(aws.StringValue(task.TaskArn)))
+	}
+	e.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (e *fakeECS) DescribeTasks(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &ecs.DescribeTasksOutput{}
+	for _, taskArn := range input.Tasks {
+		found := false
+		for _, task := range e.f.ecs.tasks[aws.StringValue(input.Cluster)] {
+			if aws.StringValue(task.TaskArn) == aws.StringValue(taskArn) {
+				output.Tasks = append(output.Tasks, awsutil.CopyOf(task).(*ecs.Task))
+				found = true
+			}
+		}
+		if !found {
+			output.Failures = append(output.Failures, &ecs.Failure{Arn: taskArn, Reason: aws.String("MISSING")})
+		}
+	}
+	return output, nil
+}
+
+func (e *fakeECS) ListContainerInstancesPages(input *ecs.ListContainerInstancesInput, fn func(*ecs.ListContainerInstancesOutput, bool) bool) error {
+	e.f.mu.Lock()
+	output := &ecs.ListContainerInstancesOutput{}
+	for _, ci := range e.f.ecs.containerInstances[aws.StringValue(input.Cluster)] {
+		output.ContainerInstanceArns = append(output.ContainerInstanceArns, aws.String(aws.StringValue(ci.ContainerInstanceArn)))
+	}
+	e.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+func (e *fakeECS) DescribeContainerInstances(input *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &ecs.DescribeContainerInstancesOutput{}
+	for _, arn := range input.ContainerInstances {
+		for _, ci := range e.f.ecs.containerInstances[aws.StringValue(input.Cluster)] {
+			if aws.StringValue(ci.ContainerInstanceArn) == aws.StringValue(arn) || aws.StringValue(ci.Ec2InstanceId) == aws.StringValue(arn) {
+				output.ContainerInstances = append(output.ContainerInstances, awsutil.CopyOf(ci).(*ecs.ContainerInstance))
+			}
+		}
+	}
+	return output, nil
+}
+
+func (e *fakeECS) UpdateContainerInstancesState(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &ecs.UpdateContainerInstancesStateOutput{}
+	for _, arn := range input.ContainerInstances {
+		for _, ci := range e.f.ecs.containerInstances[aws.StringValue(input.Cluster)] {
+			if aws.StringValue(ci.ContainerInstanceArn) == aws.StringValue(arn) {
+				ci.Status = aws.String(aws.StringValue(input.Status))
+				output.ContainerInstances = append(output.ContainerInstances, awsutil.CopyOf(ci).(*ecs.ContainerInstance))
+			}
+		}
+	}
+	return output, nil
+}
@@ -0,0 +1,676 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"strconv"
+	"strings"
+)
+
+type elbState struct {
+	loadBalancers         []*elbv2.LoadBalancer
+	listeners             []*elbv2.Listener
+	targetGroups          []*elbv2.TargetGroup
+	targetGroupAttributes map[string]map[string]string // target group arn
+	rules                 map[string][]*elbv2.Rule     // listener arn
+	tags                  map[string][]*elbv2.Tag      // resource arn
+}
+
+func (s *elbState) init() {
+	s.targetGroupAttributes = make(map[string]map[string]string)
+	s.rules = make(map[string][]*elbv2.Rule)
+	s.tags = make(map[string][]*elbv2.Tag)
+}
+
+// AddLoadBalancer creates an application loadbalancer and returns its arn
+func (f *AWS) AddLoadBalancer(loadBalancerName, vpcId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return aws.StringValue(f.addLoadBalancer(loadBalancerName, vpcId, "application").LoadBalancerArn)
+}
+
+// AddNetworkLoadBalancer creates a network loadbalancer and returns its arn
+func (f *AWS) AddNetworkLoadBalancer(loadBalancerName, vpcId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return aws.StringValue(f.addLoadBalancer(loadBalancerName, vpcId, "network").LoadBalancerArn)
+}
+
+// GetListeners returns a copy of the listeners of a loadbalancer
+func (f *AWS) GetListeners(loadBalancerArn string) []*elbv2.Listener {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var listeners []*elbv2.Listener
+	for _, l := range f.elb.listeners {
+		if aws.StringValue(l.LoadBalancerArn) == loadBalancerArn {
+			listeners = append(listeners, awsutil.CopyOf(l).(*elbv2.Listener))
+		}
+	}
+	return listeners
+}
+
+// AddListener creates a listener with a default rule forwarding to a new target group and returns the listener arn
+func (f *AWS) AddListener(loadBalancerArn, protocol string, port int64) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tg := &elbv2.TargetGroup{
+		TargetGroupArn:  aws.String(f.arn("elasticloadbalancing", "targetgroup/default-"+protocol)),
+		TargetGroupName: aws.String("default-" + protocol + "-" + strconv.FormatInt(f.counter, 10)),
+		Protocol:        aws.String(protocol),
+		Port:            aws.Int64(port),
+	}
+	f.elb.targetGroups = append(f.elb.targetGroups, tg)
+	return f.addListener(loadBalancerArn, protocol, port, aws.StringValue(tg.TargetGroupArn))
+}
+
+// GetTargetGroup returns a copy of the target group, or nil when the target group doesn't exist
+func (f *AWS) GetTargetGroup(name string) *elbv2.TargetGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tg := range f.elb.targetGroups {
+		if aws.StringValue(tg.TargetGroupName) == name {
+			return awsutil.CopyOf(tg).(*elbv2.TargetGroup)
+		}
+	}
+	return nil
+}
+
+// GetTargetGroupAttributes returns the attributes that were set on the target group
+func (f *AWS) GetTargetGroupAttributes(targetGroupArn string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attributes := make(map[string]string)
+	for k, v := range f.elb.targetGroupAttributes[targetGroupArn] {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+// GetRules returns a copy of the rules of a listener
+func (f *AWS) GetRules(listenerArn string) []*elbv2.Rule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rules []*elbv2.Rule
+	for _, r := range f.elb.rules[listenerArn] {
+		rules = append(rules, awsutil.CopyOf(r).(*elbv2.Rule))
+	}
+	return rules
+}
+
+func (f *AWS) addLoadBalancer(loadBalancerName, vpcId, lbType string) *elbv2.LoadBalancer {
+	prefix := "app/"
+	if lbType == "network" {
+		prefix = "net/"
+	}
+	lb := &elbv2.LoadBalancer{
+		LoadBalancerArn:  aws.String(f.arn("elasticloadbalancing", "loadbalancer/"+prefix+loadBalancerName)),
+		LoadBalancerName: aws.String(loadBalancerName),
+		DNSName:          aws.String(loadBalancerName + "." + f.Region + ".elb.amazonaws.com"),
+		VpcId:            aws.String(vpcId),
+		Type:             aws.String(lbType),
+		Scheme:           aws.String("internet-facing"),
+		State:            &elbv2.LoadBalancerState{Code: aws.String("active")},
+	}
+	f.elb.loadBalancers = append(f.elb.loadBalancers, lb)
+	return lb
+}
+
+func (f *AWS) addListener(loadBalancerArn, protocol string, port int64, targetGroupArn string) string {
+	listener := &elbv2.Listener{
+		ListenerArn:     aws.String(f.arn("elasticloadbalancing", "listener")),
+		LoadBalancerArn: aws.String(loadBalancerArn),
+		Protocol:        aws.String(protocol),
+		Port:            aws.Int64(port),
+		DefaultActions: []*elbv2.Action{
+			{Type: aws.String("forward"), TargetGroupArn: aws.String(targetGroupArn)},
+		},
+	}
+	f.elb.listeners = append(f.elb.listeners, listener)
+	listenerArn := aws.StringValue(listener.ListenerArn)
+	f.elb.rules[listenerArn] = []*elbv2.Rule{
+		{
+			RuleArn:   aws.String(f.arn("elasticloadbalancing", "listener-rule")),
+			Priority:  aws.String("default"),
+			IsDefault: aws.Bool(true),
+			Actions: []*elbv2.Action{
+				{Type: aws.String("forward"), TargetGroupArn: aws.String(targetGroupArn)},
+			},
+		},
+	}
+	f.associateTargetGroup(targetGroupArn, loadBalancerArn)
+	return listenerArn
+}
+
+func (f *AWS) findListener(listenerArn string) *elbv2.Listener {
+	for _, l := range f.elb.listeners {
+		if aws.StringValue(l.ListenerArn) == listenerArn {
+			return l
+		}
+	}
+	return nil
+}
+
+func (f *AWS) findTargetGroup(targetGroupArn string) *elbv2.TargetGroup {
+	for _, tg := range f.elb.targetGroups {
+		if aws.StringValue(tg.TargetGroupArn) == targetGroupArn {
+			return tg
+		}
+	}
+	return nil
+}
+
+func (f *AWS) associateTargetGroup(targetGroupArn, loadBalancerArn string) {
+	tg := f.findTargetGroup(targetGroupArn)
+	if tg == nil {
+		return
+	}
+	for _, arn := range tg.LoadBalancerArns {
+		if aws.StringValue(arn) == loadBalancerArn {
+			return
+		}
+	}
+	tg.LoadBalancerArns = append(tg.LoadBalancerArns, aws.String(loadBalancerArn))
+}
+
+// targetGroupInUse returns true when a listener or rule still forwards to the target group
+func (f *AWS) targetGroupInUse(targetGroupArn string) bool {
+	for _, rules := range f.elb.rules {
+		for _, r := range rules {
+			for _, a := range r.Actions {
+				if aws.StringValue(a.TargetGroupArn) == targetGroupArn {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type fakeELBV2 struct {
+	elbv2iface.ELBV2API
+	f *AWS
+}
+
+func (e *fakeELBV2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &elbv2.DescribeLoadBalancersOutput{}
+	for _, lb := range e.f.elb.loadBalancers {
+		match := len(input.Names) == 0 && len(input.LoadBalancerArns) == 0
+		for _, name := range input.Names {
+			if aws.StringValue(name) == aws.StringValue(lb.LoadBalancerName) {
+				match = true
+			}
+		}
+		for _, arn := range input.LoadBalancerArns {
+			if aws.StringValue(arn) == aws.StringValue(lb.LoadBalancerArn) {
+				match = true
+			}
+		}
+		if match {
+			output.LoadBalancers = append(output.LoadBalancers, awsutil.CopyOf(lb).(*elbv2.LoadBalancer))
+		}
+	}
+	if len(output.LoadBalancers) < len(input.Names)+len(input.LoadBalancerArns) {
+		return nil, awsError(elbv2.ErrCodeLoadBalancerNotFoundException, "One or more load balancers not found")
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) CreateLoadBalancer(input *elbv2.CreateLoadBalancerInput) (*elbv2.CreateLoadBalancerOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	for _, lb := range e.f.elb.loadBalancers {
+		if aws.StringValue(lb.LoadBalancerName) == aws.StringValue(input.Name) {
+			return nil, awsError(elbv2.ErrCodeDuplicateLoadBalancerNameException, "A load balancer with the same name already exists")
+		}
+	}
+	lbType := aws.StringValue(input.Type)
+	if lbType == "" {
+		lbType = "application"
+	}
+	lb := e.f.addLoadBalancer(aws.StringValue(input.Name), "vpc-"+strconv.FormatInt(e.f.counter, 10), lbType)
+	lb.SecurityGroups = aws.StringSlice(aws.StringValueSlice(input.SecurityGroups))
+	if input.Scheme != nil {
+		lb.Scheme = aws.String(aws.StringValue(input.Scheme))
+	}
+	if input.IpAddressType != nil {
+		lb.IpAddressType = aws.String(aws.StringValue(input.IpAddressType))
+	}
+	return &elbv2.CreateLoadBalancerOutput{LoadBalancers: []*elbv2.LoadBalancer{awsutil.CopyOf(lb).(*elbv2.LoadBalancer)}}, nil
+}
+
+func (e *fakeELBV2) DeleteLoadBalancer(input *elbv2.DeleteLoadBalancerInput) (*elbv2.DeleteLoadBalancerOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	var lbs []*elbv2.LoadBalancer
+	for _, lb := range e.f.elb.loadBalancers {
+		if aws.StringValue(lb.LoadBalancerArn) != aws.StringValue(input.LoadBalancerArn) {
+			lbs = append(lbs, lb)
+		}
+	}
+	e.f.elb.loadBalancers = lbs
+	var listeners []*elbv2.Listener
+	for _, l := range e.f.elb.listeners {
+		if aws.StringValue(l.LoadBalancerArn) == aws.StringValue(input.LoadBalancerArn) {
+			delete(e.f.elb.rules, aws.StringValue(l.ListenerArn))
+		} else {
+			listeners = append(listeners, l)
+		}
+	}
+	e.f.elb.listeners = listeners
+	return &elbv2.DeleteLoadBalancerOutput{}, nil
+}
+
+func (e *fakeELBV2) DescribeListeners(input *elbv2.DescribeListenersInput) (*elbv2.DescribeListenersOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &elbv2.DescribeListenersOutput{}
+	for _, l := range e.f.elb.listeners {
+		match := aws.StringValue(l.LoadBalancerArn) == aws.StringValue(input.LoadBalancerArn)
+		for _, arn := range input.ListenerArns {
+			if aws.StringValue(arn) == aws.StringValue(l.ListenerArn) {
+				match = true
+			}
+		}
+		if match {
+			output.Listeners = append(output.Listeners, awsutil.CopyOf(l).(*elbv2.Listener))
+		}
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) CreateListener(input *elbv2.CreateListenerInput) (*elbv2.CreateListenerOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	for _, l := range e.f.elb.listeners {
+		if aws.StringValue(l.LoadBalancerArn) == aws.StringValue(input.LoadBalancerArn) && aws.Int64Value(l.Port) == aws.Int64Value(input.Port) {
+			return nil, awsError(elbv2.ErrCodeDuplicateListenerException, "A listener already exists on this port for this load balancer")
+		}
+	}
+	var targetGroupArn string
+	if len(input.DefaultActions) > 0 {
+		targetGroupArn = aws.StringValue(input.DefaultActions[0].TargetGroupArn)
+	}
+	listenerArn := e.f.addListener(aws.StringValue(input.LoadBalancerArn), aws.StringValue(input.Protocol), aws.Int64Value(input.Port), targetGroupArn)
+	listener := e.f.findListener(listenerArn)
+	for _, c := range input.Certificates {
+		listener.Certificates = append(listener.Certificates, awsutil.CopyOf(c).(*elbv2.Certificate))
+	}
+	return &elbv2.CreateListenerOutput{Listeners: []*elbv2.Listener{awsutil.CopyOf(listener).(*elbv2.Listener)}}, nil
+}
+
+func (e *fakeELBV2) DeleteListener(input *elbv2.DeleteListenerInput) (*elbv2.DeleteListenerOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if e.f.findListener(aws.StringValue(input.ListenerArn)) == nil {
+		return nil, awsError(elbv2.ErrCodeListenerNotFoundException, "Listener not found")
+	}
+	var listeners []*elbv2.Listener
+	for _, l := range e.f.elb.listeners {
+		if aws.StringValue(l.ListenerArn) != aws.StringValue(input.ListenerArn) {
+			listeners = append(listeners, l)
+		}
+	}
+	e.f.elb.listeners = listeners
+	delete(e.f.elb.rules, aws.StringValue(input.ListenerArn))
+	return &elbv2.DeleteListenerOutput{}, nil
+}
+
+func (e *fakeELBV2) DescribeTargetGroups(input *elbv2.DescribeTargetGroupsInput) (*elbv2.DescribeTargetGroupsOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &elbv2.DescribeTargetGroupsOutput{}
+	for _, tg := range e.f.elb.targetGroups {
+		match := len(input.Names) == 0 && len(input.TargetGroupArns) == 0 && input.LoadBalancerArn == nil
+		for _, name := range input.Names {
+			if aws.StringValue(name) == aws.StringValue(tg.TargetGroupName) {
+				match = true
+			}
+		}
+		for _, arn := range input.TargetGroupArns {
+			if aws.StringValue(arn) == aws.StringValue(tg.TargetGroupArn) {
+				match = true
+			}
+		}
+		for _, arn := range tg.LoadBalancerArns {
+			if input.LoadBalancerArn != nil && aws.StringValue(arn) == aws.StringValue(input.LoadBalancerArn) {
+				match = true
+			}
+		}
+		if match {
+			output.TargetGroups = append(output.TargetGroups, awsutil.CopyOf(tg).(*elbv2.TargetGroup))
+		}
+	}
+	if len(output.TargetGroups) < len(input.Names)+len(input.TargetGroupArns) {
+		return nil, awsError(elbv2.ErrCodeTargetGroupNotFoundException, "One or more target groups not found")
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) CreateTargetGroup(input *elbv2.CreateTargetGroupInput) (*elbv2.CreateTargetGroupOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	for _, tg := range e.f.elb.targetGroups {
+		if aws.StringValue(tg.TargetGroupName) == aws.StringValue(input.Name) {
+			return nil, awsError(elbv2.ErrCodeDuplicateTargetGroupNameException, "A target group with the same name '"+aws.StringValue(input.Name)+"' exists")
+		}
+	}
+	in := awsutil.CopyOf(input).(*elbv2.CreateTargetGroupInput)
+	tg := &elbv2.TargetGroup{
+		TargetGroupArn:             aws.String(e.f.arn("elasticloadbalancing", "targetgroup/"+aws.StringValue(in.Name))),
+		TargetGroupName:            in.Name,
+		VpcId:                      in.VpcId,
+		Port:                       in.Port,
+		Protocol:                   in.Protocol,
+		TargetType:                 in.TargetType,
+		HealthCheckPath:            in.HealthCheckPath,
+		HealthCheckPort:            in.HealthCheckPort,
+		HealthCheckProtocol:        in.HealthCheckProtocol,
+		HealthCheckIntervalSeconds: in.HealthCheckIntervalSeconds,
+		HealthCheckTimeoutSeconds:  in.HealthCheckTimeoutSeconds,
+		HealthyThresholdCount:      in.HealthyThresholdCount,
+		UnhealthyThresholdCount:    in.UnhealthyThresholdCount,
+		Matcher:                    in.Matcher,
+	}
+	e.f.elb.targetGroups = append(e.f.elb.targetGroups, tg)
+	return &elbv2.CreateTargetGroupOutput{TargetGroups: []*elbv2.TargetGroup{awsutil.CopyOf(tg).(*elbv2.TargetGroup)}}, nil
+}
+
+func (e *fakeELBV2) DeleteTargetGroup(input *elbv2.DeleteTargetGroupInput) (*elbv2.DeleteTargetGroupOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	targetGroupArn := aws.StringValue(input.TargetGroupArn)
+	if e.f.targetGroupInUse(targetGroupArn) {
+		return nil, awsError(elbv2.ErrCodeResourceInUseException, "Target group '"+targetGroupArn+"' is currently in use by a listener or a rule")
+	}
+	var tgs []*elbv2.TargetGroup
+	for _, tg := range e.f.elb.targetGroups {
+		if aws.StringValue(tg.TargetGroupArn) != targetGroupArn {
+			tgs = append(tgs, tg)
+		}
+	}
+	e.f.elb.targetGroups = tgs
+	delete(e.f.elb.targetGroupAttributes, targetGroupArn)
+	return &elbv2.DeleteTargetGroupOutput{}, nil
+}
+
+func (e *fakeELBV2) ModifyTargetGroup(input *elbv2.ModifyTargetGroupInput) (*elbv2.ModifyTargetGroupOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	tg := e.f.findTargetGroup(aws.StringValue(input.TargetGroupArn))
+	if tg == nil {
+		return nil, awsError(elbv2.ErrCodeTargetGroupNotFoundException, "Target group not found")
+	}
+	in := awsutil.CopyOf(input).(*elbv2.ModifyTargetGroupInput)
+	if in.HealthCheckPath != nil {
+		tg.HealthCheckPath = in.HealthCheckPath
+	}
+	if in.HealthCheckPort != nil {
+		tg.HealthCheckPort = in.HealthCheckPort
+	}
+	if in.HealthCheckProtocol != nil {
+		tg.HealthCheckProtocol = in.HealthCheckProtocol
+	}
+	if in.HealthCheckIntervalSeconds != nil {
+		tg.HealthCheckIntervalSeconds = in.HealthCheckIntervalSeconds
+	}
+	if in.HealthCheckTimeoutSeconds != nil {
+		tg.HealthCheckTimeoutSeconds = in.HealthCheckTimeoutSeconds
+	}
+	if in.HealthyThresholdCount != nil {
+		tg.HealthyThresholdCount = in.HealthyThresholdCount
+	}
+	if in.UnhealthyThresholdCount != nil {
+		tg.UnhealthyThresholdCount = in.UnhealthyThresholdCount
+	}
+	if in.Matcher != nil {
+		tg.Matcher = in.Matcher
+	}
+	return &elbv2.ModifyTargetGroupOutput{TargetGroups: []*elbv2.TargetGroup{awsutil.CopyOf(tg).(*elbv2.TargetGroup)}}, nil
+}
+
+func (e *fakeELBV2) ModifyTargetGroupAttributes(input *elbv2.ModifyTargetGroupAttributesInput) (*elbv2.ModifyTargetGroupAttributesOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	targetGroupArn := aws.StringValue(input.TargetGroupArn)
+	if e.f.findTargetGroup(targetGroupArn) == nil {
+		return nil, awsError(elbv2.ErrCodeTargetGroupNotFoundException, "Target group not found")
+	}
+	if e.f.elb.targetGroupAttributes[targetGroupArn] == nil {
+		e.f.elb.targetGroupAttributes[targetGroupArn] = make(map[string]string)
+	}
+	output := &elbv2.ModifyTargetGroupAttributesOutput{}
+	for _, attr := range input.Attributes {
+		e.f.elb.targetGroupAttributes[targetGroupArn][aws.StringValue(attr.Key)] = aws.StringValue(attr.Value)
+	}
+	for k, v := range e.f.elb.targetGroupAttributes[targetGroupArn] {
+		output.Attributes = append(output.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) DescribeTargetGroupAttributes(input *elbv2.DescribeTargetGroupAttributesInput) (*elbv2.DescribeTargetGroupAttributesOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	targetGroupArn := aws.StringValue(input.TargetGroupArn)
+	if e.f.findTargetGroup(targetGroupArn) == nil {
+		return nil, awsError(elbv2.ErrCodeTargetGroupNotFoundException, "Target group not found")
+	}
+	output := &elbv2.DescribeTargetGroupAttributesOutput{}
+	for k, v := range e.f.elb.targetGroupAttributes[targetGroupArn] {
+		output.Attributes = append(output.Attributes, &elbv2.TargetGroupAttribute{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) DescribeRules(input *elbv2.DescribeRulesInput) (*elbv2.DescribeRulesOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	output := &elbv2.DescribeRulesOutput{}
+	if input.ListenerArn != nil {
+		rules, ok := e.f.elb.rules[aws.StringValue(input.ListenerArn)]
+		if !ok {
+			return nil, awsError(elbv2.ErrCodeListenerNotFoundException, "Listener not found")
+		}
+		for _, r := range rules {
+			output.Rules = append(output.Rules, awsutil.CopyOf(r).(*elbv2.Rule))
+		}
+		return output, nil
+	}
+	for _, ruleArn := range input.RuleArns {
+		found := false
+		for _, rules := range e.f.elb.rules {
+			for _, r := range rules {
+				if aws.StringValue(r.RuleArn) == aws.StringValue(ruleArn) {
+					output.Rules = append(output.Rules, awsutil.CopyOf(r).(*elbv2.Rule))
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, awsError(elbv2.ErrCodeRuleNotFoundException, "One or more rules not found")
+		}
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) CreateRule(input *elbv2.CreateRuleInput) (*elbv2.CreateRuleOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if err := e.f.injectedError("CreateRule"); err != nil {
+		return nil, err
+	}
+	listenerArn := aws.StringValue(input.ListenerArn)
+	listener := e.f.findListener(listenerArn)
+	if listener == nil {
+		return nil, awsError(elbv2.ErrCodeListenerNotFoundException, "Listener not found")
+	}
+	priority := strconv.FormatInt(aws.Int64Value(input.Priority), 10)
+	for _, r := range e.f.elb.rules[listenerArn] {
+		if aws.StringValue(r.Priority) == priority {
+			return nil, awsError(elbv2.ErrCodePriorityInUseException, "Priority '"+priority+"' is currently in use")
+		}
+	}
+	for _, a := range input.Actions {
+		if a.TargetGroupArn != nil {
+			if e.f.findTargetGroup(aws.StringValue(a.TargetGroupArn)) == nil {
+				return nil, awsError(elbv2.ErrCodeTargetGroupNotFoundException, "Target group not found")
+			}
+			e.f.associateTargetGroup(aws.StringValue(a.TargetGroupArn), aws.StringValue(listener.LoadBalancerArn))
+		}
+	}
+	in := awsutil.CopyOf(input).(*elbv2.CreateRuleInput)
+	rule := &elbv2.Rule{
+		RuleArn:    aws.String(e.f.arn("elasticloadbalancing", "listener-rule")),
+		Priority:   aws.String(priority),
+		IsDefault:  aws.Bool(false),
+		Actions:    in.Actions,
+		Conditions: in.Conditions,
+	}
+	e.f.elb.rules[listenerArn] = append(e.f.elb.rules[listenerArn], rule)
+	if len(in.Tags) > 0 {
+		e.f.elb.tags[aws.StringValue(rule.RuleArn)] = in.Tags
+	}
+	return &elbv2.CreateRuleOutput{Rules: []*elbv2.Rule{awsutil.CopyOf(rule).(*elbv2.Rule)}}, nil
+}
+
+func (e *fakeELBV2) ModifyRule(input *elbv2.ModifyRuleInput) (*elbv2.ModifyRuleOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if err := e.f.injectedError("ModifyRule"); err != nil {
+		return nil, err
+	}
+	in := awsutil.CopyOf(input).(*elbv2.ModifyRuleInput)
+	for _, rules := range e.f.elb.rules {
+		for _, r := range rules {
+			if aws.StringValue(r.RuleArn) == aws.StringValue(in.RuleArn) {
+				if in.Actions != nil {
+					r.Actions = in.Actions
+				}
+				if in.Conditions != nil {
+					r.Conditions = in.Conditions
+				}
+				return &elbv2.ModifyRuleOutput{Rules: []*elbv2.Rule{awsutil.CopyOf(r).(*elbv2.Rule)}}, nil
+			}
+		}
+	}
+	return nil, awsError(elbv2.ErrCodeRuleNotFoundException, "Rule not found")
+}
+
+// SetRulePriorities moves all rules at once, the new priorities can't be in use by rules that don't move
+func (e *fakeELBV2) SetRulePriorities(input *elbv2.SetRulePrioritiesInput) (*elbv2.SetRulePrioritiesOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	priorities := make(map[string]string)
+	for _, p := range input.RulePriorities {
+		priorities[aws.StringValue(p.RuleArn)] = strconv.FormatInt(aws.Int64Value(p.Priority), 10)
+	}
+	var moved []*elbv2.Rule
+	for listenerArn, rules := range e.f.elb.rules {
+		inUse := make(map[string]bool)
+		for _, r := range rules {
+			priority, ok := priorities[aws.StringValue(r.RuleArn)]
+			if !ok {
+				priority = aws.StringValue(r.Priority)
+			}
+			if inUse[priority] {
+				return nil, awsError(elbv2.ErrCodePriorityInUseException, "Priority '"+priority+"' is currently in use on listener "+listenerArn)
+			}
+			inUse[priority] = true
+			if ok {
+				moved = append(moved, r)
+			}
+		}
+	}
+	if len(moved) != len(priorities) {
+		return nil, awsError(elbv2.ErrCodeRuleNotFoundException, "One or more rules not found")
+	}
+	output := &elbv2.SetRulePrioritiesOutput{}
+	for _, r := range moved {
+		r.Priority = aws.String(priorities[aws.StringValue(r.RuleArn)])
+		output.Rules = append(output.Rules, awsutil.CopyOf(r).(*elbv2.Rule))
+	}
+	return output, nil
+}
+
+func (e *fakeELBV2) DeleteRule(input *elbv2.DeleteRuleInput) (*elbv2.DeleteRuleOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if err := e.f.injectedError("DeleteRule"); err != nil {
+		return nil, err
+	}
+	for listenerArn, rules := range e.f.elb.rules {
+		for i, r := range rules {
+			if aws.StringValue(r.RuleArn) == aws.StringValue(input.RuleArn) {
+				if aws.BoolValue(r.IsDefault) {
+					return nil, awsError(elbv2.ErrCodeOperationNotPermittedException, "Default rule cannot be deleted")
+				}
+				e.f.elb.rules[listenerArn] = append(rules[:i:i], rules[i+1:]...)
+				delete(e.f.elb.tags, aws.StringValue(input.RuleArn))
+				return &elbv2.DeleteRuleOutput{}, nil
+			}
+		}
+	}
+	return nil, awsError(elbv2.ErrCodeRuleNotFoundException, "Rule not found")
+}
+
+func (e *fakeELBV2) DescribeTags(input *elbv2.DescribeTagsInput) (*elbv2.DescribeTagsOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if len(input.ResourceArns) > 20 {
+		return nil, awsError("ValidationError", "Up to 20 resource arns can be described at once")
+	}
+	output := &elbv2.DescribeTagsOutput{}
+	for _, arn := range input.ResourceArns {
+		description := &elbv2.TagDescription{ResourceArn: aws.String(aws.StringValue(arn))}
+		for _, tag := range e.f.elb.tags[aws.StringValue(arn)] {
+			description.Tags = append(description.Tags, awsutil.CopyOf(tag).(*elbv2.Tag))
+		}
+		output.TagDescriptions = append(output.TagDescriptions, description)
+	}
+	return output, nil
+}
+
+// DescribeTargetHealth returns the running tasks of the ecs services attached to the target group as healthy targets
+func (e *fakeELBV2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	e.f.mu.Lock()
+	defer e.f.mu.Unlock()
+	if err := e.f.injectedError("DescribeTargetHealth"); err != nil {
+		return nil, err
+	}
+	targetGroupArn := aws.StringValue(input.TargetGroupArn)
+	found := false
+	for _, tg := range e.f.elb.targetGroups {
+		if aws.StringValue(tg.TargetGroupArn) == targetGroupArn {
+			found = true
+		}
+	}
+	if !found {
+		return nil, awsError(elbv2.ErrCodeTargetGroupNotFoundException, "One or more target groups not found")
+	}
+	output := &elbv2.DescribeTargetHealthOutput{}
+	for clusterName, services := range e.f.ecs.services {
+		for _, svc := range services {
+			for _, lb := range svc.LoadBalancers {
+				if aws.StringValue(lb.TargetGroupArn) != targetGroupArn {
+					continue
+				}
+				for _, task := range e.f.ecs.tasks[clusterName] {
+					if aws.StringValue(task.Group) != "service:"+aws.StringValue(svc.ServiceName) || aws.StringValue(task.LastStatus) != "RUNNING" {
+						continue
+					}
+					taskArn := aws.StringValue(task.TaskArn)
+					output.TargetHealthDescriptions = append(output.TargetHealthDescriptions, &elbv2.TargetHealthDescription{
+						Target:       &elbv2.TargetDescription{Id: aws.String(taskArn[strings.LastIndex(taskArn, "/")+1:]), Port: lb.ContainerPort},
+						TargetHealth: &elbv2.TargetHealth{State: aws.String("healthy")},
+					})
+				}
+			}
+		}
+	}
+	return output, nil
+}
@@ -0,0 +1,124 @@
+// Package fake contains an in-memory implementation of the AWS APIs used by provider/ecs
+//
+// The fake can be passed as Clients to the provider structs, or set for all of them with
+// ecs.SetDefaultClients(fake.New()). Only the calls that ecs-deploy makes are modelled,
+// calling any other SDK method panics.
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"fmt"
+	"sync"
+)
+
+// AWS holds the state of the fake AWS account
+type AWS struct {
+	Region    string
+	AccountId string
+
+	mu      sync.Mutex
+	counter int64
+	errors  map[string]error
+
+	ecs         ecsState
+	elb         elbState
+	iam         iamState
+	ssm         ssmState
+	cloudwatch  cloudwatchState
+	autoscaling autoscalingState
+	ecr         ecrState
+	acm         acmState
+}
+
+// New returns an empty fake AWS account
+func New() *AWS {
+	f := &AWS{Region: "us-east-1", AccountId: "123456789012"}
+	f.ecs.init()
+	f.elb.init()
+	f.iam.init()
+	f.ssm.init()
+	f.cloudwatch.init()
+	f.autoscaling.init()
+	f.ecr.init()
+	f.acm.init()
+	return f
+}
+
+// arn returns a new unique arn for the given service and resource
+func (f *AWS) arn(service, resource string) string {
+	f.counter++
+	return fmt.Sprintf("arn:aws:%s:%s:%s:%s/%d", service, f.Region, f.AccountId, resource, f.counter)
+}
+
+// SetError makes every call of the method (e.g. "CreateRule") return the error, a nil error removes it
+func (f *AWS) SetError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.errors == nil {
+		f.errors = make(map[string]error)
+	}
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	f.errors[method] = err
+}
+
+// injectedError returns the error set with SetError, the lock needs to be held
+func (f *AWS) injectedError(method string) error {
+	return f.errors[method]
+}
+
+func awsError(code, message string) error {
+	return awserr.New(code, message, nil)
+}
+
+func (f *AWS) ECS() ecsiface.ECSAPI {
+	return &fakeECS{f: f}
+}
+func (f *AWS) EC2() ec2iface.EC2API {
+	return &fakeEC2{f: f}
+}
+func (f *AWS) ELBV2() elbv2iface.ELBV2API {
+	return &fakeELBV2{f: f}
+}
+func (f *AWS) ACM() acmiface.ACMAPI {
+	return &fakeACM{f: f}
+}
+func (f *AWS) IAM() iamiface.IAMAPI {
+	return &fakeIAM{f: f}
+}
+func (f *AWS) STS() stsiface.STSAPI {
+	return &fakeSTS{f: f}
+}
+func (f *AWS) SSM() ssmiface.SSMAPI {
+	return &fakeSSM{f: f}
+}
+func (f *AWS) CloudWatch() cloudwatchiface.CloudWatchAPI {
+	return &fakeCloudWatch{f: f}
+}
+func (f *AWS) CloudWatchLogs() cloudwatchlogsiface.CloudWatchLogsAPI {
+	return &fakeCloudWatchLogs{f: f}
+}
+func (f *AWS) AutoScaling() autoscalingiface.AutoScalingAPI {
+	return &fakeAutoScaling{f: f}
+}
+func (f *AWS) ApplicationAutoScaling() applicationautoscalingiface.ApplicationAutoScalingAPI {
+	return &fakeApplicationAutoScaling{f: f}
+}
+func (f *AWS) ECR() ecriface.ECRAPI {
+	return &fakeECR{f: f}
+}
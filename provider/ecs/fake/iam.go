@@ -0,0 +1,241 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	"time"
+)
+
+type iamState struct {
+	roles            map[string]*iam.Role
+	rolePolicies     map[string]map[string]string // role name -> policy name -> document
+	attachedPolicies map[string][]string          // role name -> policy arns
+	instanceProfiles map[string]*iam.InstanceProfile
+}
+
+func (s *iamState) init() {
+	s.roles = make(map[string]*iam.Role)
+	s.rolePolicies = make(map[string]map[string]string)
+	s.attachedPolicies = make(map[string][]string)
+	s.instanceProfiles = make(map[string]*iam.InstanceProfile)
+}
+
+type fakeIAM struct {
+	iamiface.IAMAPI
+	f *AWS
+}
+
+func (i *fakeIAM) noSuchEntity(name string) error {
+	return awsError(iam.ErrCodeNoSuchEntityException, "The role with name "+name+" cannot be found.")
+}
+
+func (i *fakeIAM) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	role, ok := i.f.iam.roles[aws.StringValue(input.RoleName)]
+	if !ok {
+		return nil, i.noSuchEntity(aws.StringValue(input.RoleName))
+	}
+	return &iam.GetRoleOutput{Role: awsutil.CopyOf(role).(*iam.Role)}, nil
+}
+
+func (i *fakeIAM) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName := aws.StringValue(input.RoleName)
+	if _, ok := i.f.iam.roles[roleName]; ok {
+		return nil, awsError(iam.ErrCodeEntityAlreadyExistsException, "Role with name "+roleName+" already exists.")
+	}
+	role := &iam.Role{
+		RoleName:                 aws.String(roleName),
+		RoleId:                   aws.String(i.f.arn("iam", "roleid")),
+		Arn:                      aws.String("arn:aws:iam::" + i.f.AccountId + ":role" + aws.StringValue(input.Path) + roleName),
+		Path:                     aws.String(aws.StringValue(input.Path)),
+		AssumeRolePolicyDocument: aws.String(aws.StringValue(input.AssumeRolePolicyDocument)),
+		CreateDate:               aws.Time(time.Now()),
+	}
+	i.f.iam.roles[roleName] = role
+	return &iam.CreateRoleOutput{Role: awsutil.CopyOf(role).(*iam.Role)}, nil
+}
+
+func (i *fakeIAM) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName := aws.StringValue(input.RoleName)
+	if _, ok := i.f.iam.roles[roleName]; !ok {
+		return nil, i.noSuchEntity(roleName)
+	}
+	if len(i.f.iam.rolePolicies[roleName]) > 0 || len(i.f.iam.attachedPolicies[roleName]) > 0 {
+		return nil, awsError(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must delete policies first.")
+	}
+	delete(i.f.iam.roles, roleName)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (i *fakeIAM) PutRolePolicy(input *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName := aws.StringValue(input.RoleName)
+	if _, ok := i.f.iam.roles[roleName]; !ok {
+		return nil, i.noSuchEntity(roleName)
+	}
+	if i.f.iam.rolePolicies[roleName] == nil {
+		i.f.iam.rolePolicies[roleName] = make(map[string]string)
+	}
+	i.f.iam.rolePolicies[roleName][aws.StringValue(input.PolicyName)] = aws.StringValue(input.PolicyDocument)
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (i *fakeIAM) GetRolePolicy(input *iam.GetRolePolicyInput) (*iam.GetRolePolicyOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	document, ok := i.f.iam.rolePolicies[aws.StringValue(input.RoleName)][aws.StringValue(input.PolicyName)]
+	if !ok {
+		return nil, awsError(iam.ErrCodeNoSuchEntityException, "The role policy with name "+aws.StringValue(input.PolicyName)+" cannot be found.")
+	}
+	return &iam.GetRolePolicyOutput{
+		RoleName:       aws.String(aws.StringValue(input.RoleName)),
+		PolicyName:     aws.String(aws.StringValue(input.PolicyName)),
+		PolicyDocument: aws.String(document),
+	}, nil
+}
+
+func (i *fakeIAM) DeleteRolePolicy(input *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName, policyName := aws.StringValue(input.RoleName), aws.StringValue(input.PolicyName)
+	if _, ok := i.f.iam.rolePolicies[roleName][policyName]; !ok {
+		return nil, awsError(iam.ErrCodeNoSuchEntityException, "The role policy with name "+policyName+" cannot be found.")
+	}
+	delete(i.f.iam.rolePolicies[roleName], policyName)
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+func (i *fakeIAM) AttachRolePolicy(input *iam.AttachRolePolicyInput) (*iam.AttachRolePolicyOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName := aws.StringValue(input.RoleName)
+	if _, ok := i.f.iam.roles[roleName]; !ok {
+		return nil, i.noSuchEntity(roleName)
+	}
+	for _, arn := range i.f.iam.attachedPolicies[roleName] {
+		if arn == aws.StringValue(input.PolicyArn) {
+			return &iam.AttachRolePolicyOutput{}, nil
+		}
+	}
+	i.f.iam.attachedPolicies[roleName] = append(i.f.iam.attachedPolicies[roleName], aws.StringValue(input.PolicyArn))
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (i *fakeIAM) DetachRolePolicy(input *iam.DetachRolePolicyInput) (*iam.DetachRolePolicyOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName := aws.StringValue(input.RoleName)
+	for k, arn := range i.f.iam.attachedPolicies[roleName] {
+		if arn == aws.StringValue(input.PolicyArn) {
+			i.f.iam.attachedPolicies[roleName] = append(i.f.iam.attachedPolicies[roleName][:k:k], i.f.iam.attachedPolicies[roleName][k+1:]...)
+			return &iam.DetachRolePolicyOutput{}, nil
+		}
+	}
+	return nil, awsError(iam.ErrCodeNoSuchEntityException, "Policy "+aws.StringValue(input.PolicyArn)+" was not found.")
+}
+
+func (i *fakeIAM) ListAttachedRolePolicies(input *iam.ListAttachedRolePoliciesInput) (*iam.ListAttachedRolePoliciesOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	roleName := aws.StringValue(input.RoleName)
+	if _, ok := i.f.iam.roles[roleName]; !ok {
+		return nil, i.noSuchEntity(roleName)
+	}
+	output := &iam.ListAttachedRolePoliciesOutput{IsTruncated: aws.Bool(false)}
+	for _, arn := range i.f.iam.attachedPolicies[roleName] {
+		output.AttachedPolicies = append(output.AttachedPolicies, &iam.AttachedPolicy{PolicyArn: aws.String(arn)})
+	}
+	return output, nil
+}
+
+func (i *fakeIAM) CreateInstanceProfile(input *iam.CreateInstanceProfileInput) (*iam.CreateInstanceProfileOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	name := aws.StringValue(input.InstanceProfileName)
+	if _, ok := i.f.iam.instanceProfiles[name]; ok {
+		return nil, awsError(iam.ErrCodeEntityAlreadyExistsException, "Instance Profile "+name+" already exists.")
+	}
+	ip := &iam.InstanceProfile{
+		InstanceProfileName: aws.String(name),
+		Arn:                 aws.String("arn:aws:iam::" + i.f.AccountId + ":instance-profile/" + name),
+		CreateDate:          aws.Time(time.Now()),
+	}
+	i.f.iam.instanceProfiles[name] = ip
+	return &iam.CreateInstanceProfileOutput{InstanceProfile: awsutil.CopyOf(ip).(*iam.InstanceProfile)}, nil
+}
+
+func (i *fakeIAM) AddRoleToInstanceProfile(input *iam.AddRoleToInstanceProfileInput) (*iam.AddRoleToInstanceProfileOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	ip, ok := i.f.iam.instanceProfiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, awsError(iam.ErrCodeNoSuchEntityException, "Instance Profile "+aws.StringValue(input.InstanceProfileName)+" cannot be found.")
+	}
+	role, ok := i.f.iam.roles[aws.StringValue(input.RoleName)]
+	if !ok {
+		return nil, i.noSuchEntity(aws.StringValue(input.RoleName))
+	}
+	ip.Roles = append(ip.Roles, role)
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+func (i *fakeIAM) RemoveRoleFromInstanceProfile(input *iam.RemoveRoleFromInstanceProfileInput) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	ip, ok := i.f.iam.instanceProfiles[aws.StringValue(input.InstanceProfileName)]
+	if !ok {
+		return nil, awsError(iam.ErrCodeNoSuchEntityException, "Instance Profile "+aws.StringValue(input.InstanceProfileName)+" cannot be found.")
+	}
+	var roles []*iam.Role
+	for _, role := range ip.Roles {
+		if aws.StringValue(role.RoleName) != aws.StringValue(input.RoleName) {
+			roles = append(roles, role)
+		}
+	}
+	ip.Roles = roles
+	return &iam.RemoveRoleFromInstanceProfileOutput{}, nil
+}
+
+func (i *fakeIAM) DeleteInstanceProfile(input *iam.DeleteInstanceProfileInput) (*iam.DeleteInstanceProfileOutput, error) {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	name := aws.StringValue(input.InstanceProfileName)
+	if _, ok := i.f.iam.instanceProfiles[name]; !ok {
+		return nil, awsError(iam.ErrCodeNoSuchEntityException, "Instance Profile "+name+" cannot be found.")
+	}
+	delete(i.f.iam.instanceProfiles, name)
+	return &iam.DeleteInstanceProfileOutput{}, nil
+}
+
+func (i *fakeIAM) WaitUntilInstanceProfileExists(input *iam.GetInstanceProfileInput) error {
+	i.f.mu.Lock()
+	defer i.f.mu.Unlock()
+	if _, ok := i.f.iam.instanceProfiles[aws.StringValue(input.InstanceProfileName)]; !ok {
+		return awsError(iam.ErrCodeNoSuchEntityException, "Instance Profile "+aws.StringValue(input.InstanceProfileName)+" cannot be found.")
+	}
+	return nil
+}
+
+type fakeSTS struct {
+	stsiface.STSAPI
+	f *AWS
+}
+
+func (s *fakeSTS) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(s.f.AccountId),
+		Arn:     aws.String("arn:aws:iam::" + s.f.AccountId + ":user/ecs-deploy"),
+		UserId:  aws.String("AIDAFAKEUSERID"),
+	}, nil
+}
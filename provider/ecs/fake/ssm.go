@@ -0,0 +1,86 @@
+package fake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"sort"
+	"strings"
+	"time"
+)
+
+type ssmState struct {
+	parameters map[string]*ssm.Parameter
+}
+
+func (s *ssmState) init() {
+	s.parameters = make(map[string]*ssm.Parameter)
+}
+
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	f *AWS
+}
+
+func (s *fakeSSM) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	name := aws.StringValue(input.Name)
+	version := int64(1)
+	if p, ok := s.f.ssm.parameters[name]; ok {
+		if !aws.BoolValue(input.Overwrite) {
+			return nil, awsError(ssm.ErrCodeParameterAlreadyExists, "The parameter already exists.")
+		}
+		version = aws.Int64Value(p.Version) + 1
+	}
+	s.f.ssm.parameters[name] = &ssm.Parameter{
+		Name:             aws.String(name),
+		Type:             aws.String(aws.StringValue(input.Type)),
+		Value:            aws.String(aws.StringValue(input.Value)),
+		Version:          aws.Int64(version),
+		LastModifiedDate: aws.Time(time.Now()),
+	}
+	return &ssm.PutParameterOutput{Version: aws.Int64(version)}, nil
+}
+
+func (s *fakeSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	p, ok := s.f.ssm.parameters[aws.StringValue(input.Name)]
+	if !ok {
+		return nil, awsError(ssm.ErrCodeParameterNotFound, "Parameter "+aws.StringValue(input.Name)+" not found.")
+	}
+	return &ssm.GetParameterOutput{Parameter: awsutil.CopyOf(p).(*ssm.Parameter)}, nil
+}
+
+func (s *fakeSSM) DeleteParameter(input *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	if _, ok := s.f.ssm.parameters[aws.StringValue(input.Name)]; !ok {
+		return nil, awsError(ssm.ErrCodeParameterNotFound, "Parameter "+aws.StringValue(input.Name)+" not found.")
+	}
+	delete(s.f.ssm.parameters, aws.StringValue(input.Name))
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+// GetParametersByPathPages returns the parameters directly under the path (the fake doesn't support recursive lookups)
+func (s *fakeSSM) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	s.f.mu.Lock()
+	path := aws.StringValue(input.Path)
+	output := &ssm.GetParametersByPathOutput{}
+	var names []string
+	for name := range s.f.ssm.parameters {
+		if strings.HasPrefix(name, path) && !strings.Contains(strings.TrimPrefix(name, path), "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output.Parameters = append(output.Parameters, awsutil.CopyOf(s.f.ssm.parameters[name]).(*ssm.Parameter))
+	}
+	s.f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
@@ -0,0 +1,116 @@
+package ecs
+
+import (
+	"github.com/in4it/ecs-deploy/service"
+
+	"fmt"
+	"time"
+)
+
+// default window in seconds during which the health gates need to stay ok, and the interval between the checks
+var (
+	DefaultHealthGateWindow   = int64(300)
+	DefaultHealthGateInterval = int64(30)
+)
+
+// HasHealthGates returns true if the deployment has alarms or metrics that need to stay ok after the deployment
+func HasHealthGates(healthGates service.DeployHealthGates) bool {
+	return len(healthGates.Alarms) > 0 || len(healthGates.Metrics) > 0
+}
+
+// waitForHealthGates checks the health gates until the window is over. Returns the reason of the first gate that
+// fails, or an empty string if all gates stayed ok
+func (e *ECS) waitForHealthGates(dd *service.DynamoDeployment) (string, error) {
+	healthGates := dd.DeployData.HealthGates
+	window, interval := healthGates.Window, healthGates.Interval
+	if window == 0 {
+		window = DefaultHealthGateWindow
+	}
+	if interval == 0 {
+		interval = DefaultHealthGateInterval
+	}
+	start := time.Now()
+	end := start.Add(time.Duration(window) * time.Second)
+	ecsLogger.Debugf("Checking health gates of %v until %v", dd.ServiceName, end)
+	for {
+		wait := time.Duration(interval) * time.Second
+		if remaining := end.Sub(time.Now()); remaining < wait {
+			wait = remaining
+		}
+		time.Sleep(wait)
+		reason, err := e.checkHealthGates(dd, start, time.Now())
+		if err != nil || reason != "" {
+			return reason, err
+		}
+		if !time.Now().Before(end) {
+			return "", nil
+		}
+	}
+}
+
+// checkHealthGates returns the reason why a health gate failed, or an empty string if all gates are ok
+func (e *ECS) checkHealthGates(dd *service.DynamoDeployment, startTime, endTime time.Time) (string, error) {
+	healthGates := dd.DeployData.HealthGates
+	cw := CloudWatch{Clients: e.Clients}
+	if len(healthGates.Alarms) > 0 {
+		states, err := cw.GetAlarmStates(healthGates.Alarms)
+		if err != nil {
+			return "", err
+		}
+		for _, alarmName := range healthGates.Alarms {
+			state, ok := states[alarmName]
+			if !ok {
+				return "Health gate failed: alarm " + alarmName + " not found", nil
+			}
+			if state == "ALARM" {
+				return "Health gate failed: alarm " + alarmName + " is in ALARM state", nil
+			}
+		}
+	}
+	// metrics have a granularity of 1 minute
+	startTime = startTime.Truncate(time.Minute)
+	endTime = endTime.Truncate(time.Minute).Add(time.Minute)
+	for _, metric := range healthGates.Metrics {
+		dimensions := metric.Dimensions
+		if len(dimensions) == 0 {
+			dimensions = map[string]string{
+				"ClusterName": dd.DeployData.Cluster,
+				"ServiceName": dd.ServiceName,
+			}
+		}
+		statistic := metric.Statistic
+		if statistic == "" {
+			statistic = "Average"
+		}
+		comparisonOperator := metric.ComparisonOperator
+		if comparisonOperator == "" {
+			comparisonOperator = "GreaterThanThreshold"
+		}
+		value, err := cw.GetMetricStatistic(metric.Namespace, metric.MetricName, statistic, dimensions, startTime, endTime)
+		if err != nil {
+			return "", err
+		}
+		if value == -1 {
+			// no datapoints
+			continue
+		}
+		if isThresholdBreached(value, metric.Threshold, comparisonOperator) {
+			return fmt.Sprintf("Health gate failed: %v %v of %v is %v (%v %v)", statistic, metric.MetricName, metric.Namespace, value, comparisonOperator, metric.Threshold), nil
+		}
+	}
+	return "", nil
+}
+
+func isThresholdBreached(value, threshold float64, comparisonOperator string) bool {
+	switch comparisonOperator {
+	case "GreaterThanOrEqualToThreshold":
+		return value >= threshold
+	case "LessThanThreshold":
+		return value < threshold
+	case "LessThanOrEqualToThreshold":
+		return value <= threshold
+	case "GreaterThanThreshold":
+		return value > threshold
+	}
+	return false
+}
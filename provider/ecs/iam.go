@@ -8,11 +8,14 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/in4it/ecs-deploy/util"
 	"github.com/juju/loggo"
 
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // logging
@@ -20,8 +23,9 @@ var iamLogger = loggo.GetLogger("iam")
 
 // IAM struct
 type IAM struct {
-	stsAssumingRole *sts.STS
+	stsAssumingRole stsiface.STSAPI
 	AccountId       string
+	Clients         Clients
 }
 
 // default IAM trust
@@ -42,10 +46,53 @@ func (e *IAM) GetEcsServicePolicy() string {
 	return `arn:aws:iam::aws:policy/service-role/AmazonEC2ContainerServiceRole`
 }
 
+func (e *IAM) GetEcsTaskExecutionPolicy() string {
+	return `arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy`
+}
+
+type iamPolicyDocument struct {
+	Version   string
+	Statement []iamPolicyStatement
+}
+type iamPolicyStatement struct {
+	Effect   string
+	Action   []string
+	Resource []string
+}
+
+// GetSecretsIAMPolicy returns a policy that only allows reading the given parameters and Secrets Manager secrets
+func (e *IAM) GetSecretsIAMPolicy(secretArns []string) string {
+	var parameters, secrets []string
+	for _, arn := range secretArns {
+		if strings.HasPrefix(arn, "arn:aws:secretsmanager:") {
+			// arn:aws:secretsmanager:region:account:secret:name-suffix[:json-key:version-stage:version-id]
+			parts := strings.Split(arn, ":")
+			if len(parts) > 7 {
+				parts = parts[:7]
+			}
+			secrets = append(secrets, strings.Join(parts, ":")+"*")
+		} else {
+			parameters = append(parameters, arn)
+		}
+	}
+	policy := iamPolicyDocument{Version: "2012-10-17"}
+	if len(parameters) > 0 {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{Effect: "Allow", Action: []string{"ssm:GetParameters"}, Resource: parameters})
+	}
+	if len(secrets) > 0 {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{Effect: "Allow", Action: []string{"secretsmanager:GetSecretValue"}, Resource: secrets})
+	}
+	if util.GetEnv("PARAMSTORE_KMS_ARN", "") != "" {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{Effect: "Allow", Action: []string{"kms:Decrypt"}, Resource: []string{util.GetEnv("PARAMSTORE_KMS_ARN", "")}})
+	}
+	document, _ := json.Marshal(policy)
+	return string(document)
+}
+
 func (e *IAM) GetAccountId() error {
-	var svc *sts.STS
+	var svc stsiface.STSAPI
 	if e.stsAssumingRole == nil {
-		svc = sts.New(session.New())
+		svc = getClients(e.Clients).STS()
 	} else {
 		svc = e.stsAssumingRole
 	}
@@ -68,7 +115,7 @@ func (e *IAM) GetAccountId() error {
 }
 
 func (e *IAM) RoleExists(roleName string) (*string, error) {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.GetRoleInput{
 		RoleName: aws.String(roleName),
 	}
@@ -93,11 +140,11 @@ func (e *IAM) RoleExists(roleName string) (*string, error) {
 }
 
 func (e *IAM) CreateRole(roleName, assumePolicyDocument string) (*string, error) {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.CreateRoleInput{
 		AssumeRolePolicyDocument: aws.String(assumePolicyDocument),
-		Path:     aws.String("/"),
-		RoleName: aws.String(roleName),
+		Path:                     aws.String("/"),
+		RoleName:                 aws.String(roleName),
 	}
 
 	result, err := svc.CreateRole(input)
@@ -129,7 +176,7 @@ func (e *IAM) CreateRole(roleName, assumePolicyDocument string) (*string, error)
 	}
 }
 func (e *IAM) DeleteRolePolicy(roleName, policyName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.DeleteRolePolicyInput{
 		RoleName:   aws.String(roleName),
 		PolicyName: aws.String(policyName),
@@ -147,7 +194,7 @@ func (e *IAM) DeleteRolePolicy(roleName, policyName string) error {
 	return nil
 }
 func (e *IAM) DeleteRole(roleName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.DeleteRoleInput{
 		RoleName: aws.String(roleName),
 	}
@@ -164,7 +211,7 @@ func (e *IAM) DeleteRole(roleName string) error {
 	return nil
 }
 func (e *IAM) CreateInstanceProfile(instanceProfileName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.CreateInstanceProfileInput{
 		InstanceProfileName: aws.String(instanceProfileName),
 		Path:                aws.String("/"),
@@ -182,7 +229,7 @@ func (e *IAM) CreateInstanceProfile(instanceProfileName string) error {
 	return nil
 }
 func (e *IAM) AddRoleToInstanceProfile(instanceProfileName, roleName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.AddRoleToInstanceProfileInput{
 		InstanceProfileName: aws.String(instanceProfileName),
 		RoleName:            aws.String(roleName),
@@ -200,7 +247,7 @@ func (e *IAM) AddRoleToInstanceProfile(instanceProfileName, roleName string) err
 	return nil
 }
 func (e *IAM) RemoveRoleFromInstanceProfile(instanceProfileName, roleName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.RemoveRoleFromInstanceProfileInput{
 		InstanceProfileName: aws.String(instanceProfileName),
 		RoleName:            aws.String(roleName),
@@ -218,7 +265,7 @@ func (e *IAM) RemoveRoleFromInstanceProfile(instanceProfileName, roleName string
 	return nil
 }
 func (e *IAM) DeleteInstanceProfile(instanceProfileName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.DeleteInstanceProfileInput{
 		InstanceProfileName: aws.String(instanceProfileName),
 	}
@@ -235,7 +282,7 @@ func (e *IAM) DeleteInstanceProfile(instanceProfileName string) error {
 	return nil
 }
 func (e *IAM) WaitUntilInstanceProfileExists(instanceProfileName string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.GetInstanceProfileInput{
 		InstanceProfileName: aws.String(instanceProfileName),
 	}
@@ -253,7 +300,7 @@ func (e *IAM) WaitUntilInstanceProfileExists(instanceProfileName string) error {
 }
 
 func (e *IAM) PutRolePolicy(roleName, policyName, policy string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 
 	input := &iam.PutRolePolicyInput{
 		PolicyDocument: aws.String(policy),
@@ -286,7 +333,7 @@ func (e *IAM) PutRolePolicy(roleName, policyName, policy string) error {
 	return nil
 }
 func (e *IAM) AttachRolePolicy(roleName, policyArn string) error {
-	svc := iam.New(session.New())
+	svc := getClients(e.Clients).IAM()
 	input := &iam.AttachRolePolicyInput{
 		PolicyArn: aws.String(policyArn),
 		RoleName:  aws.String(roleName),
@@ -3,12 +3,14 @@ package ecs
 import (
 	"errors"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/in4it/ecs-deploy/service"
 	"github.com/in4it/ecs-deploy/util"
 	"github.com/juju/loggo"
@@ -17,6 +19,9 @@ import (
 // logging
 var paramstoreLogger = loggo.GetLogger("paramstore")
 
+// the characters sts doesn't accept in a role session name
+var roleSessionNameInvalidChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
 // parameter type
 type Parameter struct {
 	Name    string `json:"name"`
@@ -28,7 +33,8 @@ type Parameter struct {
 // Paramstore struct
 type Paramstore struct {
 	Parameters      map[string]Parameter
-	SsmAssumingRole *ssm.SSM
+	SsmAssumingRole ssmiface.SSMAPI
+	Clients         Clients
 }
 
 func (p *Paramstore) IsEnabled() bool {
@@ -57,8 +63,45 @@ func (p *Paramstore) GetPrefixForService(serviceName string) string {
 		return "/" + util.GetEnv("PARAMSTORE_PREFIX", "") + "-" + util.GetEnv("AWS_ACCOUNT_ENV", "") + "/" + serviceName + "/"
 	}
 }
+
+// GetSecretArn returns the arn of a container secret. ValueFrom can be the arn of a parameter or a Secrets Manager
+// secret, a parameter name starting with /, or a parameter name relative to the paramstore namespace of the service
+func (p *Paramstore) GetSecretArn(valueFrom, namespace, accountId string) string {
+	if strings.HasPrefix(valueFrom, "arn:") {
+		return valueFrom
+	}
+	parameterName := valueFrom
+	if !strings.HasPrefix(valueFrom, "/") {
+		prefix := p.GetPrefixForService(namespace)
+		if prefix == "" {
+			prefix = "/" + namespace + "/"
+		}
+		parameterName = prefix + valueFrom
+	}
+	return "arn:aws:ssm:" + util.GetEnv("AWS_REGION", "") + ":" + accountId + ":parameter" + parameterName
+}
+
+// GetSecretArns returns the arns of the secrets and the repository credentials of all containers
+func (p *Paramstore) GetSecretArns(d service.Deploy, serviceName, accountId string) []string {
+	var arns []string
+	namespace := d.EnvNamespace
+	if namespace == "" {
+		namespace = serviceName
+	}
+	for _, container := range d.Containers {
+		for _, secret := range container.Secrets {
+			arns = append(arns, p.GetSecretArn(secret.ValueFrom, namespace, accountId))
+		}
+		if container.RepositoryCredentials.CredentialsParameter != "" {
+			arns = append(arns, container.RepositoryCredentials.CredentialsParameter)
+		}
+	}
+	return arns
+}
+
 func (p *Paramstore) AssumeRole(roleArn, roleSessionName, prevCreds string) (string, error) {
 	iam := IAM{}
+	roleSessionName = GetRoleSessionName(roleSessionName)
 	creds, jsonCreds, err := iam.AssumeRole(roleArn, roleSessionName, prevCreds)
 	if err != nil {
 		return "", err
@@ -73,15 +116,27 @@ func (p *Paramstore) AssumeRole(roleArn, roleSessionName, prevCreds string) (str
 
 	return jsonCreds, nil
 }
+
+// GetRoleSessionName returns the user id as a valid role session name: 2 to 64 letters, digits or +=,.@-_
+func GetRoleSessionName(userId string) string {
+	name := roleSessionNameInvalidChars.ReplaceAllString(userId, "-")
+	for len(name) < 2 {
+		name += "-"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
 func (p *Paramstore) GetParameters(prefix string, withDecryption bool) error {
-	var svc *ssm.SSM
+	var svc ssmiface.SSMAPI
 	p.Parameters = make(map[string]Parameter)
 	if prefix == "" {
 		// no valid prefix - parameter store not in use
 		return nil
 	}
 	if p.SsmAssumingRole == nil {
-		svc = ssm.New(session.New())
+		svc = getClients(p.Clients).SSM()
 	} else {
 		svc = p.SsmAssumingRole
 	}
@@ -133,7 +188,7 @@ func (p *Paramstore) GetParameterValue(name string) (*string, error) {
 	}
 
 	// val not found, but does exist, retrieve
-	svc := ssm.New(session.New())
+	svc := getClients(p.Clients).SSM()
 	input := &ssm.GetParameterInput{
 		Name:           aws.String(p.GetPrefix() + name),
 		WithDecryption: aws.Bool(true),
@@ -157,6 +212,26 @@ func (p *Paramstore) GetParameterValue(name string) (*string, error) {
 	return result.Parameter.Value, nil
 }
 
+// GetDecryptedParameter retrieves a parameter by its full name, without the prefix of the parameter store
+func (p *Paramstore) GetDecryptedParameter(name string) (string, error) {
+	svc := getClients(p.Clients).SSM()
+	input := &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	}
+
+	result, err := svc.GetParameter(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			paramstoreLogger.Errorf(aerr.Error())
+		} else {
+			paramstoreLogger.Errorf(err.Error())
+		}
+		return "", err
+	}
+	return aws.StringValue(result.Parameter.Value), nil
+}
+
 func (p *Paramstore) GetParamstoreIAMPolicy(path string) string {
 	iam := IAM{}
 	err := iam.GetAccountId()
@@ -193,9 +268,9 @@ func (p *Paramstore) GetParamstoreIAMPolicy(path string) string {
 	return policy
 }
 func (p *Paramstore) PutParameter(serviceName string, parameter service.DeployServiceParameter) (*int64, error) {
-	var svc *ssm.SSM
+	var svc ssmiface.SSMAPI
 	if p.SsmAssumingRole == nil {
-		svc = ssm.New(session.New())
+		svc = getClients(p.Clients).SSM()
 	} else {
 		svc = p.SsmAssumingRole
 	}
@@ -224,9 +299,9 @@ func (p *Paramstore) PutParameter(serviceName string, parameter service.DeploySe
 	return result.Version, nil
 }
 func (p *Paramstore) DeleteParameter(serviceName, parameter string) error {
-	var svc *ssm.SSM
+	var svc ssmiface.SSMAPI
 	if p.SsmAssumingRole == nil {
-		svc = ssm.New(session.New())
+		svc = getClients(p.Clients).SSM()
 	} else {
 		svc = p.SsmAssumingRole
 	}
@@ -2,6 +2,7 @@ package ecs
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -18,3 +19,19 @@ func TestGetPrefix(t *testing.T) {
 		t.Errorf("Wrong prefix returned: %v", p.GetPrefix())
 	}
 }
+
+func TestGetRoleSessionName(t *testing.T) {
+	tests := map[string]string{
+		"deploy":                         "deploy",
+		"token-ci":                       "token-ci",
+		"john.doe@example.com":           "john.doe@example.com",
+		"auth0|5f7c8ec7c33c6c004bbafe82": "auth0-5f7c8ec7c33c6c004bbafe82",
+		"a":                              "a-",
+		strings.Repeat("x", 70):          strings.Repeat("x", 64),
+	}
+	for userId, expected := range tests {
+		if name := GetRoleSessionName(userId); name != expected {
+			t.Errorf("Expected role session name %v for %v, got %v", expected, userId, name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/in4it/ecs-deploy/service"
+
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrRulePriorityInUse is returned when a rule is created with a priority that is taken by another rule
+var ErrRulePriorityInUse = errors.New("Could not create alb rule: priority is already in use")
+
+// number of times the rules of a listener are created with new priorities when a priority was taken in the meantime
+const ruleCreateRetries = 5
+
+// CreateRulesWithPriorities creates the rules of rule conditions on the listeners with the protocols of the rule
+// conditions. The priorities are reserved per listener with service.AllocateRulePriorities. The rules are tagged with
+// the service name, as they don't necessarily forward to the target group
+func (a *ALB) CreateRulesWithPriorities(serviceName, targetGroupArn string, ruleConditions []*service.DeployRuleConditions) ([]string, error) {
+	var listeners []string
+	actions := make([][]*elbv2.Action, len(ruleConditions))
+	for i, r := range ruleConditions {
+		var err error
+		actions[i], err = a.GetRuleActions(targetGroupArn, r.Action)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range a.Listeners {
+		var requests []service.RulePriorityRequest
+		inputs := make(map[string]*elbv2.CreateRuleInput)
+		for i, r := range ruleConditions {
+			if !listenerHasProtocol(l, r.Listeners) {
+				continue
+			}
+			key := strconv.Itoa(i)
+			requests = append(requests, service.RulePriorityRequest{Key: key, Priority: r.Priority, Specificity: service.GetRuleSpecificity(r)})
+			inputs[key] = &elbv2.CreateRuleInput{
+				ListenerArn: l.ListenerArn,
+				Conditions:  a.GetRuleConditions(r),
+				Actions:     actions[i],
+				Tags:        []*elbv2.Tag{{Key: aws.String(RuleServiceTag), Value: aws.String(serviceName)}},
+			}
+		}
+		if len(requests) == 0 {
+			continue
+		}
+		_, err := a.createListenerRules(serviceName, aws.StringValue(l.ListenerArn), requests, inputs, nil)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, aws.StringValue(l.ListenerArn))
+	}
+	return listeners, nil
+}
+
+// ReleaseRulePriorities removes the priority reservations of a service on all listeners of the loadbalancer
+func (a *ALB) ReleaseRulePriorities(serviceName string) error {
+	s := service.NewService()
+	s.ServiceName = serviceName
+	for _, l := range a.Listeners {
+		err := s.ReleaseRulePriorities(aws.StringValue(l.ListenerArn))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createListenerRules reserves the priorities and creates the rules on a listener. existing are the rules of the
+// service that already exist, by request key, they are moved to their new priority. The new rules are created on free
+// priorities first, all rules move to their priorities in one call once they are created, together with the rules of
+// other services that need to make room or that move to close gaps. When a rule can't be created or moved, the created
+// rules are removed and the reservations are reverted, so the rules of other services don't move. When a priority was
+// taken in the meantime the allocation is retried. Returns whether rules of the service were created or moved
+func (a *ALB) createListenerRules(serviceName, listenerArn string, requests []service.RulePriorityRequest, inputs map[string]*elbv2.CreateRuleInput, existing map[string]*elbv2.Rule) (bool, error) {
+	s := service.NewService()
+	s.ServiceName = serviceName
+	existingKeys := make(map[string]string)
+	for key, rule := range existing {
+		existingKeys[aws.StringValue(rule.RuleArn)] = key
+	}
+	for i := 0; i < ruleCreateRetries; i++ {
+		err := a.GetRulesForAllListeners()
+		if err != nil {
+			return false, err
+		}
+		var inUse []int64
+		for _, rule := range a.Rules[listenerArn] {
+			if priority, err := strconv.ParseInt(aws.StringValue(rule.Priority), 10, 64); err == nil {
+				inUse = append(inUse, priority)
+			}
+		}
+		allocation, err := s.AllocateRulePriorities(listenerArn, requests, inUse)
+		if err != nil {
+			return false, err
+		}
+		free := getFreeRulePriorities(inUse, allocation)
+		created := make(map[string]string)
+		conflict := false
+		for _, request := range requests {
+			if _, ok := existing[request.Key]; ok {
+				continue
+			}
+			if len(free) == 0 {
+				a.revertListenerRules(s, allocation, created)
+				return false, errors.New("Could not create alb rules of " + serviceName + ": no free rule priority left")
+			}
+			input := inputs[request.Key]
+			input.SetPriority(free[0])
+			free = free[1:]
+			albLogger.Debugf("Creating rule %v of service %v with priority %d", request.Key, serviceName, aws.Int64Value(input.Priority))
+			ruleArn, err := a.createRule(input)
+			if err == ErrRulePriorityInUse {
+				conflict = true
+				break
+			}
+			if err != nil {
+				a.revertListenerRules(s, allocation, created)
+				return false, err
+			}
+			created[ruleArn] = request.Key
+		}
+		if conflict {
+			albLogger.Infof("Rule priority on listener %v was taken by another rule, retrying", listenerArn)
+			a.revertListenerRules(s, allocation, created)
+			continue
+		}
+		var rulePriorities []*elbv2.RulePriorityPair
+		for ruleArn, key := range created {
+			rulePriorities = append(rulePriorities, &elbv2.RulePriorityPair{RuleArn: aws.String(ruleArn), Priority: aws.Int64(allocation.Assigned[key])})
+		}
+		changed := false
+		movedServices := make(map[string]bool)
+		for _, rule := range a.Rules[listenerArn] {
+			priority, err := strconv.ParseInt(aws.StringValue(rule.Priority), 10, 64)
+			if err != nil {
+				continue
+			}
+			if key, ok := existingKeys[aws.StringValue(rule.RuleArn)]; ok {
+				if allocation.Assigned[key] != priority {
+					rulePriorities = append(rulePriorities, &elbv2.RulePriorityPair{RuleArn: rule.RuleArn, Priority: aws.Int64(allocation.Assigned[key])})
+					changed = true
+				}
+			} else if move, ok := allocation.Moved[priority]; ok {
+				rulePriorities = append(rulePriorities, &elbv2.RulePriorityPair{RuleArn: rule.RuleArn, Priority: aws.Int64(move.Priority)})
+				movedServices[move.Owner] = true
+			}
+		}
+		err = a.setRulePriorities(rulePriorities)
+		if err != nil {
+			a.revertListenerRules(s, allocation, created)
+			return false, err
+		}
+		if len(movedServices) > 0 {
+			var owners []string
+			for owner := range movedServices {
+				owners = append(owners, owner)
+			}
+			sort.Strings(owners)
+			albLogger.Infof("Moved the rules of %v on listener %v to new priorities for the rules of %v", strings.Join(owners, ", "), listenerArn, serviceName)
+		}
+		return changed || len(created) > 0, nil
+	}
+	return false, errors.New("Could not create alb rules of " + serviceName + ": rule priorities were taken by other rules")
+}
+
+// revertListenerRules removes the rules that were created and puts back the priority reservations of the listener
+func (a *ALB) revertListenerRules(s *service.Service, allocation *service.RulePriorityAllocation, created map[string]string) {
+	for ruleArn := range created {
+		a.DeleteRule(ruleArn)
+	}
+	err := s.RevertRulePriorities(allocation)
+	if err != nil {
+		albLogger.Errorf("Could not revert the rule priorities of service %v: %v", s.ServiceName, err)
+	}
+}
+
+// getFreeRulePriorities returns the priorities that are not in use and not allocated, highest first. New rules are
+// created on them before they move to their allocated priority
+func getFreeRulePriorities(inUse []int64, allocation *service.RulePriorityAllocation) []int64 {
+	taken := make(map[int64]bool)
+	for _, priority := range inUse {
+		taken[priority] = true
+	}
+	for _, priority := range allocation.Assigned {
+		taken[priority] = true
+	}
+	for _, move := range allocation.Moved {
+		taken[move.Priority] = true
+	}
+	var free []int64
+	for priority := int64(service.MaxRulePriority); priority >= service.MinRulePriority && len(free) < len(allocation.Assigned); priority-- {
+		if !taken[priority] {
+			free = append(free, priority)
+		}
+	}
+	return free
+}
+
+// setRulePriorities moves the rules to their new priorities. All rules move in one call, so rules can take each
+// other's priorities
+func (a *ALB) setRulePriorities(rulePriorities []*elbv2.RulePriorityPair) error {
+	if len(rulePriorities) == 0 {
+		return nil
+	}
+	albLogger.Debugf("Moving %d rule(s) to new priorities", len(rulePriorities))
+	svc := getClients(a.Clients).ELBV2()
+	_, err := svc.SetRulePriorities(&elbv2.SetRulePrioritiesInput{RulePriorities: rulePriorities})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			albLogger.Errorf(aerr.Error())
+		} else {
+			albLogger.Errorf(err.Error())
+		}
+		return errors.New("Could not set alb rule priorities")
+	}
+	return nil
+}
+
+// listenerHasProtocol returns true if the protocol of the listener is one of protocols
+func listenerHasProtocol(l *elbv2.Listener, protocols []string) bool {
+	for _, protocol := range protocols {
+		if l.Protocol != nil && strings.ToLower(*l.Protocol) == strings.ToLower(protocol) {
+			return true
+		}
+	}
+	return false
+}
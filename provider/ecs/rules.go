@@ -0,0 +1,509 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/in4it/ecs-deploy/service"
+	"github.com/in4it/ecs-deploy/util"
+
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the tag with the name of the service on the listener rules that are created from rule conditions
+const RuleServiceTag = "ecs-deploy-service"
+
+// GetRuleConditions returns the conditions of a listener rule. The domain of the loadbalancer is appended to the hostnames
+func (a *ALB) GetRuleConditions(r *service.DeployRuleConditions) []*elbv2.RuleCondition {
+	var conditions []*elbv2.RuleCondition
+	pathPatterns := r.PathPatterns
+	if r.PathPattern != "" {
+		pathPatterns = append([]string{r.PathPattern}, pathPatterns...)
+	}
+	if len(pathPatterns) == 1 {
+		conditions = append(conditions, &elbv2.RuleCondition{Field: aws.String("path-pattern"), Values: aws.StringSlice(pathPatterns)})
+	} else if len(pathPatterns) > 1 {
+		conditions = append(conditions, &elbv2.RuleCondition{
+			Field:             aws.String("path-pattern"),
+			PathPatternConfig: &elbv2.PathPatternConditionConfig{Values: aws.StringSlice(pathPatterns)},
+		})
+	}
+	var hostnames []string
+	if r.Hostname != "" {
+		hostnames = append(hostnames, r.Hostname+"."+util.GetEnv("LOADBALANCER_DOMAIN", a.Domain))
+	}
+	for _, hostname := range r.Hostnames {
+		hostnames = append(hostnames, hostname+"."+util.GetEnv("LOADBALANCER_DOMAIN", a.Domain))
+	}
+	if len(hostnames) == 1 {
+		conditions = append(conditions, &elbv2.RuleCondition{Field: aws.String("host-header"), Values: aws.StringSlice(hostnames)})
+	} else if len(hostnames) > 1 {
+		conditions = append(conditions, &elbv2.RuleCondition{
+			Field:            aws.String("host-header"),
+			HostHeaderConfig: &elbv2.HostHeaderConditionConfig{Values: aws.StringSlice(hostnames)},
+		})
+	}
+	for _, header := range r.HttpHeaders {
+		conditions = append(conditions, &elbv2.RuleCondition{
+			Field:            aws.String("http-header"),
+			HttpHeaderConfig: &elbv2.HttpHeaderConditionConfig{HttpHeaderName: aws.String(header.Name), Values: aws.StringSlice(header.Values)},
+		})
+	}
+	if len(r.QueryStrings) > 0 {
+		config := &elbv2.QueryStringConditionConfig{}
+		for _, queryString := range r.QueryStrings {
+			pair := &elbv2.QueryStringKeyValuePair{Value: aws.String(queryString.Value)}
+			if queryString.Key != "" {
+				pair.SetKey(queryString.Key)
+			}
+			config.Values = append(config.Values, pair)
+		}
+		conditions = append(conditions, &elbv2.RuleCondition{Field: aws.String("query-string"), QueryStringConfig: config})
+	}
+	if len(r.HttpRequestMethods) > 0 {
+		conditions = append(conditions, &elbv2.RuleCondition{
+			Field:                   aws.String("http-request-method"),
+			HttpRequestMethodConfig: &elbv2.HttpRequestMethodConditionConfig{Values: aws.StringSlice(r.HttpRequestMethods)},
+		})
+	}
+	if len(r.SourceIps) > 0 {
+		conditions = append(conditions, &elbv2.RuleCondition{
+			Field:          aws.String("source-ip"),
+			SourceIpConfig: &elbv2.SourceIpConditionConfig{Values: aws.StringSlice(r.SourceIps)},
+		})
+	}
+	return conditions
+}
+
+// GetRuleActions returns the actions of a listener rule: the optional authentication, followed by a forward to the
+// target group, a fixed response or a redirect. The oidc client secret is retrieved from the parameter store
+func (a *ALB) GetRuleActions(targetGroupArn string, action service.DeployRuleAction) ([]*elbv2.Action, error) {
+	var actions []*elbv2.Action
+	if oidc := action.AuthenticateOidc; oidc != nil {
+		ps := Paramstore{Clients: a.Clients}
+		clientSecret, err := ps.GetDecryptedParameter(oidc.ClientSecretParameter)
+		if err != nil {
+			return nil, errors.New("Could not retrieve the oidc client secret from parameter " + oidc.ClientSecretParameter)
+		}
+		config := &elbv2.AuthenticateOidcActionConfig{
+			Issuer:                aws.String(oidc.Issuer),
+			AuthorizationEndpoint: aws.String(oidc.AuthorizationEndpoint),
+			TokenEndpoint:         aws.String(oidc.TokenEndpoint),
+			UserInfoEndpoint:      aws.String(oidc.UserInfoEndpoint),
+			ClientId:              aws.String(oidc.ClientId),
+			ClientSecret:          aws.String(clientSecret),
+		}
+		if oidc.Scope != "" {
+			config.SetScope(oidc.Scope)
+		}
+		if oidc.OnUnauthenticatedRequest != "" {
+			config.SetOnUnauthenticatedRequest(oidc.OnUnauthenticatedRequest)
+		}
+		actions = append(actions, &elbv2.Action{Type: aws.String(elbv2.ActionTypeEnumAuthenticateOidc), AuthenticateOidcConfig: config})
+	}
+	if cognito := action.AuthenticateCognito; cognito != nil {
+		config := &elbv2.AuthenticateCognitoActionConfig{
+			UserPoolArn:      aws.String(cognito.UserPoolArn),
+			UserPoolClientId: aws.String(cognito.UserPoolClientId),
+			UserPoolDomain:   aws.String(cognito.UserPoolDomain),
+		}
+		if cognito.Scope != "" {
+			config.SetScope(cognito.Scope)
+		}
+		if cognito.OnUnauthenticatedRequest != "" {
+			config.SetOnUnauthenticatedRequest(cognito.OnUnauthenticatedRequest)
+		}
+		actions = append(actions, &elbv2.Action{Type: aws.String(elbv2.ActionTypeEnumAuthenticateCognito), AuthenticateCognitoConfig: config})
+	}
+	switch strings.ToLower(action.Type) {
+	case elbv2.ActionTypeEnumFixedResponse:
+		config := &elbv2.FixedResponseActionConfig{StatusCode: aws.String(action.FixedResponse.StatusCode)}
+		if action.FixedResponse.ContentType != "" {
+			config.SetContentType(action.FixedResponse.ContentType)
+		}
+		if action.FixedResponse.MessageBody != "" {
+			config.SetMessageBody(action.FixedResponse.MessageBody)
+		}
+		actions = append(actions, &elbv2.Action{Type: aws.String(elbv2.ActionTypeEnumFixedResponse), FixedResponseConfig: config})
+	case elbv2.ActionTypeEnumRedirect:
+		redirect := action.Redirect
+		config := &elbv2.RedirectActionConfig{StatusCode: aws.String(redirect.StatusCode)}
+		if redirect.Protocol != "" {
+			config.SetProtocol(redirect.Protocol)
+		}
+		if redirect.Host != "" {
+			config.SetHost(redirect.Host)
+		}
+		if redirect.Port != "" {
+			config.SetPort(redirect.Port)
+		}
+		if redirect.Path != "" {
+			config.SetPath(redirect.Path)
+		}
+		if redirect.Query != "" {
+			config.SetQuery(redirect.Query)
+		}
+		actions = append(actions, &elbv2.Action{Type: aws.String(elbv2.ActionTypeEnumRedirect), RedirectConfig: config})
+	default:
+		actions = append(actions, &elbv2.Action{Type: aws.String(elbv2.ActionTypeEnumForward), TargetGroupArn: aws.String(targetGroupArn)})
+	}
+	// the authentication needs to happen before the last action
+	if len(actions) > 1 {
+		for i, action := range actions {
+			action.SetOrder(int64(i + 1))
+		}
+	}
+	return actions, nil
+}
+
+// GetRulesByServiceTag returns the rules that were created for the rule conditions of a service. The rules need to
+// be retrieved first with GetRulesForAllListeners
+func (a *ALB) GetRulesByServiceTag(serviceName string) ([]string, error) {
+	var result []string
+	var ruleArns []*string
+	for _, rules := range a.Rules {
+		for _, rule := range rules {
+			if !aws.BoolValue(rule.IsDefault) {
+				ruleArns = append(ruleArns, rule.RuleArn)
+			}
+		}
+	}
+	svc := getClients(a.Clients).ELBV2()
+	// the tags of up to 20 resources can be described at once
+	for i := 0; i < len(ruleArns); i += 20 {
+		end := i + 20
+		if end > len(ruleArns) {
+			end = len(ruleArns)
+		}
+		output, err := svc.DescribeTags(&elbv2.DescribeTagsInput{ResourceArns: ruleArns[i:end]})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				albLogger.Errorf(aerr.Error())
+			} else {
+				albLogger.Errorf(err.Error())
+			}
+			return nil, errors.New("Could not describe the tags of the alb rules")
+		}
+		for _, description := range output.TagDescriptions {
+			for _, tag := range description.Tags {
+				if aws.StringValue(tag.Key) == RuleServiceTag && aws.StringValue(tag.Value) == serviceName {
+					result = append(result, aws.StringValue(description.ResourceArn))
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// getRuleAuthenticateActions returns the authenticate actions of a rule, to keep them when the forward of the rule is
+// modified. The existing oidc client secret is reused, as it can't be described
+func (a *ALB) getRuleAuthenticateActions(ruleArn string) []*elbv2.Action {
+	var actions []*elbv2.Action
+	for _, rules := range a.Rules {
+		for _, rule := range rules {
+			if aws.StringValue(rule.RuleArn) != ruleArn {
+				continue
+			}
+			for _, action := range rule.Actions {
+				switch aws.StringValue(action.Type) {
+				case elbv2.ActionTypeEnumAuthenticateOidc:
+					action = awsutil.CopyOf(action).(*elbv2.Action)
+					if action.AuthenticateOidcConfig != nil {
+						action.AuthenticateOidcConfig.ClientSecret = nil
+						action.AuthenticateOidcConfig.SetUseExistingClientSecret(true)
+					}
+					actions = append(actions, action)
+				case elbv2.ActionTypeEnumAuthenticateCognito:
+					actions = append(actions, awsutil.CopyOf(action).(*elbv2.Action))
+				}
+			}
+		}
+	}
+	return actions
+}
+
+// ReconcileRules changes the rules of a service on the listeners to match the rule conditions. Rules with the same
+// conditions are kept and get the new actions, the other rules of the service get the changed conditions in place, the
+// rules that are left over are deleted and the missing rules are created. An existing forward is kept, as it can
+// forward to the other color or to the canary. Returns the listeners with rules of the service and whether rules of the
+// service were changed
+func (a *ALB) ReconcileRules(serviceName, targetGroupArn string, ruleConditions []*service.DeployRuleConditions) ([]string, bool, error) {
+	var listeners []string
+	var changed bool
+	err := a.GetRulesForAllListeners()
+	if err != nil {
+		return nil, false, err
+	}
+	serviceRules, err := a.getServiceRules(serviceName, targetGroupArn)
+	if err != nil {
+		return nil, false, err
+	}
+	forward := &elbv2.Action{Type: aws.String(elbv2.ActionTypeEnumForward), TargetGroupArn: aws.String(targetGroupArn)}
+	for _, rules := range a.Rules {
+		for _, rule := range rules {
+			if !serviceRules[aws.StringValue(rule.RuleArn)] {
+				continue
+			}
+			for _, action := range rule.Actions {
+				if aws.StringValue(action.Type) == elbv2.ActionTypeEnumForward {
+					forward = awsutil.CopyOf(action).(*elbv2.Action)
+				}
+			}
+		}
+	}
+	actions := make([][]*elbv2.Action, len(ruleConditions))
+	for i, r := range ruleConditions {
+		actions[i], err = a.GetRuleActions(targetGroupArn, r.Action)
+		if err != nil {
+			return nil, false, err
+		}
+		for j, action := range actions[i] {
+			if aws.StringValue(action.Type) == elbv2.ActionTypeEnumForward {
+				actions[i][j] = awsutil.CopyOf(forward).(*elbv2.Action)
+				actions[i][j].Order = action.Order
+			}
+		}
+	}
+
+	s := service.NewService()
+	s.ServiceName = serviceName
+	for _, l := range a.Listeners {
+		listenerArn := aws.StringValue(l.ListenerArn)
+		var owned []*elbv2.Rule
+		for _, rule := range a.Rules[listenerArn] {
+			if serviceRules[aws.StringValue(rule.RuleArn)] {
+				owned = append(owned, rule)
+			}
+		}
+		var requests []service.RulePriorityRequest
+		inputs := make(map[string]*elbv2.CreateRuleInput)
+		existing := make(map[string]*elbv2.Rule)
+		for i, r := range ruleConditions {
+			if !listenerHasProtocol(l, r.Listeners) {
+				continue
+			}
+			key := strconv.Itoa(i)
+			conditions := a.GetRuleConditions(r)
+			requests = append(requests, service.RulePriorityRequest{Key: key, Priority: r.Priority, Specificity: service.GetRuleSpecificity(r)})
+			inputs[key] = &elbv2.CreateRuleInput{
+				ListenerArn: l.ListenerArn,
+				Conditions:  conditions,
+				Actions:     actions[i],
+				Tags:        []*elbv2.Tag{{Key: aws.String(RuleServiceTag), Value: aws.String(serviceName)}},
+			}
+			for j, rule := range owned {
+				if rule != nil && ruleConditionsKey(rule.Conditions) == ruleConditionsKey(conditions) {
+					existing[key] = rule
+					owned[j] = nil
+					break
+				}
+			}
+		}
+		// the rules with conditions that changed get the new conditions in place, so the traffic keeps going to the
+		// service while the rules change
+		for _, request := range requests {
+			if _, ok := existing[request.Key]; ok {
+				continue
+			}
+			for j, rule := range owned {
+				if rule == nil {
+					continue
+				}
+				albLogger.Infof("Modifying the conditions of rule %v of service %v", aws.StringValue(rule.RuleArn), serviceName)
+				err = a.modifyRuleConditions(aws.StringValue(rule.RuleArn), inputs[request.Key].Conditions, inputs[request.Key].Actions)
+				if err != nil {
+					return nil, false, err
+				}
+				rule.Conditions = inputs[request.Key].Conditions
+				rule.Actions = inputs[request.Key].Actions
+				existing[request.Key] = rule
+				owned[j] = nil
+				changed = true
+				break
+			}
+		}
+		for _, rule := range owned {
+			if rule == nil {
+				continue
+			}
+			albLogger.Infof("Deleting rule %v of service %v, the conditions are not in the deploy anymore", aws.StringValue(rule.RuleArn), serviceName)
+			err = a.DeleteRule(aws.StringValue(rule.RuleArn))
+			if err != nil {
+				return nil, false, err
+			}
+			changed = true
+		}
+		for key, rule := range existing {
+			if ruleActionsKey(rule.Actions) != ruleActionsKey(inputs[key].Actions) {
+				albLogger.Infof("Modifying the actions of rule %v of service %v", aws.StringValue(rule.RuleArn), serviceName)
+				err = a.modifyRule(aws.StringValue(rule.RuleArn), inputs[key].Actions)
+				if err != nil {
+					return nil, false, err
+				}
+				changed = true
+			}
+		}
+		if len(requests) == 0 {
+			err = s.ReleaseRulePriorities(listenerArn)
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		created, err := a.createListenerRules(serviceName, listenerArn, requests, inputs, existing)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = changed || created
+		listeners = append(listeners, listenerArn)
+	}
+	return listeners, changed, nil
+}
+
+// modifyRuleConditions replaces the conditions and the actions of a rule
+func (a *ALB) modifyRuleConditions(ruleArn string, conditions []*elbv2.RuleCondition, actions []*elbv2.Action) error {
+	svc := getClients(a.Clients).ELBV2()
+	_, err := svc.ModifyRule(&elbv2.ModifyRuleInput{
+		RuleArn:    aws.String(ruleArn),
+		Conditions: conditions,
+		Actions:    actions,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			albLogger.Errorf(aerr.Error())
+		} else {
+			albLogger.Errorf(err.Error())
+		}
+		return errors.New("Could not modify alb rule")
+	}
+	return nil
+}
+
+// getServiceRules returns the arns of the rules of a service: the rules with the service tag and the rules that forward
+// to the target group, or to the green or canary target group. The rules need to be retrieved first with
+// GetRulesForAllListeners
+func (a *ALB) getServiceRules(serviceName, targetGroupArn string) (map[string]bool, error) {
+	result := make(map[string]bool)
+	targetGroupArns := []string{targetGroupArn}
+	for _, name := range []string{GetBlueGreenServiceName(serviceName, ColorGreen), GetCanaryServiceName(serviceName)} {
+		arn, err := a.FindTargetGroupArn(name)
+		if err != nil {
+			return nil, err
+		}
+		if arn != nil {
+			targetGroupArns = append(targetGroupArns, *arn)
+		}
+	}
+	for _, arn := range targetGroupArns {
+		for _, ruleArn := range a.GetRulesByTargetGroupArn(arn) {
+			result[ruleArn] = true
+		}
+	}
+	taggedRuleArns, err := a.GetRulesByServiceTag(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	for _, ruleArn := range taggedRuleArns {
+		result[ruleArn] = true
+	}
+	return result, nil
+}
+
+// ruleConditionsKey returns the same key for the same conditions, whether the values are set in Values or in the
+// config of the condition
+func ruleConditionsKey(conditions []*elbv2.RuleCondition) string {
+	var keys []string
+	for _, c := range conditions {
+		field := aws.StringValue(c.Field)
+		values := aws.StringValueSlice(c.Values)
+		switch field {
+		case "path-pattern":
+			if c.PathPatternConfig != nil {
+				values = append(values, aws.StringValueSlice(c.PathPatternConfig.Values)...)
+			}
+		case "host-header":
+			if c.HostHeaderConfig != nil {
+				values = append(values, aws.StringValueSlice(c.HostHeaderConfig.Values)...)
+			}
+			for i := range values {
+				values[i] = strings.ToLower(values[i])
+			}
+		case "http-header":
+			if c.HttpHeaderConfig != nil {
+				field += "/" + strings.ToLower(aws.StringValue(c.HttpHeaderConfig.HttpHeaderName))
+				values = append(values, aws.StringValueSlice(c.HttpHeaderConfig.Values)...)
+			}
+		case "query-string":
+			if c.QueryStringConfig != nil {
+				for _, pair := range c.QueryStringConfig.Values {
+					values = append(values, aws.StringValue(pair.Key)+"="+aws.StringValue(pair.Value))
+				}
+			}
+		case "http-request-method":
+			if c.HttpRequestMethodConfig != nil {
+				values = append(values, aws.StringValueSlice(c.HttpRequestMethodConfig.Values)...)
+			}
+		case "source-ip":
+			if c.SourceIpConfig != nil {
+				values = append(values, aws.StringValueSlice(c.SourceIpConfig.Values)...)
+			}
+		}
+		unique := make(map[string]bool)
+		var uniqueValues []string
+		for _, value := range values {
+			if !unique[value] {
+				unique[value] = true
+				uniqueValues = append(uniqueValues, value)
+			}
+		}
+		sort.Strings(uniqueValues)
+		keys = append(keys, field+":"+strings.Join(uniqueValues, ","))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ";")
+}
+
+// ruleActionsKey returns the same key for the same actions. The target group of a forward and the oidc client secret
+// are left out, the values that aws fills in when they are not set are used as default
+func ruleActionsKey(actions []*elbv2.Action) string {
+	var keys []string
+	for _, action := range actions {
+		actionType := aws.StringValue(action.Type)
+		fields := []string{actionType}
+		switch actionType {
+		case elbv2.ActionTypeEnumFixedResponse:
+			if c := action.FixedResponseConfig; c != nil {
+				fields = append(fields, aws.StringValue(c.StatusCode), aws.StringValue(c.ContentType), aws.StringValue(c.MessageBody))
+			}
+		case elbv2.ActionTypeEnumRedirect:
+			if c := action.RedirectConfig; c != nil {
+				fields = append(fields, aws.StringValue(c.StatusCode), stringValueOrDefault(c.Protocol, "#{protocol}"), stringValueOrDefault(c.Host, "#{host}"),
+					stringValueOrDefault(c.Port, "#{port}"), stringValueOrDefault(c.Path, "/#{path}"), stringValueOrDefault(c.Query, "#{query}"))
+			}
+		case elbv2.ActionTypeEnumAuthenticateOidc:
+			if c := action.AuthenticateOidcConfig; c != nil {
+				fields = append(fields, aws.StringValue(c.Issuer), aws.StringValue(c.AuthorizationEndpoint), aws.StringValue(c.TokenEndpoint),
+					aws.StringValue(c.UserInfoEndpoint), aws.StringValue(c.ClientId), stringValueOrDefault(c.Scope, "openid"),
+					stringValueOrDefault(c.OnUnauthenticatedRequest, elbv2.AuthenticateOidcActionConditionalBehaviorEnumAuthenticate))
+			}
+		case elbv2.ActionTypeEnumAuthenticateCognito:
+			if c := action.AuthenticateCognitoConfig; c != nil {
+				fields = append(fields, aws.StringValue(c.UserPoolArn), aws.StringValue(c.UserPoolClientId), aws.StringValue(c.UserPoolDomain),
+					stringValueOrDefault(c.Scope, "openid"), stringValueOrDefault(c.OnUnauthenticatedRequest, elbv2.AuthenticateCognitoActionConditionalBehaviorEnumAuthenticate))
+			}
+		}
+		keys = append(keys, strings.Join(fields, "|"))
+	}
+	return strings.Join(keys, ";")
+}
+
+func stringValueOrDefault(s *string, defaultValue string) string {
+	if aws.StringValue(s) == "" {
+		return defaultValue
+	}
+	return *s
+}
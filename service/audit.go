@@ -0,0 +1,84 @@
+package service
+
+import (
+	"github.com/in4it/ecs-deploy/util"
+
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outcomes of an audited api call
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// the summary of the request is truncated to this length
+const auditSummaryMaxLength = 4096
+
+// keys of the request of which the values are redacted in the summary, e.g. parameter and environment values
+var auditRedactedKeys = []string{"value", "password", "secret", "token", "credentials"}
+
+// PutAuditEntry stores the entry, the entry expires after AUDIT_RETENTION_DAYS
+func (s *Service) PutAuditEntry(e *DynamoAuditEntry) error {
+	days, err := strconv.Atoi(util.GetEnv("AUDIT_RETENTION_DAYS", "90"))
+	if err != nil {
+		s.logger().Errorf("Invalid AUDIT_RETENTION_DAYS, using 90 days: %v", err)
+		days = 90
+	}
+	e.Identifier = "__AUDIT"
+	e.ExpirationTimeTTL = e.Time.AddDate(0, 0, days).Unix()
+	return s.store.PutAuditEntry(e)
+}
+
+// GetAuditEntries returns the entries between from and to, newest first, optionally only of a service or a user
+func (s *Service) GetAuditEntries(from, to time.Time, serviceName, user string, limit int64) ([]DynamoAuditEntry, error) {
+	return s.store.GetAuditEntries(from, to, serviceName, user, limit)
+}
+
+// GetAuditSummary returns the json request with the secret values redacted. Requests that are not json are not
+// summarized, as they can't be redacted
+func GetAuditSummary(body []byte) string {
+	var request interface{}
+	if len(body) == 0 || json.Unmarshal(body, &request) != nil {
+		return ""
+	}
+	summary, err := json.Marshal(redactAuditValues(request))
+	if err != nil {
+		return ""
+	}
+	if len(summary) > auditSummaryMaxLength {
+		return string(summary[:auditSummaryMaxLength]) + "..."
+	}
+	return string(summary)
+}
+
+func redactAuditValues(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if isAuditRedactedKey(k) {
+				value[k] = "[redacted]"
+			} else {
+				value[k] = redactAuditValues(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactAuditValues(item)
+		}
+	}
+	return v
+}
+
+func isAuditRedactedKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, redacted := range auditRedactedKeys {
+		if strings.Contains(key, redacted) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetAuditSummary(t *testing.T) {
+	body := []byte(`{"name":"db-password","value":"hunter2","containers":[{"environment":[{"name":"A","value":"secret"}]}],"clientSecret":"x"}`)
+	expected := `{"clientSecret":"[redacted]","containers":[{"environment":[{"name":"A","value":"[redacted]"}]}],"name":"db-password","value":"[redacted]"}`
+	if summary := GetAuditSummary(body); summary != expected {
+		t.Errorf("Unexpected summary: %v", summary)
+	}
+	if summary := GetAuditSummary([]byte("not json")); summary != "" {
+		t.Errorf("Expected no summary for a request that is not json, got: %v", summary)
+	}
+}
+
+func TestAuditEntries(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	now := time.Now().UTC()
+	entries := []DynamoAuditEntry{
+		{Time: now.Add(-3 * time.Hour), User: "deploy", Services: []string{"a"}},
+		{Time: now.Add(-2 * time.Hour), User: "alice", Services: []string{"a", "b"}},
+		{Time: now.Add(-time.Hour), User: "deploy", Services: []string{"b"}},
+	}
+	for i := range entries {
+		if err := s.PutAuditEntry(&entries[i]); err != nil {
+			t.Fatalf("PutAuditEntry: %v", err)
+		}
+	}
+	tests := []struct {
+		from          time.Time
+		service, user string
+		expected      []string
+	}{
+		{now.Add(-24 * time.Hour), "", "", []string{"deploy", "alice", "deploy"}},
+		{now.Add(-24 * time.Hour), "a", "", []string{"alice", "deploy"}},
+		{now.Add(-24 * time.Hour), "b", "deploy", []string{"deploy"}},
+		{now.Add(-150 * time.Minute), "", "", []string{"deploy", "alice"}},
+	}
+	for _, test := range tests {
+		result, err := s.GetAuditEntries(test.from, now, test.service, test.user, 100)
+		if err != nil {
+			t.Fatalf("GetAuditEntries: %v", err)
+		}
+		var users []string
+		for _, e := range result {
+			users = append(users, e.User)
+		}
+		if len(users) != len(test.expected) {
+			t.Errorf("GetAuditEntries(%v, %v, %v): expected %v, got %v", test.from, test.service, test.user, test.expected, users)
+			continue
+		}
+		for i := range users {
+			if users[i] != test.expected[i] {
+				t.Errorf("GetAuditEntries(%v, %v, %v): expected %v, got %v", test.from, test.service, test.user, test.expected, users)
+			}
+		}
+	}
+
+	// expired entries are not returned
+	os.Setenv("AUDIT_RETENTION_DAYS", "1")
+	defer os.Unsetenv("AUDIT_RETENTION_DAYS")
+	if err := s.PutAuditEntry(&DynamoAuditEntry{Time: now.Add(-25 * time.Hour), User: "expired"}); err != nil {
+		t.Fatalf("PutAuditEntry: %v", err)
+	}
+	result, err := s.GetAuditEntries(now.Add(-48*time.Hour), now, "", "expired", 100)
+	if err != nil {
+		t.Fatalf("GetAuditEntries: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("Expected no expired entries, got: %+v", result)
+	}
+}
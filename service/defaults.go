@@ -1,6 +1,85 @@
 package service
 
+import (
+	"strconv"
+	"strings"
+)
+
 func SetDeployDefaults(d *Deploy) {
 	d.DeregistrationDelay = -1
 	d.Stickiness.Duration = -1
 }
+
+// IsFargate returns true if the tasks run on fargate, with the FARGATE launch type or the FARGATE and FARGATE_SPOT
+// capacity providers
+func IsFargate(d Deploy) bool {
+	if len(d.CapacityProviderStrategy) > 0 {
+		for _, item := range d.CapacityProviderStrategy {
+			if item.CapacityProvider != "FARGATE" && item.CapacityProvider != "FARGATE_SPOT" {
+				return false
+			}
+		}
+		return true
+	}
+	return strings.ToUpper(d.LaunchType) == "FARGATE"
+}
+
+// GetTaskSize returns the cpu units and memory of the task. When they are not set on the task, the sum of the
+// containers is used
+func GetTaskSize(d Deploy) (int64, int64) {
+	cpu, memory := d.CPU, d.Memory
+	for _, container := range d.Containers {
+		if d.CPU == 0 {
+			if container.CPU > 0 {
+				cpu += container.CPU
+			} else {
+				cpu += container.CPUReservation
+			}
+		}
+		if d.Memory == 0 {
+			if container.Memory > 0 {
+				memory += container.Memory
+			} else {
+				memory += container.MemoryReservation
+			}
+		}
+	}
+	return cpu, memory
+}
+
+// IsNetworkProtocol returns true if the protocol is served by a network load balancer, with a listener per service
+// instead of listener rules
+func IsNetworkProtocol(protocol string) bool {
+	switch strings.ToUpper(protocol) {
+	case "TCP", "UDP", "TCP_UDP", "TLS":
+		return true
+	}
+	return false
+}
+
+// GetLoadBalancerName returns the name of the load balancer of the service, which defaults to the name of the cluster
+func GetLoadBalancerName(d Deploy) string {
+	if d.LoadBalancer == "" {
+		return d.Cluster
+	}
+	return d.LoadBalancer
+}
+
+// GetLoadBalancerTargetGroupName returns the name of the target group of one of the loadBalancers of the service
+func GetLoadBalancerTargetGroupName(serviceName string, lb DeployLoadBalancer) string {
+	return serviceName + "-" + strconv.FormatInt(lb.ContainerPort, 10)
+}
+
+// GetLoadBalancerDeploy returns the deploy with the port, protocol, certificate, health check, rule conditions and load balancer
+// of one of the loadBalancers of the service, to create and update its target group like the target group of the service
+func GetLoadBalancerDeploy(d Deploy, lb DeployLoadBalancer) Deploy {
+	d.ServicePort = lb.ContainerPort
+	d.ServiceProtocol = lb.Protocol
+	d.HealthCheck = lb.HealthCheck
+	d.CertificateArn = lb.CertificateArn
+	d.RuleConditions = lb.RuleConditions
+	if lb.LoadBalancer != "" {
+		d.LoadBalancer = lb.LoadBalancer
+	}
+	return d
+}
@@ -9,45 +9,93 @@ type DeployServices struct {
 	Services []Deploy `json:"services" yaml:"services" binding:"required"`
 }
 type Deploy struct {
-	Cluster               string                      `json:"cluster" yaml:"cluster" binding:"required"`
-	LoadBalancer          string                      `json:"loadBalancer" yaml:"loadBalancer"`
-	ServiceName           string                      `json:"serviceName" yaml:"serviceName"`
-	ServicePort           int64                       `json:"servicePort" yaml:"servicePort"`
-	ServiceProtocol       string                      `json:"serviceProtocol" yaml:"serviceProtocol" binding:"required"`
-	DesiredCount          int64                       `json:"desiredCount" yaml:"desiredCount" binding:"required"`
-	MinimumHealthyPercent int64                       `json:"minimumHealthyPercent" yaml:"minimumHealthyPercent"`
-	MaximumPercent        int64                       `json:"maximumPercent" yaml:"maximumPercent"`
-	Containers            []*DeployContainer          `json:"containers" yaml:"containers" binding:"required,dive"`
-	HealthCheck           DeployHealthCheck           `json:"healthCheck" yaml:"healthCheck"`
-	RuleConditions        []*DeployRuleConditions     `json:"ruleConditions" yaml:"ruleConditions"`
-	NetworkMode           string                      `json:"networkMode" yaml:"networkMode"`
-	NetworkConfiguration  DeployNetworkConfiguration  `json:"networkConfiguration" yaml:"networkConfiguration"`
-	PlacementConstraints  []DeployPlacementConstraint `json:"placementConstraints" yaml:"placementConstraints"`
-	LaunchType            string                      `json:"launchType" yaml:"launchType"`
-	DeregistrationDelay   int64                       `json:"deregistrationDelay" yaml:"deregistrationDelay"`
-	Stickiness            DeployStickiness            `json:"stickiness" yaml:"stickiness"`
-	Volumes               []DeployVolume              `json:"volumes" yaml:"volumes"`
-	EnvNamespace          string                      `json:"envNamespace" yaml:"envNamespace"`
+	Cluster                  string                               `json:"cluster" yaml:"cluster" binding:"required"`
+	LoadBalancer             string                               `json:"loadBalancer" yaml:"loadBalancer"`
+	ServiceName              string                               `json:"serviceName" yaml:"serviceName"`
+	ServicePort              int64                                `json:"servicePort" yaml:"servicePort"`
+	ServiceProtocol          string                               `json:"serviceProtocol" yaml:"serviceProtocol" binding:"required"`
+	CertificateArn           string                               `json:"certificateArn" yaml:"certificateArn"`
+	DesiredCount             int64                                `json:"desiredCount" yaml:"desiredCount" binding:"required"`
+	MinimumHealthyPercent    int64                                `json:"minimumHealthyPercent" yaml:"minimumHealthyPercent"`
+	MaximumPercent           int64                                `json:"maximumPercent" yaml:"maximumPercent"`
+	Containers               []*DeployContainer                   `json:"containers" yaml:"containers" binding:"required,dive"`
+	HealthCheck              DeployHealthCheck                    `json:"healthCheck" yaml:"healthCheck"`
+	RuleConditions           []*DeployRuleConditions              `json:"ruleConditions" yaml:"ruleConditions"`
+	NetworkMode              string                               `json:"networkMode" yaml:"networkMode"`
+	NetworkConfiguration     DeployNetworkConfiguration           `json:"networkConfiguration" yaml:"networkConfiguration"`
+	PlacementConstraints     []DeployPlacementConstraint          `json:"placementConstraints" yaml:"placementConstraints"`
+	LaunchType               string                               `json:"launchType" yaml:"launchType"`
+	CapacityProviderStrategy []DeployCapacityProviderStrategyItem `json:"capacityProviderStrategy" yaml:"capacityProviderStrategy"`
+	PlacementStrategy        []DeployPlacementStrategy            `json:"placementStrategy" yaml:"placementStrategy"`
+	PlatformVersion          string                               `json:"platformVersion" yaml:"platformVersion"`
+	CPU                      int64                                `json:"cpu" yaml:"cpu"`
+	Memory                   int64                                `json:"memory" yaml:"memory"`
+	DeregistrationDelay      int64                                `json:"deregistrationDelay" yaml:"deregistrationDelay"`
+	Stickiness               DeployStickiness                     `json:"stickiness" yaml:"stickiness"`
+	Volumes                  []DeployVolume                       `json:"volumes" yaml:"volumes"`
+	EnvNamespace             string                               `json:"envNamespace" yaml:"envNamespace"`
+	DeploymentStrategy       string                               `json:"deploymentStrategy" yaml:"deploymentStrategy"`
+	BakeTime                 int64                                `json:"bakeTime" yaml:"bakeTime"`
+	Canary                   DeployCanary                         `json:"canary" yaml:"canary"`
+	HealthGates              DeployHealthGates                    `json:"healthGates" yaml:"healthGates"`
+	DependsOn                []string                             `json:"dependsOn" yaml:"dependsOn"`
+	LoadBalancers            []DeployLoadBalancer                 `json:"loadBalancers" yaml:"loadBalancers"`
+}
+type DeployLoadBalancer struct {
+	ContainerName  string                  `json:"containerName" yaml:"containerName"`
+	ContainerPort  int64                   `json:"containerPort" yaml:"containerPort"`
+	Protocol       string                  `json:"protocol" yaml:"protocol"`
+	CertificateArn string                  `json:"certificateArn" yaml:"certificateArn"`
+	LoadBalancer   string                  `json:"loadBalancer" yaml:"loadBalancer"`
+	HealthCheck    DeployHealthCheck       `json:"healthCheck" yaml:"healthCheck"`
+	RuleConditions []*DeployRuleConditions `json:"ruleConditions" yaml:"ruleConditions"`
 }
 type DeployContainer struct {
-	ContainerName       string                        `json:"containerName" yaml:"containerName" binding:"required"`
-	ContainerTag        string                        `json:"containerTag" yaml:"containerTag" binding:"required"`
-	ContainerPort       int64                         `json:"containerPort" yaml:"containerPort"`
-	ContainerCommand    []*string                     `json:"containerCommand" yaml:"containerCommand"`
-	ContainerImage      string                        `json:"containerImage" yaml:"containerImage"`
-	ContainerURI        string                        `json:"containerURI" yaml:"containerURI"`
-	ContainerEntryPoint []*string                     `json:"containerEntryPoint" yaml:"containerEntryPoint"`
-	Essential           bool                          `json:"essential" yaml:"essential"`
-	Memory              int64                         `json:"memory" yaml:"memory"`
-	MemoryReservation   int64                         `json:"memoryReservation" yaml:"memoryReservation"`
-	CPU                 int64                         `json:"cpu" yaml:"cpu"`
-	CPUReservation      int64                         `json:"cpuReservation" yaml:"cpuReservation"`
-	DockerLabels        map[string]string             `json:"dockerLabels" yaml:"dockerLabels"`
-	HealthCheck         DeployContainerHealthCheck    `json:"healthCheck" yaml:"healthCheck"`
-	Environment         []*DeployContainerEnvironment `json:"environment" yaml:"environment"`
-	MountPoints         []*DeployContainerMountPoint  `json:"mountPoints" yaml:"mountPoints"`
-	Ulimits             []*DeployContainerUlimit      `json:"ulimits" yaml:"ulimits"`
-	Links               []*string                     `json:"links" yaml:"links"`
+	ContainerName         string                               `json:"containerName" yaml:"containerName" binding:"required"`
+	ContainerTag          string                               `json:"containerTag" yaml:"containerTag" binding:"required"`
+	ContainerPort         int64                                `json:"containerPort" yaml:"containerPort"`
+	ContainerCommand      []*string                            `json:"containerCommand" yaml:"containerCommand"`
+	ContainerImage        string                               `json:"containerImage" yaml:"containerImage"`
+	ContainerURI          string                               `json:"containerURI" yaml:"containerURI"`
+	ContainerEntryPoint   []*string                            `json:"containerEntryPoint" yaml:"containerEntryPoint"`
+	Essential             bool                                 `json:"essential" yaml:"essential"`
+	Memory                int64                                `json:"memory" yaml:"memory"`
+	MemoryReservation     int64                                `json:"memoryReservation" yaml:"memoryReservation"`
+	CPU                   int64                                `json:"cpu" yaml:"cpu"`
+	CPUReservation        int64                                `json:"cpuReservation" yaml:"cpuReservation"`
+	DockerLabels          map[string]string                    `json:"dockerLabels" yaml:"dockerLabels"`
+	HealthCheck           DeployContainerHealthCheck           `json:"healthCheck" yaml:"healthCheck"`
+	Environment           []*DeployContainerEnvironment        `json:"environment" yaml:"environment"`
+	MountPoints           []*DeployContainerMountPoint         `json:"mountPoints" yaml:"mountPoints"`
+	Ulimits               []*DeployContainerUlimit             `json:"ulimits" yaml:"ulimits"`
+	Links                 []*string                            `json:"links" yaml:"links"`
+	Secrets               []*DeployContainerSecret             `json:"secrets" yaml:"secrets"`
+	RepositoryCredentials DeployContainerRepositoryCredentials `json:"repositoryCredentials" yaml:"repositoryCredentials"`
+	PortMappings          []*DeployContainerPortMapping        `json:"portMappings" yaml:"portMappings"`
+	DependsOn             []*DeployContainerDependency         `json:"dependsOn" yaml:"dependsOn"`
+	StartTimeout          int64                                `json:"startTimeout" yaml:"startTimeout"`
+	StopTimeout           int64                                `json:"stopTimeout" yaml:"stopTimeout"`
+	FirelensConfiguration DeployContainerFirelensConfiguration `json:"firelensConfiguration" yaml:"firelensConfiguration"`
+}
+type DeployContainerPortMapping struct {
+	ContainerPort int64  `json:"containerPort" yaml:"containerPort"`
+	HostPort      int64  `json:"hostPort" yaml:"hostPort"`
+	Protocol      string `json:"protocol" yaml:"protocol"`
+}
+type DeployContainerDependency struct {
+	ContainerName string `json:"containerName" yaml:"containerName"`
+	Condition     string `json:"condition" yaml:"condition"`
+}
+type DeployContainerFirelensConfiguration struct {
+	Type    string            `json:"type" yaml:"type"`
+	Options map[string]string `json:"options" yaml:"options"`
+}
+type DeployContainerRepositoryCredentials struct {
+	CredentialsParameter string `json:"credentialsParameter" yaml:"credentialsParameter"`
+}
+type DeployContainerSecret struct {
+	Name      string `json:"name" yaml:"name"`
+	ValueFrom string `json:"valueFrom" yaml:"valueFrom"`
 }
 type DeployContainerUlimit struct {
 	Name      string `json:"name" yaml:"name"`
@@ -79,6 +127,15 @@ type DeployPlacementConstraint struct {
 	Expression string `json:"expression" yaml:"expression"`
 	Type       string `json:"type" yaml:"type"`
 }
+type DeployPlacementStrategy struct {
+	Field string `json:"field" yaml:"field"`
+	Type  string `json:"type" yaml:"type"`
+}
+type DeployCapacityProviderStrategyItem struct {
+	CapacityProvider string `json:"capacityProvider" yaml:"capacityProvider"`
+	Weight           int64  `json:"weight" yaml:"weight"`
+	Base             int64  `json:"base" yaml:"base"`
+}
 type DeployHealthCheck struct {
 	HealthyThreshold   int64  `json:"healthyThreshold" yaml:"healthyThreshold"`
 	UnhealthyThreshold int64  `json:"unhealthyThreshold" yaml:"unhealthyThreshold"`
@@ -91,14 +148,87 @@ type DeployHealthCheck struct {
 	GracePeriodSeconds int64  `json:"gracePeriodSeconds" yaml:"gracePeriodSeconds"`
 }
 type DeployRuleConditions struct {
-	Listeners   []string `json:"listeners" yaml:"listeners"`
-	PathPattern string   `json:"pathPattern" yaml:"pathPattern"`
-	Hostname    string   `json:"hostname" yaml:"hostname"`
+	Listeners          []string                `json:"listeners" yaml:"listeners"`
+	PathPattern        string                  `json:"pathPattern" yaml:"pathPattern"`
+	Hostname           string                  `json:"hostname" yaml:"hostname"`
+	PathPatterns       []string                `json:"pathPatterns" yaml:"pathPatterns"`
+	Hostnames          []string                `json:"hostnames" yaml:"hostnames"`
+	HttpHeaders        []DeployRuleHttpHeader  `json:"httpHeaders" yaml:"httpHeaders"`
+	QueryStrings       []DeployRuleQueryString `json:"queryStrings" yaml:"queryStrings"`
+	HttpRequestMethods []string                `json:"httpRequestMethods" yaml:"httpRequestMethods"`
+	SourceIps          []string                `json:"sourceIps" yaml:"sourceIps"`
+	Action             DeployRuleAction        `json:"action" yaml:"action"`
+	Priority           int64                   `json:"priority" yaml:"priority"`
+}
+type DeployRuleHttpHeader struct {
+	Name   string   `json:"name" yaml:"name"`
+	Values []string `json:"values" yaml:"values"`
+}
+type DeployRuleQueryString struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+}
+type DeployRuleAction struct {
+	Type                string                         `json:"type" yaml:"type"`
+	FixedResponse       DeployRuleFixedResponse        `json:"fixedResponse" yaml:"fixedResponse"`
+	Redirect            DeployRuleRedirect             `json:"redirect" yaml:"redirect"`
+	AuthenticateOidc    *DeployRuleAuthenticateOidc    `json:"authenticateOidc" yaml:"authenticateOidc"`
+	AuthenticateCognito *DeployRuleAuthenticateCognito `json:"authenticateCognito" yaml:"authenticateCognito"`
+}
+type DeployRuleFixedResponse struct {
+	StatusCode  string `json:"statusCode" yaml:"statusCode"`
+	ContentType string `json:"contentType" yaml:"contentType"`
+	MessageBody string `json:"messageBody" yaml:"messageBody"`
+}
+type DeployRuleRedirect struct {
+	Protocol   string `json:"protocol" yaml:"protocol"`
+	Host       string `json:"host" yaml:"host"`
+	Port       string `json:"port" yaml:"port"`
+	Path       string `json:"path" yaml:"path"`
+	Query      string `json:"query" yaml:"query"`
+	StatusCode string `json:"statusCode" yaml:"statusCode"`
+}
+type DeployRuleAuthenticateOidc struct {
+	Issuer                   string `json:"issuer" yaml:"issuer"`
+	AuthorizationEndpoint    string `json:"authorizationEndpoint" yaml:"authorizationEndpoint"`
+	TokenEndpoint            string `json:"tokenEndpoint" yaml:"tokenEndpoint"`
+	UserInfoEndpoint         string `json:"userInfoEndpoint" yaml:"userInfoEndpoint"`
+	ClientId                 string `json:"clientId" yaml:"clientId"`
+	ClientSecretParameter    string `json:"clientSecretParameter" yaml:"clientSecretParameter"`
+	Scope                    string `json:"scope" yaml:"scope"`
+	OnUnauthenticatedRequest string `json:"onUnauthenticatedRequest" yaml:"onUnauthenticatedRequest"`
+}
+type DeployRuleAuthenticateCognito struct {
+	UserPoolArn              string `json:"userPoolArn" yaml:"userPoolArn"`
+	UserPoolClientId         string `json:"userPoolClientId" yaml:"userPoolClientId"`
+	UserPoolDomain           string `json:"userPoolDomain" yaml:"userPoolDomain"`
+	Scope                    string `json:"scope" yaml:"scope"`
+	OnUnauthenticatedRequest string `json:"onUnauthenticatedRequest" yaml:"onUnauthenticatedRequest"`
 }
 type DeployStickiness struct {
 	Enabled  bool  `json:"enabled" yaml:"enabled"`
 	Duration int64 `json:"duration" yaml:"duration"`
 }
+type DeployCanary struct {
+	Steps        []int64 `json:"steps" yaml:"steps"`
+	Interval     int64   `json:"interval" yaml:"interval"`
+	MaxErrorRate float64 `json:"maxErrorRate" yaml:"maxErrorRate"`
+	MaxLatency   float64 `json:"maxLatency" yaml:"maxLatency"`
+}
+type DeployHealthGates struct {
+	Window   int64                    `json:"window" yaml:"window"`
+	Interval int64                    `json:"interval" yaml:"interval"`
+	Alarms   []string                 `json:"alarms" yaml:"alarms"`
+	Metrics  []DeployHealthGateMetric `json:"metrics" yaml:"metrics"`
+}
+type DeployHealthGateMetric struct {
+	Namespace          string            `json:"namespace" yaml:"namespace"`
+	MetricName         string            `json:"metricName" yaml:"metricName"`
+	Dimensions         map[string]string `json:"dimensions" yaml:"dimensions"`
+	Statistic          string            `json:"statistic" yaml:"statistic"`
+	ComparisonOperator string            `json:"comparisonOperator" yaml:"comparisonOperator"`
+	Threshold          float64           `json:"threshold" yaml:"threshold"`
+}
 type DeployVolume struct {
 	Host                      DeployVolumeHost                      `json:"host" yaml:"host"`
 	DockerVolumeConfiguration DeployVolumeDockerVolumeConfiguration `json:"dockerVolumeConfiguration" yaml:"dockerVolumeConfiguration"`
@@ -122,6 +252,26 @@ type DeployResult struct {
 	Status            string    `json:"status" yaml:"status"`
 	DeployError       string    `json:"deployError" yaml:"deployError"`
 	DeploymentTime    time.Time `json:"deploymentTime" yaml:"deploymentTime"`
+	CanaryWeight      int64     `json:"canaryWeight" yaml:"canaryWeight"`
+}
+type DeployEvent struct {
+	Time        time.Time `json:"time" yaml:"time"`
+	Type        string    `json:"type" yaml:"type"`
+	ServiceName string    `json:"serviceName" yaml:"serviceName"`
+	Message     string    `json:"message" yaml:"message"`
+	Status      string    `json:"status" yaml:"status"`
+}
+type DeployPlan struct {
+	ServiceName string             `json:"serviceName" yaml:"serviceName"`
+	Action      string             `json:"action" yaml:"action"`
+	Changes     []DeployPlanChange `json:"changes" yaml:"changes"`
+	Warnings    []string           `json:"warnings" yaml:"warnings"`
+}
+type DeployPlanChange struct {
+	Field  string `json:"field" yaml:"field"`
+	Before string `json:"before" yaml:"before"`
+	After  string `json:"after" yaml:"after"`
+	Effect string `json:"effect" yaml:"effect"`
 }
 type DeployServiceParameter struct {
 	Name      string `json:"name" yaml:"name" binding:"required"`
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+
+	"time"
+)
+
+// DynamoStore stores everything in a single DynamoDB table
+type DynamoStore struct {
+	db        *dynamo.DB
+	table     dynamo.Table
+	tableName string
+}
+
+func NewDynamoStore(tableName string) *DynamoStore {
+	d := DynamoStore{tableName: tableName}
+	d.db = dynamo.New(session.New(), &aws.Config{})
+	d.table = d.db.Table(tableName)
+	return &d
+}
+
+// convertError logs the AWS error and converts the not found and conditional check errors
+func (d *DynamoStore) convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == dynamo.ErrNotFound {
+		return ErrNoItemFound
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case dynamodb.ErrCodeConditionalCheckFailedException:
+			return ErrConditionalCheckFailed
+		case dynamodb.ErrCodeProvisionedThroughputExceededException:
+			serviceLogger.Errorf(dynamodb.ErrCodeProvisionedThroughputExceededException, aerr.Error())
+		case dynamodb.ErrCodeResourceNotFoundException:
+			serviceLogger.Errorf(dynamodb.ErrCodeResourceNotFoundException, aerr.Error())
+		case dynamodb.ErrCodeInternalServerError:
+			serviceLogger.Errorf(dynamodb.ErrCodeInternalServerError, aerr.Error())
+		case "ValidationException":
+			serviceLogger.Errorf("%v", aerr.Error())
+		default:
+			serviceLogger.Errorf(aerr.Error())
+		}
+	} else {
+		serviceLogger.Errorf(err.Error())
+	}
+	return err
+}
+
+func (d *DynamoStore) CreateTable() error {
+	err := d.db.CreateTable(d.tableName, DynamoDeployment{}).
+		Provision(2, 1).
+		ProvisionIndex("DayIndex", 1, 1).
+		ProvisionIndex("MonthIndex", 1, 1).
+		Run()
+	if err != nil {
+		return err
+	}
+
+	d.table = d.db.Table(d.tableName)
+	return nil
+}
+
+func (d *DynamoStore) GetServices(ds *DynamoServices) error {
+	err := d.table.Get("ServiceName", "__SERVICES").Range("Time", dynamo.Equal, "0").One(ds)
+	return d.convertError(err)
+}
+func (d *DynamoStore) PutServices(ds *DynamoServices) error {
+	return d.convertError(d.table.Put(ds).Run())
+}
+func (d *DynamoStore) PutServicesIfVersion(ds *DynamoServices, version int64) error {
+	return d.convertError(d.table.Put(ds).If("$ = ?", "Version", version).Run())
+}
+
+func (d *DynamoStore) GetDeployment(serviceName string, t time.Time) (*DynamoDeployment, error) {
+	var dd DynamoDeployment
+	err := d.table.Get("ServiceName", serviceName).Range("Time", dynamo.Equal, t).Limit(1).One(&dd)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &dd, nil
+}
+func (d *DynamoStore) GetDeploymentsForService(serviceName string, limit int64) ([]DynamoDeployment, error) {
+	var dds []DynamoDeployment
+	err := d.table.Get("ServiceName", serviceName).Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(limit).All(&dds)
+	return dds, d.convertError(err)
+}
+func (d *DynamoStore) GetDeploymentsByDay(day string, limit int64) ([]DynamoDeployment, error) {
+	var dds []DynamoDeployment
+	err := d.table.Get("Day", day).Index("DayIndex").Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(limit).All(&dds)
+	return dds, d.convertError(err)
+}
+func (d *DynamoStore) GetDeploymentsByMonth(month string, limit int64) ([]DynamoDeployment, error) {
+	var dds []DynamoDeployment
+	err := d.table.Get("Month", month).Index("MonthIndex").Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(limit).All(&dds)
+	return dds, d.convertError(err)
+}
+func (d *DynamoStore) PutDeployment(dd *DynamoDeployment) error {
+	return d.convertError(d.table.Put(dd).Run())
+}
+func (d *DynamoStore) PutDeploymentIfVersion(dd *DynamoDeployment, version int64) error {
+	return d.convertError(d.table.Put(dd).If("$ = ?", "Version", version).Run())
+}
+func (d *DynamoStore) PutDeploymentIfStatus(dd *DynamoDeployment, status string) error {
+	return d.convertError(d.table.Put(dd).If("$ = ?", "Status", status).Run())
+}
+
+func (d *DynamoStore) GetLastClusterInfo() (*DynamoCluster, error) {
+	var dc DynamoCluster
+	err := d.table.Get("ServiceName", "__CLUSTERS").Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(1).One(&dc)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &dc, nil
+}
+func (d *DynamoStore) GetClusterInfoSince(startTime time.Time) ([]DynamoCluster, error) {
+	var dcs []DynamoCluster
+	err := d.table.Get("ServiceName", "__CLUSTERS").Range("Time", dynamo.GreaterOrEqual, startTime).All(&dcs)
+	return dcs, d.convertError(err)
+}
+func (d *DynamoStore) PutClusterInfo(dc *DynamoCluster) error {
+	return d.convertError(d.table.Put(dc).Run())
+}
+
+func (d *DynamoStore) PutAutoscalingPullIfNotExists(p *DynamoAutoscalingPull) error {
+	return d.convertError(d.table.Put(p).If("attribute_not_exists(L)").Run())
+}
+func (d *DynamoStore) PutAutoscalingPullIfExpired(p *DynamoAutoscalingPull, expiredBefore time.Time) error {
+	return d.convertError(d.table.Put(p).If("$ < ?", "LT", expiredBefore).Run())
+}
+
+func (d *DynamoStore) GetDeployLock(serviceName string) (*DynamoDeployLock, error) {
+	var l DynamoDeployLock
+	err := d.table.Get("ServiceName", "__DEPLOYLOCK").Range("Time", dynamo.Equal, serviceName).One(&l)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &l, nil
+}
+
+// PutDeployLockIfHolder writes the lock if it's held by holder, an empty holder means the lock is free
+func (d *DynamoStore) PutDeployLockIfHolder(l *DynamoDeployLock, holder string) error {
+	if holder == "" {
+		return d.convertError(d.table.Put(l).If("attribute_not_exists(L)").Run())
+	}
+	return d.convertError(d.table.Put(l).If("$ = ?", "L", holder).Run())
+}
+
+func (d *DynamoStore) GetRulePriorities(listenerArn string) (*DynamoRulePriorities, error) {
+	var rp DynamoRulePriorities
+	err := d.table.Get("ServiceName", "__RULEPRIORITIES").Range("Time", dynamo.Equal, listenerArn).One(&rp)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &rp, nil
+}
+
+// PutRulePrioritiesIfVersion writes the rule priorities if the stored version matches, version 0 means no record yet
+func (d *DynamoStore) PutRulePrioritiesIfVersion(rp *DynamoRulePriorities, version int64) error {
+	if version == 0 {
+		return d.convertError(d.table.Put(rp).If("attribute_not_exists(Version)").Run())
+	}
+	return d.convertError(d.table.Put(rp).If("$ = ?", "Version", version).Run())
+}
+
+func (d *DynamoStore) GetRBAC() (*DynamoRBAC, error) {
+	var r DynamoRBAC
+	err := d.table.Get("ServiceName", "__RBAC").Range("Time", dynamo.Equal, "0").One(&r)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &r, nil
+}
+
+// PutRBACIfVersion writes the rbac if the stored version matches, version 0 means no record yet
+func (d *DynamoStore) PutRBACIfVersion(r *DynamoRBAC, version int64) error {
+	if version == 0 {
+		return d.convertError(d.table.Put(r).If("attribute_not_exists(Version)").Run())
+	}
+	return d.convertError(d.table.Put(r).If("$ = ?", "Version", version).Run())
+}
+
+func (d *DynamoStore) GetAPITokens() ([]DynamoAPIToken, error) {
+	var tokens []DynamoAPIToken
+	err := d.table.Get("ServiceName", "__APITOKENS").All(&tokens)
+	return tokens, d.convertError(err)
+}
+func (d *DynamoStore) GetAPIToken(hash string) (*DynamoAPIToken, error) {
+	var t DynamoAPIToken
+	err := d.table.Get("ServiceName", "__APITOKENS").Range("Time", dynamo.Equal, hash).One(&t)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &t, nil
+}
+func (d *DynamoStore) PutAPITokenIfNotExists(t *DynamoAPIToken) error {
+	return d.convertError(d.table.Put(t).If("attribute_not_exists(ServiceName)").Run())
+}
+
+// PutAPITokenLastUsed updates the last used time, a revoked token is not recreated
+func (d *DynamoStore) PutAPITokenLastUsed(hash string, lastUsed time.Time) error {
+	return d.convertError(d.table.Update("ServiceName", "__APITOKENS").Range("Time", hash).Set("LastUsed", lastUsed).If("attribute_exists(ServiceName)").Run())
+}
+func (d *DynamoStore) DeleteAPIToken(hash string) error {
+	return d.convertError(d.table.Delete("ServiceName", "__APITOKENS").Range("Time", hash).Run())
+}
+
+func (d *DynamoStore) GetWaveDeploy(id string) (*DynamoWaveDeploy, error) {
+	var w DynamoWaveDeploy
+	err := d.table.Get("ServiceName", "__WAVEDEPLOYS").Range("Time", dynamo.Equal, id).One(&w)
+	if err != nil {
+		return nil, d.convertError(err)
+	}
+	return &w, nil
+}
+
+// GetWaveDeploysSince returns the wave deploys with an id from the given id, oldest first
+func (d *DynamoStore) GetWaveDeploysSince(id string) ([]DynamoWaveDeploy, error) {
+	var waveDeploys []DynamoWaveDeploy
+	err := d.table.Get("ServiceName", "__WAVEDEPLOYS").Range("Time", dynamo.GreaterOrEqual, id).All(&waveDeploys)
+	return waveDeploys, d.convertError(err)
+}
+
+// PutWaveDeployIfVersion writes the wave deploy if the stored version matches, version 0 means no record yet
+func (d *DynamoStore) PutWaveDeployIfVersion(w *DynamoWaveDeploy, version int64) error {
+	if version == 0 {
+		return d.convertError(d.table.Put(w).If("attribute_not_exists(Version)").Run())
+	}
+	return d.convertError(d.table.Put(w).If("$ = ?", "Version", version).Run())
+}
+
+func (d *DynamoStore) PutAuditEntry(e *DynamoAuditEntry) error {
+	return d.convertError(d.table.Put(e).Run())
+}
+
+// GetAuditEntries returns the entries between from and to, newest first. The expired entries that are not removed by
+// the ttl yet are skipped
+func (d *DynamoStore) GetAuditEntries(from, to time.Time, serviceName, user string, limit int64) ([]DynamoAuditEntry, error) {
+	var entries []DynamoAuditEntry
+	q := d.table.Get("ServiceName", "__AUDIT").Range("Time", dynamo.Between, from, to).Order(dynamo.Descending).Filter("$ > ?", "ExpirationTimeTTL", time.Now().Unix())
+	if serviceName != "" {
+		q = q.Filter("contains($, ?)", "Services", serviceName)
+	}
+	if user != "" {
+		q = q.Filter("$ = ?", "User", user)
+	}
+	err := q.Limit(limit).All(&entries)
+	return entries, d.convertError(err)
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/in4it/ecs-deploy/util"
+	"github.com/juju/loggo"
+)
+
+var accountId *string
+
+const noAWSMsg = "AWS Credentials not found - test skipped"
+
+func init() {
+	// set logging to debug
+	if util.GetEnv("DEBUG", "") == "true" {
+		loggo.ConfigureLoggers(`<root>=DEBUG`)
+	}
+	// check AWS access first
+	svc := sts.New(session.New())
+	result, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return
+	}
+	accountId = result.Account
+}
@@ -0,0 +1,493 @@
+package service
+
+import (
+	bolt "go.etcd.io/bbolt"
+
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LocalStore stores everything in a local BoltDB file
+//
+// Every hash key (service name, __SERVICES, __CLUSTERS, ...) gets its own bucket,
+// the range key is used as key within the bucket. Times are formatted with a fixed
+// width, so the keys are sorted in time order.
+type LocalStore struct {
+	db *bolt.DB
+}
+
+const localStoreTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// a bolt file can only be opened once, so local stores are shared within the process
+var localStores = make(map[string]*LocalStore)
+var localStoresMu sync.Mutex
+
+func NewLocalStore(path string) (*LocalStore, error) {
+	localStoresMu.Lock()
+	defer localStoresMu.Unlock()
+
+	if l, ok := localStores[path]; ok {
+		return l, nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		serviceLogger.Errorf("Couldn't open local store %v: %v", path, err.Error())
+		return nil, err
+	}
+	l := &LocalStore{db: db}
+	localStores[path] = l
+	return l, nil
+}
+
+func (l *LocalStore) timeKey(t time.Time) []byte {
+	return []byte(t.UTC().Format(localStoreTimeLayout))
+}
+
+func (l *LocalStore) get(bucket string, key []byte, v interface{}) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNoItemFound
+		}
+		data := b.Get(key)
+		if data == nil {
+			return ErrNoItemFound
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+// put writes v, when condition is set it has to return true for the existing item (nil when not found)
+func (l *LocalStore) put(bucket string, key []byte, v interface{}, condition func(existing []byte) (bool, error)) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		if condition != nil {
+			ok, err := condition(b.Get(key))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrConditionalCheckFailed
+			}
+		}
+		return b.Put(key, data)
+	})
+}
+
+func (l *LocalStore) delete(bucket string, key []byte) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete(key)
+	})
+}
+
+// versionCondition checks whether the existing item has the given version
+func (l *LocalStore) versionCondition(version int64) func([]byte) (bool, error) {
+	return func(existing []byte) (bool, error) {
+		if existing == nil {
+			return false, nil
+		}
+		var item struct{ Version int64 }
+		if err := json.Unmarshal(existing, &item); err != nil {
+			return false, err
+		}
+		return item.Version == version, nil
+	}
+}
+
+// forEachDescending calls fn for every item in the bucket, newest first, until fn returns false
+func (l *LocalStore) forEachDescending(bucket string, fn func(k, v []byte) (bool, error)) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			cont, err := fn(k, v)
+			if err != nil {
+				return err
+			}
+			if !cont {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (l *LocalStore) CreateTable() error {
+	// buckets are created when the first item is written
+	return nil
+}
+
+func (l *LocalStore) GetServices(ds *DynamoServices) error {
+	return l.get("__SERVICES", []byte("0"), ds)
+}
+func (l *LocalStore) PutServices(ds *DynamoServices) error {
+	return l.put("__SERVICES", []byte("0"), ds, nil)
+}
+func (l *LocalStore) PutServicesIfVersion(ds *DynamoServices, version int64) error {
+	return l.put("__SERVICES", []byte("0"), ds, l.versionCondition(version))
+}
+
+func (l *LocalStore) GetDeployment(serviceName string, t time.Time) (*DynamoDeployment, error) {
+	var dd DynamoDeployment
+	err := l.get(serviceName, l.timeKey(t), &dd)
+	if err != nil {
+		return nil, err
+	}
+	return &dd, nil
+}
+func (l *LocalStore) GetDeploymentsForService(serviceName string, limit int64) ([]DynamoDeployment, error) {
+	var dds []DynamoDeployment
+	now := l.timeKey(time.Now())
+	err := l.forEachDescending(serviceName, func(k, v []byte) (bool, error) {
+		if bytes.Compare(k, now) > 0 {
+			return true, nil
+		}
+		var dd DynamoDeployment
+		if err := json.Unmarshal(v, &dd); err != nil {
+			return false, err
+		}
+		dds = append(dds, dd)
+		return int64(len(dds)) < limit, nil
+	})
+	return dds, err
+}
+
+// getDeploymentsByIndex scans the deployments of all services, this replaces the secondary indexes of DynamoDB
+func (l *LocalStore) getDeploymentsByIndex(match func(dd DynamoDeployment) bool, limit int64) ([]DynamoDeployment, error) {
+	var dds []DynamoDeployment
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if strings.HasPrefix(string(name), "__") {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				var dd DynamoDeployment
+				if err := json.Unmarshal(v, &dd); err != nil {
+					return err
+				}
+				if match(dd) {
+					dds = append(dds, dd)
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dds, func(i, j int) bool {
+		return dds[i].Time.After(dds[j].Time)
+	})
+	if int64(len(dds)) > limit {
+		dds = dds[0:limit]
+	}
+	return dds, nil
+}
+func (l *LocalStore) GetDeploymentsByDay(day string, limit int64) ([]DynamoDeployment, error) {
+	return l.getDeploymentsByIndex(func(dd DynamoDeployment) bool { return dd.Day == day }, limit)
+}
+func (l *LocalStore) GetDeploymentsByMonth(month string, limit int64) ([]DynamoDeployment, error) {
+	return l.getDeploymentsByIndex(func(dd DynamoDeployment) bool { return dd.Month == month }, limit)
+}
+func (l *LocalStore) PutDeployment(dd *DynamoDeployment) error {
+	return l.put(dd.ServiceName, l.timeKey(dd.Time), dd, nil)
+}
+func (l *LocalStore) PutDeploymentIfVersion(dd *DynamoDeployment, version int64) error {
+	return l.put(dd.ServiceName, l.timeKey(dd.Time), dd, l.versionCondition(version))
+}
+func (l *LocalStore) PutDeploymentIfStatus(dd *DynamoDeployment, status string) error {
+	return l.put(dd.ServiceName, l.timeKey(dd.Time), dd, func(existing []byte) (bool, error) {
+		if existing == nil {
+			return false, nil
+		}
+		var item struct{ Status string }
+		if err := json.Unmarshal(existing, &item); err != nil {
+			return false, err
+		}
+		return item.Status == status, nil
+	})
+}
+
+func (l *LocalStore) GetLastClusterInfo() (*DynamoCluster, error) {
+	var dc *DynamoCluster
+	err := l.forEachDescending("__CLUSTERS", func(k, v []byte) (bool, error) {
+		dc = &DynamoCluster{}
+		return false, json.Unmarshal(v, dc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dc == nil {
+		return nil, ErrNoItemFound
+	}
+	return dc, nil
+}
+func (l *LocalStore) GetClusterInfoSince(startTime time.Time) ([]DynamoCluster, error) {
+	var dcs []DynamoCluster
+	start := l.timeKey(startTime)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__CLUSTERS"))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+			var dc DynamoCluster
+			if err := json.Unmarshal(v, &dc); err != nil {
+				return err
+			}
+			dcs = append(dcs, dc)
+		}
+		return nil
+	})
+	return dcs, err
+}
+func (l *LocalStore) PutClusterInfo(dc *DynamoCluster) error {
+	return l.put("__CLUSTERS", l.timeKey(dc.Time), dc, nil)
+}
+
+func (l *LocalStore) PutAutoscalingPullIfNotExists(p *DynamoAutoscalingPull) error {
+	return l.put("__AUTOSCALINGPULL", []byte(p.Time), p, func(existing []byte) (bool, error) {
+		return existing == nil, nil
+	})
+}
+func (l *LocalStore) PutAutoscalingPullIfExpired(p *DynamoAutoscalingPull, expiredBefore time.Time) error {
+	return l.put("__AUTOSCALINGPULL", []byte(p.Time), p, func(existing []byte) (bool, error) {
+		if existing == nil {
+			return false, nil
+		}
+		var current DynamoAutoscalingPull
+		if err := json.Unmarshal(existing, &current); err != nil {
+			return false, err
+		}
+		return current.LockTimestamp.Before(expiredBefore), nil
+	})
+}
+
+func (l *LocalStore) GetDeployLock(serviceName string) (*DynamoDeployLock, error) {
+	var lock DynamoDeployLock
+	if err := l.get("__DEPLOYLOCK", []byte(serviceName), &lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// PutDeployLockIfHolder writes the lock if it's held by holder, an empty holder means the lock is free
+func (l *LocalStore) PutDeployLockIfHolder(lock *DynamoDeployLock, holder string) error {
+	return l.put("__DEPLOYLOCK", []byte(lock.Time), lock, func(existing []byte) (bool, error) {
+		if existing == nil {
+			return holder == "", nil
+		}
+		var current DynamoDeployLock
+		if err := json.Unmarshal(existing, &current); err != nil {
+			return false, err
+		}
+		return current.Lock == holder, nil
+	})
+}
+
+func (l *LocalStore) GetRulePriorities(listenerArn string) (*DynamoRulePriorities, error) {
+	var rp DynamoRulePriorities
+	if err := l.get("__RULEPRIORITIES", []byte(listenerArn), &rp); err != nil {
+		return nil, err
+	}
+	return &rp, nil
+}
+
+// PutRulePrioritiesIfVersion writes the rule priorities if the stored version matches, version 0 means no record yet
+func (l *LocalStore) PutRulePrioritiesIfVersion(rp *DynamoRulePriorities, version int64) error {
+	if version == 0 {
+		return l.put("__RULEPRIORITIES", []byte(rp.Time), rp, func(existing []byte) (bool, error) {
+			return existing == nil, nil
+		})
+	}
+	return l.put("__RULEPRIORITIES", []byte(rp.Time), rp, l.versionCondition(version))
+}
+
+func (l *LocalStore) GetRBAC() (*DynamoRBAC, error) {
+	var r DynamoRBAC
+	if err := l.get("__RBAC", []byte("0"), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// PutRBACIfVersion writes the rbac if the stored version matches, version 0 means no record yet
+func (l *LocalStore) PutRBACIfVersion(r *DynamoRBAC, version int64) error {
+	if version == 0 {
+		return l.put("__RBAC", []byte("0"), r, func(existing []byte) (bool, error) {
+			return existing == nil, nil
+		})
+	}
+	return l.put("__RBAC", []byte("0"), r, l.versionCondition(version))
+}
+
+func (l *LocalStore) GetAPITokens() ([]DynamoAPIToken, error) {
+	var tokens []DynamoAPIToken
+	err := l.forEachDescending("__APITOKENS", func(k, v []byte) (bool, error) {
+		var t DynamoAPIToken
+		if err := json.Unmarshal(v, &t); err != nil {
+			return false, err
+		}
+		// the hash is the key, it's not part of the json
+		t.Identifier, t.Hash = "__APITOKENS", string(k)
+		tokens = append(tokens, t)
+		return true, nil
+	})
+	return tokens, err
+}
+func (l *LocalStore) GetAPIToken(hash string) (*DynamoAPIToken, error) {
+	var t DynamoAPIToken
+	if err := l.get("__APITOKENS", []byte(hash), &t); err != nil {
+		return nil, err
+	}
+	t.Identifier, t.Hash = "__APITOKENS", hash
+	return &t, nil
+}
+func (l *LocalStore) PutAPITokenIfNotExists(t *DynamoAPIToken) error {
+	return l.put("__APITOKENS", []byte(t.Hash), t, func(existing []byte) (bool, error) {
+		return existing == nil, nil
+	})
+}
+
+// PutAPITokenLastUsed updates the last used time, a revoked token is not recreated
+func (l *LocalStore) PutAPITokenLastUsed(hash string, lastUsed time.Time) error {
+	t, err := l.GetAPIToken(hash)
+	if err == ErrNoItemFound {
+		return ErrConditionalCheckFailed
+	}
+	if err != nil {
+		return err
+	}
+	t.LastUsed = &lastUsed
+	return l.put("__APITOKENS", []byte(hash), t, func(existing []byte) (bool, error) {
+		return existing != nil, nil
+	})
+}
+func (l *LocalStore) DeleteAPIToken(hash string) error {
+	return l.delete("__APITOKENS", []byte(hash))
+}
+
+func (l *LocalStore) GetWaveDeploy(id string) (*DynamoWaveDeploy, error) {
+	var w DynamoWaveDeploy
+	if err := l.get("__WAVEDEPLOYS", []byte(id), &w); err != nil {
+		return nil, err
+	}
+	w.Identifier = "__WAVEDEPLOYS"
+	return &w, nil
+}
+
+// GetWaveDeploysSince returns the wave deploys with an id from the given id, oldest first
+func (l *LocalStore) GetWaveDeploysSince(id string) ([]DynamoWaveDeploy, error) {
+	var waveDeploys []DynamoWaveDeploy
+	err := l.forEachDescending("__WAVEDEPLOYS", func(k, v []byte) (bool, error) {
+		if string(k) < id {
+			return false, nil
+		}
+		var w DynamoWaveDeploy
+		if err := json.Unmarshal(v, &w); err != nil {
+			return false, err
+		}
+		w.Identifier = "__WAVEDEPLOYS"
+		waveDeploys = append([]DynamoWaveDeploy{w}, waveDeploys...)
+		return true, nil
+	})
+	return waveDeploys, err
+}
+
+// PutWaveDeployIfVersion writes the wave deploy if the stored version matches, version 0 means no record yet
+func (l *LocalStore) PutWaveDeployIfVersion(w *DynamoWaveDeploy, version int64) error {
+	if version == 0 {
+		return l.put("__WAVEDEPLOYS", []byte(w.Id), w, func(existing []byte) (bool, error) {
+			return existing == nil, nil
+		})
+	}
+	return l.put("__WAVEDEPLOYS", []byte(w.Id), w, l.versionCondition(version))
+}
+
+// PutAuditEntry writes the entry and removes the expired entries, the local store has no ttl
+func (l *LocalStore) PutAuditEntry(e *DynamoAuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__AUDIT"))
+		if err != nil {
+			return err
+		}
+		// deleting while iterating with a cursor skips items
+		var expired [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var existing DynamoAuditEntry
+			if err := json.Unmarshal(v, &existing); err != nil {
+				return err
+			}
+			if existing.ExpirationTimeTTL > now {
+				break
+			}
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put(l.timeKey(e.Time), data)
+	})
+}
+func (l *LocalStore) GetAuditEntries(from, to time.Time, serviceName, user string, limit int64) ([]DynamoAuditEntry, error) {
+	var entries []DynamoAuditEntry
+	start, end := string(l.timeKey(from)), string(l.timeKey(to))
+	now := time.Now().Unix()
+	err := l.forEachDescending("__AUDIT", func(k, v []byte) (bool, error) {
+		if string(k) > end {
+			return true, nil
+		}
+		if string(k) < start {
+			return false, nil
+		}
+		var e DynamoAuditEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			return false, err
+		}
+		if e.ExpirationTimeTTL <= now || (user != "" && e.User != user) {
+			return true, nil
+		}
+		if serviceName != "" {
+			found := false
+			for _, s := range e.Services {
+				if s == serviceName {
+					found = true
+				}
+			}
+			if !found {
+				return true, nil
+			}
+		}
+		e.Identifier = "__AUDIT"
+		entries = append(entries, e)
+		return limit <= 0 || int64(len(entries)) < limit, nil
+	})
+	return entries, err
+}
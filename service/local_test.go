@@ -0,0 +1,237 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newLocalTestService(t *testing.T) (*Service, func()) {
+	dir, err := ioutil.TempDir("", "ecs-deploy-test")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %v", err)
+	}
+	store, err := NewLocalStore(filepath.Join(dir, "ecs-deploy.db"))
+	if err != nil {
+		t.Fatalf("Couldn't open local store: %v", err)
+	}
+	return NewServiceWithStore(store), func() { os.RemoveAll(dir) }
+}
+
+func TestLocalStoreServices(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	var ds DynamoServices
+	if err := s.GetServices(&ds); err != ErrNoItemFound {
+		t.Errorf("Expected ErrNoItemFound, got: %v", err)
+	}
+	if err := s.InitDB("1"); err != nil {
+		t.Errorf("InitDB: %v", err)
+	}
+	s.ServiceName = "myservice"
+	s.ClusterName = "mycluster"
+	if err := s.CreateService(&DynamoServicesElement{S: "myservice", C: "mycluster"}); err != nil {
+		t.Errorf("CreateService: %v", err)
+	}
+	clusterName, err := s.GetClusterName()
+	if err != nil {
+		t.Errorf("GetClusterName: %v", err)
+	}
+	if clusterName != "mycluster" {
+		t.Errorf("Expected mycluster, got %v", clusterName)
+	}
+	// write with an outdated version
+	if err := s.GetServices(&ds); err != nil {
+		t.Errorf("GetServices: %v", err)
+	}
+	if err := s.store.PutServicesIfVersion(&ds, ds.Version-1); err != ErrConditionalCheckFailed {
+		t.Errorf("Expected ErrConditionalCheckFailed, got: %v", err)
+	}
+}
+
+func TestLocalStoreDeployments(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	s.ServiceName = "myservice"
+	if _, err := s.GetLastDeploy(); err == nil {
+		t.Errorf("Expected error when there are no deployments")
+	}
+	first, err := s.NewDeployment(nil, &Deploy{DesiredCount: 1})
+	if err != nil {
+		t.Fatalf("NewDeployment: %v", err)
+	}
+	second, err := s.NewDeployment(nil, &Deploy{DesiredCount: 2})
+	if err != nil {
+		t.Fatalf("NewDeployment: %v", err)
+	}
+	dd, err := s.GetLastDeploy()
+	if err != nil {
+		t.Fatalf("GetLastDeploy: %v", err)
+	}
+	if !dd.Time.Equal(second.Time) || dd.Scaling.DesiredCount != 2 {
+		t.Errorf("Last deploy doesn't match second deploy: %v", dd.Time)
+	}
+	dds, err := s.GetDeploys("secondToLast", 1)
+	if err != nil {
+		t.Fatalf("GetDeploys: %v", err)
+	}
+	if !dds[0].Time.Equal(first.Time) {
+		t.Errorf("Second to last deploy doesn't match first deploy: %v", dds[0].Time)
+	}
+	dds, err = s.GetDeploys("byDay", 20)
+	if err != nil {
+		t.Fatalf("GetDeploys: %v", err)
+	}
+	if len(dds) != 2 {
+		t.Errorf("Expected 2 deploys by day, got %d", len(dds))
+	}
+	if err := s.SetDeploymentStatus(dd, "success"); err != nil {
+		t.Errorf("SetDeploymentStatus: %v", err)
+	}
+	dd, err = s.GetDeployment("myservice", dd.Time.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("GetDeployment: %v", err)
+	}
+	if dd.Status != "success" {
+		t.Errorf("Expected status success, got %v", dd.Status)
+	}
+	// an outdated copy can't overwrite the status
+	second.Status = "failed"
+	if err := s.store.PutDeploymentIfVersion(second, second.Version); err != ErrConditionalCheckFailed {
+		t.Errorf("Expected ErrConditionalCheckFailed, got: %v", err)
+	}
+	if err := s.store.PutDeploymentIfStatus(second, "running"); err != ErrConditionalCheckFailed {
+		t.Errorf("Expected ErrConditionalCheckFailed, got: %v", err)
+	}
+	if err := s.store.PutDeploymentIfStatus(dd, "success"); err != nil {
+		t.Errorf("PutDeploymentIfStatus: %v", err)
+	}
+}
+
+func TestLocalStoreAutoscalingPullLock(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	if err := s.AutoscalingPullInit(); err != nil {
+		t.Fatalf("AutoscalingPullInit: %v", err)
+	}
+	// init should be idempotent
+	if err := s.AutoscalingPullInit(); err != nil {
+		t.Fatalf("AutoscalingPullInit: %v", err)
+	}
+	ok, err := s.AutoscalingPullAcquireLock("one")
+	if err != nil || !ok {
+		t.Errorf("Expected to acquire the lock (err: %v)", err)
+	}
+	ok, err = s.AutoscalingPullAcquireLock("two")
+	if err != nil || ok {
+		t.Errorf("Expected lock to be taken (err: %v)", err)
+	}
+}
+
+func TestLocalStoreDeployLock(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	s.ServiceName = "myservice"
+	held, err := s.AcquireDeployLock("one", false)
+	if err != nil || held != nil {
+		t.Fatalf("Expected to acquire the lock (err: %v)", err)
+	}
+	dd, err := s.NewDeployment(nil, &Deploy{DesiredCount: 1})
+	if err != nil {
+		t.Fatalf("NewDeployment: %v", err)
+	}
+	if err := s.SetDeployLockDeployment("one", dd.Time); err != nil {
+		t.Fatalf("SetDeployLockDeployment: %v", err)
+	}
+	held, err = s.AcquireDeployLock("two", false)
+	if err != nil || held == nil {
+		t.Fatalf("Expected lock to be taken (err: %v)", err)
+	}
+	if !held.DeploymentTime.Equal(dd.Time) {
+		t.Errorf("Expected lock to be held by deployment %v, got %v", dd.Time, held.DeploymentTime)
+	}
+	// the lock is free once the deployment is finished
+	if err := s.SetDeploymentStatus(dd, "success"); err != nil {
+		t.Fatalf("SetDeploymentStatus: %v", err)
+	}
+	held, err = s.AcquireDeployLock("two", false)
+	if err != nil || held != nil {
+		t.Fatalf("Expected to acquire the lock (err: %v)", err)
+	}
+	// a release by a previous holder doesn't free the lock
+	if err := s.ReleaseDeployLock("one"); err != nil {
+		t.Fatalf("ReleaseDeployLock: %v", err)
+	}
+	held, err = s.AcquireDeployLock("three", true)
+	if err != nil || held == nil {
+		t.Fatalf("Expected lock of a starting deploy to be taken (err: %v)", err)
+	}
+	if err := s.ReleaseDeployLock("two"); err != nil {
+		t.Fatalf("ReleaseDeployLock: %v", err)
+	}
+	held, err = s.AcquireDeployLock("three", false)
+	if err != nil || held != nil {
+		t.Errorf("Expected to acquire the lock (err: %v)", err)
+	}
+}
+
+func TestLocalStoreWaveDeploy(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	waves := [][]Deploy{{{ServiceName: "api"}}, {{ServiceName: "worker", DependsOn: []string{"api"}}}}
+	w := NewWaveDeploy(waves, map[string]string{"worker": "invalid"})
+	if err := s.CreateWaveDeploy(w); err != nil {
+		t.Fatalf("CreateWaveDeploy: %v", err)
+	}
+	w.SetServiceRunning("api", time.Now())
+	if err := s.UpdateWaveDeploy(w); err != nil {
+		t.Fatalf("UpdateWaveDeploy: %v", err)
+	}
+	// an update of an older version fails
+	stale, err := s.GetWaveDeploy(w.Id)
+	if err != nil {
+		t.Fatalf("GetWaveDeploy: %v", err)
+	}
+	if err := s.UpdateWaveDeploy(w); err != nil {
+		t.Fatalf("UpdateWaveDeploy: %v", err)
+	}
+	if err := s.UpdateWaveDeploy(stale); err != ErrConditionalCheckFailed {
+		t.Errorf("Expected ErrConditionalCheckFailed, got: %v", err)
+	}
+	deploys, errors := stale.GetQueued(1)
+	if len(deploys) != 1 || deploys[0].ServiceName != "worker" || errors["worker"] != "invalid" {
+		t.Errorf("Expected the worker to be queued with its error, got %v %v", deploys, errors)
+	}
+	if running := stale.GetRunning(0); len(running) != 1 || running[0].DeploymentTime == nil {
+		t.Errorf("Expected the api to be running, got %v", running)
+	}
+	running, err := s.GetRunningWaveDeploys(time.Now().Add(-1 * time.Hour))
+	if err != nil || len(running) != 1 || running[0].Id != w.Id || running[0].Identifier != "__WAVEDEPLOYS" {
+		t.Fatalf("Expected the wave deploy to be running, got %v (err: %v)", running, err)
+	}
+	w.Halt("halted")
+	if err := s.UpdateWaveDeploy(w); err != nil {
+		t.Fatalf("UpdateWaveDeploy: %v", err)
+	}
+	if running, err := s.GetRunningWaveDeploys(time.Now().Add(-1 * time.Hour)); err != nil || len(running) != 0 {
+		t.Errorf("Expected no running wave deploys, got %v (err: %v)", running, err)
+	}
+}
+
+func TestNewServiceUnknownBackend(t *testing.T) {
+	os.Setenv("STORAGE_BACKEND", "unknown")
+	defer os.Unsetenv("STORAGE_BACKEND")
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected a panic when the storage backend can't be initialized")
+		}
+	}()
+	NewService()
+}
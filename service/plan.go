@@ -0,0 +1,187 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// GetDeployWaves orders the services in waves. A service is deployed in the wave after the services it depends on.
+// Dependencies on services that are not part of the deploy are ignored, those services are already deployed
+func GetDeployWaves(services []Deploy) ([][]Deploy, error) {
+	var waves [][]Deploy
+	remaining := make(map[string]bool)
+	for _, d := range services {
+		remaining[d.ServiceName] = true
+	}
+	for len(remaining) > 0 {
+		var wave []Deploy
+		for _, d := range services {
+			if !remaining[d.ServiceName] {
+				continue
+			}
+			ready := true
+			for _, dependency := range d.DependsOn {
+				if remaining[dependency] {
+					ready = false
+				}
+			}
+			if ready {
+				wave = append(wave, d)
+			}
+		}
+		if len(wave) == 0 {
+			var circular []string
+			for serviceName := range remaining {
+				circular = append(circular, serviceName)
+			}
+			sort.Strings(circular)
+			return waves, errors.New("Circular dependency between services: " + strings.Join(circular, ", "))
+		}
+		for _, d := range wave {
+			delete(remaining, d.ServiceName)
+		}
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+// what a change of a field of the deploy does to an existing service. The most specific field path is used
+var deployPlanEffects = map[string]string{
+	"containers":                     "new task definition",
+	"volumes":                        "new task definition",
+	"networkMode":                    "new task definition",
+	"launchType":                     "new task definition",
+	"cpu":                            "new task definition",
+	"memory":                         "new task definition",
+	"platformVersion":                "service updated",
+	"capacityProviderStrategy":       "service updated",
+	"placementStrategy":              "service updated",
+	"placementConstraints":           "new task definition",
+	"envNamespace":                   "new task definition, paramstore policy replaced",
+	"healthCheck":                    "target group health check updated",
+	"healthCheck.gracePeriodSeconds": "service updated",
+	"stickiness":                     "target group attributes updated",
+	"deregistrationDelay":            "target group attributes updated",
+	"networkConfiguration":           "service updated",
+	"loadBalancer":                   "service recreated",
+	"loadBalancers":                  "target groups and service updated",
+	"deploymentStrategy":             "deployment",
+	"bakeTime":                       "deployment",
+	"canary":                         "deployment",
+	"healthGates":                    "deployment",
+	"dependsOn":                      "deployment",
+}
+
+// DiffDeploy returns the fields that are different in after, with the json path of the field and the effect of the change
+func DiffDeploy(before, after Deploy) ([]DeployPlanChange, error) {
+	var changes []DeployPlanChange
+	var b, a interface{}
+	for _, v := range []struct {
+		deploy Deploy
+		value  *interface{}
+	}{{before, &b}, {after, &a}} {
+		data, err := json.Marshal(v.deploy)
+		if err != nil {
+			return changes, err
+		}
+		if err := json.Unmarshal(data, v.value); err != nil {
+			return changes, err
+		}
+	}
+	diffPlanValue("", b, a, &changes)
+	return changes, nil
+}
+
+func diffPlanValue(path string, before, after interface{}, changes *[]DeployPlanChange) {
+	if isEmptyPlanValue(before) && isEmptyPlanValue(after) {
+		return
+	}
+	switch b := before.(type) {
+	case map[string]interface{}:
+		if a, ok := after.(map[string]interface{}); ok {
+			var keys []string
+			for k := range b {
+				keys = append(keys, k)
+			}
+			for k := range a {
+				if _, ok := b[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				field := k
+				if path != "" {
+					field = path + "." + k
+				}
+				diffPlanValue(field, b[k], a[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if a, ok := after.([]interface{}); ok && len(a) == len(b) {
+			for i := range b {
+				diffPlanValue(fmt.Sprintf("%v[%d]", path, i), b[i], a[i], changes)
+			}
+			return
+		}
+	}
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	*changes = append(*changes, DeployPlanChange{
+		Field:  path,
+		Before: formatPlanValue(before),
+		After:  formatPlanValue(after),
+		Effect: getPlanEffect(path),
+	})
+}
+
+func isEmptyPlanValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+func formatPlanValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// getPlanEffect returns the effect of the most specific field in deployPlanEffects. Fields that are not listed are
+// only used when the service is created
+func getPlanEffect(path string) string {
+	field := path
+	for field != "" {
+		if effect, ok := deployPlanEffects[field]; ok {
+			return effect
+		}
+		i := strings.LastIndexAny(field, ".[")
+		if i == -1 {
+			break
+		}
+		field = field[:i]
+	}
+	return "not applied to existing service"
+}
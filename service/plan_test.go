@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestGetDeployWaves(t *testing.T) {
+	services := []Deploy{
+		{ServiceName: "worker", DependsOn: []string{"api"}},
+		{ServiceName: "api", DependsOn: []string{"database"}},
+		{ServiceName: "frontend", DependsOn: []string{"api", "other"}},
+		{ServiceName: "database"},
+	}
+	waves, err := GetDeployWaves(services)
+	if err != nil {
+		t.Fatalf("GetDeployWaves: %v", err)
+	}
+	var names [][]string
+	for _, wave := range waves {
+		var waveNames []string
+		for _, d := range wave {
+			waveNames = append(waveNames, d.ServiceName)
+		}
+		names = append(names, waveNames)
+	}
+	if len(names) != 3 || len(names[0]) != 1 || names[0][0] != "database" || names[1][0] != "api" || len(names[2]) != 2 || names[2][0] != "worker" || names[2][1] != "frontend" {
+		t.Errorf("Unexpected waves: %v", names)
+	}
+
+	services[3].DependsOn = []string{"worker"}
+	if _, err := GetDeployWaves(services); err == nil {
+		t.Errorf("Expected error on circular dependency")
+	}
+}
+
+func TestDiffDeploy(t *testing.T) {
+	before := Deploy{
+		Cluster:      "mycluster",
+		DesiredCount: 2,
+		HealthCheck:  DeployHealthCheck{Path: "/", GracePeriodSeconds: 10},
+		Containers:   []*DeployContainer{{ContainerName: "myservice", ContainerTag: "v1"}},
+	}
+	after := before
+	after.DesiredCount = 3
+	after.HealthCheck.Path = "/health"
+	after.HealthCheck.GracePeriodSeconds = 20
+	after.Containers = []*DeployContainer{{ContainerName: "myservice", ContainerTag: "v2"}}
+	after.DependsOn = []string{}
+	changes, err := DiffDeploy(before, after)
+	if err != nil {
+		t.Fatalf("DiffDeploy: %v", err)
+	}
+	expected := []DeployPlanChange{
+		{Field: "containers[0].containerTag", Before: "v1", After: "v2", Effect: "new task definition"},
+		{Field: "desiredCount", Before: "2", After: "3", Effect: "not applied to existing service"},
+		{Field: "healthCheck.gracePeriodSeconds", Before: "10", After: "20", Effect: "service updated"},
+		{Field: "healthCheck.path", Before: "/", After: "/health", Effect: "target group health check updated"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got: %v", len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected change %v, got %v", expected[i], changes[i])
+		}
+	}
+}
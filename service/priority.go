@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// priorities of the listener rules of an application loadbalancer, the rule with the lowest priority is evaluated first
+const (
+	MinRulePriority = 1
+	MaxRulePriority = 50000
+)
+
+// number of times a reservation is retried when another deploy changed the reservations of the listener
+const rulePriorityRetries = 5
+
+// RulePriorityRequest is a rule of a service on a listener that needs a priority. Priority is the explicit priority of
+// the rule, 0 to allocate one
+type RulePriorityRequest struct {
+	Key         string
+	Priority    int64
+	Specificity int64
+}
+
+// GetRuleSpecificity ranks rule conditions, rules with a higher specificity get a lower priority: path rules go before
+// host only rules, exact paths before wildcards, more conditions before less conditions and longer paths before shorter
+// paths
+func GetRuleSpecificity(r *DeployRuleConditions) int64 {
+	var specificity int64
+	pathPatterns := r.PathPatterns
+	if r.PathPattern != "" {
+		pathPatterns = append([]string{r.PathPattern}, pathPatterns...)
+	}
+	if len(pathPatterns) > 0 {
+		specificity += 1 << 30
+		exact := true
+		var longest int64
+		for _, pathPattern := range pathPatterns {
+			if strings.ContainsAny(pathPattern, "*?") {
+				exact = false
+			}
+			if int64(len(pathPattern)) > longest {
+				longest = int64(len(pathPattern))
+			}
+		}
+		if exact {
+			specificity += 1 << 29
+		}
+		if longest > 4095 {
+			longest = 4095
+		}
+		specificity += longest
+	}
+	conditions := len(r.HttpHeaders)
+	for _, present := range []bool{len(pathPatterns) > 0, r.Hostname != "" || len(r.Hostnames) > 0, len(r.QueryStrings) > 0, len(r.HttpRequestMethods) > 0, len(r.SourceIps) > 0} {
+		if present {
+			conditions++
+		}
+	}
+	specificity += int64(conditions) << 12
+	return specificity
+}
+
+// RulePriorityMove is a rule of another service that moves to a new priority
+type RulePriorityMove struct {
+	Owner    string
+	Key      string
+	Priority int64
+}
+
+// RulePriorityAllocation are the priorities reserved by AllocateRulePriorities. Assigned is the priority per request
+// key, Moved are the rules of other services that need to move, by old priority
+type RulePriorityAllocation struct {
+	Assigned     map[string]int64
+	Moved        map[int64]RulePriorityMove
+	listenerArn  string
+	reservations []DynamoRulePriorityReservation
+	version      int64
+}
+
+// AllocateRulePriorities reserves the priorities of the rules of the service on a listener. inUse are the priorities
+// of the rules on the listener. The reservations are stored with a version check, and retried when another deploy
+// was faster
+func (s *Service) AllocateRulePriorities(listenerArn string, requests []RulePriorityRequest, inUse []int64) (*RulePriorityAllocation, error) {
+	for i := 0; i < rulePriorityRetries; i++ {
+		rp, err := s.store.GetRulePriorities(listenerArn)
+		if err == ErrNoItemFound {
+			rp = &DynamoRulePriorities{Identifier: "__RULEPRIORITIES", Time: listenerArn}
+		} else if err != nil {
+			return nil, err
+		}
+		allocation := &RulePriorityAllocation{listenerArn: listenerArn, reservations: rp.Reservations}
+		allocation.Moved, err = allocateRulePriorities(rp, s.ServiceName, requests, inUse)
+		if err != nil {
+			return nil, err
+		}
+		rp.Version++
+		err = s.store.PutRulePrioritiesIfVersion(rp, rp.Version-1)
+		if err == ErrConditionalCheckFailed {
+			s.logger().Debugf("Rule priorities of listener %v changed, retrying", listenerArn)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		allocation.version = rp.Version
+		allocation.Assigned = make(map[string]int64)
+		for _, r := range rp.Reservations {
+			if r.Owner == s.ServiceName {
+				allocation.Assigned[r.Key] = r.Priority
+			}
+		}
+		return allocation, nil
+	}
+	return nil, fmt.Errorf("Could not reserve rule priorities on listener %v after %d attempts", listenerArn, rulePriorityRetries)
+}
+
+// RevertRulePriorities puts back the reservations from before the allocation, when the rules couldn't be created or
+// moved. The reservations are kept when another deploy changed them in the meantime, as that deploy used them
+func (s *Service) RevertRulePriorities(allocation *RulePriorityAllocation) error {
+	rp := &DynamoRulePriorities{
+		Identifier:   "__RULEPRIORITIES",
+		Time:         allocation.listenerArn,
+		Reservations: allocation.reservations,
+		Version:      allocation.version + 1,
+	}
+	err := s.store.PutRulePrioritiesIfVersion(rp, allocation.version)
+	if err == ErrConditionalCheckFailed {
+		s.logger().Warningf("Rule priorities of listener %v changed in the meantime, the reservations are not reverted", allocation.listenerArn)
+		return nil
+	}
+	return err
+}
+
+// ReleaseRulePriorities removes the reservations of the service on a listener. The gaps are closed by the next
+// allocation on the listener
+func (s *Service) ReleaseRulePriorities(listenerArn string) error {
+	for i := 0; i < rulePriorityRetries; i++ {
+		rp, err := s.store.GetRulePriorities(listenerArn)
+		if err == ErrNoItemFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var reservations []DynamoRulePriorityReservation
+		for _, r := range rp.Reservations {
+			if r.Owner != s.ServiceName {
+				reservations = append(reservations, r)
+			}
+		}
+		if len(reservations) == len(rp.Reservations) {
+			return nil
+		}
+		rp.Reservations = reservations
+		rp.Version++
+		err = s.store.PutRulePrioritiesIfVersion(rp, rp.Version-1)
+		if err == ErrConditionalCheckFailed {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("Could not release rule priorities on listener %v after %d attempts", listenerArn, rulePriorityRetries)
+}
+
+// allocateRulePriorities replaces the reservations of owner by the requests. Explicit priorities are kept, as well as
+// the priorities in use by rules without reservation. The other reservations are sorted on specificity and get the
+// lowest free priorities, which closes the gaps. Returns the reservations of other owners that moved, by old priority
+func allocateRulePriorities(rp *DynamoRulePriorities, owner string, requests []RulePriorityRequest, inUse []int64) (map[int64]RulePriorityMove, error) {
+	reserved := make(map[int64]bool)
+	for _, r := range rp.Reservations {
+		reserved[r.Priority] = true
+	}
+	// rules that were not created by ecs-deploy (or before the reservations existed) keep their priority
+	fixed := make(map[int64]bool)
+	for _, priority := range inUse {
+		if !reserved[priority] {
+			fixed[priority] = true
+		}
+	}
+	var reservations []DynamoRulePriorityReservation
+	for _, r := range rp.Reservations {
+		if r.Owner != owner {
+			reservations = append(reservations, r)
+		}
+	}
+	for _, request := range requests {
+		reservations = append(reservations, DynamoRulePriorityReservation{
+			Owner:       owner,
+			Key:         request.Key,
+			Priority:    request.Priority,
+			Explicit:    request.Priority != 0,
+			Specificity: request.Specificity,
+		})
+	}
+
+	var explicit, automatic []DynamoRulePriorityReservation
+	for _, r := range reservations {
+		if !r.Explicit {
+			automatic = append(automatic, r)
+			continue
+		}
+		if fixed[r.Priority] {
+			return nil, fmt.Errorf("Priority %d of rule %v/%v is already in use", r.Priority, r.Owner, r.Key)
+		}
+		fixed[r.Priority] = true
+		explicit = append(explicit, r)
+	}
+
+	// the order of the rules of an owner is kept when the specificity is the same
+	sort.SliceStable(automatic, func(i, j int) bool {
+		if automatic[i].Specificity != automatic[j].Specificity {
+			return automatic[i].Specificity > automatic[j].Specificity
+		}
+		return automatic[i].Owner < automatic[j].Owner
+	})
+	moved := make(map[int64]RulePriorityMove)
+	priority := int64(MinRulePriority)
+	for i := range automatic {
+		for fixed[priority] {
+			priority++
+		}
+		if priority > MaxRulePriority {
+			return nil, fmt.Errorf("No free rule priority left for rule %v/%v", automatic[i].Owner, automatic[i].Key)
+		}
+		if automatic[i].Owner != owner && automatic[i].Priority != priority {
+			moved[automatic[i].Priority] = RulePriorityMove{Owner: automatic[i].Owner, Key: automatic[i].Key, Priority: priority}
+		}
+		automatic[i].Priority = priority
+		priority++
+	}
+
+	rp.Reservations = append(explicit, automatic...)
+	sort.Slice(rp.Reservations, func(i, j int) bool {
+		return rp.Reservations[i].Priority < rp.Reservations[j].Priority
+	})
+	return moved, nil
+}
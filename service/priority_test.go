@@ -0,0 +1,119 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetRuleSpecificity(t *testing.T) {
+	ordered := []*DeployRuleConditions{
+		{PathPattern: "/api/users", Hostname: "www"},
+		{PathPattern: "/api/users"},
+		{PathPattern: "/api"},
+		{PathPattern: "/api/*"},
+		{Hostname: "www", HttpRequestMethods: []string{"GET"}},
+		{Hostname: "www"},
+	}
+	for i := 1; i < len(ordered); i++ {
+		if GetRuleSpecificity(ordered[i-1]) <= GetRuleSpecificity(ordered[i]) {
+			t.Errorf("Expected %+v to be more specific than %+v", ordered[i-1], ordered[i])
+		}
+	}
+}
+
+func TestAllocateRulePriorities(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+	listenerArn := "arn:listener"
+	host := GetRuleSpecificity(&DeployRuleConditions{Hostname: "www"})
+	path := GetRuleSpecificity(&DeployRuleConditions{PathPattern: "/b"})
+	wildcard := GetRuleSpecificity(&DeployRuleConditions{PathPattern: "/c/*"})
+
+	var allocation *RulePriorityAllocation
+	allocate := func(serviceName string, requests []RulePriorityRequest, inUse []int64) (map[string]int64, map[int64]int64) {
+		s.ServiceName = serviceName
+		var err error
+		allocation, err = s.AllocateRulePriorities(listenerArn, requests, inUse)
+		if err != nil {
+			t.Fatalf("AllocateRulePriorities %v: %v", serviceName, err)
+		}
+		moved := make(map[int64]int64)
+		for priority, move := range allocation.Moved {
+			moved[priority] = move.Priority
+		}
+		return allocation.Assigned, moved
+	}
+
+	// a catch-all host rule
+	assigned, moved := allocate("a", []RulePriorityRequest{{Key: "0", Specificity: host}}, nil)
+	if assigned["0"] != 1 || len(moved) != 0 {
+		t.Errorf("Unexpected allocation for a: %v, moved: %v", assigned, moved)
+	}
+	// a path rule needs to go before the host rule
+	assigned, moved = allocate("b", []RulePriorityRequest{{Key: "0", Specificity: path}}, []int64{1})
+	if assigned["0"] != 1 || !reflect.DeepEqual(moved, map[int64]int64{1: 2}) {
+		t.Errorf("Unexpected allocation for b: %v, moved: %v", assigned, moved)
+	}
+	if move := allocation.Moved[1]; move.Owner != "a" || move.Key != "0" {
+		t.Errorf("Expected the rule of a to move, got %+v", move)
+	}
+	// priority 3 is used by a rule without reservation
+	assigned, moved = allocate("c", []RulePriorityRequest{{Key: "0", Specificity: wildcard}}, []int64{1, 2, 3})
+	if assigned["0"] != 2 || !reflect.DeepEqual(moved, map[int64]int64{2: 4}) {
+		t.Errorf("Unexpected allocation for c: %v, moved: %v", assigned, moved)
+	}
+	// explicit priorities stay, and can't take the priority of a rule without reservation
+	assigned, moved = allocate("d", []RulePriorityRequest{{Key: "0", Priority: 2, Specificity: host}}, []int64{1, 2, 3, 4})
+	if assigned["0"] != 2 || !reflect.DeepEqual(moved, map[int64]int64{2: 4, 4: 5}) {
+		t.Errorf("Unexpected allocation for d: %v, moved: %v", assigned, moved)
+	}
+	s.ServiceName = "e"
+	if _, err := s.AllocateRulePriorities(listenerArn, []RulePriorityRequest{{Key: "0", Priority: 3}}, []int64{1, 2, 3, 4, 5}); err == nil {
+		t.Errorf("Expected an error for a priority in use")
+	}
+
+	// releasing leaves a gap that is closed by the next allocation
+	s.ServiceName = "b"
+	if err := s.ReleaseRulePriorities(listenerArn); err != nil {
+		t.Fatalf("ReleaseRulePriorities: %v", err)
+	}
+	assigned, moved = allocate("e", []RulePriorityRequest{{Key: "0", Specificity: host}}, []int64{2, 3, 4, 5})
+	if assigned["0"] != 5 || !reflect.DeepEqual(moved, map[int64]int64{4: 1, 5: 4}) {
+		t.Errorf("Unexpected allocation for e: %v, moved: %v", assigned, moved)
+	}
+	rp, err := s.store.GetRulePriorities(listenerArn)
+	if err != nil {
+		t.Fatalf("GetRulePriorities: %v", err)
+	}
+	var owners []string
+	for _, r := range rp.Reservations {
+		owners = append(owners, r.Owner)
+	}
+	if !reflect.DeepEqual(owners, []string{"c", "d", "a", "e"}) {
+		t.Errorf("Unexpected reservations: %+v", rp.Reservations)
+	}
+
+	// the reservations from before the allocation are put back when the rules couldn't be created
+	allocate("f", []RulePriorityRequest{{Key: "0", Specificity: path}}, []int64{1, 2, 3, 4, 5})
+	if err := s.RevertRulePriorities(allocation); err != nil {
+		t.Fatalf("RevertRulePriorities: %v", err)
+	}
+	reverted, err := s.store.GetRulePriorities(listenerArn)
+	if err != nil {
+		t.Fatalf("GetRulePriorities: %v", err)
+	}
+	if !reflect.DeepEqual(reverted.Reservations, rp.Reservations) {
+		t.Errorf("Expected the reservations to be reverted to %+v, got %+v", rp.Reservations, reverted.Reservations)
+	}
+
+	// a revert doesn't overwrite the reservations of another deploy
+	allocate("f", []RulePriorityRequest{{Key: "0", Specificity: path}}, []int64{1, 2, 3, 4, 5})
+	previous := allocation
+	allocate("g", []RulePriorityRequest{{Key: "0", Specificity: host}}, []int64{1, 2, 3, 4, 5, 6})
+	if err := s.RevertRulePriorities(previous); err != nil {
+		t.Fatalf("RevertRulePriorities: %v", err)
+	}
+	if rp, err = s.store.GetRulePriorities(listenerArn); err != nil || len(rp.Reservations) != 6 {
+		t.Errorf("Expected the reservations of f and g to stay, got %+v (%v)", rp, err)
+	}
+}
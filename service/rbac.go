@@ -0,0 +1,162 @@
+package service
+
+import (
+	"path"
+)
+
+// permissions of the rbac roles
+const (
+	PermissionRead             = "read"
+	PermissionDeploy           = "deploy"
+	PermissionScale            = "scale"
+	PermissionRunTask          = "runtask"
+	PermissionParameterRead    = "parameter:read"
+	PermissionParameterWrite   = "parameter:write"
+	PermissionAutoscalingWrite = "autoscaling:write"
+	PermissionEcrWrite         = "ecr:write"
+	PermissionRBAC             = "rbac"
+	PermissionToken            = "token"
+	PermissionAudit            = "audit"
+)
+
+// Permissions are the actions that can be granted, the action of a permission can be a glob of these
+var Permissions = []string{
+	PermissionRead,
+	PermissionDeploy,
+	PermissionScale,
+	PermissionRunTask,
+	PermissionParameterRead,
+	PermissionParameterWrite,
+	PermissionAutoscalingWrite,
+	PermissionEcrWrite,
+	PermissionRBAC,
+	PermissionToken,
+	PermissionAudit,
+}
+
+// Role is a named set of permissions
+type Role struct {
+	Name        string       `json:"name" dynamo:"N"`
+	Permissions []Permission `json:"permissions" dynamo:"P"`
+}
+
+// Permission grants an action (or a glob of actions) on the services matching the cluster and service globs. An empty
+// cluster or service matches everything
+type Permission struct {
+	Action  string `json:"action" dynamo:"A"`
+	Cluster string `json:"cluster" dynamo:"C"`
+	Service string `json:"service" dynamo:"S"`
+}
+
+// RoleBinding gives roles to a user (the id of the login or the saml name id) or to a group (from the saml group
+// attribute)
+type RoleBinding struct {
+	User  string   `json:"user" dynamo:"U"`
+	Group string   `json:"group" dynamo:"G"`
+	Roles []string `json:"roles" dynamo:"R"`
+}
+
+// GetDefaultRBAC returns the rbac used when nothing is stored yet: the deploy user is admin, the developer user and the
+// users without role binding can only read
+func GetDefaultRBAC() *DynamoRBAC {
+	return &DynamoRBAC{
+		Identifier: "__RBAC",
+		Time:       "0",
+		Roles: []Role{
+			{Name: "admin", Permissions: []Permission{{Action: "*"}}},
+			{Name: "developer", Permissions: []Permission{{Action: PermissionRead}, {Action: PermissionParameterRead}}},
+		},
+		Bindings: []RoleBinding{
+			{User: "deploy", Roles: []string{"admin"}},
+			{User: "developer", Roles: []string{"developer"}},
+			{Group: "*", Roles: []string{"developer"}},
+		},
+	}
+}
+
+// GetRBAC returns the stored rbac, or the default rbac when nothing is stored yet
+func (s *Service) GetRBAC() (*DynamoRBAC, error) {
+	r, err := s.store.GetRBAC()
+	if err == ErrNoItemFound {
+		return GetDefaultRBAC(), nil
+	}
+	return r, err
+}
+
+// PutRBAC stores the rbac if it wasn't changed since it was retrieved (the version didn't change)
+func (s *Service) PutRBAC(r *DynamoRBAC) error {
+	r.Identifier = "__RBAC"
+	r.Time = "0"
+	r.Version++
+	return s.store.PutRBACIfVersion(r, r.Version-1)
+}
+
+// GetRoles returns the roles of the bindings of the user and the groups, and the roles granted by the identity provider
+// (roleNames). A binding with group * applies to everyone
+func (r *DynamoRBAC) GetRoles(user string, groups, roleNames []string) []Role {
+	var roles []Role
+	names := make(map[string]bool)
+	for _, name := range roleNames {
+		names[name] = true
+	}
+	for _, binding := range r.Bindings {
+		if !r.bindingMatches(binding, user, groups) {
+			continue
+		}
+		for _, name := range binding.Roles {
+			names[name] = true
+		}
+	}
+	for _, role := range r.Roles {
+		if names[role.Name] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (r *DynamoRBAC) bindingMatches(binding RoleBinding, user string, groups []string) bool {
+	if binding.User != "" {
+		return binding.User == user
+	}
+	if binding.Group == "*" {
+		return true
+	}
+	for _, group := range groups {
+		if binding.Group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAuthorized returns true if one of the roles of the user, the groups and the role names grants the action on the
+// service. An empty cluster or service (not known, or all services) is only matched by permissions for all clusters or
+// services
+func (r *DynamoRBAC) IsAuthorized(user string, groups, roleNames []string, action, clusterName, serviceName string) bool {
+	for _, role := range r.GetRoles(user, groups, roleNames) {
+		if permissionsAllow(role.Permissions, action, clusterName, serviceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionsAllow returns true if one of the permissions grants the action on the service
+func permissionsAllow(permissions []Permission, action, clusterName, serviceName string) bool {
+	for _, p := range permissions {
+		if globMatches(p.Action, action) && globMatches(p.Cluster, clusterName) && globMatches(p.Service, serviceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatches matches the value with the glob, an empty glob matches everything
+func globMatches(glob, value string) bool {
+	if glob == "" || glob == "*" {
+		return true
+	}
+	matched, err := path.Match(glob, value)
+	return err == nil && matched
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestRBACIsAuthorized(t *testing.T) {
+	r := &DynamoRBAC{
+		Roles: []Role{
+			{Name: "admin", Permissions: []Permission{{Action: "*"}}},
+			{Name: "team-a", Permissions: []Permission{
+				{Action: PermissionDeploy, Cluster: "staging", Service: "team-a-*"},
+				{Action: "parameter:*", Service: "team-a-*"},
+			}},
+			{Name: "reader", Permissions: []Permission{{Action: PermissionRead}}},
+		},
+		Bindings: []RoleBinding{
+			{User: "deploy", Roles: []string{"admin"}},
+			{Group: "team-a", Roles: []string{"team-a"}},
+			{Group: "*", Roles: []string{"reader"}},
+		},
+	}
+	tests := []struct {
+		user, action, cluster, service string
+		groups, roles                  []string
+		expected                       bool
+	}{
+		{"deploy", PermissionRBAC, "", "", nil, nil, true},
+		{"someone", PermissionRead, "production", "team-b-api", nil, nil, true},
+		{"someone", PermissionDeploy, "staging", "team-a-api", nil, nil, false},
+		{"someone", PermissionDeploy, "staging", "team-a-api", []string{"team-a"}, nil, true},
+		{"someone", PermissionDeploy, "production", "team-a-api", []string{"team-a"}, nil, false},
+		{"someone", PermissionDeploy, "staging", "team-b-api", []string{"team-a"}, nil, false},
+		// an unknown cluster is only matched by permissions on all clusters
+		{"someone", PermissionDeploy, "", "team-a-api", []string{"team-a"}, nil, false},
+		{"someone", PermissionParameterWrite, "production", "team-a-api", []string{"team-a"}, nil, true},
+		{"someone", PermissionScale, "staging", "team-a-api", []string{"team-a"}, nil, false},
+		// roles granted by the identity provider
+		{"someone", PermissionScale, "staging", "team-a-api", nil, []string{"admin"}, true},
+		{"someone", PermissionScale, "staging", "team-a-api", nil, []string{"unknown"}, false},
+	}
+	for _, test := range tests {
+		if got := r.IsAuthorized(test.user, test.groups, test.roles, test.action, test.cluster, test.service); got != test.expected {
+			t.Errorf("IsAuthorized(%v, %v, %v, %v, %v, %v): expected %v, got %v", test.user, test.groups, test.roles, test.action, test.cluster, test.service, test.expected, got)
+		}
+	}
+}
+
+func TestRBACStore(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	r, err := s.GetRBAC()
+	if err != nil {
+		t.Fatalf("GetRBAC: %v", err)
+	}
+	if !r.IsAuthorized("deploy", nil, nil, PermissionDeploy, "mycluster", "myservice") {
+		t.Errorf("Expected the deploy user to be admin by default")
+	}
+	if r.IsAuthorized("developer", nil, nil, PermissionDeploy, "mycluster", "myservice") {
+		t.Errorf("Expected the developer user to be read-only by default")
+	}
+	stale := *r
+	if err = s.PutRBAC(r); err != nil {
+		t.Fatalf("PutRBAC: %v", err)
+	}
+	if err = s.PutRBAC(&stale); err != ErrConditionalCheckFailed {
+		t.Errorf("Expected a conditional check failure for a stale version, got: %v", err)
+	}
+}
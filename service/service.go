@@ -1,16 +1,12 @@
 package service
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/guregu/dynamo"
 	"github.com/in4it/ecs-deploy/util"
 	"github.com/juju/loggo"
 
 	"errors"
 	"math"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,11 +15,13 @@ import (
 var serviceLogger = loggo.GetLogger("service")
 
 type Service struct {
-	db          *dynamo.DB
-	table       dynamo.Table
+	store       Store
 	ServiceName string
 	ClusterName string
 	Listeners   []string
+	Color       string
+	// RequestID is the id of the api request, added to the logs
+	RequestID string
 }
 
 type DynamoDeployment struct {
@@ -38,7 +36,17 @@ type DynamoDeployment struct {
 	ManualTasksArns   []string
 	TaskDefinitionArn *string
 	DeployData        *Deploy
-	Version           int64
+	Color             string
+	Canary            *DynamoDeploymentCanary
+	// BakeDeadline is set while the previous color of a blueGreen deployment is kept running
+	BakeDeadline *time.Time
+	Version      int64
+}
+
+type DynamoDeploymentCanary struct {
+	Step   int64
+	Steps  int64
+	Weight int64
 }
 
 type DynamoDeploymentScaling struct {
@@ -100,21 +108,136 @@ type DynamoAutoscalingPull struct {
 	LockTimestamp time.Time `dynamo:"LT"`
 }
 
+// dynamo rule priorities struct, one per listener
+type DynamoRulePriorities struct {
+	Identifier   string                          `dynamo:"ServiceName,hash"`
+	Time         string                          `dynamo:"Time,range"`
+	Reservations []DynamoRulePriorityReservation `dynamo:"R"`
+	Version      int64
+}
+type DynamoRulePriorityReservation struct {
+	Owner       string `dynamo:"O"`
+	Key         string `dynamo:"K"`
+	Priority    int64  `dynamo:"P"`
+	Explicit    bool   `dynamo:"E"`
+	Specificity int64  `dynamo:"S"`
+}
+
+// dynamo rbac struct
+type DynamoRBAC struct {
+	Identifier string        `dynamo:"ServiceName,hash" json:"-"`
+	Time       string        `dynamo:"Time,range" json:"-"`
+	Roles      []Role        `json:"roles"`
+	Bindings   []RoleBinding `json:"bindings"`
+	Version    int64         `json:"version"`
+}
+
+// dynamo api token struct, one per token. Only the sha256 hash of the token is stored
+type DynamoAPIToken struct {
+	Identifier  string       `dynamo:"ServiceName,hash" json:"-"`
+	Hash        string       `dynamo:"Time,range" json:"-"`
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+	CreatedBy   string       `json:"createdBy"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	ExpiresAt   *time.Time   `json:"expiresAt,omitempty"`
+	LastUsed    *time.Time   `json:"lastUsed,omitempty"`
+}
+
+// dynamo audit struct, one per mutating api call
+type DynamoAuditEntry struct {
+	Identifier        string    `dynamo:"ServiceName,hash" json:"-"`
+	Time              time.Time `dynamo:"Time,range" json:"time"`
+	User              string    `json:"user"`
+	SourceIP          string    `json:"sourceIp"`
+	Method            string    `json:"method"`
+	Route             string    `json:"route"`
+	Services          []string  `json:"services,omitempty"`
+	Summary           string    `json:"summary,omitempty"`
+	StatusCode        int       `json:"statusCode"`
+	Outcome           string    `json:"outcome"`
+	Error             string    `json:"error,omitempty"`
+	RequestID         string    `json:"requestId,omitempty"`
+	ExpirationTimeTTL int64     `json:"expirationTimeTTL"`
+}
+
+// dynamo wave deploy struct, one per deploy of services in more than one wave (see GetDeployWaves). The progress
+// of every service is stored, so the next waves are resumed after a restart
+type DynamoWaveDeploy struct {
+	Identifier string                    `dynamo:"ServiceName,hash" json:"-"`
+	Id         string                    `dynamo:"Time,range" json:"id"`
+	Status     string                    `json:"status"`
+	Services   []DynamoWaveDeployService `json:"services"`
+	CreatedAt  time.Time                 `json:"createdAt"`
+	UpdatedAt  time.Time                 `json:"updatedAt"`
+	Version    int64                     `json:"version"`
+}
+type DynamoWaveDeployService struct {
+	ServiceName    string     `json:"serviceName"`
+	Wave           int64      `json:"wave"`
+	Status         string     `json:"status"`
+	DeploymentTime *time.Time `json:"deploymentTime,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	Deploy         *Deploy    `json:"deploy,omitempty"`
+}
+
+// dynamo deploy lock struct, one per service
+type DynamoDeployLock struct {
+	Identifier     string    `dynamo:"ServiceName,hash"`
+	Time           string    `dynamo:"Time,range"`
+	Lock           string    `dynamo:"L"`
+	LockTimestamp  time.Time `dynamo:"LT"`
+	DeploymentTime time.Time `dynamo:"DT"`
+}
+
+var (
+	// ErrServiceNotFound is returned when the service is not in the __SERVICES record
+	ErrServiceNotFound = errors.New("Service not found")
+	// ErrNoDeploymentFound is returned when the service has no deployments
+	ErrNoDeploymentFound = errors.New("NoItemsFound: no items found")
+)
+
+// DeployLockedError is returned when the deploy lock of a service is held by another deployment
+type DeployLockedError struct {
+	ServiceName    string
+	DeploymentTime time.Time
+}
+
+func (e *DeployLockedError) Error() string {
+	if e.DeploymentTime.IsZero() {
+		return "Deployment of " + e.ServiceName + " is locked by a deployment that is starting"
+	}
+	return "Deployment of " + e.ServiceName + " is locked by the running deployment of " + e.DeploymentTime.UTC().Format(time.RFC3339Nano)
+}
+
+// NewService returns a service using the storage backend of STORAGE_BACKEND. The backend is checked when ecs-deploy
+// starts, a backend that can't be initialized panics instead of returning a service without a store
 func NewService() *Service {
-	s := Service{}
-	s.db = dynamo.New(session.New(), &aws.Config{})
-	s.table = s.db.Table(util.GetEnv("DYNAMODB_TABLE", "Services"))
-	return &s
+	store, err := NewStore()
+	if err != nil {
+		panic("Couldn't initialize storage backend: " + err.Error())
+	}
+	return &Service{store: store}
+}
+
+// logger returns the logger of the service, with the request id in the messages
+func (s *Service) logger() util.RequestLogger {
+	return util.RequestLogger{Logger: serviceLogger, RequestID: s.RequestID}
+}
+
+// NewServiceWithStore returns a service using the given storage backend
+func NewServiceWithStore(store Store) *Service {
+	return &Service{store: store}
 }
 
 func (s *Service) InitDB(apiVersion string) error {
 	ds := DynamoServices{ApiVersion: apiVersion, ServiceName: "__SERVICES", Time: "0", Version: 1, Services: []*DynamoServicesElement{}}
 
 	// __SERVICE not found, write first record
-	err := s.table.Put(ds).Run()
+	err := s.store.PutServices(&ds)
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put of first record: %v", err.Error())
+		s.logger().Errorf("Error during put of first record: %v", err.Error())
 		return err
 	}
 	return nil
@@ -124,35 +247,21 @@ func (s *Service) initService(dsElement *DynamoServicesElement) error {
 	ds := DynamoServices{ServiceName: "__SERVICES", Time: "0", Version: 1, Services: []*DynamoServicesElement{dsElement}}
 
 	// __SERVICE not found, write first record
-	err := s.table.Put(ds).Run()
+	err := s.store.PutServices(&ds)
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put of first record: %v", err.Error())
+		s.logger().Errorf("Error during put of first record: %v", err.Error())
 		return err
 	}
 	return nil
 }
 
 func (s *Service) GetServices(ds *DynamoServices) error {
-	err := s.table.Get("ServiceName", "__SERVICES").Range("Time", dynamo.Equal, "0").One(ds)
+	err := s.store.GetServices(ds)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				serviceLogger.Errorf(dynamodb.ErrCodeProvisionedThroughputExceededException, aerr.Error())
-			case dynamodb.ErrCodeResourceNotFoundException:
-				serviceLogger.Errorf(dynamodb.ErrCodeResourceNotFoundException, aerr.Error())
-			case dynamodb.ErrCodeInternalServerError:
-				serviceLogger.Errorf(dynamodb.ErrCodeInternalServerError, aerr.Error())
-			case "ValidationException":
-				serviceLogger.Errorf("%v", aerr.Error())
-			default:
-				serviceLogger.Errorf(aerr.Error())
-			}
-		} else {
-			return err
+		if err != ErrNoItemFound {
+			s.logger().Errorf("Error during get: %v", err.Error())
 		}
-		serviceLogger.Errorf("Error during get: %v", err.Error())
 		return err
 	}
 	return nil
@@ -160,16 +269,16 @@ func (s *Service) GetServices(ds *DynamoServices) error {
 func (s *Service) CreateService(dsElement *DynamoServicesElement) error {
 	// check input
 	if (s.ServiceName == "") || (s.ClusterName == "") {
-		serviceLogger.Errorf("Couldn't add %v (cluster = %v, listener # = %d)", s.ServiceName, s.ClusterName, len(s.Listeners))
+		s.logger().Errorf("Couldn't add %v (cluster = %v, listener # = %d)", s.ServiceName, s.ClusterName, len(s.Listeners))
 		return errors.New("Couldn't add " + s.ServiceName + ": cluster / listeners is empty")
 	}
 
 	var ds DynamoServices
 	err := s.GetServices(&ds)
 	if err != nil {
-		if err.Error() == "dynamo: no item found" {
+		if err == ErrNoItemFound {
 			// service needs to be initialized
-			serviceLogger.Debugf("Item not found: writing first __SERVICE record")
+			s.logger().Debugf("Item not found: writing first __SERVICE record")
 			err = s.initService(dsElement)
 			if err != nil {
 				return err
@@ -177,7 +286,7 @@ func (s *Service) CreateService(dsElement *DynamoServicesElement) error {
 			// record is written, return
 			return nil
 		} else {
-			serviceLogger.Errorf(err.Error())
+			s.logger().Errorf(err.Error())
 			return err
 		}
 	}
@@ -198,24 +307,18 @@ func (s *Service) CreateService(dsElement *DynamoServicesElement) error {
 		ds.Version += 1
 
 		// do a conditional put, where version
-		serviceLogger.Debugf("Putting new services record with version %v", ds.Version)
-		err = s.table.Put(ds).If("$ = ?", "Version", ds.Version-1).Run()
+		s.logger().Debugf("Putting new services record with version %v", ds.Version)
+		err = s.store.PutServicesIfVersion(&ds, ds.Version-1)
 
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case dynamodb.ErrCodeConditionalCheckFailedException:
-					serviceLogger.Debugf("Conditional check failed - retrying (%v)", aerr.Error())
-					err = s.GetServices(&ds)
-					if err != nil {
-						return err
-					}
-				default:
-					serviceLogger.Errorf("Error during put of first record: %v", aerr.Error())
+			if err == ErrConditionalCheckFailed {
+				s.logger().Debugf("Conditional check failed - retrying (%v)", err.Error())
+				err = s.GetServices(&ds)
+				if err != nil {
 					return err
 				}
 			} else {
-				serviceLogger.Errorf("Error during put of first record: %v", err.Error())
+				s.logger().Errorf("Error during put of first record: %v", err.Error())
 				return err
 			}
 		} else {
@@ -229,7 +332,7 @@ func (s *Service) ServiceExistsInDynamo() (bool, error) {
 	var ds DynamoServices
 	err := s.GetServices(&ds)
 	if err != nil {
-		serviceLogger.Errorf(err.Error())
+		s.logger().Errorf(err.Error())
 		return false, err
 	}
 	for _, a := range ds.Services {
@@ -242,7 +345,7 @@ func (s *Service) ServiceExistsInDynamo() (bool, error) {
 func (s *Service) NewDeployment(taskDefinitionArn *string, d *Deploy) (*DynamoDeployment, error) {
 	day := time.Now().Format("2006-01-02")
 	month := time.Now().Format("2006-01")
-	w := DynamoDeployment{ServiceName: s.ServiceName, Time: time.Now(), Day: day, Month: month, TaskDefinitionArn: taskDefinitionArn, DeployData: d, Status: "running", Version: 1}
+	w := DynamoDeployment{ServiceName: s.ServiceName, Time: time.Now(), Day: day, Month: month, TaskDefinitionArn: taskDefinitionArn, DeployData: d, Color: s.Color, Status: "running", Version: 1}
 
 	lastDeploy, err := s.GetLastDeploy()
 	if err != nil {
@@ -252,44 +355,28 @@ func (s *Service) NewDeployment(taskDefinitionArn *string, d *Deploy) (*DynamoDe
 		w.Scaling.DesiredCount = util.Max(d.DesiredCount, lastDeploy.Scaling.DesiredCount)
 	}
 
-	err = s.table.Put(w).Run()
+	err = s.store.PutDeployment(&w)
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put: %v", err.Error())
+		s.logger().Errorf("Error during put: %v", err.Error())
 		return nil, err
 	}
 	return &w, nil
 }
 func (s *Service) GetLastDeploy() (*DynamoDeployment, error) {
-	var dd DynamoDeployment
 	if s.ServiceName == "" {
 		return nil, errors.New("serviceName not set")
 	}
-	err := s.table.Get("ServiceName", s.ServiceName).Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(1).One(&dd)
+	dds, err := s.store.GetDeploymentsForService(s.ServiceName, 1)
 	if err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, errors.New("NoItemsFound: no items found")
-		}
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				serviceLogger.Errorf(dynamodb.ErrCodeProvisionedThroughputExceededException, aerr.Error())
-			case dynamodb.ErrCodeResourceNotFoundException:
-				serviceLogger.Errorf(dynamodb.ErrCodeResourceNotFoundException, aerr.Error())
-			case dynamodb.ErrCodeInternalServerError:
-				serviceLogger.Errorf(dynamodb.ErrCodeInternalServerError, aerr.Error())
-			case "ValidationException":
-				serviceLogger.Errorf("%v", aerr.Error())
-			default:
-				serviceLogger.Errorf(aerr.Error())
-			}
-		} else {
-			return nil, err
-		}
-		serviceLogger.Errorf("Error during get: %v", err.Error())
+		s.logger().Errorf("Error during get: %v", err.Error())
 		return nil, err
 	}
-	serviceLogger.Debugf("Retrieved last deployment %v at %v", dd.ServiceName, dd.Time)
+	if len(dds) == 0 {
+		return nil, ErrNoDeploymentFound
+	}
+	dd := dds[0]
+	s.logger().Debugf("Retrieved last deployment %v at %v", dd.ServiceName, dd.Time)
 	return &dd, nil
 }
 func (s *Service) GetDeploys(action string, limit int64) ([]DynamoDeployment, error) {
@@ -298,9 +385,8 @@ func (s *Service) GetDeploys(action string, limit int64) ([]DynamoDeployment, er
 	switch {
 	case action == "byMonth":
 		for i := 0; i < 3; i++ {
-			var dd []DynamoDeployment
-			serviceLogger.Debugf("Retrieving records from: %v", time.Now().AddDate(0, i*-1, 0).Format("2006-01"))
-			err := s.table.Get("Month", time.Now().AddDate(0, i*-1, 0).Format("2006-01")).Index("MonthIndex").Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(limit).All(&dd)
+			s.logger().Debugf("Retrieving records from: %v", time.Now().AddDate(0, i*-1, 0).Format("2006-01"))
+			dd, err := s.store.GetDeploymentsByMonth(time.Now().AddDate(0, i*-1, 0).Format("2006-01"), limit)
 			dds = append(dds, dd...)
 			if err != nil {
 				return dds, err
@@ -311,9 +397,8 @@ func (s *Service) GetDeploys(action string, limit int64) ([]DynamoDeployment, er
 		}
 	case action == "byDay":
 		for i := 0; i < 3; i++ {
-			var dd []DynamoDeployment
-			serviceLogger.Debugf("Retrieving records from: %v", time.Now().AddDate(0, 0, i*-1).Format("2006-01-02"))
-			err := s.table.Get("Day", time.Now().AddDate(0, 0, i*-1).Format("2006-01-02")).Index("DayIndex").Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(limit).All(&dd)
+			s.logger().Debugf("Retrieving records from: %v", time.Now().AddDate(0, 0, i*-1).Format("2006-01-02"))
+			dd, err := s.store.GetDeploymentsByDay(time.Now().AddDate(0, 0, i*-1).Format("2006-01-02"), limit)
 			dds = append(dds, dd...)
 			if err != nil {
 				return dds, err
@@ -323,9 +408,8 @@ func (s *Service) GetDeploys(action string, limit int64) ([]DynamoDeployment, er
 			}
 		}
 	case action == "secondToLast":
-		var dd []DynamoDeployment
-		serviceLogger.Debugf("Retrieving second last deploy")
-		err := s.table.Get("ServiceName", s.ServiceName).Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(2).All(&dd)
+		s.logger().Debugf("Retrieving second last deploy")
+		dd, err := s.store.GetDeploymentsForService(s.ServiceName, 2)
 		if err != nil {
 			return dds, err
 		}
@@ -339,10 +423,8 @@ func (s *Service) GetDeploys(action string, limit int64) ([]DynamoDeployment, er
 	return dds, nil
 }
 func (s *Service) GetDeploysForService(serviceName string) ([]DynamoDeployment, error) {
-	var dds []DynamoDeployment
-	serviceLogger.Debugf("Retrieving records for: %v", serviceName)
-	err := s.table.Get("ServiceName", serviceName).Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(20).All(&dds)
-	return dds, err
+	s.logger().Debugf("Retrieving records for: %v", serviceName)
+	return s.store.GetDeploymentsForService(serviceName, 20)
 }
 
 func (s *Service) SetDeploymentStatus(dd *DynamoDeployment, status string) error {
@@ -350,17 +432,17 @@ func (s *Service) SetDeploymentStatus(dd *DynamoDeployment, status string) error
 	dd.Version = dd.Version + 1
 	dd.Status = status
 
-	serviceLogger.Debugf("Setting status of service %v_%v to %v", dd.ServiceName, dd.Time.Format("2006-01-02T15:04:05-0700"), status)
+	s.logger().Debugf("Setting status of service %v_%v to %v", dd.ServiceName, dd.Time.Format("2006-01-02T15:04:05-0700"), status)
 
 	if dd.Version > 1 {
-		err = s.table.Put(dd).If("$ = ?", "Version", (dd.Version - 1)).Run()
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
 	} else {
 		// version was not set, don't use version conditional
-		err = s.table.Put(dd).Run()
+		err = s.store.PutDeploymentIfStatus(dd, dd.Status)
 	}
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put: %v", err.Error())
+		s.logger().Errorf("Error during put: %v", err.Error())
 		return err
 	}
 	return nil
@@ -371,56 +453,90 @@ func (s *Service) SetDeploymentStatusWithReason(dd *DynamoDeployment, status, re
 	dd.Status = status
 	dd.DeployError = reason
 
-	serviceLogger.Debugf("Setting status of service %v_%v to %v", dd.ServiceName, dd.Time.Format("2006-01-02T15:04:05-0700"), status)
+	s.logger().Debugf("Setting status of service %v_%v to %v", dd.ServiceName, dd.Time.Format("2006-01-02T15:04:05-0700"), status)
 
 	if dd.Version > 1 {
-		err = s.table.Put(dd).If("$ = ?", "Version", (dd.Version - 1)).Run()
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
 	} else {
 		// version was not set, don't use version conditional
-		err = s.table.Put(dd).Run()
+		err = s.store.PutDeploymentIfStatus(dd, dd.Status)
 	}
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put: %v", err.Error())
+		s.logger().Errorf("Error during put: %v", err.Error())
 		return err
 	}
 	return nil
 }
-func (s *Service) GetDeployment(serviceName string, strTime string) (*DynamoDeployment, error) {
-	var dd DynamoDeployment
+func (s *Service) SetCanaryProgress(dd *DynamoDeployment, canary DynamoDeploymentCanary) error {
+	var err error
+	dd.Version = dd.Version + 1
+	dd.Canary = &canary
+
+	s.logger().Debugf("Setting canary weight of service %v_%v to %d%% (step %d/%d)", dd.ServiceName, dd.Time.Format("2006-01-02T15:04:05-0700"), canary.Weight, canary.Step, canary.Steps)
+
+	if dd.Version > 1 {
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
+	} else {
+		err = s.store.PutDeployment(dd)
+	}
+
+	if err != nil {
+		s.logger().Errorf("Error during put: %v", err.Error())
+		return err
+	}
+	return nil
+}
+
+// SetBakeDeadline sets until when the previous color of a blueGreen deployment keeps running, nil once it's scaled down
+func (s *Service) SetBakeDeadline(dd *DynamoDeployment, bakeDeadline *time.Time) error {
+	var err error
+	dd.Version = dd.Version + 1
+	dd.BakeDeadline = bakeDeadline
+
+	s.logger().Debugf("Setting bake deadline of service %v_%v to %v", dd.ServiceName, dd.Time.Format("2006-01-02T15:04:05-0700"), bakeDeadline)
 
+	if dd.Version > 1 {
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
+	} else {
+		err = s.store.PutDeployment(dd)
+	}
+
+	if err != nil {
+		s.logger().Errorf("Error during put: %v", err.Error())
+		return err
+	}
+	return nil
+}
+func (s *Service) GetDeployment(serviceName string, strTime string) (*DynamoDeployment, error) {
 	layout := "2006-01-02T15:04:05.9Z"
 	t, err := time.Parse(layout, strTime)
 
 	if err != nil {
-		serviceLogger.Errorf("Could not parse %v from string to time", strTime)
+		s.logger().Errorf("Could not parse %v from string to time", strTime)
 		return nil, err
 	}
 
-	serviceLogger.Debugf("Retrieving deployment of service %v_%v", serviceName, strTime)
-	err = s.table.Get("ServiceName", serviceName).Range("Time", dynamo.Equal, t).Limit(1).One(&dd)
+	s.logger().Debugf("Retrieving deployment of service %v_%v", serviceName, strTime)
+	dd, err := s.store.GetDeployment(serviceName, t)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			serviceLogger.Errorf(aerr.Error())
-		} else {
-			return nil, err
+		if err != ErrNoItemFound {
+			s.logger().Errorf("Error during get: %v", err.Error())
 		}
-		serviceLogger.Errorf("Error during get: %v", err.Error())
 		return nil, err
 	}
-	serviceLogger.Debugf("Retrieved deployment %v_%v with status %v", dd.ServiceName, dd.Time, dd.Status)
+	s.logger().Debugf("Retrieved deployment %v_%v with status %v", dd.ServiceName, dd.Time, dd.Status)
 
-	return &dd, nil
+	return dd, nil
 }
 
 func (s *Service) GetServiceVersionsByTags(serviceName, imageName string, tags map[string]string) ([]ServiceVersion, error) {
 	var svs []ServiceVersion
-	var dds []DynamoDeployment
 
 	matched := make(map[string]bool)
 
-	serviceLogger.Debugf("Retrieving records for: %v, imageId: %v", serviceName, imageName)
-	err := s.table.Get("ServiceName", serviceName).Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(int64(math.Max(float64(100), float64(len(tags))))).All(&dds)
+	s.logger().Debugf("Retrieving records for: %v, imageId: %v", serviceName, imageName)
+	dds, err := s.store.GetDeploymentsForService(serviceName, int64(math.Max(float64(100), float64(len(tags)))))
 	for _, dd := range dds {
 		for _, container := range dd.DeployData.Containers {
 			// determine containerTag
@@ -453,22 +569,12 @@ func (s *Service) GetServiceVersionsByTags(serviceName, imageName string, tags m
 }
 
 func (s *Service) CreateTable() error {
-	err := s.db.CreateTable(util.GetEnv("DYNAMODB_TABLE", "Services"), DynamoDeployment{}).
-		Provision(2, 1).
-		ProvisionIndex("DayIndex", 1, 1).
-		ProvisionIndex("MonthIndex", 1, 1).
-		Run()
-	if err != nil {
-		return err
-	}
-
-	s.table = s.db.Table(util.GetEnv("DYNAMODB_TABLE", "Services"))
-	return nil
+	return s.store.CreateTable()
 }
 func (s *Service) GetClusterName() (string, error) {
 	var clusterName string
 	var ds DynamoServices
-	serviceLogger.Debugf("Going to determine clusterName of %v", s.ServiceName)
+	s.logger().Debugf("Going to determine clusterName of %v", s.ServiceName)
 	err := s.GetServices(&ds)
 	if err != nil {
 		return clusterName, err
@@ -479,7 +585,7 @@ func (s *Service) GetClusterName() (string, error) {
 		}
 	}
 	if clusterName == "" {
-		return clusterName, errors.New("Service not found")
+		return clusterName, ErrServiceNotFound
 	}
 	return clusterName, nil
 }
@@ -489,14 +595,14 @@ func (s *Service) SetScalingProperty(desiredCount int64) error {
 	dd.Scaling.DesiredCount = desiredCount
 
 	if dd.Version > 1 {
-		err = s.table.Put(dd).If("$ = ?", "Version", (dd.Version - 1)).Run()
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
 	} else {
 		// version was not set, don't use version conditional
-		err = s.table.Put(dd).If("$ = ?", "Status", dd.Status).Run()
+		err = s.store.PutDeploymentIfStatus(dd, dd.Status)
 	}
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put: %v", err.Error())
+		s.logger().Errorf("Error during put: %v", err.Error())
 		return err
 	}
 	return nil
@@ -509,14 +615,14 @@ func (s *Service) SetAutoscalingProperties(desiredCount int64, resourceId string
 	dd.Scaling.Autoscaling.PolicyNames = policyNames
 
 	if dd.Version > 1 {
-		err = s.table.Put(dd).If("$ = ?", "Version", (dd.Version - 1)).Run()
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
 	} else {
 		// version was not set, don't use version conditional
-		err = s.table.Put(dd).If("$ = ?", "Status", dd.Status).Run()
+		err = s.store.PutDeploymentIfStatus(dd, dd.Status)
 	}
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put: %v", err.Error())
+		s.logger().Errorf("Error during put: %v", err.Error())
 		return err
 	}
 	return nil
@@ -527,14 +633,14 @@ func (s *Service) SetManualTasksArn(manualTaskArn string) error {
 	dd.Version = dd.Version + 1
 
 	if dd.Version > 1 {
-		err = s.table.Put(dd).If("$ = ?", "Version", (dd.Version - 1)).Run()
+		err = s.store.PutDeploymentIfVersion(dd, dd.Version-1)
 	} else {
 		// version was not set, don't use version conditional
-		err = s.table.Put(dd).If("$ = ?", "Status", dd.Status).Run()
+		err = s.store.PutDeploymentIfStatus(dd, dd.Status)
 	}
 
 	if err != nil {
-		serviceLogger.Errorf("Error during put: %v", err.Error())
+		s.logger().Errorf("Error during put: %v", err.Error())
 		return err
 	}
 	return nil
@@ -559,7 +665,7 @@ func (s *Service) UpdateServiceLimits(clusterName, serviceName string, cpuReserv
 		return errors.New("Couldn't update service limits: Service not found")
 	}
 	dss.Version = dss.Version + 1
-	return s.table.Put(dss).If("$ = ?", "Version", dss.Version-1).Run()
+	return s.store.PutServicesIfVersion(&dss, dss.Version-1)
 }
 func (s *Service) UpdateServiceListeners(clusterName, serviceName string, listeners []string) error {
 	var dss DynamoServices
@@ -578,7 +684,7 @@ func (s *Service) UpdateServiceListeners(clusterName, serviceName string, listen
 		return errors.New("Couldn't update service listener: Service not found")
 	}
 	dss.Version = dss.Version + 1
-	return s.table.Put(dss).If("$ = ?", "Version", dss.Version-1).Run()
+	return s.store.PutServicesIfVersion(&dss, dss.Version-1)
 }
 func (s *Service) GetApiVersion() (string, error) {
 	var dss DynamoServices
@@ -596,24 +702,19 @@ func (s *Service) SetApiVersion(apiVersion string) error {
 	}
 	dss.Version = dss.Version + 1
 	dss.ApiVersion = apiVersion
-	return s.table.Put(dss).If("$ = ?", "Version", dss.Version-1).Run()
+	return s.store.PutServicesIfVersion(&dss, dss.Version-1)
 }
 
 func (s *Service) GetClusterInfo() (*DynamoCluster, error) {
-	var dc DynamoCluster
-	err := s.table.Get("ServiceName", "__CLUSTERS").Range("Time", dynamo.LessOrEqual, time.Now()).Order(dynamo.Descending).Limit(1).One(&dc)
+	dc, err := s.store.GetLastClusterInfo()
 	if err != nil {
-		if err.Error() == "dynamo: no item found" {
+		if err == ErrNoItemFound {
 			return nil, nil
 		}
-		if aerr, ok := err.(awserr.Error); ok {
-			serviceLogger.Errorf(aerr.Error())
-		} else {
-			serviceLogger.Errorf(err.Error())
-		}
+		s.logger().Errorf(err.Error())
 		return nil, err
 	}
-	return &dc, nil
+	return dc, nil
 }
 func (s *Service) PutClusterInfo(dc DynamoCluster, clusterName string, action string, pendingAction string) (*DynamoCluster, error) {
 	dc.ScalingOperation = DynamoClusterScalingOperation{ClusterName: clusterName, Action: action, PendingAction: pendingAction}
@@ -621,43 +722,31 @@ func (s *Service) PutClusterInfo(dc DynamoCluster, clusterName string, action st
 	dc.Time = time.Now()
 	dc.ExpirationTime = time.Now().AddDate(0, 0, 30)
 	dc.ExpirationTimeTTL = dc.ExpirationTime.Unix()
-	err := s.table.Put(dc).Run()
+	err := s.store.PutClusterInfo(&dc)
 	if err != nil {
-		if err.Error() == "dynamo: no item found" {
-			return nil, nil
-		}
-		if aerr, ok := err.(awserr.Error); ok {
-			serviceLogger.Errorf(aerr.Error())
-		} else {
-			serviceLogger.Errorf(err.Error())
-		}
+		s.logger().Errorf(err.Error())
 		return nil, err
 	}
 	return &dc, nil
 }
 func (s *Service) GetScalingActivity(clusterName string, startTime time.Time) (string, string, error) {
-	var dcs []DynamoCluster
-	err := s.table.Get("ServiceName", "__CLUSTERS").Range("Time", dynamo.GreaterOrEqual, startTime).All(&dcs)
+	dcs, err := s.store.GetClusterInfoSince(startTime)
 	if err != nil {
-		if err.Error() == "dynamo: no item found" {
+		if err == ErrNoItemFound {
 			return "", "", nil
 		}
-		if aerr, ok := err.(awserr.Error); ok {
-			serviceLogger.Errorf(aerr.Error())
-		} else {
-			serviceLogger.Errorf(err.Error())
-		}
+		s.logger().Errorf(err.Error())
 		return "", "", err
 	}
 	for _, dc := range dcs {
 		// check actions
 		if dc.ScalingOperation.ClusterName == clusterName && dc.ScalingOperation.Action != "no" {
-			serviceLogger.Debugf("Found a previous scaling operation (action %v, start time: %v)", dc.ScalingOperation.Action, startTime.UTC().Format("2006-01-02T15:04:05-0700"))
+			s.logger().Debugf("Found a previous scaling operation (action %v, start time: %v)", dc.ScalingOperation.Action, startTime.UTC().Format("2006-01-02T15:04:05-0700"))
 			return dc.ScalingOperation.Action, "", nil
 		}
 		// check pending actions
 		if dc.ScalingOperation.ClusterName == clusterName && dc.ScalingOperation.PendingAction != "" {
-			serviceLogger.Debugf("Found a previous pending scaling operation (action %v, start time: %v)", dc.ScalingOperation.PendingAction, startTime.UTC().Format("2006-01-02T15:04:05-0700"))
+			s.logger().Debugf("Found a previous pending scaling operation (action %v, start time: %v)", dc.ScalingOperation.PendingAction, startTime.UTC().Format("2006-01-02T15:04:05-0700"))
 			return "no", dc.ScalingOperation.PendingAction, nil
 		}
 	}
@@ -680,39 +769,101 @@ func (s *Service) IsDeployRunning() (bool, error) {
 
 func (s *Service) AutoscalingPullInit() error {
 	p := &DynamoAutoscalingPull{Identifier: "__AUTOSCALINGPULL", Time: "0", Lock: "initial"}
-	err := s.table.Put(p).If("attribute_not_exists(L)").Run()
+	err := s.store.PutAutoscalingPullIfNotExists(p)
 
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return nil
-			default:
-				serviceLogger.Errorf("Error during put of first record: %v", aerr.Error())
-				return err
-			}
-		} else {
-			serviceLogger.Errorf("Error during put of first record: %v", err.Error())
-			return err
+		if err == ErrConditionalCheckFailed {
+			return nil
 		}
+		s.logger().Errorf("Error during put of first record: %v", err.Error())
+		return err
 	}
-	serviceLogger.Infof("initialized autoscalingPull in backend")
+	s.logger().Infof("initialized autoscalingPull in backend")
 	return nil
 }
 func (s *Service) AutoscalingPullAcquireLock(localId string) (bool, error) {
 	p := &DynamoAutoscalingPull{Identifier: "__AUTOSCALINGPULL", Time: "0", Lock: localId, LockTimestamp: time.Now()}
-	err := s.table.Put(p).If("$ < ?", "LT", time.Now().Add(-1*time.Minute)).Run()
+	err := s.store.PutAutoscalingPullIfExpired(p, time.Now().Add(-1*time.Minute))
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return false, nil
-			default:
-				return false, err
-			}
-		} else {
-			return false, err
+		if err == ErrConditionalCheckFailed {
+			return false, nil
 		}
+		return false, err
 	}
 	return true, nil
 }
+
+// AcquireDeployLock takes the deploy lock of the service when it's free, or when the deployment holding the lock
+// is finished. With supersede, a lock held by a running deployment is taken over as well. Returns the current lock
+// when it's held by another deployment
+func (s *Service) AcquireDeployLock(lockId string, supersede bool) (*DynamoDeployLock, error) {
+	var holder string
+	current, err := s.store.GetDeployLock(s.ServiceName)
+	if err != nil && err != ErrNoItemFound {
+		return nil, err
+	}
+	if current != nil && current.Lock != "" {
+		free, err := s.isDeployLockFree(current, supersede)
+		if err != nil {
+			return nil, err
+		}
+		if !free {
+			return current, nil
+		}
+		holder = current.Lock
+	}
+	l := &DynamoDeployLock{Identifier: "__DEPLOYLOCK", Time: s.ServiceName, Lock: lockId, LockTimestamp: time.Now()}
+	err = s.store.PutDeployLockIfHolder(l, holder)
+	if err == ErrConditionalCheckFailed {
+		// another deployment was faster
+		current, err = s.store.GetDeployLock(s.ServiceName)
+		if err == ErrNoItemFound {
+			return &DynamoDeployLock{Identifier: "__DEPLOYLOCK", Time: s.ServiceName}, nil
+		}
+		return current, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.logger().Debugf("Acquired deploy lock of %v (%v)", s.ServiceName, lockId)
+	return nil, nil
+}
+
+// isDeployLockFree returns true if the deployment that holds the lock is finished. A lock without deployment time
+// is held by a deploy that is still starting, which expires after DEPLOY_LOCK_TIMEOUT seconds
+func (s *Service) isDeployLockFree(l *DynamoDeployLock, supersede bool) (bool, error) {
+	if l.DeploymentTime.IsZero() {
+		timeout, err := strconv.Atoi(util.GetEnv("DEPLOY_LOCK_TIMEOUT", "900"))
+		if err != nil {
+			return false, err
+		}
+		return l.LockTimestamp.Before(time.Now().Add(-1 * time.Duration(timeout) * time.Second)), nil
+	}
+	if supersede {
+		return true, nil
+	}
+	dd, err := s.store.GetDeployment(s.ServiceName, l.DeploymentTime)
+	if err != nil {
+		if err == ErrNoItemFound {
+			return true, nil
+		}
+		return false, err
+	}
+	return dd.Status != "running", nil
+}
+
+// SetDeployLockDeployment stores the deployment time in the lock, the lock is held until that deployment is finished
+func (s *Service) SetDeployLockDeployment(lockId string, deploymentTime time.Time) error {
+	l := &DynamoDeployLock{Identifier: "__DEPLOYLOCK", Time: s.ServiceName, Lock: lockId, LockTimestamp: time.Now(), DeploymentTime: deploymentTime}
+	return s.store.PutDeployLockIfHolder(l, lockId)
+}
+
+// ReleaseDeployLock frees the deploy lock, if it's still held by lockId
+func (s *Service) ReleaseDeployLock(lockId string) error {
+	l := &DynamoDeployLock{Identifier: "__DEPLOYLOCK", Time: s.ServiceName}
+	err := s.store.PutDeployLockIfHolder(l, lockId)
+	if err == ErrConditionalCheckFailed {
+		return nil
+	}
+	return err
+}
@@ -13,9 +13,9 @@ func TestGetLastDeploy(t *testing.T) {
 	if accountId == nil {
 		t.Skip(noAWSMsg)
 	}
-	service := service.NewService()
-	service.serviceName = util.GetEnv("TEST_SERVICENAME", "ecs-deploy")
-	dd, err := service.getLastDeploy()
+	s := NewService()
+	s.ServiceName = util.GetEnv("TEST_SERVICENAME", "ecs-deploy")
+	dd, err := s.GetLastDeploy()
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "NoItemsFound") {
 			t.Errorf("getLastDeploys: %v", err)
@@ -33,8 +33,8 @@ func TestGetDeploymentByMonth(t *testing.T) {
 		t.Skip(noAWSMsg)
 	}
 	limit := 20
-	service := service.NewService()
-	dds, err := service.getDeploys("byMonth", int64(limit))
+	s := NewService()
+	dds, err := s.GetDeploys("byMonth", int64(limit))
 	if err != nil {
 		t.Errorf("getDeploys byMonth: %v", err)
 	}
@@ -48,8 +48,8 @@ func TestGetDeploymentByDay(t *testing.T) {
 		t.Skip(noAWSMsg)
 	}
 	limit := 20
-	service := service.NewService()
-	dds, err := service.getDeploys("byDay", int64(limit))
+	s := NewService()
+	dds, err := s.GetDeploys("byDay", int64(limit))
 	if err != nil {
 		t.Errorf("getDeploys byDay: %v", err)
 	}
@@ -62,9 +62,9 @@ func TestGetDeploymentSecondToLast(t *testing.T) {
 	if accountId == nil {
 		t.Skip(noAWSMsg)
 	}
-	service := service.NewService()
-	service.serviceName = util.GetEnv("TEST_SERVICENAME", "ecs-deploy")
-	dds, err := service.getDeploys("secondToLast", 1)
+	s := NewService()
+	s.ServiceName = util.GetEnv("TEST_SERVICENAME", "ecs-deploy")
+	dds, err := s.GetDeploys("secondToLast", 1)
 	if err != nil {
 		if !strings.HasPrefix(err.Error(), "NoSecondToLast") {
 			t.Errorf("getDeploys secondToLast: %v", err)
@@ -81,9 +81,9 @@ func TestGetServices(t *testing.T) {
 	if accountId == nil {
 		t.Skip(noAWSMsg)
 	}
-	service := service.NewService()
+	s := NewService()
 	var ds DynamoServices
-	err := service.getServices(&ds)
+	err := s.GetServices(&ds)
 	if err != nil {
 		t.Errorf("Couldn't retrieve services from dynamodb: %v\n", err.Error())
 	}
@@ -92,8 +92,8 @@ func TestGetClusterInfo(t *testing.T) {
 	if accountId == nil {
 		t.Skip(noAWSMsg)
 	}
-	service := service.NewService()
-	dc, err := service.getClusterInfo()
+	s := NewService()
+	dc, err := s.GetClusterInfo()
 	if err != nil {
 		t.Errorf("ClusterInfo: %v", err)
 	}
@@ -107,12 +107,12 @@ func TestGetScalingActivity(t *testing.T) {
 	if accountId == nil {
 		t.Skip(noAWSMsg)
 	}
-	service := service.NewService()
+	s := NewService()
 
 	clusterName := util.GetEnv("TEST_CLUSTERNAME", "testcluster")
 	startTime := time.Now().Add(-5 * time.Minute)
 
-	result, err := service.getScalingActivity(clusterName, startTime)
+	result, _, err := s.GetScalingActivity(clusterName, startTime)
 	if err != nil {
 		t.Errorf("ScalingActivity: %v", err)
 	}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/in4it/ecs-deploy/util"
+
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoItemFound            = errors.New("NoItemFound: no item found")
+	ErrConditionalCheckFailed = errors.New("ConditionalCheckFailed: conditional check failed")
+)
+
+// Store is the storage backend of ecs-deploy
+//
+// A store holds the deployments (one partition per service), the __SERVICES record,
+// the __CLUSTERS scaling state, the __AUTOSCALINGPULL lock, the __DEPLOYLOCK locks
+// (one per service), the __RULEPRIORITIES reservations (one per listener), the __RBAC roles, the __APITOKENS (one per token hash), the
+// __WAVEDEPLOYS (one per deploy in waves) and the __AUDIT entries. Get methods return
+// ErrNoItemFound when nothing matches, conditional puts return ErrConditionalCheckFailed
+// when the condition is not met.
+type Store interface {
+	CreateTable() error
+
+	GetServices(ds *DynamoServices) error
+	PutServices(ds *DynamoServices) error
+	PutServicesIfVersion(ds *DynamoServices, version int64) error
+
+	GetDeployment(serviceName string, t time.Time) (*DynamoDeployment, error)
+	GetDeploymentsForService(serviceName string, limit int64) ([]DynamoDeployment, error)
+	GetDeploymentsByDay(day string, limit int64) ([]DynamoDeployment, error)
+	GetDeploymentsByMonth(month string, limit int64) ([]DynamoDeployment, error)
+	PutDeployment(dd *DynamoDeployment) error
+	PutDeploymentIfVersion(dd *DynamoDeployment, version int64) error
+	PutDeploymentIfStatus(dd *DynamoDeployment, status string) error
+
+	GetLastClusterInfo() (*DynamoCluster, error)
+	GetClusterInfoSince(startTime time.Time) ([]DynamoCluster, error)
+	PutClusterInfo(dc *DynamoCluster) error
+
+	PutAutoscalingPullIfNotExists(p *DynamoAutoscalingPull) error
+	PutAutoscalingPullIfExpired(p *DynamoAutoscalingPull, expiredBefore time.Time) error
+
+	GetDeployLock(serviceName string) (*DynamoDeployLock, error)
+	PutDeployLockIfHolder(l *DynamoDeployLock, holder string) error
+
+	GetRulePriorities(listenerArn string) (*DynamoRulePriorities, error)
+	PutRulePrioritiesIfVersion(rp *DynamoRulePriorities, version int64) error
+
+	GetRBAC() (*DynamoRBAC, error)
+	PutRBACIfVersion(r *DynamoRBAC, version int64) error
+
+	GetAPITokens() ([]DynamoAPIToken, error)
+	GetAPIToken(hash string) (*DynamoAPIToken, error)
+	PutAPITokenIfNotExists(t *DynamoAPIToken) error
+	PutAPITokenLastUsed(hash string, lastUsed time.Time) error
+	DeleteAPIToken(hash string) error
+
+	GetWaveDeploy(id string) (*DynamoWaveDeploy, error)
+	GetWaveDeploysSince(id string) ([]DynamoWaveDeploy, error)
+	PutWaveDeployIfVersion(w *DynamoWaveDeploy, version int64) error
+
+	PutAuditEntry(e *DynamoAuditEntry) error
+	GetAuditEntries(from, to time.Time, serviceName, user string, limit int64) ([]DynamoAuditEntry, error)
+}
+
+// NewStore returns the store configured with STORAGE_BACKEND (dynamodb or local)
+func NewStore() (Store, error) {
+	switch util.GetEnv("STORAGE_BACKEND", "dynamodb") {
+	case "dynamodb":
+		return NewDynamoStore(util.GetEnv("DYNAMODB_TABLE", "Services")), nil
+	case "local":
+		return NewLocalStore(util.GetEnv("STORAGE_LOCAL_PATH", "ecs-deploy.db"))
+	default:
+		return nil, errors.New("Unknown STORAGE_BACKEND: " + util.GetEnv("STORAGE_BACKEND", ""))
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix is the prefix of the api tokens, to tell them apart from the jwt tokens
+const APITokenPrefix = "ecsd_"
+
+// the last used time of a token is written at most once per interval
+const apiTokenLastUsedInterval = time.Minute
+
+var (
+	ErrAPITokenExists  = errors.New("An api token with this name already exists")
+	ErrAPITokenInvalid = errors.New("Invalid api token")
+	ErrAPITokenExpired = errors.New("The api token is expired")
+)
+
+// APITokenRequest is the request to create an api token, without expiry the token doesn't expire
+type APITokenRequest struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+	ExpiresAt   *time.Time   `json:"expiresAt"`
+}
+
+// IsAPIToken returns true if the token looks like an api token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+func getAPITokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateAPIToken creates a named token with the permissions. The token is returned once, only its hash is stored
+func (s *Service) CreateAPIToken(name, createdBy string, permissions []Permission, expiresAt *time.Time) (string, *DynamoAPIToken, error) {
+	tokens, err := s.store.GetAPITokens()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, t := range tokens {
+		if t.Name == name {
+			return "", nil, ErrAPITokenExists
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + hex.EncodeToString(b)
+	t := &DynamoAPIToken{
+		Identifier:  "__APITOKENS",
+		Hash:        getAPITokenHash(token),
+		Name:        name,
+		Permissions: permissions,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   expiresAt,
+	}
+	if err := s.store.PutAPITokenIfNotExists(t); err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+// GetAPITokens returns all api tokens
+func (s *Service) GetAPITokens() ([]DynamoAPIToken, error) {
+	return s.store.GetAPITokens()
+}
+
+// AuthenticateAPIToken returns the api token if it exists and is not expired, and updates the last used time
+func (s *Service) AuthenticateAPIToken(token string) (*DynamoAPIToken, error) {
+	if !IsAPIToken(token) {
+		return nil, ErrAPITokenInvalid
+	}
+	t, err := s.store.GetAPIToken(getAPITokenHash(token))
+	if err == ErrNoItemFound {
+		return nil, ErrAPITokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+		return nil, ErrAPITokenExpired
+	}
+	if t.LastUsed == nil || now.Sub(*t.LastUsed) >= apiTokenLastUsedInterval {
+		err = s.store.PutAPITokenLastUsed(t.Hash, now)
+		if err == ErrConditionalCheckFailed {
+			// revoked in the meantime
+			return nil, ErrAPITokenInvalid
+		}
+		if err != nil {
+			s.logger().Errorf("Could not update last used time of api token %v: %v", t.Name, err)
+		}
+		t.LastUsed = &now
+	}
+	return t, nil
+}
+
+// RevokeAPIToken deletes the api token with the name, returns ErrNoItemFound if it doesn't exist
+func (s *Service) RevokeAPIToken(name string) error {
+	tokens, err := s.store.GetAPITokens()
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.Name == name {
+			return s.store.DeleteAPIToken(t.Hash)
+		}
+	}
+	return ErrNoItemFound
+}
+
+// IsAuthorized returns true if the permissions of the token grant the action on the service
+func (t *DynamoAPIToken) IsAuthorized(action, clusterName, serviceName string) bool {
+	return permissionsAllow(t.Permissions, action, clusterName, serviceName)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	s, teardown := newLocalTestService(t)
+	defer teardown()
+
+	permissions := []Permission{{Action: PermissionDeploy, Service: "team-a-*"}}
+	token, apiToken, err := s.CreateAPIToken("ci", "deploy", permissions, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if !IsAPIToken(token) || apiToken.Hash == token || apiToken.Hash != getAPITokenHash(token) {
+		t.Errorf("Unexpected token %v with hash %v", token, apiToken.Hash)
+	}
+	if _, _, err = s.CreateAPIToken("ci", "deploy", permissions, nil); err != ErrAPITokenExists {
+		t.Errorf("Expected ErrAPITokenExists, got: %v", err)
+	}
+
+	authenticated, err := s.AuthenticateAPIToken(token)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIToken: %v", err)
+	}
+	if authenticated.LastUsed == nil {
+		t.Errorf("Expected the last used time to be set")
+	}
+	if !authenticated.IsAuthorized(PermissionDeploy, "mycluster", "team-a-api") || authenticated.IsAuthorized(PermissionDeploy, "mycluster", "team-b-api") {
+		t.Errorf("Unexpected authorization of token with permissions %+v", authenticated.Permissions)
+	}
+	if _, err = s.AuthenticateAPIToken(APITokenPrefix + "unknown"); err != ErrAPITokenInvalid {
+		t.Errorf("Expected ErrAPITokenInvalid, got: %v", err)
+	}
+
+	// expired tokens can't be used
+	expired := time.Now().Add(-time.Minute)
+	expiredToken, _, err := s.CreateAPIToken("expired", "deploy", permissions, &expired)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if _, err = s.AuthenticateAPIToken(expiredToken); err != ErrAPITokenExpired {
+		t.Errorf("Expected ErrAPITokenExpired, got: %v", err)
+	}
+
+	tokens, err := s.GetAPITokens()
+	if err != nil {
+		t.Fatalf("GetAPITokens: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("Expected 2 tokens, got: %+v", tokens)
+	}
+	if err = s.RevokeAPIToken("ci"); err != nil {
+		t.Fatalf("RevokeAPIToken: %v", err)
+	}
+	if _, err = s.AuthenticateAPIToken(token); err != ErrAPITokenInvalid {
+		t.Errorf("Expected a revoked token to be invalid, got: %v", err)
+	}
+	if err = s.RevokeAPIToken("ci"); err != ErrNoItemFound {
+		t.Errorf("Expected ErrNoItemFound, got: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"time"
+)
+
+// statuses of a wave deploy and of the services of a wave deploy
+const (
+	WaveDeployStatusQueued  = "queued"
+	WaveDeployStatusRunning = "running"
+	WaveDeployStatusSuccess = "success"
+	WaveDeployStatusFailed  = "failed"
+	WaveDeployStatusSkipped = "skipped"
+)
+
+// NewWaveDeploy returns a wave deploy with all services queued. The services in errors (e.g. failed validation) are
+// not deployed when it's their turn
+func NewWaveDeploy(waves [][]Deploy, errors map[string]string) *DynamoWaveDeploy {
+	now := time.Now().UTC()
+	w := &DynamoWaveDeploy{
+		Identifier: "__WAVEDEPLOYS",
+		Id:         now.Format(localStoreTimeLayout),
+		Status:     WaveDeployStatusRunning,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	for i, wave := range waves {
+		for j := range wave {
+			w.Services = append(w.Services, DynamoWaveDeployService{
+				ServiceName: wave[j].ServiceName,
+				Wave:        int64(i),
+				Status:      WaveDeployStatusQueued,
+				Error:       errors[wave[j].ServiceName],
+				Deploy:      &wave[j],
+			})
+		}
+	}
+	return w
+}
+
+// GetWaves returns the number of waves
+func (w *DynamoWaveDeploy) GetWaves() int64 {
+	var waves int64
+	for _, ws := range w.Services {
+		if ws.Wave+1 > waves {
+			waves = ws.Wave + 1
+		}
+	}
+	return waves
+}
+
+// GetQueued returns the deploys of the wave that are queued and the errors of the deploys that can't be deployed
+func (w *DynamoWaveDeploy) GetQueued(wave int64) ([]Deploy, map[string]string) {
+	var deploys []Deploy
+	errors := make(map[string]string)
+	for _, ws := range w.Services {
+		if ws.Wave != wave || ws.Status != WaveDeployStatusQueued {
+			continue
+		}
+		deploys = append(deploys, *ws.Deploy)
+		if ws.Error != "" {
+			errors[ws.ServiceName] = ws.Error
+		}
+	}
+	return deploys, errors
+}
+
+// GetRunning returns the services of the wave that are being deployed
+func (w *DynamoWaveDeploy) GetRunning(wave int64) []DynamoWaveDeployService {
+	var running []DynamoWaveDeployService
+	for _, ws := range w.Services {
+		if ws.Wave == wave && ws.Status == WaveDeployStatusRunning {
+			running = append(running, ws)
+		}
+	}
+	return running
+}
+
+// IsWaveFailed returns true when a service of the wave failed
+func (w *DynamoWaveDeploy) IsWaveFailed(wave int64) bool {
+	for _, ws := range w.Services {
+		if ws.Wave == wave && ws.Status == WaveDeployStatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// SetServiceRunning sets the deployment of the service
+func (w *DynamoWaveDeploy) SetServiceRunning(serviceName string, deploymentTime time.Time) {
+	for i := range w.Services {
+		if w.Services[i].ServiceName == serviceName {
+			deploymentTime := deploymentTime.UTC()
+			w.Services[i].Status = WaveDeployStatusRunning
+			w.Services[i].DeploymentTime = &deploymentTime
+		}
+	}
+}
+
+// SetServiceStatus sets the status of the service, with the error when the service failed
+func (w *DynamoWaveDeploy) SetServiceStatus(serviceName, status, reason string) {
+	for i := range w.Services {
+		if w.Services[i].ServiceName == serviceName {
+			w.Services[i].Status = status
+			w.Services[i].Error = reason
+		}
+	}
+}
+
+// Halt skips the services that are still queued and fails the wave deploy
+func (w *DynamoWaveDeploy) Halt(reason string) {
+	for i := range w.Services {
+		if w.Services[i].Status == WaveDeployStatusQueued {
+			w.Services[i].Status = WaveDeployStatusSkipped
+			w.Services[i].Error = reason
+		}
+	}
+	w.Status = WaveDeployStatusFailed
+}
+
+// CreateWaveDeploy stores a new wave deploy
+func (s *Service) CreateWaveDeploy(w *DynamoWaveDeploy) error {
+	w.Version = 1
+	if err := s.store.PutWaveDeployIfVersion(w, 0); err != nil {
+		w.Version = 0
+		return err
+	}
+	return nil
+}
+
+// UpdateWaveDeploy stores the progress of the wave deploy. ErrConditionalCheckFailed is returned when the wave deploy
+// was updated by another ecs-deploy in the meantime
+func (s *Service) UpdateWaveDeploy(w *DynamoWaveDeploy) error {
+	w.Version = w.Version + 1
+	w.UpdatedAt = time.Now().UTC()
+	if err := s.store.PutWaveDeployIfVersion(w, w.Version-1); err != nil {
+		w.Version = w.Version - 1
+		return err
+	}
+	return nil
+}
+
+func (s *Service) GetWaveDeploy(id string) (*DynamoWaveDeploy, error) {
+	return s.store.GetWaveDeploy(id)
+}
+
+// GetRunningWaveDeploys returns the wave deploys since the given time that are not finished
+func (s *Service) GetRunningWaveDeploys(since time.Time) ([]DynamoWaveDeploy, error) {
+	var running []DynamoWaveDeploy
+	waveDeploys, err := s.store.GetWaveDeploysSince(since.UTC().Format(localStoreTimeLayout))
+	if err != nil {
+		return running, err
+	}
+	for _, w := range waveDeploys {
+		if w.Status == WaveDeployStatusRunning {
+			running = append(running, w)
+		}
+	}
+	return running, nil
+}
@@ -5,7 +5,7 @@ resource "aws_alb_listener_rule" "${SERVICE}-rule-${LISTENER_PRIORITY}" {
 
   action {
     type = "forward"
-    target_group_arn = "${aws_alb_target_group.${SERVICE}.arn}"
+    target_group_arn = "${aws_alb_target_group.${TARGET_GROUP_NAME}.arn}"
   }
   ${LISTENER_CONDITION_RULE}
 }
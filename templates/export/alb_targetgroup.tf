@@ -1,6 +1,6 @@
-resource "aws_alb_target_group" "${SERVICE}" {
+resource "aws_alb_target_group" "${TARGET_GROUP_NAME}" {
   # arn = ${TARGET_GROUP_ARN}
-  name     = "${SERVICE}"
+  name     = "${TARGET_GROUP_NAME}"
   port     = ${SERVICE_PORT}
   protocol = "${SERVICE_PROTOCOL}"
   vpc_id   = "${VPC_ID}"
@@ -0,0 +1,12 @@
+resource "aws_lb_listener" "${SERVICE}-listener-${LISTENER_PORT}" {
+  # arn = ${LISTENER_ARN}
+  load_balancer_arn = "${LOADBALANCER_ARN}"
+  port = ${LISTENER_PORT}
+  protocol = "${LISTENER_PROTOCOL}"
+  ${LISTENER_CERTIFICATE}
+
+  default_action {
+    type = "forward"
+    target_group_arn = "${aws_alb_target_group.${TARGET_GROUP_NAME}.arn}"
+  }
+}
@@ -0,0 +1,7 @@
+health_check {
+    healthy_threshold = ${HEALTHCHECK_HEALTHYTHRESHOLD}
+    unhealthy_threshold = ${HEALTHCHECK_UNHEALTHYTHRESHOLD}
+    protocol = "TCP"
+    interval = ${HEALTHCHECK_INTERVAL}
+    timeout = ${HEALTHCHECK_TIMEOUT}
+  }
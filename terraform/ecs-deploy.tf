@@ -109,6 +109,10 @@ resource "aws_iam_role_policy" "ecs-deploy-policy" {
         "elasticloadbalancing:Describe*",
         "elasticloadbalancing:CreateRule",
         "elasticloadbalancing:DeleteRule",
+        "elasticloadbalancing:AddTags",
+        "elasticloadbalancing:SetRulePriorities",
+        "elasticloadbalancing:CreateListener",
+        "elasticloadbalancing:DeleteListener",
         "elasticloadbalancing:CreateTargetGroup",
         "elasticloadbalancing:DeleteTargetGroup",
         "elasticloadbalancing:ModifyTargetGroupAttributes",
@@ -119,6 +123,8 @@ resource "aws_iam_role_policy" "ecs-deploy-policy" {
         "autoscaling:UpdateAutoScalingGroup",
         "autoscaling:CompleteLifecycleAction",
         "logs:GetLogEvents",
+        "logs:CreateLogStream",
+        "logs:PutLogEvents",
         "ec2:DescribeTags",
         "cloudwatch:PutMetricAlarm",
         "cloudwatch:DescribeAlarms",
@@ -138,6 +144,7 @@ resource "aws_iam_role_policy" "ecs-deploy-policy" {
           "iam:CreateRole",
           "iam:AttachRolePolicy",
           "iam:PutRolePolicy",
+          "iam:DeleteRolePolicy",
           "iam:GetRole",
           "iam:PassRole"
       ],
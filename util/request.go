@@ -0,0 +1,56 @@
+package util
+
+import (
+	"github.com/juju/loggo"
+
+	"context"
+)
+
+// requestIDKey is the key of the request id in the context of an api request
+type requestIDKey struct{}
+
+// WithRequestID returns the context with the id of the api request
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// GetRequestID returns the id of the api request of the context, empty when the context isn't of an api request
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// RequestLogger prefixes the messages with the id of the api request, to find all the logs of a request
+type RequestLogger struct {
+	loggo.Logger
+	RequestID string
+}
+
+func (l RequestLogger) logf(level loggo.Level, message string, args ...interface{}) {
+	if l.RequestID != "" {
+		// the id comes from the X-Request-ID header, it's an argument so a % in it isn't a verb
+		message = "[%s] " + message
+		args = append([]interface{}{l.RequestID}, args...)
+	}
+	// the location of the log is the caller of Errorf, Infof, ...
+	l.Logger.LogCallf(3, level, message, args...)
+}
+
+func (l RequestLogger) Criticalf(message string, args ...interface{}) {
+	l.logf(loggo.CRITICAL, message, args...)
+}
+func (l RequestLogger) Errorf(message string, args ...interface{}) {
+	l.logf(loggo.ERROR, message, args...)
+}
+func (l RequestLogger) Warningf(message string, args ...interface{}) {
+	l.logf(loggo.WARNING, message, args...)
+}
+func (l RequestLogger) Infof(message string, args ...interface{}) {
+	l.logf(loggo.INFO, message, args...)
+}
+func (l RequestLogger) Debugf(message string, args ...interface{}) {
+	l.logf(loggo.DEBUG, message, args...)
+}
+func (l RequestLogger) Tracef(message string, args ...interface{}) {
+	l.logf(loggo.TRACE, message, args...)
+}
@@ -0,0 +1,65 @@
+package validation
+
+import (
+	"github.com/in4it/ecs-deploy/service"
+
+	"fmt"
+	"path"
+)
+
+// ValidateRBAC checks the roles and the role bindings and returns all the problems at once
+func ValidateRBAC(r *service.DynamoRBAC) Errors {
+	v := &validator{}
+	roles := make(map[string]bool)
+	for i, role := range r.Roles {
+		field := fmt.Sprintf("roles[%d]", i)
+		if role.Name == "" {
+			v.add(field+".name", "is required")
+		} else if roles[role.Name] {
+			v.add(field+".name", "role %v already exists", role.Name)
+		}
+		roles[role.Name] = true
+		for j, p := range role.Permissions {
+			v.validatePermission(fmt.Sprintf("%v.permissions[%d]", field, j), p)
+		}
+	}
+	for i, binding := range r.Bindings {
+		field := fmt.Sprintf("bindings[%d]", i)
+		if (binding.User == "") == (binding.Group == "") {
+			v.add(field, "needs either a user or a group")
+		}
+		if len(binding.Roles) == 0 {
+			v.add(field+".roles", "at least one role is required")
+		}
+		for j, name := range binding.Roles {
+			if !roles[name] {
+				v.add(fmt.Sprintf("%v.roles[%d]", field, j), "role %v doesn't exist", name)
+			}
+		}
+	}
+	return v.errors
+}
+
+func (v *validator) validatePermission(field string, p service.Permission) {
+	if p.Action == "" {
+		v.add(field+".action", "is required")
+	} else if _, err := path.Match(p.Action, ""); err != nil {
+		v.add(field+".action", "is not a valid glob")
+	} else {
+		var found bool
+		for _, permission := range service.Permissions {
+			if matched, _ := path.Match(p.Action, permission); matched {
+				found = true
+			}
+		}
+		if !found {
+			v.add(field+".action", "doesn't match any of %v", service.Permissions)
+		}
+	}
+	if _, err := path.Match(p.Cluster, ""); err != nil {
+		v.add(field+".cluster", "is not a valid glob")
+	}
+	if _, err := path.Match(p.Service, ""); err != nil {
+		v.add(field+".service", "is not a valid glob")
+	}
+}
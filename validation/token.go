@@ -0,0 +1,29 @@
+package validation
+
+import (
+	"github.com/in4it/ecs-deploy/service"
+
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var apiTokenNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// ValidateAPIToken checks the request to create an api token and returns all the problems at once
+func ValidateAPIToken(r *service.APITokenRequest) Errors {
+	v := &validator{}
+	if !apiTokenNameRegexp.MatchString(r.Name) {
+		v.add("name", "needs to be 1 to 64 letters, digits, _, . or -")
+	}
+	if len(r.Permissions) == 0 {
+		v.add("permissions", "at least one permission is required")
+	}
+	for i, p := range r.Permissions {
+		v.validatePermission(fmt.Sprintf("permissions[%d]", i), p)
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		v.add("expiresAt", "needs to be in the future")
+	}
+	return v.errors
+}
@@ -0,0 +1,765 @@
+package validation
+
+import (
+	"github.com/in4it/ecs-deploy/service"
+
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// FieldError is a problem with a field of a deploy. Field is the json path of the field, e.g. containers[0].memory
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Errors contains all the problems of a deploy
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	var messages []string
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// fargate cpu units and the memory (in MiB) that can be used with them
+var fargateTaskSizes = map[int64][]int64{
+	256:  {512, 1024, 2048},
+	512:  {1024, 2048, 3072, 4096},
+	1024: {2048, 3072, 4096, 5120, 6144, 7168, 8192},
+	2048: {4096, 5120, 6144, 7168, 8192, 9216, 10240, 11264, 12288, 13312, 14336, 15360, 16384},
+	4096: {8192, 9216, 10240, 11264, 12288, 13312, 14336, 15360, 16384, 17408, 18432, 19456, 20480, 21504, 22528, 23552, 24576, 25600, 26624, 27648, 28672, 29696, 30720},
+}
+
+// IsValidFargateTaskSize returns true if the combination of cpu units and memory is supported by fargate
+func IsValidFargateTaskSize(cpu, memory int64) bool {
+	for _, m := range fargateTaskSizes[cpu] {
+		if m == memory {
+			return true
+		}
+	}
+	return false
+}
+
+type validator struct {
+	errors Errors
+}
+
+func (v *validator) add(field, format string, a ...interface{}) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) checkRange(field string, value, min, max int64) {
+	if value != 0 && (value < min || value > max) {
+		v.add(field, "needs to be between %d and %d", min, max)
+	}
+}
+
+// ValidateDeploy checks the deploy of a service and returns all the problems at once. The rule conditions are
+// checked against the listeners (protocols) by load balancer name, the check is skipped for load balancers that
+// are not in listeners
+func ValidateDeploy(serviceName string, d service.Deploy, listeners map[string][]string) Errors {
+	v := &validator{}
+	v.validateService(serviceName, d)
+	v.validateDeploymentStrategy(serviceName, d)
+	v.validateContainers(serviceName, d)
+	v.validateNetwork(d)
+	v.validateHealthCheck(d)
+	v.validateNetworkProtocol("", d)
+	if len(d.RuleConditions) > 0 && strings.ToLower(d.ServiceProtocol) == "none" {
+		v.add("ruleConditions", "can't be used when serviceProtocol is set to none")
+	} else if !service.IsNetworkProtocol(d.ServiceProtocol) {
+		v.validateRuleConditions("ruleConditions", d.RuleConditions, listeners[service.GetLoadBalancerName(d)])
+	}
+	v.validateLoadBalancers(serviceName, d, listeners)
+	return v.errors
+}
+
+func (v *validator) validateService(serviceName string, d service.Deploy) {
+	if len(serviceName) < 3 {
+		v.add("serviceName", "needs to be at least 3 characters")
+	}
+	if d.Cluster == "" {
+		v.add("cluster", "is required")
+	}
+	if strings.ToLower(d.ServiceProtocol) != "none" && d.ServicePort == 0 {
+		v.add("servicePort", "needs to be set if serviceProtocol is not set to none")
+	}
+	if d.DesiredCount < 0 {
+		v.add("desiredCount", "can't be negative")
+	}
+	for i, dependency := range d.DependsOn {
+		if dependency == serviceName {
+			v.add(fmt.Sprintf("dependsOn[%d]", i), "a service can't depend on itself")
+		}
+	}
+}
+
+func (v *validator) validateDeploymentStrategy(serviceName string, d service.Deploy) {
+	switch strings.ToLower(d.DeploymentStrategy) {
+	case "", "rolling":
+	case "bluegreen":
+		if strings.ToLower(d.ServiceProtocol) == "none" {
+			v.add("serviceProtocol", "blueGreen deployments need a loadbalancer, serviceProtocol can't be set to none")
+		} else if service.IsNetworkProtocol(d.ServiceProtocol) {
+			v.add("serviceProtocol", "blueGreen deployments need listener rules, they can't be used with protocol %v", d.ServiceProtocol)
+		}
+		// the target group name of the green color has a -green suffix, target group names have a maximum of 32 characters
+		if len(serviceName) > 26 {
+			v.add("serviceName", "can't be longer than 26 characters when using blueGreen deployments")
+		}
+	case "canary":
+		if strings.ToLower(d.ServiceProtocol) == "none" {
+			v.add("serviceProtocol", "canary deployments need a loadbalancer, serviceProtocol can't be set to none")
+		} else if service.IsNetworkProtocol(d.ServiceProtocol) {
+			v.add("serviceProtocol", "canary deployments need listener rules, they can't be used with protocol %v", d.ServiceProtocol)
+		}
+		// the canary target group has a -canary suffix
+		if len(serviceName) > 25 {
+			v.add("serviceName", "can't be longer than 25 characters when using canary deployments")
+		}
+		var previousStep int64
+		for i, step := range d.Canary.Steps {
+			if step <= previousStep || step > 100 {
+				v.add(fmt.Sprintf("canary.steps[%d]", i), "canary steps need to be ascending percentages between 1 and 100")
+			}
+			previousStep = step
+		}
+		if d.Canary.Interval < 0 {
+			v.add("canary.interval", "can't be negative")
+		}
+		if d.Canary.MaxErrorRate < 0 {
+			v.add("canary.maxErrorRate", "can't be negative")
+		}
+		if d.Canary.MaxLatency < 0 {
+			v.add("canary.maxLatency", "can't be negative")
+		}
+	default:
+		v.add("deploymentStrategy", "needs to be rolling, blueGreen or canary")
+	}
+	// the health gates are only checked after a rolling deployment
+	switch strings.ToLower(d.DeploymentStrategy) {
+	case "bluegreen", "canary":
+		if len(d.HealthGates.Alarms) > 0 || len(d.HealthGates.Metrics) > 0 {
+			v.add("healthGates", "can only be used with rolling deployments, not with %v deployments", d.DeploymentStrategy)
+		}
+	}
+	if d.BakeTime < 0 {
+		v.add("bakeTime", "can't be negative")
+	}
+	if d.HealthGates.Window < 0 {
+		v.add("healthGates.window", "can't be negative")
+	}
+	if d.HealthGates.Interval < 0 {
+		v.add("healthGates.interval", "can't be negative")
+	}
+	for i, metric := range d.HealthGates.Metrics {
+		field := fmt.Sprintf("healthGates.metrics[%d]", i)
+		if metric.Namespace == "" {
+			v.add(field+".namespace", "is required")
+		}
+		if metric.MetricName == "" {
+			v.add(field+".metricName", "is required")
+		}
+		switch metric.Statistic {
+		case "", "Average", "Sum", "Minimum", "Maximum", "SampleCount":
+		default:
+			v.add(field+".statistic", "needs to be Average, Sum, Minimum, Maximum or SampleCount")
+		}
+		switch metric.ComparisonOperator {
+		case "", "GreaterThanThreshold", "GreaterThanOrEqualToThreshold", "LessThanThreshold", "LessThanOrEqualToThreshold":
+		default:
+			v.add(field+".comparisonOperator", "needs to be GreaterThanThreshold, GreaterThanOrEqualToThreshold, LessThanThreshold or LessThanOrEqualToThreshold")
+		}
+	}
+}
+
+func (v *validator) validateContainers(serviceName string, d service.Deploy) {
+	if len(d.Containers) == 0 {
+		v.add("containers", "at least one container is required")
+		return
+	}
+	volumes := make(map[string]bool)
+	for _, volume := range d.Volumes {
+		volumes[volume.Name] = true
+	}
+	containerNames := make(map[string]bool)
+	var serviceContainer bool
+	for i, container := range d.Containers {
+		field := fmt.Sprintf("containers[%d]", i)
+		if container.ContainerName == "" {
+			v.add(field+".containerName", "is required")
+		}
+		if containerNames[container.ContainerName] {
+			v.add(field+".containerName", "container %v is defined more than once", container.ContainerName)
+		}
+		containerNames[container.ContainerName] = true
+		if container.ContainerName == serviceName {
+			serviceContainer = true
+		}
+		if container.Memory == 0 && container.MemoryReservation == 0 && d.Memory == 0 {
+			v.add(field+".memory", "at least one of memory or memoryReservation needs to be set")
+		}
+		if container.Memory > 0 && container.MemoryReservation > container.Memory {
+			v.add(field+".memoryReservation", "can't be higher than memory")
+		}
+		if container.CPU > 0 && container.CPUReservation > container.CPU {
+			v.add(field+".cpuReservation", "can't be higher than cpu")
+		}
+		for j, mountPoint := range container.MountPoints {
+			if !volumes[mountPoint.SourceVolume] {
+				v.add(fmt.Sprintf("%v.mountPoints[%d].sourceVolume", field, j), "volume %v is not defined in volumes", mountPoint.SourceVolume)
+			}
+		}
+		secretNames := make(map[string]bool)
+		for j, secret := range container.Secrets {
+			secretField := fmt.Sprintf("%v.secrets[%d]", field, j)
+			if secret.Name == "" {
+				v.add(secretField+".name", "is required")
+			} else if secretNames[secret.Name] {
+				v.add(secretField+".name", "secret %v is defined more than once", secret.Name)
+			}
+			secretNames[secret.Name] = true
+			if secret.ValueFrom == "" {
+				v.add(secretField+".valueFrom", "is required")
+			} else if strings.HasPrefix(secret.ValueFrom, "arn:") && !strings.HasPrefix(secret.ValueFrom, "arn:aws:ssm:") && !strings.HasPrefix(secret.ValueFrom, "arn:aws:secretsmanager:") {
+				v.add(secretField+".valueFrom", "needs to be a parameter or a Secrets Manager secret")
+			}
+		}
+		if credentials := container.RepositoryCredentials.CredentialsParameter; credentials != "" && !strings.HasPrefix(credentials, "arn:aws:secretsmanager:") {
+			v.add(field+".repositoryCredentials.credentialsParameter", "needs to be the arn of a Secrets Manager secret")
+		}
+		if len(container.Links) > 0 && d.NetworkMode != "" && d.NetworkMode != "bridge" {
+			v.add(field+".links", "links are only supported with the bridge networkMode")
+		}
+		containerPorts := make(map[string]bool)
+		for j, pm := range container.PortMappings {
+			portField := fmt.Sprintf("%v.portMappings[%d]", field, j)
+			if pm.ContainerPort < 1 || pm.ContainerPort > 65535 {
+				v.add(portField+".containerPort", "needs to be between 1 and 65535")
+			}
+			if pm.HostPort < 0 || pm.HostPort > 65535 {
+				v.add(portField+".hostPort", "needs to be between 0 and 65535")
+			}
+			if d.NetworkMode == "awsvpc" && pm.HostPort != 0 && pm.HostPort != pm.ContainerPort {
+				v.add(portField+".hostPort", "needs to be the same as the containerPort with the awsvpc networkMode")
+			}
+			protocol := strings.ToLower(pm.Protocol)
+			if protocol != "" && protocol != "tcp" && protocol != "udp" {
+				v.add(portField+".protocol", "needs to be tcp or udp")
+			}
+			if protocol == "" {
+				protocol = "tcp"
+			}
+			key := fmt.Sprintf("%d/%v", pm.ContainerPort, protocol)
+			if containerPorts[key] {
+				v.add(portField+".containerPort", "port %v is mapped more than once", key)
+			}
+			containerPorts[key] = true
+		}
+		if container.StartTimeout < 0 {
+			v.add(field+".startTimeout", "can't be negative")
+		}
+		if container.StopTimeout < 0 || container.StopTimeout > 120 {
+			v.add(field+".stopTimeout", "needs to be between 0 and 120")
+		}
+		switch container.FirelensConfiguration.Type {
+		case "", "fluentd", "fluentbit":
+		default:
+			v.add(field+".firelensConfiguration.type", "needs to be fluentd or fluentbit")
+		}
+		v.checkRange(field+".healthCheck.interval", container.HealthCheck.Interval, 5, 300)
+		v.checkRange(field+".healthCheck.timeout", container.HealthCheck.Timeout, 2, 60)
+		v.checkRange(field+".healthCheck.retries", container.HealthCheck.Retries, 1, 10)
+		v.checkRange(field+".healthCheck.startPeriod", container.HealthCheck.StartPeriod, 0, 300)
+	}
+	if !serviceContainer {
+		v.add("containers", "at least one container needs to have the same name as the service (%v)", serviceName)
+	}
+	v.validateContainerDependencies(d)
+	for i, volume := range d.Volumes {
+		if volume.Name == "" {
+			v.add(fmt.Sprintf("volumes[%d].name", i), "is required")
+		}
+	}
+}
+
+// validateContainerDependencies checks whether the containers depend on existing containers, without cycles
+func (v *validator) validateContainerDependencies(d service.Deploy) {
+	containers := make(map[string]*service.DeployContainer)
+	var firelens int
+	for _, container := range d.Containers {
+		containers[container.ContainerName] = container
+		if container.FirelensConfiguration.Type != "" {
+			firelens++
+		}
+	}
+	if firelens > 1 {
+		v.add("containers", "only one container can have a firelensConfiguration")
+	}
+	for i, container := range d.Containers {
+		for j, dependency := range container.DependsOn {
+			field := fmt.Sprintf("containers[%d].dependsOn[%d]", i, j)
+			dependsOn, ok := containers[dependency.ContainerName]
+			if !ok {
+				v.add(field+".containerName", "container %v is not defined in containers", dependency.ContainerName)
+				continue
+			}
+			if dependency.ContainerName == container.ContainerName {
+				v.add(field+".containerName", "a container can't depend on itself")
+				continue
+			}
+			switch strings.ToUpper(dependency.Condition) {
+			case "", "START", "COMPLETE", "SUCCESS":
+			case "HEALTHY":
+				if len(dependsOn.HealthCheck.Command) == 0 {
+					v.add(field+".condition", "HEALTHY needs a healthCheck on container %v", dependency.ContainerName)
+				}
+			default:
+				v.add(field+".condition", "needs to be START, COMPLETE, SUCCESS or HEALTHY")
+			}
+		}
+	}
+	// depth first search for cycles, a container is visiting while its dependencies are checked
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(name string, path []string) bool
+	visit = func(name string, path []string) bool {
+		switch state[name] {
+		case visiting:
+			v.add("containers", "circular dependency between containers: %v", strings.Join(append(path, name), " -> "))
+			return false
+		case visited:
+			return true
+		}
+		state[name] = visiting
+		for _, dependency := range containers[name].DependsOn {
+			if _, ok := containers[dependency.ContainerName]; !ok || dependency.ContainerName == name {
+				continue
+			}
+			if !visit(dependency.ContainerName, append(path, name)) {
+				return false
+			}
+		}
+		state[name] = visited
+		return true
+	}
+	for _, container := range d.Containers {
+		if !visit(container.ContainerName, nil) {
+			return
+		}
+	}
+}
+
+func (v *validator) validateNetwork(d service.Deploy) {
+	switch d.NetworkMode {
+	case "", "bridge", "host", "none":
+	case "awsvpc":
+		if len(d.NetworkConfiguration.Subnets) == 0 {
+			v.add("networkConfiguration.subnets", "awsvpc networkMode needs at least one subnet")
+		}
+	default:
+		v.add("networkMode", "needs to be bridge, host, awsvpc or none")
+	}
+	switch strings.ToUpper(d.NetworkConfiguration.AssignPublicIp) {
+	case "", "ENABLED", "DISABLED":
+	default:
+		v.add("networkConfiguration.assignPublicIp", "needs to be ENABLED or DISABLED")
+	}
+	switch strings.ToUpper(d.LaunchType) {
+	case "", "EC2", "FARGATE":
+	default:
+		v.add("launchType", "needs to be EC2 or FARGATE")
+	}
+	v.validateCapacityProviderStrategy(d)
+	v.validatePlacementStrategy(d)
+	if service.IsFargate(d) {
+		if d.NetworkMode != "awsvpc" {
+			v.add("networkMode", "FARGATE needs the awsvpc networkMode")
+		}
+		cpu, memory := service.GetTaskSize(d)
+		if d.CPU > 0 || d.Memory > 0 {
+			if !IsValidFargateTaskSize(cpu, memory) {
+				v.add("cpu", "the cpu (%d) and memory (%d) of the task is not a valid FARGATE task size", cpu, memory)
+			}
+		} else if !IsValidFargateTaskSize(cpu, memory) {
+			v.add("containers", "the cpu (%d) and memory (%d) of the containers is not a valid FARGATE task size, set the cpu and memory of the task", cpu, memory)
+		}
+		if len(d.PlacementConstraints) > 0 {
+			v.add("placementConstraints", "are not supported by FARGATE")
+		}
+		if len(d.PlacementStrategy) > 0 {
+			v.add("placementStrategy", "is not supported by FARGATE")
+		}
+	} else if d.PlatformVersion != "" {
+		v.add("platformVersion", "can only be set when the tasks run on FARGATE")
+	}
+	// the task size is the limit of the containers
+	var cpu, memory int64
+	for _, container := range d.Containers {
+		cpu += container.CPU
+		memory += container.Memory
+	}
+	if d.CPU > 0 && cpu > d.CPU {
+		v.add("containers", "the cpu of the containers (%d) is higher than the cpu of the task (%d)", cpu, d.CPU)
+	}
+	if d.Memory > 0 && memory > d.Memory {
+		v.add("containers", "the memory of the containers (%d) is higher than the memory of the task (%d)", memory, d.Memory)
+	}
+}
+
+func (v *validator) validateCapacityProviderStrategy(d service.Deploy) {
+	if len(d.CapacityProviderStrategy) == 0 {
+		return
+	}
+	if d.LaunchType != "" {
+		v.add("launchType", "can't be set together with a capacityProviderStrategy")
+	}
+	var fargate, base, weight int
+	for i, item := range d.CapacityProviderStrategy {
+		field := fmt.Sprintf("capacityProviderStrategy[%d]", i)
+		if item.CapacityProvider == "" {
+			v.add(field+".capacityProvider", "is required")
+		}
+		if item.CapacityProvider == "FARGATE" || item.CapacityProvider == "FARGATE_SPOT" {
+			fargate++
+		}
+		if item.Weight < 0 || item.Weight > 1000 {
+			v.add(field+".weight", "needs to be between 0 and 1000")
+		}
+		if item.Weight > 0 {
+			weight++
+		}
+		if item.Base < 0 || item.Base > 100000 {
+			v.add(field+".base", "needs to be between 0 and 100000")
+		}
+		if item.Base > 0 {
+			base++
+		}
+	}
+	if fargate > 0 && fargate != len(d.CapacityProviderStrategy) {
+		v.add("capacityProviderStrategy", "FARGATE capacity providers can't be mixed with other capacity providers")
+	}
+	if base > 1 {
+		v.add("capacityProviderStrategy", "only one capacity provider can have a base")
+	}
+	if weight == 0 {
+		v.add("capacityProviderStrategy", "at least one capacity provider needs a weight")
+	}
+}
+
+func (v *validator) validatePlacementStrategy(d service.Deploy) {
+	for i, ps := range d.PlacementStrategy {
+		field := fmt.Sprintf("placementStrategy[%d]", i)
+		switch ps.Type {
+		case "random":
+		case "spread":
+			if ps.Field == "" {
+				v.add(field+".field", "is required for the spread type")
+			}
+		case "binpack":
+			if ps.Field != "cpu" && ps.Field != "memory" {
+				v.add(field+".field", "needs to be cpu or memory for the binpack type")
+			}
+		default:
+			v.add(field+".type", "needs to be random, spread or binpack")
+		}
+	}
+}
+
+func (v *validator) validateHealthCheck(d service.Deploy) {
+	if strings.ToLower(d.ServiceProtocol) == "none" {
+		return
+	}
+	healthCheck := d.HealthCheck
+	v.checkRange("healthCheck.healthyThreshold", healthCheck.HealthyThreshold, 2, 10)
+	v.checkRange("healthCheck.unhealthyThreshold", healthCheck.UnhealthyThreshold, 2, 10)
+	v.checkRange("healthCheck.interval", healthCheck.Interval, 5, 300)
+	v.checkRange("healthCheck.timeout", healthCheck.Timeout, 2, 120)
+	if healthCheck.Timeout != 0 && healthCheck.Interval != 0 && healthCheck.Timeout >= healthCheck.Interval {
+		v.add("healthCheck.timeout", "needs to be smaller than the interval")
+	}
+	if healthCheck.Path != "" && !strings.HasPrefix(healthCheck.Path, "/") {
+		v.add("healthCheck.path", "needs to start with /")
+	}
+	if healthCheck.GracePeriodSeconds < 0 {
+		v.add("healthCheck.gracePeriodSeconds", "can't be negative")
+	}
+}
+
+// validateNetworkProtocol checks the certificate, health check and rule conditions of services behind a network
+// loadbalancer, which get their own listener instead of listener rules
+func (v *validator) validateNetworkProtocol(prefix string, d service.Deploy) {
+	if strings.ToUpper(d.ServiceProtocol) == "TLS" {
+		if d.CertificateArn == "" {
+			v.add(prefix+"certificateArn", "is required for the TLS protocol")
+		}
+	} else if d.CertificateArn != "" {
+		v.add(prefix+"certificateArn", "can only be used with the TLS protocol")
+	}
+	if !service.IsNetworkProtocol(d.ServiceProtocol) {
+		return
+	}
+	if len(d.RuleConditions) > 0 {
+		v.add(prefix+"ruleConditions", "can't be used with protocol %v, the service gets a listener on its port", d.ServiceProtocol)
+	}
+	switch strings.ToUpper(d.HealthCheck.Protocol) {
+	case "", "TCP":
+		if d.HealthCheck.Path != "" {
+			v.add(prefix+"healthCheck.path", "can't be used with tcp health checks")
+		}
+		if d.HealthCheck.Matcher != "" {
+			v.add(prefix+"healthCheck.matcher", "can't be used with tcp health checks")
+		}
+	}
+}
+
+func (v *validator) validateRuleConditions(ruleConditionsField string, ruleConditions []*service.DeployRuleConditions, listeners []string) {
+	priorities := make(map[int64]int)
+	for i, ruleCondition := range ruleConditions {
+		field := fmt.Sprintf("%v[%d]", ruleConditionsField, i)
+		v.validateRuleConditionValues(field, ruleCondition)
+		if len(ruleCondition.Listeners) == 0 {
+			v.add(field+".listeners", "at least one listener is required")
+		}
+		v.validateRuleAction(field+".action", ruleCondition)
+		v.checkRange(field+".priority", ruleCondition.Priority, service.MinRulePriority, service.MaxRulePriority)
+		if ruleCondition.Priority != 0 {
+			if j, ok := priorities[ruleCondition.Priority]; ok {
+				v.add(field+".priority", "is already used by %v[%d]", ruleConditionsField, j)
+			} else {
+				priorities[ruleCondition.Priority] = i
+			}
+		}
+		if listeners == nil {
+			continue
+		}
+		for j, listener := range ruleCondition.Listeners {
+			var found bool
+			for _, l := range listeners {
+				if strings.ToLower(l) == strings.ToLower(listener) {
+					found = true
+				}
+			}
+			if !found {
+				v.add(fmt.Sprintf("%v.listeners[%d]", field, j), "the loadbalancer has no %v listener", listener)
+			}
+		}
+	}
+}
+
+// maximum number of condition values of a listener rule
+const maxRuleConditionValues = 5
+
+var fixedResponseStatusCode = regexp.MustCompile(`^[245][0-9][0-9]$`)
+
+func (v *validator) validateRuleConditionValues(field string, r *service.DeployRuleConditions) {
+	var values int
+	if r.PathPattern != "" {
+		values++
+	}
+	if r.Hostname != "" {
+		values++
+	}
+	values += len(r.PathPatterns) + len(r.Hostnames) + len(r.QueryStrings) + len(r.HttpRequestMethods) + len(r.SourceIps)
+	for j, header := range r.HttpHeaders {
+		if header.Name == "" {
+			v.add(fmt.Sprintf("%v.httpHeaders[%d].name", field, j), "is required")
+		}
+		if len(header.Values) == 0 {
+			v.add(fmt.Sprintf("%v.httpHeaders[%d].values", field, j), "at least one value is required")
+		}
+		values += len(header.Values)
+	}
+	if values == 0 {
+		v.add(field, "needs at least one condition: pathPattern, hostname, httpHeaders, queryStrings, httpRequestMethods or sourceIps")
+	} else if values > maxRuleConditionValues {
+		v.add(field, "can't have more than %d condition values, got %d", maxRuleConditionValues, values)
+	}
+	for j, queryString := range r.QueryStrings {
+		if queryString.Value == "" {
+			v.add(fmt.Sprintf("%v.queryStrings[%d].value", field, j), "is required")
+		}
+	}
+	for j, method := range r.HttpRequestMethods {
+		if method == "" || method != strings.ToUpper(method) {
+			v.add(fmt.Sprintf("%v.httpRequestMethods[%d]", field, j), "needs to be an uppercase http method")
+		}
+	}
+	for j, sourceIp := range r.SourceIps {
+		if _, _, err := net.ParseCIDR(sourceIp); err != nil {
+			v.add(fmt.Sprintf("%v.sourceIps[%d]", field, j), "needs to be a CIDR block")
+		}
+	}
+}
+
+func (v *validator) validateRuleAction(field string, r *service.DeployRuleConditions) {
+	action := r.Action
+	switch strings.ToLower(action.Type) {
+	case "", "forward":
+	case "fixed-response":
+		if !fixedResponseStatusCode.MatchString(action.FixedResponse.StatusCode) {
+			v.add(field+".fixedResponse.statusCode", "needs to be a 2XX, 4XX or 5XX status code")
+		}
+		switch action.FixedResponse.ContentType {
+		case "", "text/plain", "text/css", "text/html", "application/javascript", "application/json":
+		default:
+			v.add(field+".fixedResponse.contentType", "needs to be text/plain, text/css, text/html, application/javascript or application/json")
+		}
+		if len(action.FixedResponse.MessageBody) > 1024 {
+			v.add(field+".fixedResponse.messageBody", "can't be longer than 1024 characters")
+		}
+	case "redirect":
+		redirect := action.Redirect
+		if redirect.StatusCode != "HTTP_301" && redirect.StatusCode != "HTTP_302" {
+			v.add(field+".redirect.statusCode", "needs to be HTTP_301 or HTTP_302")
+		}
+		switch redirect.Protocol {
+		case "", "HTTP", "HTTPS", "#{protocol}":
+		default:
+			v.add(field+".redirect.protocol", "needs to be HTTP, HTTPS or #{protocol}")
+		}
+		// without any change the request would be redirected to itself
+		if redirect.Protocol == "" && redirect.Host == "" && redirect.Port == "" && redirect.Path == "" && redirect.Query == "" {
+			v.add(field+".redirect", "needs a protocol, host, port, path or query to redirect to")
+		}
+	default:
+		v.add(field+".type", "needs to be forward, fixed-response or redirect")
+	}
+	if action.AuthenticateOidc == nil && action.AuthenticateCognito == nil {
+		return
+	}
+	if action.AuthenticateOidc != nil && action.AuthenticateCognito != nil {
+		v.add(field, "can't have both authenticateOidc and authenticateCognito")
+	}
+	for _, listener := range r.Listeners {
+		if strings.ToLower(listener) != "https" {
+			v.add(field, "authentication can only be used on https listeners")
+			break
+		}
+	}
+	if oidc := action.AuthenticateOidc; oidc != nil {
+		required := []struct{ name, value string }{
+			{"issuer", oidc.Issuer},
+			{"authorizationEndpoint", oidc.AuthorizationEndpoint},
+			{"tokenEndpoint", oidc.TokenEndpoint},
+			{"userInfoEndpoint", oidc.UserInfoEndpoint},
+			{"clientId", oidc.ClientId},
+			{"clientSecretParameter", oidc.ClientSecretParameter},
+		}
+		for _, f := range required {
+			if f.value == "" {
+				v.add(field+".authenticateOidc."+f.name, "is required")
+			}
+		}
+		v.validateOnUnauthenticatedRequest(field+".authenticateOidc.onUnauthenticatedRequest", oidc.OnUnauthenticatedRequest)
+	}
+	if cognito := action.AuthenticateCognito; cognito != nil {
+		if cognito.UserPoolArn == "" {
+			v.add(field+".authenticateCognito.userPoolArn", "is required")
+		}
+		if cognito.UserPoolClientId == "" {
+			v.add(field+".authenticateCognito.userPoolClientId", "is required")
+		}
+		if cognito.UserPoolDomain == "" {
+			v.add(field+".authenticateCognito.userPoolDomain", "is required")
+		}
+		v.validateOnUnauthenticatedRequest(field+".authenticateCognito.onUnauthenticatedRequest", cognito.OnUnauthenticatedRequest)
+	}
+}
+
+func (v *validator) validateOnUnauthenticatedRequest(field, onUnauthenticatedRequest string) {
+	switch onUnauthenticatedRequest {
+	case "", "deny", "allow", "authenticate":
+	default:
+		v.add(field, "needs to be deny, allow or authenticate")
+	}
+}
+
+func (v *validator) validateLoadBalancers(serviceName string, d service.Deploy, listeners map[string][]string) {
+	if len(d.LoadBalancers) == 0 {
+		return
+	}
+	switch strings.ToLower(d.DeploymentStrategy) {
+	case "", "rolling":
+	default:
+		v.add("loadBalancers", "can only be used with rolling deployments")
+	}
+	containers := make(map[string]*service.DeployContainer)
+	for _, container := range d.Containers {
+		containers[container.ContainerName] = container
+	}
+	targetGroups := make(map[string]bool)
+	if strings.ToLower(d.ServiceProtocol) != "none" {
+		targetGroups[serviceName] = true
+	}
+	for i, lb := range d.LoadBalancers {
+		field := fmt.Sprintf("loadBalancers[%d]", i)
+		container, ok := containers[lb.ContainerName]
+		if !ok {
+			v.add(field+".containerName", "container %v is not defined in containers", lb.ContainerName)
+		} else if !isPortMapped(container, lb.ContainerPort) {
+			v.add(field+".containerPort", "port %d is not mapped on container %v", lb.ContainerPort, lb.ContainerName)
+		}
+		if lb.ContainerPort < 1 || lb.ContainerPort > 65535 {
+			v.add(field+".containerPort", "needs to be between 1 and 65535")
+		}
+		switch strings.ToUpper(lb.Protocol) {
+		case "HTTP", "HTTPS", "TCP", "UDP", "TCP_UDP", "TLS":
+		default:
+			v.add(field+".protocol", "needs to be HTTP, HTTPS, TCP, UDP, TCP_UDP or TLS")
+		}
+		targetGroupName := service.GetLoadBalancerTargetGroupName(serviceName, lb)
+		if len(targetGroupName) > 32 {
+			v.add(field, "the name of the target group (%v) can't be longer than 32 characters", targetGroupName)
+		}
+		if targetGroups[targetGroupName] {
+			v.add(field+".containerPort", "port %d has more than one load balancer", lb.ContainerPort)
+		}
+		targetGroups[targetGroupName] = true
+		healthCheckField := field + ".healthCheck"
+		v.checkRange(healthCheckField+".healthyThreshold", lb.HealthCheck.HealthyThreshold, 2, 10)
+		v.checkRange(healthCheckField+".unhealthyThreshold", lb.HealthCheck.UnhealthyThreshold, 2, 10)
+		v.checkRange(healthCheckField+".interval", lb.HealthCheck.Interval, 5, 300)
+		v.checkRange(healthCheckField+".timeout", lb.HealthCheck.Timeout, 2, 120)
+		if lb.HealthCheck.Path != "" && !strings.HasPrefix(lb.HealthCheck.Path, "/") {
+			v.add(healthCheckField+".path", "needs to start with /")
+		}
+		lbDeploy := service.GetLoadBalancerDeploy(d, lb)
+		v.validateNetworkProtocol(field+".", lbDeploy)
+		if service.IsNetworkProtocol(lb.Protocol) {
+			continue
+		}
+		// the default rules (/serviceName) are used by the target group of the service
+		if len(lb.RuleConditions) == 0 {
+			v.add(field+".ruleConditions", "at least one rule condition is required")
+		}
+		v.validateRuleConditions(field+".ruleConditions", lb.RuleConditions, listeners[service.GetLoadBalancerName(lbDeploy)])
+	}
+}
+
+func isPortMapped(container *service.DeployContainer, port int64) bool {
+	if container.ContainerPort == port {
+		return true
+	}
+	for _, pm := range container.PortMappings {
+		if pm.ContainerPort == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,398 @@
+package validation
+
+import (
+	"github.com/in4it/ecs-deploy/service"
+
+	"testing"
+	"time"
+)
+
+func TestValidateDeploy(t *testing.T) {
+	link := "myservice"
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "HTTP",
+		ServicePort:     80,
+		NetworkMode:     "awsvpc",
+		LaunchType:      "FARGATE",
+		HealthCheck: service.DeployHealthCheck{
+			HealthyThreshold: 1,
+			Interval:         30,
+			Timeout:          30,
+		},
+		RuleConditions: []*service.DeployRuleConditions{
+			{Listeners: []string{"http", "https"}, PathPattern: "/myservice"},
+		},
+		Containers: []*service.DeployContainer{
+			{
+				ContainerName:     "myservice",
+				CPU:               256,
+				MemoryReservation: 512,
+				MountPoints:       []*service.DeployContainerMountPoint{{SourceVolume: "data"}},
+				Links:             []*string{},
+				Secrets: []*service.DeployContainerSecret{
+					{Name: "DB_PASSWORD"},
+					{Name: "DB_PASSWORD", ValueFrom: "db/password"},
+				},
+			},
+			{
+				ContainerName: "sidecar",
+				Links:         []*string{&link},
+			},
+		},
+	}
+	errs := ValidateDeploy("myservice", d, map[string][]string{"mycluster": {"http"}})
+	expected := []string{
+		"containers[0].mountPoints[0].sourceVolume",
+		"containers[0].secrets[0].valueFrom",
+		"containers[0].secrets[1].name",
+		"containers[1].memory",
+		"containers[1].links",
+		"networkConfiguration.subnets",
+		"healthCheck.healthyThreshold",
+		"healthCheck.timeout",
+		"ruleConditions[0].listeners[1]",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+
+	// fixed deploy
+	d.NetworkConfiguration.Subnets = []string{"subnet-123"}
+	d.NetworkMode = "awsvpc"
+	d.HealthCheck.HealthyThreshold = 3
+	d.HealthCheck.Timeout = 5
+	d.Volumes = []service.DeployVolume{{Name: "data"}}
+	d.Containers = d.Containers[:1]
+	d.Containers[0].MemoryReservation = 1024
+	d.Containers[0].Secrets = d.Containers[0].Secrets[1:]
+	if errs := ValidateDeploy("myservice", d, nil); len(errs) > 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+
+	// health gates are only checked after a rolling deployment
+	d.HealthGates.Alarms = []string{"myservice-5xx"}
+	if errs := ValidateDeploy("myservice", d, nil); len(errs) > 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+	for _, strategy := range []string{"blueGreen", "canary"} {
+		d.DeploymentStrategy = strategy
+		if errs := ValidateDeploy("myservice", d, nil); len(errs) != 1 || errs[0].Field != "healthGates" {
+			t.Errorf("Expected healthGates error for %v, got: %v", strategy, errs)
+		}
+	}
+}
+
+func TestIsValidFargateTaskSize(t *testing.T) {
+	if !IsValidFargateTaskSize(256, 512) || !IsValidFargateTaskSize(4096, 30720) {
+		t.Errorf("Expected valid fargate task size")
+	}
+	if IsValidFargateTaskSize(256, 4096) || IsValidFargateTaskSize(128, 512) {
+		t.Errorf("Expected invalid fargate task size")
+	}
+}
+
+func TestValidateTaskSize(t *testing.T) {
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "none",
+		NetworkMode:     "awsvpc",
+		LaunchType:      "FARGATE",
+		CPU:             256,
+		Memory:          1024,
+		NetworkConfiguration: service.DeployNetworkConfiguration{
+			Subnets: []string{"subnet-123"},
+		},
+		Containers: []*service.DeployContainer{
+			{ContainerName: "myservice", CPU: 128},
+			{ContainerName: "sidecar", Memory: 2048},
+		},
+	}
+	errs := ValidateDeploy("myservice", d, nil)
+	if len(errs) != 1 || errs[0].Field != "containers" {
+		t.Fatalf("Expected the memory of the containers to be too high, got: %v", errs)
+	}
+	d.Containers[1].Memory = 256
+	d.Memory = 4096
+	errs = ValidateDeploy("myservice", d, nil)
+	if len(errs) != 1 || errs[0].Field != "cpu" {
+		t.Fatalf("Expected an invalid FARGATE task size, got: %v", errs)
+	}
+	d.LaunchType = "EC2"
+	d.PlatformVersion = "LATEST"
+	errs = ValidateDeploy("myservice", d, nil)
+	if len(errs) != 1 || errs[0].Field != "platformVersion" {
+		t.Fatalf("Expected platformVersion to be invalid, got: %v", errs)
+	}
+}
+
+func TestValidateCapacityProviderStrategy(t *testing.T) {
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "none",
+		LaunchType:      "EC2",
+		CapacityProviderStrategy: []service.DeployCapacityProviderStrategyItem{
+			{CapacityProvider: "FARGATE_SPOT", Weight: 3, Base: 1},
+			{CapacityProvider: "my-asg", Weight: 1, Base: 1},
+		},
+		PlacementStrategy: []service.DeployPlacementStrategy{
+			{Type: "binpack", Field: "attribute:ecs.availability-zone"},
+		},
+		Containers: []*service.DeployContainer{
+			{ContainerName: "myservice", MemoryReservation: 128},
+		},
+	}
+	errs := ValidateDeploy("myservice", d, nil)
+	expected := []string{"launchType", "capacityProviderStrategy", "capacityProviderStrategy", "placementStrategy[0].field"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+	d.LaunchType = ""
+	d.CapacityProviderStrategy = d.CapacityProviderStrategy[1:]
+	d.PlacementStrategy[0].Field = "memory"
+	if errs := ValidateDeploy("myservice", d, nil); len(errs) > 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+}
+
+func TestValidateContainerDependencies(t *testing.T) {
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "none",
+		Containers: []*service.DeployContainer{
+			{
+				ContainerName:     "myservice",
+				MemoryReservation: 128,
+				DependsOn: []*service.DeployContainerDependency{
+					{ContainerName: "envoy", Condition: "HEALTHY"},
+					{ContainerName: "datadog"},
+				},
+			},
+			{
+				ContainerName:     "envoy",
+				MemoryReservation: 128,
+				DependsOn:         []*service.DeployContainerDependency{{ContainerName: "log-router"}},
+			},
+			{
+				ContainerName:         "log-router",
+				MemoryReservation:     64,
+				FirelensConfiguration: service.DeployContainerFirelensConfiguration{Type: "fluentbit"},
+				DependsOn:             []*service.DeployContainerDependency{{ContainerName: "myservice"}},
+			},
+		},
+	}
+	errs := ValidateDeploy("myservice", d, nil)
+	expected := []string{"containers[0].dependsOn[0].condition", "containers[0].dependsOn[1].containerName", "containers"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+	if errs[2].Message != "circular dependency between containers: myservice -> envoy -> log-router -> myservice" {
+		t.Errorf("Unexpected message: %v", errs[2].Message)
+	}
+
+	// fixed deploy
+	d.Containers[0].DependsOn = d.Containers[0].DependsOn[:1]
+	d.Containers[1].HealthCheck.Command = []*string{&d.Containers[1].ContainerName}
+	d.Containers[2].DependsOn = nil
+	if errs := ValidateDeploy("myservice", d, nil); len(errs) > 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+}
+
+func TestValidateLoadBalancers(t *testing.T) {
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "HTTP",
+		ServicePort:     8080,
+		Containers: []*service.DeployContainer{
+			{ContainerName: "myservice", ContainerPort: 8080, MemoryReservation: 128},
+		},
+		LoadBalancers: []service.DeployLoadBalancer{
+			{ContainerName: "myservice", ContainerPort: 9090, Protocol: "GRPC"},
+			{
+				ContainerName:  "myservice",
+				ContainerPort:  8080,
+				Protocol:       "HTTP",
+				LoadBalancer:   "internal",
+				RuleConditions: []*service.DeployRuleConditions{{Listeners: []string{"https"}, Hostname: "myservice"}},
+			},
+		},
+	}
+	errs := ValidateDeploy("myservice", d, map[string][]string{"internal": {"http"}})
+	expected := []string{
+		"loadBalancers[0].containerPort",
+		"loadBalancers[0].protocol",
+		"loadBalancers[0].ruleConditions",
+		"loadBalancers[1].ruleConditions[0].listeners[0]",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+}
+
+func TestValidateNetworkProtocol(t *testing.T) {
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "TLS",
+		ServicePort:     8883,
+		HealthCheck:     service.DeployHealthCheck{Path: "/health"},
+		RuleConditions:  []*service.DeployRuleConditions{{Listeners: []string{"http"}, PathPattern: "/mqtt"}},
+		Containers: []*service.DeployContainer{
+			{ContainerName: "mqtt", ContainerPort: 8883, MemoryReservation: 128, PortMappings: []*service.DeployContainerPortMapping{{ContainerPort: 1883}}},
+		},
+		LoadBalancers: []service.DeployLoadBalancer{
+			{ContainerName: "mqtt", ContainerPort: 1883, Protocol: "TCP", CertificateArn: "arn:aws:acm:us-east-1:123456789012:certificate/abc"},
+		},
+	}
+	errs := ValidateDeploy("mqtt", d, nil)
+	expected := []string{
+		"certificateArn",
+		"ruleConditions",
+		"healthCheck.path",
+		"loadBalancers[0].certificateArn",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+}
+
+func TestValidateRuleConditions(t *testing.T) {
+	d := service.Deploy{
+		Cluster:         "mycluster",
+		ServiceProtocol: "HTTP",
+		ServicePort:     8080,
+		Containers: []*service.DeployContainer{
+			{ContainerName: "myservice", ContainerPort: 8080, MemoryReservation: 128},
+		},
+		RuleConditions: []*service.DeployRuleConditions{
+			{Listeners: []string{"http"}},
+			{
+				Listeners:          []string{"http"},
+				PathPatterns:       []string{"/a", "/b", "/c"},
+				HttpRequestMethods: []string{"get", "POST"},
+				SourceIps:          []string{"10.0.0.1"},
+				Priority:           60000,
+			},
+			{
+				Listeners:   []string{"http"},
+				HttpHeaders: []service.DeployRuleHttpHeader{{Name: "X-Beta"}},
+				Action:      service.DeployRuleAction{Type: "redirect", Redirect: service.DeployRuleRedirect{StatusCode: "HTTP_301"}},
+				Priority:    10,
+			},
+			{
+				Listeners:    []string{"http", "https"},
+				QueryStrings: []service.DeployRuleQueryString{{Key: "beta", Value: "1"}},
+				Priority:     10,
+				Action: service.DeployRuleAction{
+					Type:                "fixed-response",
+					FixedResponse:       service.DeployRuleFixedResponse{StatusCode: "302"},
+					AuthenticateCognito: &service.DeployRuleAuthenticateCognito{UserPoolArn: "arn", UserPoolClientId: "client", UserPoolDomain: "domain"},
+				},
+			},
+		},
+	}
+	errs := ValidateDeploy("myservice", d, nil)
+	expected := []string{
+		"ruleConditions[0]",
+		"ruleConditions[1]",
+		"ruleConditions[1].httpRequestMethods[0]",
+		"ruleConditions[1].sourceIps[0]",
+		"ruleConditions[1].priority",
+		"ruleConditions[2].httpHeaders[0].values",
+		"ruleConditions[2]",
+		"ruleConditions[2].action.redirect",
+		"ruleConditions[3].action.fixedResponse.statusCode",
+		"ruleConditions[3].action",
+		"ruleConditions[3].priority",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+}
+
+func TestValidateRBAC(t *testing.T) {
+	r := &service.DynamoRBAC{
+		Roles: []service.Role{
+			{Name: "admin", Permissions: []service.Permission{{Action: "*"}}},
+			{Name: "admin"},
+			{Name: "team-a", Permissions: []service.Permission{{Action: "delete"}, {Action: "deploy", Service: "team-a-["}}},
+		},
+		Bindings: []service.RoleBinding{
+			{User: "deploy", Roles: []string{"admin"}},
+			{User: "deploy", Group: "team-a", Roles: []string{"team-a"}},
+			{Group: "team-b"},
+			{Group: "team-c", Roles: []string{"team-c"}},
+		},
+	}
+	errs := ValidateRBAC(r)
+	expected := []string{
+		"roles[1].name",
+		"roles[2].permissions[0].action",
+		"roles[2].permissions[1].service",
+		"bindings[1]",
+		"bindings[2].roles",
+		"bindings[3].roles[0]",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+	if errs = ValidateRBAC(service.GetDefaultRBAC()); len(errs) != 0 {
+		t.Errorf("Expected the default rbac to be valid, got: %v", errs)
+	}
+}
+
+func TestValidateAPIToken(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	errs := ValidateAPIToken(&service.APITokenRequest{
+		Name:        "ci pipeline",
+		Permissions: []service.Permission{{Action: "deploy"}, {Action: "destroy"}},
+		ExpiresAt:   &expired,
+	})
+	expected := []string{"name", "permissions[1].action", "expiresAt"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got: %v", len(expected), errs)
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Errorf("Expected error for %v, got %v", field, errs[i])
+		}
+	}
+	if errs = ValidateAPIToken(&service.APITokenRequest{Name: "ci", Permissions: []service.Permission{{Action: "deploy"}}}); len(errs) != 0 {
+		t.Errorf("Expected no errors, got: %v", errs)
+	}
+}
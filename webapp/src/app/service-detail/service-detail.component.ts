@@ -431,25 +431,22 @@ export class ServiceDetailComponent implements OnInit {
     delete this.service["logs"]
     this.sds.getServiceLog(params).subscribe(data => {
       this.loadingLogs = false
-      if("error" in data) {
-        let errorMsg: string = data["error"]
-        if(errorMsg == "") {
-          this.alertService.error("Error, but error message was empty");
-        } else {
-          if(errorMsg.startsWith("ResourceNotFoundException")) {
-            this.service["logs"] = { "count": 0 }
-          } else {
-            this.alertService.error(errorMsg);
-          }
-        }
-        return
-      }
       this.service["logs"] = data["logs"]
       if(!this.service["logs"]["logEvents"]) {
         this.service["logs"]["count"] = 0
       } else {
         this.service["logs"]["count"] = this.service["logs"]["logEvents"].length
       }
+    }, error => {
+      this.loadingLogs = false
+      // the log stream doesn't exist yet
+      if(error.status == 404) {
+        this.service["logs"] = { "count": 0 }
+      } else if(error.error && error.error.message) {
+        this.alertService.error(error.error.message);
+      } else {
+        this.alertService.error("Error, but error message was empty");
+      }
     });
   }
   refreshLogs(): void {